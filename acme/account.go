@@ -0,0 +1,89 @@
+package acme
+
+import (
+	"crypto"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	statusValid       = "valid"
+	statusDeactivated = "deactivated"
+)
+
+// account is an ACME account. Accounts are stored as individual JSON files
+// named after their ID.
+type account struct {
+	ID           string          `json:"id"`
+	Status       string          `json:"status"`
+	Contact      []string        `json:"contact,omitempty"`
+	JWK          json.RawMessage `json:"jwk"`
+	Thumbprint   string          `json:"thumbprint"`
+	CreatedAt    time.Time       `json:"createdAt"`
+	Certificates []string        `json:"certificates,omitempty"`
+	key          crypto.PublicKey
+}
+
+// ownsCert returns the storage path of the certificate with the specified ID
+// if it was issued to the account.
+func (a *account) ownsCert(id string) (string, bool) {
+	for _, p := range a.Certificates {
+		if p == id || strings.HasSuffix(p, "/"+id) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+func (a *ACME) loadAccounts() error {
+	entries, err := os.ReadDir(a.accountDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(a.accountDir, e.Name()))
+		if err != nil {
+			return err
+		}
+		v := &account{}
+		if err := json.Unmarshal(b, v); err != nil {
+			a.logger.Error(err.Error(), "file", e.Name())
+			continue
+		}
+		k, _, err := parseJWK(v.JWK)
+		if err != nil {
+			a.logger.Error(err.Error(), "file", e.Name())
+			continue
+		}
+		v.key = k
+		a.accounts[v.ID] = v
+	}
+	return nil
+}
+
+func (a *ACME) saveAccount(v *account) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(
+		filepath.Join(a.accountDir, v.ID+".json"),
+		b,
+		0600,
+	)
+}
+
+func (a *ACME) accountByThumbprint(thumbprint string) *account {
+	for _, v := range a.accounts {
+		if v.Thumbprint == thumbprint {
+			return v
+		}
+	}
+	return nil
+}
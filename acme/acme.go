@@ -0,0 +1,358 @@
+package acme
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nathan-osman/certy/storage"
+)
+
+const (
+	defaultValidity = "90d"
+	defaultHTTPPort = 80
+	defaultTLSPort  = 443
+
+	nonceLifetime = time.Hour
+	maxNonces     = 10000
+	orderLifetime = 7 * 24 * time.Hour
+
+	maxRequestSize = 64 * 1024
+
+	mimeJOSE    = "application/jose+json"
+	mimeProblem = "application/problem+json"
+	mimeChain   = "application/pem-certificate-chain"
+)
+
+// ACME implements an RFC 8555 server that issues certificates signed by a
+// single CA managed by Storage. Accounts are persisted to disk; orders,
+// authorizations and challenges are kept in memory.
+type ACME struct {
+	mutex      sync.Mutex
+	logger     *slog.Logger
+	storage    *storage.Storage
+	caPath     string
+	validity   string
+	profile    string
+	resolver   string
+	httpPort   int
+	tlsPort    int
+	accountDir string
+	nonces     map[string]time.Time
+	nonceQueue []string
+	accounts   map[string]*account
+	orders     map[string]*order
+	authzs     map[string]*authz
+	challenges map[string]*challenge
+}
+
+// New creates a new ACME instance.
+func New(cfg *Config) (*ACME, error) {
+	a := &ACME{
+		logger:     cfg.Logger,
+		storage:    cfg.Storage,
+		caPath:     cfg.CAPath,
+		validity:   cfg.Validity,
+		profile:    cfg.Profile,
+		resolver:   cfg.Resolver,
+		httpPort:   cfg.HTTPPort,
+		tlsPort:    cfg.TLSPort,
+		accountDir: cfg.Dir,
+		nonces:     map[string]time.Time{},
+		accounts:   map[string]*account{},
+		orders:     map[string]*order{},
+		authzs:     map[string]*authz{},
+		challenges: map[string]*challenge{},
+	}
+	if a.logger == nil {
+		a.logger = slog.Default()
+	}
+	a.logger = a.logger.With("package", "acme")
+	if a.validity == "" {
+		a.validity = defaultValidity
+	}
+	if a.httpPort == 0 {
+		a.httpPort = defaultHTTPPort
+	}
+	if a.tlsPort == 0 {
+		a.tlsPort = defaultTLSPort
+	}
+
+	// Ensure the CA exists and can sign certificates
+	c, err := a.storage.GetCertificate(a.caPath)
+	if err != nil {
+		return nil, err
+	}
+	if !c.CanSign() {
+		return nil, fmt.Errorf("%s cannot sign certificates", c.X509.Subject.CommonName)
+	}
+	if a.profile != "" {
+		if _, err := a.storage.GetProfile(a.profile); err != nil {
+			return nil, fmt.Errorf("profile %s: %w", a.profile, err)
+		}
+	}
+
+	if err := os.MkdirAll(a.accountDir, 0700); err != nil {
+		return nil, err
+	}
+	if err := a.loadAccounts(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Register adds the ACME routes to the router under /acme.
+func (a *ACME) Register(r gin.IRouter) {
+	g := r.Group("/acme")
+	g.Use(a.commonHeaders)
+	g.GET("/directory", a.directory)
+
+	// Nonces are only issued where the client needs one (RFC 8555, section
+	// 7.2)
+	n := g.Group("", a.replayNonce)
+	n.HEAD("/new-nonce", a.newNonce)
+	n.GET("/new-nonce", a.newNonce)
+	n.POST("/new-account", a.newAccount)
+	n.POST("/account/:id", a.updateAccount)
+	n.POST("/new-order", a.newOrder)
+	n.POST("/order/:id", a.getOrder)
+	n.POST("/order/:id/finalize", a.finalize)
+	n.POST("/authz/:id", a.getAuthz)
+	n.POST("/chall/:id", a.respondChallenge)
+	n.POST("/cert/:id", a.getCert)
+	n.POST("/revoke-cert", a.revokeCert)
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// baseURL determines the external URL of the server from the request.
+func baseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if v := c.GetHeader("X-Forwarded-Proto"); v != "" {
+		scheme = v
+	}
+	return fmt.Sprintf("%s://%s/acme", scheme, c.Request.Host)
+}
+
+// nonce issues a new replay nonce; it must be called with the mutex held.
+// Nonces are queued in the order they were issued, which is also the order
+// in which they expire, so expired nonces are removed from the front of the
+// queue. Once maxNonces are outstanding, the oldest is discarded.
+func (a *ACME) nonce() (string, error) {
+	v, err := randomID()
+	if err != nil {
+		return "", err
+	}
+	n := time.Now()
+	for len(a.nonceQueue) > 0 {
+		k := a.nonceQueue[0]
+		if t, ok := a.nonces[k]; ok && n.Before(t) && len(a.nonceQueue) < maxNonces {
+			break
+		}
+		delete(a.nonces, k)
+		a.nonceQueue = a.nonceQueue[1:]
+	}
+	a.nonces[v] = n.Add(nonceLifetime)
+	a.nonceQueue = append(a.nonceQueue, v)
+	return v, nil
+}
+
+// replayNonce adds a new nonce to the response.
+func (a *ACME) replayNonce(c *gin.Context) {
+	a.mutex.Lock()
+	n, err := a.nonce()
+	a.mutex.Unlock()
+	if err != nil {
+		a.fail(c, serverInternal(err))
+		return
+	}
+	c.Header("Replay-Nonce", n)
+	c.Next()
+}
+
+// commonHeaders adds the headers required on every response.
+func (a *ACME) commonHeaders(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Link", fmt.Sprintf(`<%s/directory>;rel="index"`, baseURL(c)))
+	c.Next()
+}
+
+func (a *ACME) fail(c *gin.Context, p *problem) {
+	if p.Status == http.StatusInternalServerError {
+		a.logger.Error(p.Detail)
+	}
+	b, _ := json.Marshal(p)
+	c.Data(p.Status, mimeProblem, b)
+	c.Abort()
+}
+
+func (a *ACME) directory(c *gin.Context) {
+	u := baseURL(c)
+	c.JSON(http.StatusOK, gin.H{
+		"newNonce":   u + "/new-nonce",
+		"newAccount": u + "/new-account",
+		"newOrder":   u + "/new-order",
+		"revokeCert": u + "/revoke-cert",
+		"meta": gin.H{
+			"externalAccountRequired": false,
+		},
+	})
+}
+
+func (a *ACME) newNonce(c *gin.Context) {
+	if c.Request.Method == http.MethodHead {
+		c.Status(http.StatusOK)
+	} else {
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// request is a verified JWS request.
+type request struct {
+	header  *jwsHeader
+	payload []byte
+	account *account
+	jwk     []byte
+}
+
+// isPostAsGet indicates that the request has an empty payload.
+func (r *request) isPostAsGet() bool {
+	return len(r.payload) == 0
+}
+
+// verify parses and authenticates the JWS in the request body. Requests using
+// a JWK instead of a key ID are only accepted when allowJWK is true. On
+// failure, the problem is sent and nil is returned.
+func (a *ACME) verify(c *gin.Context, allowJWK bool) *request {
+	if ct := c.ContentType(); ct != mimeJOSE {
+		a.fail(c, malformed("content type must be "+mimeJOSE))
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxRequestSize))
+	if err != nil {
+		a.fail(c, malformed(err.Error()))
+		return nil
+	}
+	m := &jwsMessage{}
+	if err := json.Unmarshal(body, m); err != nil {
+		a.fail(c, malformed("request is not a flattened JWS"))
+		return nil
+	}
+	b, err := b64.DecodeString(m.Protected)
+	if err != nil {
+		a.fail(c, malformed("invalid protected header encoding"))
+		return nil
+	}
+	h := &jwsHeader{}
+	if err := json.Unmarshal(b, h); err != nil {
+		a.fail(c, malformed("invalid protected header"))
+		return nil
+	}
+	payload, err := b64.DecodeString(m.Payload)
+	if err != nil {
+		a.fail(c, malformed("invalid payload encoding"))
+		return nil
+	}
+	sig, err := b64.DecodeString(m.Signature)
+	if err != nil {
+		a.fail(c, malformed("invalid signature encoding"))
+		return nil
+	}
+
+	// The URL must match the one the request was sent to
+	if h.URL != baseURL(c)+c.Request.URL.Path[len("/acme"):] {
+		a.fail(c, unauthorized("URL in protected header does not match request"))
+		return nil
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	// Consume the nonce
+	if t, ok := a.nonces[h.Nonce]; !ok || time.Now().After(t) {
+		a.fail(c, newProblem(typeBadNonce, http.StatusBadRequest, "invalid or expired nonce"))
+		return nil
+	}
+	delete(a.nonces, h.Nonce)
+
+	// Determine the key, which is either embedded or belongs to an account
+	r := &request{header: h}
+	var pub any
+	switch {
+	case len(h.JWK) != 0 && h.KID == "":
+		if !allowJWK {
+			a.fail(c, malformed("this request must use a key ID"))
+			return nil
+		}
+		k, _, err := parseJWK(h.JWK)
+		if err != nil {
+			a.fail(c, malformed(err.Error()))
+			return nil
+		}
+		pub = k
+		r.jwk = h.JWK
+	case len(h.JWK) == 0 && h.KID != "":
+		var v *account
+		for _, acct := range a.accounts {
+			if h.KID == baseURL(c)+"/account/"+acct.ID {
+				v = acct
+				break
+			}
+		}
+		if v == nil {
+			a.fail(c, newProblem(typeAccountDoesNotExist, http.StatusBadRequest, "account does not exist"))
+			return nil
+		}
+		if v.Status != statusValid {
+			a.fail(c, unauthorized("account is not valid"))
+			return nil
+		}
+		pub = v.key
+		r.account = v
+	default:
+		a.fail(c, malformed("exactly one of jwk and kid must be provided"))
+		return nil
+	}
+
+	if err := verifySignature(
+		pub,
+		h.Alg,
+		[]byte(m.Protected+"."+m.Payload),
+		sig,
+	); err != nil {
+		if err == errUnsupportedAlg {
+			a.fail(c, newProblem(typeBadSignatureAlg, http.StatusBadRequest, err.Error()))
+		} else {
+			a.fail(c, malformed(err.Error()))
+		}
+		return nil
+	}
+	r.payload = payload
+	return r
+}
+
+// decode unmarshals the request payload into v, sending a problem on failure.
+func (a *ACME) decode(c *gin.Context, r *request, v any) bool {
+	if err := json.Unmarshal(r.payload, v); err != nil {
+		a.fail(c, malformed("invalid payload: "+err.Error()))
+		return false
+	}
+	return true
+}
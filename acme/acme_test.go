@@ -0,0 +1,301 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nathan-osman/certy/storage"
+	acmeclient "golang.org/x/crypto/acme"
+)
+
+const testDomain = "localhost"
+
+func newTestACME(t *testing.T) (*ACME, *storage.Storage, *storage.Certificate) {
+	t.Helper()
+	dataDir := t.TempDir()
+	s, err := storage.New(&storage.Config{
+		DataDir: dataDir,
+	})
+	if err != nil {
+		t.Fatalf("new storage: %v", err)
+	}
+	ca, err := s.CreateCertificate("", &storage.CreateCertificateParams{
+		CommonName: "ACME Root",
+		Validity:   "1h",
+		CanSign:    true,
+		KeyType:    storage.KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create CA: %v", err)
+	}
+	a, err := New(&Config{
+		Dir:      filepath.Join(dataDir, "acme"),
+		Storage:  s,
+		CAPath:   ca.Path,
+		Validity: "1h",
+	})
+	if err != nil {
+		t.Fatalf("new ACME: %v", err)
+	}
+	return a, s, ca
+}
+
+// isProblem indicates whether err is an ACME problem of the specified type.
+func isProblem(err error, t string) bool {
+	var v *acmeclient.Error
+	return errors.As(err, &v) && v.ProblemType == errorNamespace+t
+}
+
+func TestIssueAndRevoke(t *testing.T) {
+	a, s, ca := newTestACME(t)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	a.Register(r)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	// Serve http-01 responses from a separate server
+	responses := map[string]string{}
+	challengeSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(v))
+	}))
+	defer challengeSrv.Close()
+	_, port, _ := net.SplitHostPort(challengeSrv.Listener.Addr().String())
+	a.httpPort, _ = strconv.Atoi(port)
+
+	accountKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	client := &acmeclient.Client{
+		Key:          accountKey,
+		DirectoryURL: srv.URL + "/acme/directory",
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Register an account and ensure that registering again fails
+	if _, err := client.Register(ctx, &acmeclient.Account{}, acmeclient.AcceptTOS); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := client.Register(ctx, &acmeclient.Account{}, acmeclient.AcceptTOS); err != acmeclient.ErrAccountAlreadyExists {
+		t.Fatalf("second register: %v", err)
+	}
+
+	// Create an order and complete the http-01 challenge
+	o, err := client.AuthorizeOrder(ctx, acmeclient.DomainIDs(testDomain))
+	if err != nil {
+		t.Fatalf("authorize order: %v", err)
+	}
+	if len(o.AuthzURLs) != 1 {
+		t.Fatalf("expected 1 authorization, got %d", len(o.AuthzURLs))
+	}
+	z, err := client.GetAuthorization(ctx, o.AuthzURLs[0])
+	if err != nil {
+		t.Fatalf("get authorization: %v", err)
+	}
+	var chal *acmeclient.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == typeHTTP01 {
+			chal = c
+		}
+	}
+	if chal == nil {
+		t.Fatal("no http-01 challenge offered")
+	}
+	keyAuth, err := client.HTTP01ChallengeResponse(chal.Token)
+	if err != nil {
+		t.Fatalf("challenge response: %v", err)
+	}
+	responses[client.HTTP01ChallengePath(chal.Token)] = keyAuth
+	if _, err := client.Accept(ctx, chal); err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	if _, err := client.WaitAuthorization(ctx, z.URI); err != nil {
+		t.Fatalf("wait authorization: %v", err)
+	}
+	o, err = client.WaitOrder(ctx, o.URI)
+	if err != nil {
+		t.Fatalf("wait order: %v", err)
+	}
+
+	// A CSR for a different name must be rejected
+	certKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	badCSR, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames: []string{"other.example.test"},
+	}, certKey)
+	if _, _, err := client.CreateOrderCert(ctx, o.FinalizeURL, badCSR, false); err == nil {
+		t.Fatal("expected CSR with other names to be rejected")
+	}
+
+	// Finalize the order and verify the certificate
+	csr, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: testDomain},
+		DNSNames: []string{testDomain},
+	}, certKey)
+	der, _, err := client.CreateOrderCert(ctx, o.FinalizeURL, csr, true)
+	if err != nil {
+		t.Fatalf("create order cert: %v", err)
+	}
+	if len(der) != 1 {
+		t.Fatalf("expected 1 certificate in chain, got %d", len(der))
+	}
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.X509)
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		DNSName: testDomain,
+		Roots:   roots,
+	}); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if !certKey.PublicKey.Equal(leaf.PublicKey) {
+		t.Fatal("certificate does not contain the CSR key")
+	}
+
+	// Revoke the certificate with the account key, which fails for reasons
+	// that RFC 5280 does not define
+	if err := client.RevokeCert(ctx, nil, der[0], 7); !isProblem(err, typeBadRevocationReason) {
+		t.Fatalf("expected bad revocation reason, got %v", err)
+	}
+	if err := client.RevokeCert(ctx, nil, der[0], acmeclient.CRLReasonKeyCompromise); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	v, err := s.FindCertificate(der[0])
+	if err != nil {
+		t.Fatalf("find certificate: %v", err)
+	}
+	if !strings.HasPrefix(v.Path, ca.Path+"/") {
+		t.Fatalf("certificate stored at unexpected path %s", v.Path)
+	}
+	if v.Revocation == nil || v.Revocation.Reason != storage.ReasonKeyCompromise {
+		t.Fatalf("expected revocation for key compromise, got %+v", v.Revocation)
+	}
+}
+
+func TestNewOrderIdentifiers(t *testing.T) {
+	a, _, _ := newTestACME(t)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	a.Register(r)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	accountKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	client := &acmeclient.Client{
+		Key:          accountKey,
+		DirectoryURL: srv.URL + "/acme/directory",
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := client.Register(ctx, &acmeclient.Account{}, acmeclient.AcceptTOS); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	for _, tt := range []struct {
+		id      acmeclient.AuthzID
+		problem string
+	}{
+		{acmeclient.AuthzID{Type: "ip", Value: "10.0.0.1"}, typeUnsupportedIdent},
+		{acmeclient.AuthzID{Type: "email", Value: "a@example.test"}, typeUnsupportedIdent},
+		{acmeclient.AuthzID{Type: "dns", Value: "10.0.0.1"}, typeRejectedIdentifier},
+		{acmeclient.AuthzID{Type: "dns", Value: "::1"}, typeRejectedIdentifier},
+		{acmeclient.AuthzID{Type: "dns", Value: "a@internal"}, typeRejectedIdentifier},
+		{acmeclient.AuthzID{Type: "dns", Value: "host/path"}, typeRejectedIdentifier},
+		{acmeclient.AuthzID{Type: "dns", Value: "host:8080"}, typeRejectedIdentifier},
+		{acmeclient.AuthzID{Type: "dns", Value: "-host.example.test"}, typeRejectedIdentifier},
+		{acmeclient.AuthzID{Type: "dns", Value: "a..example.test"}, typeRejectedIdentifier},
+		{acmeclient.AuthzID{Type: "dns", Value: "*.*.example.test"}, typeRejectedIdentifier},
+		{acmeclient.AuthzID{Type: "dns", Value: ""}, typeRejectedIdentifier},
+	} {
+		if _, err := client.AuthorizeOrder(ctx, []acmeclient.AuthzID{tt.id}); !isProblem(err, tt.problem) {
+			t.Fatalf("%s %q: expected %s, got %v", tt.id.Type, tt.id.Value, tt.problem, err)
+		}
+	}
+	if n := len(a.orders); n != 0 {
+		t.Fatalf("orders = %d, want 0", n)
+	}
+
+	for _, v := range []string{"Host.Example.Test.", "*.example.test", "xn--bcher-kva.example.test"} {
+		if _, err := client.AuthorizeOrder(ctx, acmeclient.DomainIDs(v)); err != nil {
+			t.Fatalf("%q: authorize order: %v", v, err)
+		}
+	}
+}
+
+func TestNonces(t *testing.T) {
+	a, _, _ := newTestACME(t)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	a.Register(r)
+
+	// Only new-nonce and POST requests are given a nonce
+	for _, tt := range []struct {
+		method string
+		target string
+		nonce  bool
+	}{
+		{http.MethodGet, "/acme/directory", false},
+		{http.MethodHead, "/acme/new-nonce", true},
+		{http.MethodGet, "/acme/new-nonce", true},
+		{http.MethodPost, "/acme/new-order", true},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+		if v := w.Header().Get("Replay-Nonce"); (v != "") != tt.nonce {
+			t.Fatalf("%s %s: nonce = %q, want nonce %v", tt.method, tt.target, v, tt.nonce)
+		}
+	}
+
+	// Outstanding nonces are limited, discarding the oldest first
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	first, err := a.nonce()
+	if err != nil {
+		t.Fatalf("nonce: %v", err)
+	}
+	for i := 0; i < maxNonces; i++ {
+		if _, err := a.nonce(); err != nil {
+			t.Fatalf("nonce: %v", err)
+		}
+	}
+	if n := len(a.nonces); n != maxNonces {
+		t.Fatalf("nonces = %d, want %d", n, maxNonces)
+	}
+	if _, ok := a.nonces[first]; ok {
+		t.Fatal("expected the oldest nonce to be discarded")
+	}
+
+	// Expired nonces are discarded when the next one is issued
+	for _, k := range a.nonceQueue {
+		a.nonces[k] = time.Now()
+	}
+	if _, err := a.nonce(); err != nil {
+		t.Fatalf("nonce: %v", err)
+	}
+	if n := len(a.nonces); n != 1 {
+		t.Fatalf("nonces = %d, want 1", n)
+	}
+}
@@ -0,0 +1,44 @@
+package acme
+
+import (
+	"log/slog"
+
+	"github.com/nathan-osman/certy/storage"
+)
+
+// Config provides configuration for ACME.
+type Config struct {
+
+	// Dir is the directory used for storing ACME accounts.
+	Dir string
+
+	// Storage is a pointer to a Storage instance.
+	Storage *storage.Storage
+
+	// CAPath is the path of the CA that signs certificates issued through
+	// ACME.
+	CAPath string
+
+	// Validity is the validity of issued certificates (for example, "90d").
+	// An empty value indicates the default of 90 days.
+	Validity string
+
+	// Profile is the ID of the certificate profile used for certificates
+	// issued through ACME. Its fixed fields take precedence over Validity.
+	Profile string
+
+	// Resolver is the address (host:port) of the DNS server used to verify
+	// dns-01 challenges. An empty value indicates the system resolver.
+	Resolver string
+
+	// HTTPPort is the port used to verify http-01 challenges. A zero value
+	// indicates the default of 80.
+	HTTPPort int
+
+	// TLSPort is the port used to verify tls-alpn-01 challenges. A zero value
+	// indicates the default of 443.
+	TLSPort int
+
+	// Logger can be used to capture log messages.
+	Logger *slog.Logger
+}
@@ -0,0 +1,489 @@
+package acme
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nathan-osman/certy/storage"
+)
+
+func (a *ACME) sendAccount(c *gin.Context, status int, v *account) {
+	u := baseURL(c) + "/account/" + v.ID
+	c.Header("Location", u)
+	c.JSON(status, gin.H{
+		"status":  v.Status,
+		"contact": v.Contact,
+	})
+}
+
+func (a *ACME) newAccount(c *gin.Context) {
+	r := a.verify(c, true)
+	if r == nil {
+		return
+	}
+	var payload struct {
+		Contact            []string `json:"contact"`
+		TOSAgreed          bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting bool     `json:"onlyReturnExisting"`
+	}
+	if !a.decode(c, r, &payload) {
+		return
+	}
+	if r.jwk == nil {
+		a.fail(c, malformed("new accounts must be created with a JWK"))
+		return
+	}
+	k, thumbprint, err := parseJWK(r.jwk)
+	if err != nil {
+		a.fail(c, malformed(err.Error()))
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if v := a.accountByThumbprint(thumbprint); v != nil {
+		a.sendAccount(c, http.StatusOK, v)
+		return
+	}
+	if payload.OnlyReturnExisting {
+		a.fail(c, newProblem(typeAccountDoesNotExist, http.StatusBadRequest, "account does not exist"))
+		return
+	}
+	id, err := randomID()
+	if err != nil {
+		a.fail(c, serverInternal(err))
+		return
+	}
+	v := &account{
+		ID:         id,
+		Status:     statusValid,
+		Contact:    payload.Contact,
+		JWK:        r.jwk,
+		Thumbprint: thumbprint,
+		CreatedAt:  time.Now(),
+		key:        k,
+	}
+	if err := a.saveAccount(v); err != nil {
+		a.fail(c, serverInternal(err))
+		return
+	}
+	a.accounts[v.ID] = v
+	a.sendAccount(c, http.StatusCreated, v)
+}
+
+func (a *ACME) updateAccount(c *gin.Context) {
+	r := a.verify(c, false)
+	if r == nil {
+		return
+	}
+	if r.account.ID != c.Param("id") {
+		a.fail(c, unauthorized("key ID does not match account"))
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if !r.isPostAsGet() {
+		var payload struct {
+			Contact []string `json:"contact"`
+			Status  string   `json:"status"`
+		}
+		if !a.decode(c, r, &payload) {
+			return
+		}
+		if payload.Contact != nil {
+			r.account.Contact = payload.Contact
+		}
+		switch payload.Status {
+		case "":
+		case statusDeactivated:
+			r.account.Status = statusDeactivated
+		default:
+			a.fail(c, malformed("invalid account status"))
+			return
+		}
+		if err := a.saveAccount(r.account); err != nil {
+			a.fail(c, serverInternal(err))
+			return
+		}
+	}
+	a.sendAccount(c, http.StatusOK, r.account)
+}
+
+func (a *ACME) newOrder(c *gin.Context) {
+	r := a.verify(c, false)
+	if r == nil {
+		return
+	}
+	var payload struct {
+		Identifiers []identifier `json:"identifiers"`
+	}
+	if !a.decode(c, r, &payload) {
+		return
+	}
+	if len(payload.Identifiers) == 0 {
+		a.fail(c, malformed("at least one identifier is required"))
+		return
+	}
+	for i, ident := range payload.Identifiers {
+		if ident.Type != identifierDNS {
+			a.fail(c, newProblem(
+				typeUnsupportedIdent,
+				http.StatusBadRequest,
+				"only DNS identifiers are supported",
+			))
+			return
+		}
+		v := strings.ToLower(strings.TrimSuffix(ident.Value, "."))
+		if !validDNSName(v) {
+			a.fail(c, newProblem(
+				typeRejectedIdentifier,
+				http.StatusBadRequest,
+				"invalid DNS identifier: "+ident.Value,
+			))
+			return
+		}
+		payload.Identifiers[i].Value = v
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.purge()
+	id, err := randomID()
+	if err != nil {
+		a.fail(c, serverInternal(err))
+		return
+	}
+	o := &order{
+		id:          id,
+		accountID:   r.account.ID,
+		status:      statusPending,
+		expires:     time.Now().Add(orderLifetime),
+		identifiers: payload.Identifiers,
+	}
+	for _, ident := range payload.Identifiers {
+		z, err := a.newAuthz(o, ident)
+		if err != nil {
+			a.removeOrder(o)
+			a.fail(c, serverInternal(err))
+			return
+		}
+		o.authzIDs = append(o.authzIDs, z.id)
+	}
+	a.orders[o.id] = o
+	u := baseURL(c)
+	c.Header("Location", u+"/order/"+o.id)
+	c.JSON(http.StatusCreated, o.json(u))
+}
+
+func (a *ACME) getOrder(c *gin.Context) {
+	r := a.verify(c, false)
+	if r == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	o, ok := a.orders[c.Param("id")]
+	if !ok || o.accountID != r.account.ID {
+		a.fail(c, notFound())
+		return
+	}
+	c.JSON(http.StatusOK, o.json(baseURL(c)))
+}
+
+func (a *ACME) getAuthz(c *gin.Context) {
+	r := a.verify(c, false)
+	if r == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	z, ok := a.authzs[c.Param("id")]
+	if !ok || z.accountID != r.account.ID {
+		a.fail(c, notFound())
+		return
+	}
+	if !r.isPostAsGet() {
+		var payload struct {
+			Status string `json:"status"`
+		}
+		if !a.decode(c, r, &payload) {
+			return
+		}
+		if payload.Status != statusDeactivated {
+			a.fail(c, malformed("invalid authorization status"))
+			return
+		}
+		z.status = statusDeactivated
+		if o, ok := a.orders[z.orderID]; ok {
+			o.status = statusInvalid
+		}
+	}
+	c.JSON(http.StatusOK, z.json(baseURL(c)))
+}
+
+func (a *ACME) respondChallenge(c *gin.Context) {
+	r := a.verify(c, false)
+	if r == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	ch, ok := a.challenges[c.Param("id")]
+	if !ok {
+		a.fail(c, notFound())
+		return
+	}
+	z := a.authzs[ch.authzID]
+	if z.accountID != r.account.ID {
+		a.fail(c, notFound())
+		return
+	}
+
+	// An empty object indicates that the client is ready for validation; an
+	// empty payload is simply a request for the challenge's status
+	if !r.isPostAsGet() && ch.status == statusPending && z.status == statusPending {
+		ch.status = statusProcessing
+		go a.validate(
+			ch,
+			z.identifier.Value,
+			keyAuthorization(ch.token, r.account.Thumbprint),
+		)
+	}
+	u := baseURL(c)
+	c.Header("Link", `<`+u+`/authz/`+z.id+`>;rel="up"`)
+	c.JSON(http.StatusOK, ch.json(u))
+}
+
+// csrMatchesOrder checks that the names requested in the CSR are exactly the
+// identifiers in the order.
+func csrMatchesOrder(r *x509.CertificateRequest, o *order) bool {
+	if len(r.IPAddresses) != 0 ||
+		len(r.EmailAddresses) != 0 ||
+		len(r.URIs) != 0 {
+		return false
+	}
+	names := []string{}
+	for _, n := range r.DNSNames {
+		names = append(names, strings.ToLower(n))
+	}
+	if cn := strings.ToLower(r.Subject.CommonName); cn != "" &&
+		!slices.Contains(names, cn) {
+		names = append(names, cn)
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+	idents := []string{}
+	for _, i := range o.identifiers {
+		idents = append(idents, i.Value)
+	}
+	slices.Sort(idents)
+	idents = slices.Compact(idents)
+	return slices.Equal(names, idents)
+}
+
+func (a *ACME) finalize(c *gin.Context) {
+	r := a.verify(c, false)
+	if r == nil {
+		return
+	}
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	if !a.decode(c, r, &payload) {
+		return
+	}
+	der, err := b64.DecodeString(payload.CSR)
+	if err != nil {
+		a.fail(c, newProblem(typeBadCSR, http.StatusBadRequest, "invalid CSR encoding"))
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		a.fail(c, newProblem(typeBadCSR, http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	o, ok := a.orders[c.Param("id")]
+	if !ok || o.accountID != r.account.ID {
+		a.fail(c, notFound())
+		return
+	}
+	if o.status != statusReady {
+		a.fail(c, newProblem(typeOrderNotReady, http.StatusForbidden, "order is not ready"))
+		return
+	}
+	if !csrMatchesOrder(csr, o) {
+		a.fail(c, newProblem(
+			typeBadCSR,
+			http.StatusBadRequest,
+			"CSR names do not match the order identifiers",
+		))
+		return
+	}
+
+	// Use the first identifier as the common name unless the CSR included one
+	names := []string{}
+	for _, i := range o.identifiers {
+		names = append(names, i.Value)
+	}
+	cn := strings.ToLower(csr.Subject.CommonName)
+	if cn == "" {
+		cn = names[0]
+	}
+	var (
+		params = storage.CreateCertificateParams{
+			ServerAuth: true,
+			ClientAuth: true,
+		}
+		fixedValidity bool
+	)
+	if a.profile != "" {
+		p, err := a.storage.GetProfile(a.profile)
+		if err != nil {
+			a.fail(c, serverInternal(err))
+			return
+		}
+		params = *p.Params()
+		fixedValidity = p.IsFixed("validity")
+	}
+	params.CommonName = cn
+	if !fixedValidity {
+		params.Validity = a.validity
+	}
+	params.SANs = strings.Join(names, " ")
+	v, err := a.storage.SignCSR(a.caPath, &storage.SignCSRParams{
+		CreateCertificateParams: params,
+		CSR: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE REQUEST",
+			Bytes: der,
+		})),
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidInput) {
+			a.fail(c, newProblem(typeBadCSR, http.StatusBadRequest, err.Error()))
+		} else {
+			a.fail(c, serverInternal(err))
+		}
+		return
+	}
+	r.account.Certificates = append(r.account.Certificates, v.Path)
+	if err := a.saveAccount(r.account); err != nil {
+		a.fail(c, serverInternal(err))
+		return
+	}
+	o.status = statusValid
+	o.certID = v.ID
+	u := baseURL(c)
+	c.Header("Location", u+"/order/"+o.id)
+	c.JSON(http.StatusOK, o.json(u))
+}
+
+func (a *ACME) getCert(c *gin.Context) {
+	r := a.verify(c, false)
+	if r == nil {
+		return
+	}
+	a.mutex.Lock()
+	p, ok := r.account.ownsCert(c.Param("id"))
+	a.mutex.Unlock()
+	if !ok {
+		a.fail(c, notFound())
+		return
+	}
+	v, err := a.storage.GetCertificate(p)
+	if err != nil {
+		a.fail(c, notFound())
+		return
+	}
+
+	// The chain starts with the certificate itself and includes all of its
+	// parents except for the root
+	b := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: v.X509.Raw,
+	})
+	for i := len(v.Parents) - 1; i > 0; i-- {
+		b = append(b, pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: v.Parents[i].X509.Raw,
+		})...)
+	}
+	c.Data(http.StatusOK, mimeChain, b)
+}
+
+func (a *ACME) revokeCert(c *gin.Context) {
+	r := a.verify(c, true)
+	if r == nil {
+		return
+	}
+	var payload struct {
+		Certificate string `json:"certificate"`
+		Reason      int    `json:"reason"`
+	}
+	if !a.decode(c, r, &payload) {
+		return
+	}
+	der, err := b64.DecodeString(payload.Certificate)
+	if err != nil {
+		a.fail(c, malformed("invalid certificate encoding"))
+		return
+	}
+	v, err := a.storage.FindCertificate(der)
+	if err != nil {
+		a.fail(c, notFound())
+		return
+	}
+
+	// The request must be signed by the account that ordered the certificate
+	// or by the certificate's key (RFC 8555, section 7.6)
+	if r.account != nil {
+		a.mutex.Lock()
+		_, ok := r.account.ownsCert(v.ID)
+		a.mutex.Unlock()
+		if !ok {
+			a.fail(c, unauthorized("certificate was not issued to this account"))
+			return
+		}
+	} else {
+		k, _, err := parseJWK(r.jwk)
+		if err != nil {
+			a.fail(c, malformed(err.Error()))
+			return
+		}
+		pub, ok := v.X509.PublicKey.(interface{ Equal(any) bool })
+		if !ok || !pub.Equal(k) {
+			a.fail(c, unauthorized("JWK does not match the certificate"))
+			return
+		}
+	}
+	if _, ok := storage.ReasonNames[payload.Reason]; !ok {
+		a.fail(c, newProblem(typeBadRevocationReason, http.StatusBadRequest, "unsupported revocation reason"))
+		return
+	}
+	if v.Revocation != nil {
+		a.fail(c, newProblem(typeAlreadyRevoked, http.StatusBadRequest, "certificate is already revoked"))
+		return
+	}
+	if err := a.storage.RevokeCertificate(v.Path, &storage.RevokeCertificateParams{
+		Reason: payload.Reason,
+	}); err != nil {
+		if errors.Is(err, storage.ErrInvalidInput) {
+			a.fail(c, malformed(err.Error()))
+		} else {
+			a.fail(c, serverInternal(err))
+		}
+		return
+	}
+	c.Status(http.StatusOK)
+}
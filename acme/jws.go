@@ -0,0 +1,188 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	errUnsupportedKey = errors.New("unsupported JWK key type")
+	errInvalidKey     = errors.New("invalid JWK")
+	errBadSignature   = errors.New("JWS signature is invalid")
+	errUnsupportedAlg = errors.New("unsupported JWS algorithm")
+)
+
+var b64 = base64.RawURLEncoding
+
+// jwsMessage is a JWS in flattened JSON serialization (RFC 7515, section
+// 7.2.2), which is the only serialization permitted by ACME.
+type jwsMessage struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// jwsHeader contains the protected header fields used by ACME.
+type jwsHeader struct {
+	Alg   string          `json:"alg"`
+	Nonce string          `json:"nonce"`
+	URL   string          `json:"url"`
+	KID   string          `json:"kid"`
+	JWK   json.RawMessage `json:"jwk"`
+}
+
+// jwk contains the members of a JSON Web Key needed for the supported key
+// types.
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(v string) (*big.Int, error) {
+	b, err := b64.DecodeString(v)
+	if err != nil || len(b) == 0 {
+		return nil, errInvalidKey
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// parseJWK parses the public key and computes its RFC 7638 thumbprint.
+func parseJWK(raw []byte) (crypto.PublicKey, string, error) {
+	k := &jwk{}
+	if err := json.Unmarshal(raw, k); err != nil {
+		return nil, "", errInvalidKey
+	}
+	var (
+		pub       crypto.PublicKey
+		canonical string
+	)
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, "", err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, "", errInvalidKey
+		}
+		pub = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, k.E, k.N)
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, "", errUnsupportedKey
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, "", err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, "", err
+		}
+		v := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		if _, err := v.ECDH(); err != nil {
+			return nil, "", errInvalidKey
+		}
+		pub = v
+		canonical = fmt.Sprintf(
+			`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`,
+			k.Crv, k.X, k.Y,
+		)
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, "", errUnsupportedKey
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, "", errInvalidKey
+		}
+		pub = ed25519.PublicKey(x)
+		canonical = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`, k.X)
+	default:
+		return nil, "", errUnsupportedKey
+	}
+	h := sha256.Sum256([]byte(canonical))
+	return pub, b64.EncodeToString(h[:]), nil
+}
+
+// verifySignature checks the JWS signature over the signing input using the
+// algorithm from the protected header.
+func verifySignature(pub crypto.PublicKey, alg string, input, sig []byte) error {
+	switch alg {
+	case "RS256":
+		k, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return errUnsupportedAlg
+		}
+		h := sha256.Sum256(input)
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig) != nil {
+			return errBadSignature
+		}
+		return nil
+	case "ES256", "ES384", "ES512":
+		k, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return errUnsupportedAlg
+		}
+		var digest []byte
+		switch {
+		case alg == "ES256" && k.Curve == elliptic.P256():
+			h := sha256.Sum256(input)
+			digest = h[:]
+		case alg == "ES384" && k.Curve == elliptic.P384():
+			h := sha512.Sum384(input)
+			digest = h[:]
+		case alg == "ES512" && k.Curve == elliptic.P521():
+			h := sha512.Sum512(input)
+			digest = h[:]
+		default:
+			return errUnsupportedAlg
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errBadSignature
+		}
+		var (
+			r = new(big.Int).SetBytes(sig[:size])
+			s = new(big.Int).SetBytes(sig[size:])
+		)
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errBadSignature
+		}
+		return nil
+	case "EdDSA":
+		k, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return errUnsupportedAlg
+		}
+		if !ed25519.Verify(k, input, sig) {
+			return errBadSignature
+		}
+		return nil
+	default:
+		return errUnsupportedAlg
+	}
+}
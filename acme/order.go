@@ -0,0 +1,229 @@
+package acme
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	statusPending    = "pending"
+	statusProcessing = "processing"
+	statusReady      = "ready"
+	statusInvalid    = "invalid"
+
+	typeHTTP01    = "http-01"
+	typeDNS01     = "dns-01"
+	typeTLSALPN01 = "tls-alpn-01"
+
+	identifierDNS = "dns"
+)
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// validDNSName indicates whether v is a lowercase hostname that may be used
+// as a DNS identifier, optionally with a leading wildcard label. IP
+// addresses, email addresses, URIs and anything else that is not a hostname
+// are rejected since the value is used to connect to the host during
+// validation and is later parsed as a SAN.
+func validDNSName(v string) bool {
+	v = strings.TrimPrefix(v, "*.")
+	if len(v) > 253 || net.ParseIP(v) != nil {
+		return false
+	}
+	for _, l := range strings.Split(v, ".") {
+		if l == "" || len(l) > 63 || l[0] == '-' || l[len(l)-1] == '-' {
+			return false
+		}
+		for _, r := range l {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+type order struct {
+	id          string
+	accountID   string
+	status      string
+	expires     time.Time
+	identifiers []identifier
+	authzIDs    []string
+	certID      string
+	err         *problem
+}
+
+type authz struct {
+	id         string
+	accountID  string
+	orderID    string
+	identifier identifier
+	wildcard   bool
+	status     string
+	expires    time.Time
+	challenges []*challenge
+}
+
+type challenge struct {
+	id        string
+	authzID   string
+	typ       string
+	token     string
+	status    string
+	validated time.Time
+	err       *problem
+}
+
+func (o *order) json(base string) map[string]any {
+	authzs := []string{}
+	for _, id := range o.authzIDs {
+		authzs = append(authzs, base+"/authz/"+id)
+	}
+	v := map[string]any{
+		"status":         o.status,
+		"expires":        o.expires.UTC().Format(time.RFC3339),
+		"identifiers":    o.identifiers,
+		"authorizations": authzs,
+		"finalize":       base + "/order/" + o.id + "/finalize",
+	}
+	if o.certID != "" {
+		v["certificate"] = base + "/cert/" + o.certID
+	}
+	if o.err != nil {
+		v["error"] = o.err
+	}
+	return v
+}
+
+func (c *challenge) json(base string) map[string]any {
+	v := map[string]any{
+		"type":   c.typ,
+		"url":    base + "/chall/" + c.id,
+		"status": c.status,
+		"token":  c.token,
+	}
+	if !c.validated.IsZero() {
+		v["validated"] = c.validated.UTC().Format(time.RFC3339)
+	}
+	if c.err != nil {
+		v["error"] = c.err
+	}
+	return v
+}
+
+func (z *authz) json(base string) map[string]any {
+	challenges := []map[string]any{}
+	for _, c := range z.challenges {
+		challenges = append(challenges, c.json(base))
+	}
+	v := map[string]any{
+		"status":     z.status,
+		"expires":    z.expires.UTC().Format(time.RFC3339),
+		"identifier": z.identifier,
+		"challenges": challenges,
+	}
+	if z.wildcard {
+		v["wildcard"] = true
+	}
+	return v
+}
+
+// newAuthz creates an authorization (and its challenges) for the identifier;
+// it must be called with the mutex held.
+func (a *ACME) newAuthz(o *order, ident identifier) (*authz, error) {
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	z := &authz{
+		id:         id,
+		accountID:  o.accountID,
+		orderID:    o.id,
+		identifier: ident,
+		status:     statusPending,
+		expires:    o.expires,
+	}
+
+	// Wildcard identifiers can only be validated with dns-01 and the
+	// authorization is for the base domain
+	types := []string{typeHTTP01, typeDNS01, typeTLSALPN01}
+	if strings.HasPrefix(ident.Value, "*.") {
+		z.identifier.Value = ident.Value[2:]
+		z.wildcard = true
+		types = []string{typeDNS01}
+	}
+	for _, t := range types {
+		id, err := randomID()
+		if err != nil {
+			return nil, err
+		}
+		token, err := randomID()
+		if err != nil {
+			return nil, err
+		}
+		z.challenges = append(z.challenges, &challenge{
+			id:      id,
+			authzID: z.id,
+			typ:     t,
+			token:   b64.EncodeToString([]byte(token)),
+			status:  statusPending,
+		})
+	}
+	for _, c := range z.challenges {
+		a.challenges[c.id] = c
+	}
+	a.authzs[z.id] = z
+	return z, nil
+}
+
+// updateOrder recalculates the status of the order from its authorizations;
+// it must be called with the mutex held.
+func (a *ACME) updateOrder(o *order) {
+	if o.status != statusPending {
+		return
+	}
+	ready := true
+	for _, id := range o.authzIDs {
+		switch a.authzs[id].status {
+		case statusInvalid:
+			o.status = statusInvalid
+			return
+		case statusValid:
+		default:
+			ready = false
+		}
+	}
+	if ready {
+		o.status = statusReady
+	}
+}
+
+// purge removes expired orders along with their authorizations and
+// challenges; it must be called with the mutex held.
+func (a *ACME) purge() {
+	n := time.Now()
+	for _, o := range a.orders {
+		if !n.Before(o.expires) {
+			a.removeOrder(o)
+		}
+	}
+}
+
+// removeOrder removes the order along with its authorizations and
+// challenges; it must be called with the mutex held.
+func (a *ACME) removeOrder(o *order) {
+	for _, zid := range o.authzIDs {
+		if z, ok := a.authzs[zid]; ok {
+			for _, c := range z.challenges {
+				delete(a.challenges, c.id)
+			}
+			delete(a.authzs, zid)
+		}
+	}
+	delete(a.orders, o.id)
+}
@@ -0,0 +1,57 @@
+package acme
+
+import (
+	"net/http"
+)
+
+const (
+	errorNamespace = "urn:ietf:params:acme:error:"
+
+	typeAccountDoesNotExist = "accountDoesNotExist"
+	typeAlreadyRevoked      = "alreadyRevoked"
+	typeBadCSR              = "badCSR"
+	typeBadNonce            = "badNonce"
+	typeBadRevocationReason = "badRevocationReason"
+	typeBadSignatureAlg     = "badSignatureAlgorithm"
+	typeConnection          = "connection"
+	typeDNS                 = "dns"
+	typeIncorrectResponse   = "incorrectResponse"
+	typeMalformed           = "malformed"
+	typeOrderNotReady       = "orderNotReady"
+	typeRejectedIdentifier  = "rejectedIdentifier"
+	typeServerInternal      = "serverInternal"
+	typeTLS                 = "tls"
+	typeUnauthorized        = "unauthorized"
+	typeUnsupportedIdent    = "unsupportedIdentifier"
+)
+
+// problem is an RFC 7807 problem document as used by RFC 8555, section 6.7.
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status,omitempty"`
+}
+
+func newProblem(t string, status int, detail string) *problem {
+	return &problem{
+		Type:   errorNamespace + t,
+		Detail: detail,
+		Status: status,
+	}
+}
+
+func malformed(detail string) *problem {
+	return newProblem(typeMalformed, http.StatusBadRequest, detail)
+}
+
+func unauthorized(detail string) *problem {
+	return newProblem(typeUnauthorized, http.StatusForbidden, detail)
+}
+
+func notFound() *problem {
+	return newProblem(typeMalformed, http.StatusNotFound, "the requested resource does not exist")
+}
+
+func serverInternal(err error) *problem {
+	return newProblem(typeServerInternal, http.StatusInternalServerError, err.Error())
+}
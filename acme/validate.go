@@ -0,0 +1,196 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	validationTimeout = 10 * time.Second
+
+	alpnProtocol = "acme-tls/1"
+)
+
+var (
+	// id-pe-acmeIdentifier (RFC 8737, section 3)
+	oidACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+)
+
+// keyAuthorization computes the key authorization for a token (RFC 8555,
+// section 8.1).
+func keyAuthorization(token, thumbprint string) string {
+	return token + "." + thumbprint
+}
+
+func (a *ACME) validateHTTP01(ctx context.Context, domain, keyAuth string) *problem {
+	u := fmt.Sprintf(
+		"http://%s/.well-known/acme-challenge/%s",
+		net.JoinHostPort(domain, strconv.Itoa(a.httpPort)),
+		keyAuth[:strings.Index(keyAuth, ".")],
+	)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return serverInternal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return newProblem(typeConnection, http.StatusBadRequest, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return newProblem(
+			typeIncorrectResponse,
+			http.StatusForbidden,
+			fmt.Sprintf("%s returned status %d", u, resp.StatusCode),
+		)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return newProblem(typeConnection, http.StatusBadRequest, err.Error())
+	}
+	if strings.TrimSpace(string(b)) != keyAuth {
+		return newProblem(
+			typeIncorrectResponse,
+			http.StatusForbidden,
+			"key authorization does not match",
+		)
+	}
+	return nil
+}
+
+func (a *ACME) validateDNS01(ctx context.Context, domain, keyAuth string) *problem {
+	r := net.DefaultResolver
+	if a.resolver != "" {
+		r = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, a.resolver)
+			},
+		}
+	}
+	records, err := r.LookupTXT(ctx, "_acme-challenge."+domain)
+	if err != nil {
+		return newProblem(typeDNS, http.StatusBadRequest, err.Error())
+	}
+	var (
+		h        = sha256.Sum256([]byte(keyAuth))
+		expected = b64.EncodeToString(h[:])
+	)
+	if slices.Contains(records, expected) {
+		return nil
+	}
+	return newProblem(
+		typeIncorrectResponse,
+		http.StatusForbidden,
+		"no TXT record matches the key authorization",
+	)
+}
+
+func (a *ACME) validateTLSALPN01(ctx context.Context, domain, keyAuth string) *problem {
+	d := &tls.Dialer{
+		Config: &tls.Config{
+			ServerName:         domain,
+			NextProtos:         []string{alpnProtocol},
+			InsecureSkipVerify: true,
+		},
+	}
+	conn, err := d.DialContext(
+		ctx,
+		"tcp",
+		net.JoinHostPort(domain, strconv.Itoa(a.tlsPort)),
+	)
+	if err != nil {
+		return newProblem(typeTLS, http.StatusBadRequest, err.Error())
+	}
+	defer conn.Close()
+	state := conn.(*tls.Conn).ConnectionState()
+	if state.NegotiatedProtocol != alpnProtocol {
+		return newProblem(typeTLS, http.StatusBadRequest, "server did not negotiate "+alpnProtocol)
+	}
+	if len(state.PeerCertificates) == 0 {
+		return newProblem(typeTLS, http.StatusBadRequest, "no certificate presented")
+	}
+	return checkALPNCert(state.PeerCertificates[0], domain, keyAuth)
+}
+
+// checkALPNCert verifies the self-signed certificate presented for a
+// tls-alpn-01 challenge (RFC 8737, section 3).
+func checkALPNCert(c *x509.Certificate, domain, keyAuth string) *problem {
+	if len(c.DNSNames) != 1 || !strings.EqualFold(c.DNSNames[0], domain) {
+		return newProblem(
+			typeIncorrectResponse,
+			http.StatusForbidden,
+			"certificate must contain exactly one SAN matching the domain",
+		)
+	}
+	h := sha256.Sum256([]byte(keyAuth))
+	for _, e := range c.Extensions {
+		if !e.Id.Equal(oidACMEIdentifier) {
+			continue
+		}
+		if !e.Critical {
+			break
+		}
+		var v []byte
+		if rest, err := asn1.Unmarshal(e.Value, &v); err != nil || len(rest) != 0 {
+			break
+		}
+		if bytes.Equal(v, h[:]) {
+			return nil
+		}
+	}
+	return newProblem(
+		typeIncorrectResponse,
+		http.StatusForbidden,
+		"certificate does not contain the expected acmeIdentifier extension",
+	)
+}
+
+// validate performs the challenge and updates the status of the challenge,
+// authorization and order.
+func (a *ACME) validate(c *challenge, domain, keyAuth string) {
+	ctx, cancel := context.WithTimeout(context.Background(), validationTimeout)
+	defer cancel()
+	var p *problem
+	switch c.typ {
+	case typeHTTP01:
+		p = a.validateHTTP01(ctx, domain, keyAuth)
+	case typeDNS01:
+		p = a.validateDNS01(ctx, domain, keyAuth)
+	case typeTLSALPN01:
+		p = a.validateTLSALPN01(ctx, domain, keyAuth)
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	z, ok := a.authzs[c.authzID]
+	if !ok {
+		return
+	}
+	if p != nil {
+		c.status = statusInvalid
+		c.err = p
+		z.status = statusInvalid
+		a.logger.Info("challenge failed", "type", c.typ, "domain", domain, "error", p.Detail)
+	} else {
+		c.status = statusValid
+		c.validated = time.Now()
+		z.status = statusValid
+	}
+	if o, ok := a.orders[z.orderID]; ok {
+		a.updateOrder(o)
+	}
+}
@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	filenameUsers = "users.json"
+
+	minPasswordLength = 8
+)
+
+var (
+	// ErrInvalidCredentials indicates that the username or password is
+	// incorrect; the two cases are deliberately not distinguished.
+	ErrInvalidCredentials = errors.New("invalid username or password")
+
+	// ErrUserExists indicates that a user with the username already exists.
+	ErrUserExists = errors.New("user already exists")
+
+	// ErrUserNotFound indicates that the user does not exist.
+	ErrUserNotFound = errors.New("user does not exist")
+
+	errInvalidUsername  = errors.New("username must not be empty or contain whitespace or colons")
+	errPasswordTooShort = errors.New("password must be at least 8 characters")
+
+	// dummyHash is compared against when the user does not exist so that
+	// the response time does not reveal valid usernames
+	dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+)
+
+type user struct {
+	Hash    string    `json:"hash"`
+	Created time.Time `json:"created"`
+	Grants  []Grant   `json:"grants"`
+}
+
+// Auth manages the users permitted to access the web interface and the
+// sessions created when they log in. Users are stored in a JSON file with
+// bcrypt password hashes. The file is reloaded whenever it changes so that
+// users added or removed from the command line take effect immediately. All
+// public methods are safe for use in multiple goroutines.
+type Auth struct {
+	mutex           sync.Mutex
+	filename        string
+	modTime         time.Time
+	users           map[string]*user
+	sessions        map[string]*session
+	sessionLifetime time.Duration
+}
+
+// New creates a new Auth instance.
+func New(cfg *Config) (*Auth, error) {
+	a := &Auth{
+		filename:        filepath.Join(cfg.DataDir, filenameUsers),
+		users:           map[string]*user{},
+		sessions:        map[string]*session{},
+		sessionLifetime: cfg.SessionLifetime,
+	}
+	if a.sessionLifetime == 0 {
+		a.sessionLifetime = defaultSessionLifetime
+	}
+	if err := os.MkdirAll(cfg.DataDir, 0700); err != nil {
+		return nil, err
+	}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// reload reads the user database if it was modified since it was last read;
+// it must be called with the mutex held.
+func (a *Auth) reload() error {
+	i, err := os.Stat(a.filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			a.users = map[string]*user{}
+			a.modTime = time.Time{}
+			return nil
+		}
+		return err
+	}
+	if i.ModTime().Equal(a.modTime) {
+		return nil
+	}
+	b, err := os.ReadFile(a.filename)
+	if err != nil {
+		return err
+	}
+	users := map[string]*user{}
+	if err := json.Unmarshal(b, &users); err != nil {
+		return err
+	}
+
+	// Users created before roles were introduced had full access
+	for _, u := range users {
+		if u.Grants == nil {
+			u.Grants = []Grant{{Role: RoleAdmin}}
+		}
+	}
+	a.users = users
+	a.modTime = i.ModTime()
+
+	// Discard sessions for users that no longer exist
+	for k, v := range a.sessions {
+		if _, ok := a.users[v.username]; !ok {
+			delete(a.sessions, k)
+		}
+	}
+	return nil
+}
+
+// save writes the user database; it must be called with the mutex held.
+func (a *Auth) save() error {
+	b, err := json.MarshalIndent(a.users, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(a.filename, b, 0600); err != nil {
+		return err
+	}
+	i, err := os.Stat(a.filename)
+	if err != nil {
+		return err
+	}
+	a.modTime = i.ModTime()
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", errPasswordTooShort
+	}
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(h), nil
+}
+
+// Usernames returns the names of all users in alphabetical order.
+func (a *Auth) Usernames() ([]string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	v := []string{}
+	for k := range a.users {
+		v = append(v, k)
+	}
+	slices.Sort(v)
+	return v, nil
+}
+
+// AddUser creates a new user with the specified password. The user has no
+// access to any certificates until roles are granted with AddGrant.
+func (a *Auth) AddUser(username, password string) error {
+	if username == "" || strings.ContainsAny(username, ": \t\r\n") {
+		return errInvalidUsername
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.reload(); err != nil {
+		return err
+	}
+	if _, ok := a.users[username]; ok {
+		return ErrUserExists
+	}
+	h, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	a.users[username] = &user{
+		Hash:    h,
+		Created: time.Now(),
+		Grants:  []Grant{},
+	}
+	return a.save()
+}
+
+// SetPassword changes the password for an existing user. All of the user's
+// sessions are ended.
+func (a *Auth) SetPassword(username, password string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.reload(); err != nil {
+		return err
+	}
+	u, ok := a.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	h, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	u.Hash = h
+	if err := a.save(); err != nil {
+		return err
+	}
+	a.endSessions(username)
+	return nil
+}
+
+// RemoveUser deletes the user and ends all of their sessions.
+func (a *Auth) RemoveUser(username string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.reload(); err != nil {
+		return err
+	}
+	if _, ok := a.users[username]; !ok {
+		return ErrUserNotFound
+	}
+	delete(a.users, username)
+	if err := a.save(); err != nil {
+		return err
+	}
+	a.endSessions(username)
+	return nil
+}
+
+// check verifies the password for the user; it must be called with the
+// mutex held.
+func (a *Auth) check(username, password string) error {
+	if err := a.reload(); err != nil {
+		return err
+	}
+	h := dummyHash
+	u, ok := a.users[username]
+	if ok {
+		h = []byte(u.Hash)
+	}
+	if err := bcrypt.CompareHashAndPassword(h, []byte(password)); err != nil || !ok {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// Authenticate verifies the username and password without creating a
+// session.
+func (a *Auth) Authenticate(username, password string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.check(username, password)
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+const (
+	testUsername = "alice"
+	testPassword = "correct horse"
+)
+
+func newTestAuth(t *testing.T, dataDir string) *Auth {
+	t.Helper()
+	a, err := New(&Config{
+		DataDir: dataDir,
+	})
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	return a
+}
+
+func TestUsersAndSessions(t *testing.T) {
+	var (
+		dataDir = t.TempDir()
+		a       = newTestAuth(t, dataDir)
+	)
+
+	if err := a.AddUser(testUsername, "short"); err == nil {
+		t.Fatal("expected short password to be rejected")
+	}
+	if err := a.AddUser("bad name", testPassword); err == nil {
+		t.Fatal("expected username with whitespace to be rejected")
+	}
+	if err := a.AddUser(testUsername, testPassword); err != nil {
+		t.Fatalf("add user: %v", err)
+	}
+	if err := a.AddUser(testUsername, testPassword); !errors.Is(err, ErrUserExists) {
+		t.Fatalf("expected ErrUserExists, got %v", err)
+	}
+
+	// Log in with the wrong and right passwords
+	if _, err := a.Login(testUsername, "wrong password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if _, err := a.Login("bob", testPassword); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials for unknown user, got %v", err)
+	}
+	token, err := a.Login(testUsername, testPassword)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if u, ok := a.Session(token); !ok || u != testUsername {
+		t.Fatalf("expected session for %s, got %q (%t)", testUsername, u, ok)
+	}
+
+	// A second instance (such as the CLI) changing the password must end the
+	// session in the first
+	b := newTestAuth(t, dataDir)
+	if v, err := b.Usernames(); err != nil || !slices.Equal(v, []string{testUsername}) {
+		t.Fatalf("expected [%s], got %v (%v)", testUsername, v, err)
+	}
+	if err := b.SetPassword(testUsername, "battery staple"); err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+	if err := a.Authenticate(testUsername, "battery staple"); err != nil {
+		t.Fatalf("authenticate with new password: %v", err)
+	}
+	token, err = a.Login(testUsername, "battery staple")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	a.Logout(token)
+	if _, ok := a.Session(token); ok {
+		t.Fatal("expected session to end after logout")
+	}
+
+	// Removing the user ends their sessions
+	token, _ = a.Login(testUsername, "battery staple")
+	if err := b.RemoveUser(testUsername); err != nil {
+		t.Fatalf("remove user: %v", err)
+	}
+	if _, ok := a.Session(token); ok {
+		t.Fatal("expected session to end after the user was removed")
+	}
+	if err := b.RemoveUser(testUsername); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+}
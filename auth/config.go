@@ -0,0 +1,16 @@
+package auth
+
+import (
+	"time"
+)
+
+// Config provides configuration for Auth.
+type Config struct {
+
+	// DataDir is the directory used for storing the user database.
+	DataDir string
+
+	// SessionLifetime is the duration a session remains valid after logging
+	// in. A zero value indicates the default of 12 hours.
+	SessionLifetime time.Duration
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// Permission is a set of operations that a user may perform on a
+// certificate.
+type Permission int
+
+const (
+	// PermView allows viewing and exporting a certificate (but not its
+	// private key).
+	PermView Permission = 1 << iota
+
+	// PermIssue allows creating certificates, signing CSRs and revoking
+	// certificates.
+	PermIssue
+
+	// PermExportKey allows exporting private keys, including in PKCS#12
+	// files.
+	PermExportKey
+
+	// PermDelete allows deleting certificates.
+	PermDelete
+)
+
+const (
+	RoleViewer      = "viewer"
+	RoleIssuer      = "issuer"
+	RoleKeyExporter = "key-exporter"
+	RoleAdmin       = "admin"
+)
+
+// Roles lists the names of the roles in order of increasing privilege.
+var Roles = []string{
+	RoleViewer,
+	RoleIssuer,
+	RoleKeyExporter,
+	RoleAdmin,
+}
+
+var rolePermissions = map[string]Permission{
+	RoleViewer:      PermView,
+	RoleIssuer:      PermView | PermIssue,
+	RoleKeyExporter: PermView | PermExportKey,
+	RoleAdmin:       PermView | PermIssue | PermExportKey | PermDelete,
+}
+
+var (
+	// ErrGrantNotFound indicates that the user has not been granted the role
+	// for the path.
+	ErrGrantNotFound = errors.New("role has not been granted for this path")
+
+	errInvalidRole = errors.New("role must be one of: " + strings.Join(Roles, ", "))
+)
+
+// Grant gives a user a role for a certificate and all certificates below it.
+// Path is the certificate's path in the hierarchy; an empty path applies to
+// the whole tree, including the creation of root certificates.
+type Grant struct {
+	Role string `json:"role"`
+	Path string `json:"path"`
+}
+
+// covers indicates whether the grant applies to the certificate path.
+func (g Grant) covers(certPath string) bool {
+	return g.Path == "" ||
+		certPath == g.Path ||
+		strings.HasPrefix(certPath, g.Path+"/")
+}
+
+// Permissions returns the combined permissions the user has been granted for
+// the certificate path.
+func (a *Auth) Permissions(username, certPath string) Permission {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.reload(); err != nil {
+		return 0
+	}
+	u, ok := a.users[username]
+	if !ok {
+		return 0
+	}
+	var p Permission
+	for _, g := range u.Grants {
+		if g.covers(certPath) {
+			p |= rolePermissions[g.Role]
+		}
+	}
+	return p
+}
+
+// Grants returns the roles granted to the user.
+func (a *Auth) Grants(username string) ([]Grant, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	u, ok := a.users[username]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return slices.Clone(u.Grants), nil
+}
+
+// AddGrant grants the user a role for the certificate path.
+func (a *Auth) AddGrant(username string, g Grant) error {
+	if _, ok := rolePermissions[g.Role]; !ok {
+		return errInvalidRole
+	}
+	g.Path = strings.Trim(g.Path, "/")
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.reload(); err != nil {
+		return err
+	}
+	u, ok := a.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if slices.Contains(u.Grants, g) {
+		return nil
+	}
+	u.Grants = append(u.Grants, g)
+	return a.save()
+}
+
+// RemoveGrant removes a role previously granted to the user.
+func (a *Auth) RemoveGrant(username string, g Grant) error {
+	g.Path = strings.Trim(g.Path, "/")
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.reload(); err != nil {
+		return err
+	}
+	u, ok := a.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	i := slices.Index(u.Grants, g)
+	if i == -1 {
+		return ErrGrantNotFound
+	}
+	u.Grants = slices.Delete(u.Grants, i, i+1)
+	return a.save()
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPermissions(t *testing.T) {
+	a := newTestAuth(t, t.TempDir())
+	if err := a.AddUser(testUsername, testPassword); err != nil {
+		t.Fatalf("add user: %v", err)
+	}
+	if p := a.Permissions(testUsername, "aaaaaaaaaaaa"); p != 0 {
+		t.Fatalf("expected no permissions for new user, got %d", p)
+	}
+	if err := a.AddGrant(testUsername, Grant{Role: "owner"}); err == nil {
+		t.Fatal("expected invalid role to be rejected")
+	}
+	for _, g := range []Grant{
+		{Role: RoleViewer},
+		{Role: RoleIssuer, Path: "aaaaaaaaaaaa/bbbbbbbbbbbb/"},
+	} {
+		if err := a.AddGrant(testUsername, g); err != nil {
+			t.Fatalf("add grant: %v", err)
+		}
+	}
+	for _, tc := range []struct {
+		path string
+		perm Permission
+	}{
+		{"", PermView},
+		{"aaaaaaaaaaaa", PermView},
+		{"aaaaaaaaaaaa/bbbbbbbbbbbb", PermView | PermIssue},
+		{"aaaaaaaaaaaa/bbbbbbbbbbbb/cccccccccccc", PermView | PermIssue},
+		{"aaaaaaaaaaaa/bbbbbbbbbbbbdd", PermView},
+	} {
+		if p := a.Permissions(testUsername, tc.path); p != tc.perm {
+			t.Fatalf("%q: expected %d, got %d", tc.path, tc.perm, p)
+		}
+	}
+	if err := a.RemoveGrant(testUsername, Grant{Role: RoleViewer}); err != nil {
+		t.Fatalf("remove grant: %v", err)
+	}
+	if err := a.RemoveGrant(testUsername, Grant{Role: RoleViewer}); !errors.Is(err, ErrGrantNotFound) {
+		t.Fatalf("expected ErrGrantNotFound, got %v", err)
+	}
+	if p := a.Permissions(testUsername, "aaaaaaaaaaaa"); p != 0 {
+		t.Fatalf("expected no permissions after removing grant, got %d", p)
+	}
+}
+
+func TestLegacyUsersAreAdmins(t *testing.T) {
+	dataDir := t.TempDir()
+	if err := os.WriteFile(
+		filepath.Join(dataDir, filenameUsers),
+		[]byte(`{"alice": {"hash": ""}}`),
+		0600,
+	); err != nil {
+		t.Fatal(err)
+	}
+	a := newTestAuth(t, dataDir)
+	all := PermView | PermIssue | PermExportKey | PermDelete
+	if p := a.Permissions(testUsername, ""); p != all {
+		t.Fatalf("expected %d, got %d", all, p)
+	}
+}
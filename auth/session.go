@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	defaultSessionLifetime = 12 * time.Hour
+)
+
+type session struct {
+	username string
+	expires  time.Time
+}
+
+// endSessions removes all sessions belonging to the user; it must be called
+// with the mutex held.
+func (a *Auth) endSessions(username string) {
+	for k, v := range a.sessions {
+		if v.username == username {
+			delete(a.sessions, k)
+		}
+	}
+}
+
+// Login verifies the username and password and creates a new session. The
+// returned token identifies the session in later calls to Session.
+func (a *Auth) Login(username, password string) (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.check(username, password); err != nil {
+		return "", err
+	}
+	n := time.Now()
+	for k, v := range a.sessions {
+		if n.After(v.expires) {
+			delete(a.sessions, k)
+		}
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	t := hex.EncodeToString(b)
+	a.sessions[t] = &session{
+		username: username,
+		expires:  n.Add(a.sessionLifetime),
+	}
+	return t, nil
+}
+
+// Session returns the username for the session identified by the token. The
+// second return value is false if the session does not exist or expired.
+func (a *Auth) Session(token string) (string, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.reload(); err != nil {
+		return "", false
+	}
+	v, ok := a.sessions[token]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(v.expires) {
+		delete(a.sessions, token)
+		return "", false
+	}
+	return v.username, true
+}
+
+// Logout ends the session identified by the token.
+func (a *Auth) Logout(token string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.sessions, token)
+}
@@ -4,8 +4,12 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
+	"github.com/nathan-osman/certy/acme"
+	"github.com/nathan-osman/certy/auth"
 	"github.com/nathan-osman/certy/server"
 	"github.com/nathan-osman/certy/storage"
 	"github.com/nathan-osman/gosvc"
@@ -30,6 +34,64 @@ func main() {
 				EnvVars: []string{"DATA_DIR"},
 				Usage:   "path to data directory",
 			},
+			&cli.DurationFlag{
+				Name:    "crl-validity",
+				Value:   7 * 24 * time.Hour,
+				EnvVars: []string{"CRL_VALIDITY"},
+				Usage:   "duration that generated CRLs remain valid for",
+			},
+			&cli.DurationFlag{
+				Name:    "ocsp-validity",
+				Value:   24 * time.Hour,
+				EnvVars: []string{"OCSP_VALIDITY"},
+				Usage:   "duration that OCSP responses remain valid for",
+			},
+			&cli.BoolFlag{
+				Name:    "ocsp-delegate",
+				EnvVars: []string{"OCSP_DELEGATE"},
+				Usage:   "sign OCSP responses with a delegated signing certificate (always used for Ed25519 CAs)",
+			},
+			&cli.StringFlag{
+				Name:    "passphrase-file",
+				EnvVars: []string{"PASSPHRASE_FILE"},
+				Usage:   "file containing the passphrase for unsealing encrypted keys on startup",
+			},
+			&cli.StringFlag{
+				Name:    "pkcs11-module",
+				EnvVars: []string{"PKCS11_MODULE"},
+				Usage:   "path to a PKCS#11 library for storing keys on a token",
+			},
+			&cli.StringFlag{
+				Name:    "pkcs11-token",
+				EnvVars: []string{"PKCS11_TOKEN"},
+				Usage:   "label of the PKCS#11 token",
+			},
+			&cli.StringFlag{
+				Name:    "pkcs11-pin-file",
+				EnvVars: []string{"PKCS11_PIN_FILE"},
+				Usage:   "file containing the user PIN for the PKCS#11 token",
+			},
+			&cli.StringFlag{
+				Name:    "acme-ca",
+				EnvVars: []string{"ACME_CA"},
+				Usage:   "path of the CA used for ACME (enables ACME)",
+			},
+			&cli.StringFlag{
+				Name:    "acme-validity",
+				Value:   "90d",
+				EnvVars: []string{"ACME_VALIDITY"},
+				Usage:   "validity of certificates issued through ACME",
+			},
+			&cli.StringFlag{
+				Name:    "acme-profile",
+				EnvVars: []string{"ACME_PROFILE"},
+				Usage:   "ID of the certificate profile used for ACME",
+			},
+			&cli.StringFlag{
+				Name:    "acme-resolver",
+				EnvVars: []string{"ACME_RESOLVER"},
+				Usage:   "DNS server (host:port) used for dns-01 challenges",
+			},
 			&cli.BoolFlag{
 				Name:    "debug",
 				EnvVars: []string{"DEBUG"},
@@ -41,23 +103,87 @@ func main() {
 				EnvVars: []string{"SERVER_ADDR"},
 				Usage:   "HTTP address to listen on",
 			},
+			&cli.StringFlag{
+				Name:    "public-url",
+				EnvVars: []string{"PUBLIC_URL"},
+				Usage:   "external URL of the server, embedded in certificates for fetching issuers, OCSP and CRLs",
+			},
 		},
-		Commands: gosvc.Commands(a.Platform()),
+		Commands: append(
+			gosvc.Commands(a.Platform()),
+			userCommand,
+			encryptKeysCommand,
+			unsealCommand,
+			importPKICommand,
+		),
 		Action: func(c *cli.Context) error {
 
+			// Read the passphrase for unsealing keys if one was provided
+			passphrase, err := readSecretFile(c.String("passphrase-file"))
+			if err != nil {
+				return err
+			}
+
+			// Configure the PKCS#11 token if a module was provided
+			var pkcs11 *storage.PKCS11Config
+			if v := c.String("pkcs11-module"); v != "" {
+				pin, err := readSecretFile(c.String("pkcs11-pin-file"))
+				if err != nil {
+					return err
+				}
+				pkcs11 = &storage.PKCS11Config{
+					Module:     v,
+					TokenLabel: c.String("pkcs11-token"),
+					PIN:        pin,
+				}
+			}
+
 			// Create the storage instance
 			st, err := storage.New(&storage.Config{
+				DataDir:      c.String("data-dir"),
+				CRLValidity:  c.Duration("crl-validity"),
+				OCSPValidity: c.Duration("ocsp-validity"),
+				OCSPDelegate: c.Bool("ocsp-delegate"),
+				Passphrase:   passphrase,
+				PKCS11:       pkcs11,
+			})
+			if err != nil {
+				return err
+			}
+			defer st.Close()
+
+			// Create the auth instance for logging in
+			au, err := auth.New(&auth.Config{
 				DataDir: c.String("data-dir"),
 			})
 			if err != nil {
 				return err
 			}
 
+			// Create the ACME instance if a CA was specified
+			var ac *acme.ACME
+			if v := c.String("acme-ca"); v != "" {
+				ac, err = acme.New(&acme.Config{
+					Dir:      filepath.Join(c.String("data-dir"), "acme"),
+					Storage:  st,
+					CAPath:   v,
+					Validity: c.String("acme-validity"),
+					Profile:  c.String("acme-profile"),
+					Resolver: c.String("acme-resolver"),
+				})
+				if err != nil {
+					return err
+				}
+			}
+
 			// Start the server
 			s, err := server.New(&server.Config{
-				Addr:    c.String("server-addr"),
-				Debug:   c.Bool("debug"),
-				Storage: st,
+				ACME:      ac,
+				Auth:      au,
+				Addr:      c.String("server-addr"),
+				Debug:     c.Bool("debug"),
+				PublicURL: c.String("public-url"),
+				Storage:   st,
 			})
 			if err != nil {
 				return err
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nathan-osman/certy/storage"
+	"github.com/urfave/cli/v2"
+)
+
+var importPKICommand = &cli.Command{
+	Name:      "import-pki",
+	Usage:     "import an OpenSSL CA directory or easy-rsa PKI",
+	ArgsUsage: "DIR",
+	Description: "Imports the certificates and private keys in DIR along with the serials and " +
+		"revocations listed in index.txt, then prints a report of anything that could " +
+		"not be mapped. Certy must not be running. Running this again imports anything " +
+		"that was added since.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "key-password-file",
+			Usage: "file containing the password for encrypted private keys",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.ShowSubcommandHelp(c)
+		}
+		passphrase, err := readSecretFile(c.String("passphrase-file"))
+		if err != nil {
+			return err
+		}
+		password, err := readSecretFile(c.String("key-password-file"))
+		if err != nil {
+			return err
+		}
+		st, err := storage.New(&storage.Config{
+			DataDir:    c.String("data-dir"),
+			Passphrase: passphrase,
+		})
+		if err != nil {
+			return err
+		}
+		r, err := st.ImportPKI(&storage.ImportPKIParams{
+			Dir:      c.Args().First(),
+			Password: password,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %d certificates\n", len(r.Imported))
+		for _, v := range r.Imported {
+			fmt.Printf("  %s  %s\n", v.Path, v.X509.Subject)
+		}
+		if len(r.Existing) != 0 {
+			fmt.Printf("Already managed: %d certificates\n", len(r.Existing))
+		}
+		fmt.Printf("Carried over %d serials and %d revocations\n", r.Serials, r.Revocations)
+		if len(r.Problems) != 0 {
+			fmt.Printf("Could not map:\n")
+			for _, v := range r.Problems {
+				fmt.Printf("  - %s\n", v)
+			}
+		}
+		return nil
+	},
+}
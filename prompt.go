@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// readSecret prompts for a secret without echoing it when stdin is a
+// terminal, asking twice if confirm is true; otherwise a single line is read
+// so that secrets can be piped in.
+func readSecret(name string, confirm bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		l, err := stdin.ReadString('\n')
+		if err != nil && l == "" {
+			return "", err
+		}
+		return strings.TrimRight(l, "\r\n"), nil
+	}
+	fmt.Fprintf(os.Stderr, "%s: ", name)
+	v1, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if !confirm {
+		return string(v1), nil
+	}
+	fmt.Fprintf(os.Stderr, "Confirm %s: ", strings.ToLower(name))
+	v2, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(v1) != string(v2) {
+		return "", fmt.Errorf("%ss do not match", strings.ToLower(name))
+	}
+	return string(v1), nil
+}
+
+// readSecretFile reads a secret from the first line of a file; an empty
+// filename results in an empty secret.
+func readSecretFile(filename string) (string, error) {
+	if filename == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/nathan-osman/certy/storage"
+	"github.com/urfave/cli/v2"
+)
+
+var encryptKeysCommand = &cli.Command{
+	Name:  "encrypt-keys",
+	Usage: "encrypt all private keys in the data directory with a passphrase",
+	Description: "Encrypts existing plaintext keys in place and stores all new keys encrypted. " +
+		"Certy must not be running. Running this again with the same passphrase " +
+		"encrypts any keys that remain in plaintext.",
+	Action: func(c *cli.Context) error {
+		st, err := storage.New(&storage.Config{
+			DataDir: c.String("data-dir"),
+		})
+		if err != nil {
+			return err
+		}
+		p, err := readSecret("Passphrase", !st.Encrypted())
+		if err != nil {
+			return err
+		}
+		if err := st.EncryptKeys(p); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "All private keys are encrypted.")
+		return nil
+	},
+}
+
+var unsealCommand = &cli.Command{
+	Name:  "unseal",
+	Usage: "unseal private keys on a running server",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "url",
+			Value: "http://localhost:8000",
+			Usage: "URL of the running server",
+		},
+		&cli.StringFlag{
+			Name:     "user",
+			Required: true,
+			Usage:    "username to authenticate with",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		password, err := readSecret("Password", false)
+		if err != nil {
+			return err
+		}
+		passphrase, err := readSecret("Passphrase", false)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(map[string]string{"passphrase": passphrase})
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(
+			http.MethodPost,
+			strings.TrimRight(c.String("url"), "/")+"/api/v1/unseal",
+			bytes.NewReader(b),
+		)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth(c.String("user"), password)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			v := &struct {
+				Error string `json:"error"`
+			}{}
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil || v.Error == "" {
+				return fmt.Errorf("server returned %s", resp.Status)
+			}
+			return errors.New(v.Error)
+		}
+		fmt.Fprintln(os.Stderr, "Private keys are unsealed.")
+		return nil
+	},
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nathan-osman/certy/storage"
+)
+
+var (
+	certPathRegExp = regexp.MustCompile(`^[0-9a-f]{12}(?:/[0-9a-f]{12})*$`)
+
+	errNotACA = errors.New("certificate is not a CA")
+)
+
+// issuerURLs returns the URLs of the endpoints below for the issuer, which
+// is identified by its key ID where possible so that the URLs keep working
+// when it is renewed with the same key.
+func issuerURLs(base string) func(*storage.Ref) *storage.IssuerURLs {
+	base = strings.TrimSuffix(base, "/")
+	return func(issuer *storage.Ref) *storage.IssuerURLs {
+		ref := issuer.Path
+		if len(issuer.X509.SubjectKeyId) != 0 {
+			ref = hex.EncodeToString(issuer.X509.SubjectKeyId)
+		}
+		return &storage.IssuerURLs{
+			CAIssuers: fmt.Sprintf("%s/ca/%s.crt", base, ref),
+			OCSP:      fmt.Sprintf("%s/ocsp", base),
+			CRL:       fmt.Sprintf("%s/crl/%s.crl", base, ref),
+		}
+	}
+}
+
+// publicCA looks up the CA in the "ref" parameter, which is either its path
+// or its key ID in hex followed by the extension.
+func (s *Server) publicCA(c *gin.Context, ext string) (*storage.Certificate, bool) {
+	var (
+		ref  = strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(c.Param("ref"), "/"), ext))
+		cert *storage.Certificate
+		err  error
+	)
+	if certPathRegExp.MatchString(ref) {
+		cert, err = s.storage.GetCertificate(ref)
+		if err == nil && !cert.MaySign() {
+			err = errNotACA
+		}
+	} else if keyID, e := hex.DecodeString(ref); e == nil {
+		cert, err = s.storage.GetCAByKeyID(keyID)
+	} else {
+		err = errNotACA
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound), errors.Is(err, errNotACA):
+			c.String(http.StatusNotFound, "CA not found\n")
+		default:
+			s.logger.Error(err.Error())
+			c.String(http.StatusInternalServerError, "unable to load the CA\n")
+		}
+		return nil, false
+	}
+	return cert, true
+}
+
+// caCert serves the DER-encoded certificate of a CA for the AIA caIssuers
+// URL of the certificates it issued.
+func (s *Server) caCert(c *gin.Context) {
+	cert, ok := s.publicCA(c, ".crt")
+	if !ok {
+		return
+	}
+	c.Data(http.StatusOK, "application/pkix-cert", cert.X509.Raw)
+}
+
+// caCRL serves the current DER-encoded CRL of a CA for the CRL distribution
+// point of the certificates it issued.
+func (s *Server) caCRL(c *gin.Context) {
+	cert, ok := s.publicCA(c, ".crl")
+	if !ok {
+		return
+	}
+	b, err := s.storage.ExportCRLDER(cert.Path)
+	if err != nil {
+		if errors.Is(err, storage.ErrSealed) {
+			c.String(http.StatusServiceUnavailable, "the CRL cannot be signed while sealed\n")
+			return
+		}
+		s.logger.Error(err.Error())
+		c.String(http.StatusInternalServerError, "unable to generate the CRL\n")
+		return
+	}
+	c.Data(http.StatusOK, "application/pkix-crl", b)
+}
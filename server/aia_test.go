@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bytes"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/nathan-osman/certy/storage"
+)
+
+const testPublicURL = "http://certy.example.test"
+
+// fetch requests the URL from the server, which must be below testPublicURL,
+// and returns the body after checking the status and content type.
+func (ts *testServer) fetch(u, contentType string) []byte {
+	ts.t.Helper()
+	if !strings.HasPrefix(u, testPublicURL+"/") {
+		ts.t.Fatalf("URL %s is not below %s", u, testPublicURL)
+	}
+	req := httptest.NewRequest(http.MethodGet, strings.TrimPrefix(u, testPublicURL), nil)
+	w := httptest.NewRecorder()
+	ts.handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != contentType {
+		ts.t.Fatalf("GET %s = %d (%s), want 200 (%s)", u, w.Code, w.Header().Get("Content-Type"), contentType)
+	}
+	return w.Body.Bytes()
+}
+
+func TestIssuerURLs(t *testing.T) {
+	ts := newTestServer(t)
+	ts.storage.SetIssuerURLs(issuerURLs(testPublicURL + "/"))
+	leaf, err := ts.storage.CreateCertificate(ts.inter.Path, &storage.CreateCertificateParams{
+		CommonName: "www.example.com",
+		Validity:   "30m",
+		ServerAuth: true,
+		KeyType:    storage.KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	if err := ts.storage.RevokeCertificate(ts.leaf.Path, &storage.RevokeCertificateParams{
+		Reason: storage.ReasonKeyCompromise,
+	}); err != nil {
+		t.Fatalf("revoke certificate: %v", err)
+	}
+	x := leaf.X509
+	if len(x.IssuingCertificateURL) != 1 || len(x.CRLDistributionPoints) != 1 || len(x.OCSPServer) != 1 {
+		t.Fatalf("AIA %v, OCSP %v and CDP %v, want one of each",
+			x.IssuingCertificateURL, x.OCSPServer, x.CRLDistributionPoints)
+	}
+
+	// The caIssuers URL serves the issuer, which verifies the certificate
+	issuer, err := x509.ParseCertificate(ts.fetch(x.IssuingCertificateURL[0], "application/pkix-cert"))
+	if err != nil {
+		t.Fatalf("parse issuer: %v", err)
+	}
+	if !bytes.Equal(issuer.Raw, ts.inter.X509.Raw) {
+		t.Fatal("expected the AIA URL to serve the intermediate CA")
+	}
+	if err := x.CheckSignatureFrom(issuer); err != nil {
+		t.Fatalf("check signature: %v", err)
+	}
+
+	// The CRL distribution point serves the issuer's CRL with the revoked
+	// certificate
+	crl, err := x509.ParseRevocationList(ts.fetch(x.CRLDistributionPoints[0], "application/pkix-crl"))
+	if err != nil {
+		t.Fatalf("parse CRL: %v", err)
+	}
+	if err := crl.CheckSignatureFrom(issuer); err != nil {
+		t.Fatalf("check CRL signature: %v", err)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 ||
+		crl.RevokedCertificateEntries[0].SerialNumber.Cmp(ts.leaf.X509.SerialNumber) != 0 {
+		t.Fatalf("CRL lists %d certificates, want the revoked leaf", len(crl.RevokedCertificateEntries))
+	}
+
+	// The URLs identify the issuer by key ID, so they are the same for
+	// every certificate it issues
+	u, err := url.Parse(x.IssuingCertificateURL[0])
+	if err != nil {
+		t.Fatalf("parse AIA URL: %v", err)
+	}
+	if !strings.HasPrefix(u.Path, "/ca/") || strings.Contains(u.Path, ts.inter.Path) {
+		t.Fatalf("AIA URL %s does not identify the issuer by key ID", u)
+	}
+
+	// Certificates that are not CAs and unknown CAs are not served
+	for _, target := range []string{
+		"/ca/" + ts.leaf.Path + ".crt",
+		"/crl/" + ts.leaf.Path + ".crl",
+		"/ca/00112233445566778899aabbccddeeff00112233.crt",
+		"/crl/invalid.crl",
+	} {
+		w := httptest.NewRecorder()
+		ts.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("GET %s = %d, want 404", target, w.Code)
+		}
+	}
+}
@@ -0,0 +1,764 @@
+package server
+
+import (
+	"crypto/x509/pkix"
+	_ "embed"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/nathan-osman/certy/auth"
+	"github.com/nathan-osman/certy/storage"
+)
+
+var (
+	//go:embed openapi.json
+	openAPIDoc []byte
+
+	apiPathRegExp = regexp.MustCompile(
+		`^/([0-9a-f]{12}(?:/[0-9a-f]{12})*)(?:/(\w+))?$`,
+	)
+
+	errAPINotFound         = errors.New("the requested resource does not exist")
+	errAPIMethodNotAllowed = errors.New("method not allowed for this resource")
+	errAPIInvalidData      = errors.New("data must be PEM-encoded or base64-encoded")
+)
+
+// apiName is the JSON representation of an X.509 distinguished name.
+type apiName struct {
+	CommonName         string   `json:"commonName"`
+	Organization       []string `json:"organization,omitempty"`
+	OrganizationalUnit []string `json:"organizationalUnit,omitempty"`
+	Country            []string `json:"country,omitempty"`
+	Province           []string `json:"province,omitempty"`
+	Locality           []string `json:"locality,omitempty"`
+	StreetAddress      []string `json:"streetAddress,omitempty"`
+	PostalCode         []string `json:"postalCode,omitempty"`
+	String             string   `json:"string"`
+}
+
+// apiRef is the JSON representation of a reference to a certificate.
+type apiRef struct {
+	ID         string    `json:"id"`
+	Path       string    `json:"path"`
+	CommonName string    `json:"commonName"`
+	IsCA       bool      `json:"isCA"`
+	NotBefore  time.Time `json:"notBefore"`
+	NotAfter   time.Time `json:"notAfter"`
+}
+
+// apiKey is the JSON representation of a private key.
+type apiKey struct {
+	Algorithm string `json:"algorithm"`
+	Curve     string `json:"curve,omitempty"`
+	Size      int    `json:"size"`
+	Token     string `json:"token,omitempty"`
+}
+
+// apiRevocation is the JSON representation of a certificate's revocation.
+type apiRevocation struct {
+	Time   time.Time `json:"time"`
+	Reason int       `json:"reason"`
+	Name   string    `json:"name"`
+}
+
+// apiNameConstraint is the JSON representation of a CA's name constraints
+// for one type of name.
+type apiNameConstraint struct {
+	Type      string   `json:"type"`
+	Permitted []string `json:"permitted"`
+	Excluded  []string `json:"excluded"`
+}
+
+// apiCert is the JSON representation of a certificate.
+type apiCert struct {
+	ID          string         `json:"id"`
+	Path        string         `json:"path"`
+	Fingerprint string         `json:"fingerprint"`
+	Serial      string         `json:"serial"`
+	Subject     *apiName       `json:"subject"`
+	Issuer      *apiName       `json:"issuer"`
+	NotBefore   time.Time      `json:"notBefore"`
+	NotAfter    time.Time      `json:"notAfter"`
+	IsCA        bool           `json:"isCA"`
+	MaxPathLen  int            `json:"maxPathLen"`
+	CanSign     bool           `json:"canSign"`
+	KeyUsage    []string       `json:"keyUsage"`
+	ExtKeyUsage []string       `json:"extKeyUsage"`
+	DNSNames    []string       `json:"dnsNames"`
+	IPAddresses []string       `json:"ipAddresses"`
+	Emails      []string       `json:"emailAddresses"`
+	URIs        []string       `json:"uris"`
+	UPNs        []string       `json:"upns"`
+	PrivateKey  *apiKey        `json:"privateKey"`
+	Revocation  *apiRevocation `json:"revocation"`
+	Parents     []*apiRef      `json:"parents"`
+	Children    []*apiRef      `json:"children"`
+	Previous    *apiRef        `json:"previous,omitempty"`
+	Next        []*apiRef      `json:"next"`
+	Clamped     bool           `json:"clamped,omitempty"`
+
+	NameConstraints         []*apiNameConstraint `json:"nameConstraints"`
+	NameConstraintsCritical bool                 `json:"nameConstraintsCritical"`
+
+	CAIssuers             []string `json:"caIssuers"`
+	OCSPServers           []string `json:"ocspServers"`
+	CRLDistributionPoints []string `json:"crlDistributionPoints"`
+
+	CertificatePolicies []*storage.CertificatePolicy `json:"certificatePolicies"`
+	MustStaple          bool                         `json:"mustStaple"`
+	Extensions          []*apiExtension              `json:"extensions"`
+
+	Lint []*apiLintFinding `json:"lint"`
+}
+
+// apiLintFinding is the JSON representation of a lint rule that a
+// certificate does not pass.
+type apiLintFinding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+// apiExtension is the JSON representation of an extension that is not
+// otherwise interpreted; the value is the DER encoding in hex.
+type apiExtension struct {
+	OID      string `json:"oid"`
+	Critical bool   `json:"critical"`
+	Value    string `json:"value"`
+}
+
+// apiIssued is the JSON representation of an entry in a CA's index of
+// issued certificates.
+type apiIssued struct {
+	Serial   string    `json:"serial"`
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"notAfter"`
+	Status   string    `json:"status"`
+	Path     string    `json:"path,omitempty"`
+}
+
+// apiValidationResult is the JSON representation of the validation result
+// for a single link in the chain.
+type apiValidationResult struct {
+	CommonName string                `json:"commonName"`
+	Valid      bool                  `json:"valid"`
+	Error      string                `json:"error,omitempty"`
+	Checks     []*apiValidationCheck `json:"checks"`
+}
+
+// apiValidationCheck is the JSON representation of a single check made when
+// validating a certificate.
+type apiValidationCheck struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message"`
+}
+
+// apiCheckParams is the JSON body for checking a certificate that is not
+// managed. Binary data (DER, PKCS#7 and PKCS#12) must be base64-encoded.
+type apiCheckParams struct {
+	storage.ValidateParams
+	Certificate string `json:"certificate"`
+	Password    string `json:"password"`
+}
+
+// apiError is the JSON representation of an error. Violations are only
+// included when a certificate is not allowed by its issuer's policy.
+type apiError struct {
+	Error      string                     `json:"error"`
+	Violations []*storage.PolicyViolation `json:"violations,omitempty"`
+}
+
+// apiPKCS12Params is the JSON body for PKCS#12 export.
+type apiPKCS12Params struct {
+	Password  string `json:"password"`
+	UseLegacy bool   `json:"useLegacy"`
+}
+
+// apiImportParams is the JSON body for importing a certificate. Binary data
+// (DER and PKCS#12) must be base64-encoded; PEM data may be sent as-is.
+type apiImportParams struct {
+	Certificate string `json:"certificate"`
+	PrivateKey  string `json:"privateKey"`
+	Password    string `json:"password"`
+}
+
+// decodeAPIData returns PEM data as-is and decodes anything else as base64.
+func decodeAPIData(v string) ([]byte, error) {
+	if v == "" || strings.Contains(v, "-----BEGIN ") {
+		return []byte(v), nil
+	}
+	b, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, errAPIInvalidData
+	}
+	return b, nil
+}
+
+func newAPIName(n pkix.Name) *apiName {
+	return &apiName{
+		CommonName:         n.CommonName,
+		Organization:       n.Organization,
+		OrganizationalUnit: n.OrganizationalUnit,
+		Country:            n.Country,
+		Province:           n.Province,
+		Locality:           n.Locality,
+		StreetAddress:      n.StreetAddress,
+		PostalCode:         n.PostalCode,
+		String:             n.String(),
+	}
+}
+
+func newAPIRefs(refs []*storage.Ref) []*apiRef {
+	v := []*apiRef{}
+	for _, r := range refs {
+		v = append(v, &apiRef{
+			ID:         r.ID,
+			Path:       r.Path,
+			CommonName: r.X509.Subject.CommonName,
+			IsCA:       r.X509.IsCA,
+			NotBefore:  r.X509.NotBefore,
+			NotAfter:   r.X509.NotAfter,
+		})
+	}
+	return v
+}
+
+func newAPICert(c *storage.Certificate) *apiCert {
+	v := &apiCert{
+		ID:          c.ID,
+		Path:        c.Path,
+		Fingerprint: c.Fingerprint,
+		Serial:      c.X509.SerialNumber.String(),
+		Subject:     newAPIName(c.X509.Subject),
+		Issuer:      newAPIName(c.X509.Issuer),
+		NotBefore:   c.X509.NotBefore,
+		NotAfter:    c.X509.NotAfter,
+		IsCA:        c.X509.IsCA,
+		MaxPathLen:  c.X509.MaxPathLen,
+		CanSign:     c.CanSign(),
+		KeyUsage:    c.KeyUsage(),
+		ExtKeyUsage: c.ExtKeyUsage(),
+		DNSNames:    c.X509.DNSNames,
+		IPAddresses: []string{},
+		Emails:      []string{},
+		URIs:        []string{},
+		UPNs:        []string{},
+		Parents:     newAPIRefs(c.Parents),
+		Children:    newAPIRefs(c.Children),
+		Next:        newAPIRefs(c.Next),
+		Clamped:     c.Clamped,
+	}
+	if c.Previous != nil {
+		v.Previous = newAPIRefs([]*storage.Ref{c.Previous})[0]
+	}
+	v.NameConstraints = []*apiNameConstraint{}
+	for _, n := range c.NameConstraints() {
+		v.NameConstraints = append(v.NameConstraints, &apiNameConstraint{
+			Type:      n.Type,
+			Permitted: append([]string{}, n.Permitted...),
+			Excluded:  append([]string{}, n.Excluded...),
+		})
+	}
+	v.NameConstraintsCritical = len(v.NameConstraints) != 0 && c.X509.PermittedDNSDomainsCritical
+	v.CAIssuers = append([]string{}, c.X509.IssuingCertificateURL...)
+	v.OCSPServers = append([]string{}, c.X509.OCSPServer...)
+	v.CRLDistributionPoints = append([]string{}, c.X509.CRLDistributionPoints...)
+	v.CertificatePolicies = c.CertificatePolicies()
+	v.MustStaple = c.MustStaple()
+	v.Extensions = []*apiExtension{}
+	for _, e := range c.CustomExtensions() {
+		v.Extensions = append(v.Extensions, &apiExtension{
+			OID:      e.OID,
+			Critical: e.Critical,
+			Value:    hex.EncodeToString(e.Value),
+		})
+	}
+	v.Lint = newAPILintFindings(c.Findings)
+	if v.DNSNames == nil {
+		v.DNSNames = []string{}
+	}
+	for _, san := range c.SANs() {
+		switch san.Type {
+		case storage.SANTypeIP:
+			v.IPAddresses = append(v.IPAddresses, san.Value)
+		case storage.SANTypeEmail:
+			v.Emails = append(v.Emails, san.Value)
+		case storage.SANTypeURI:
+			v.URIs = append(v.URIs, san.Value)
+		case storage.SANTypeUPN:
+			v.UPNs = append(v.UPNs, san.Value)
+		}
+	}
+	if c.PrivateKey != nil {
+		v.PrivateKey = &apiKey{
+			Algorithm: c.PrivateKey.Algorithm,
+			Curve:     c.PrivateKey.Curve,
+			Size:      c.PrivateKey.Size,
+			Token:     c.PrivateKey.Token,
+		}
+	}
+	if c.Revocation != nil {
+		v.Revocation = &apiRevocation{
+			Time:   c.Revocation.RevocationTime,
+			Reason: c.Revocation.Reason,
+			Name:   c.Revocation.ReasonName(),
+		}
+	}
+	return v
+}
+
+// apiFail sends an error response with a status code that reflects the type
+// of error that occurred.
+func (s *Server) apiFail(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, storage.ErrNotFound),
+		errors.Is(err, errAPINotFound),
+		errors.Is(err, errAPINoPolicy):
+		status = http.StatusNotFound
+	case errors.Is(err, storage.ErrConflict):
+		status = http.StatusConflict
+	case errors.Is(err, storage.ErrInvalidInput),
+		errors.Is(err, errInvalidFmt),
+		errors.Is(err, errAPIInvalidData):
+		status = http.StatusBadRequest
+	case errors.Is(err, errAPIForbidden):
+		status = http.StatusForbidden
+	case errors.Is(err, storage.ErrSealed):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, errAPIMethodNotAllowed):
+		status = http.StatusMethodNotAllowed
+	default:
+		s.logger.Error(err.Error())
+	}
+	v := &apiError{Error: err.Error()}
+	var e *storage.PolicyError
+	if errors.As(err, &e) {
+		v.Violations = e.Violations
+	}
+	c.AbortWithStatusJSON(status, v)
+}
+
+// apiBind binds the JSON request body to v, sending an error response and
+// returning false if it is invalid. The body is kept so that it can be bound
+// more than once.
+func (s *Server) apiBind(c *gin.Context, v any) bool {
+	if err := c.ShouldBindBodyWith(v, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, &apiError{Error: err.Error()})
+		return false
+	}
+	return true
+}
+
+func (s *Server) apiOpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", openAPIDoc)
+}
+
+func (s *Server) apiList(c *gin.Context) {
+	c.JSON(http.StatusOK, newAPIRefs(s.visibleRoots(c)))
+}
+
+func (s *Server) apiCreate(c *gin.Context, p string) {
+	form, ok := s.apiNewParams(c)
+	if !ok || !s.apiBind(c, form) {
+		return
+	}
+	v, err := s.storage.CreateCertificate(p, form)
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	c.Header("Location", fmt.Sprintf("/api/v1/certs/%s", v.Path))
+	c.JSON(http.StatusCreated, newAPICert(v))
+}
+
+func (s *Server) apiCreateRoot(c *gin.Context) {
+	s.apiCreate(c, "")
+}
+
+func (s *Server) apiGet(c *gin.Context, p string) {
+	v, err := s.storage.GetCertificate(p)
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newAPICert(v))
+}
+
+func (s *Server) apiDelete(c *gin.Context, p string) {
+	if err := s.storage.DeleteCertificate(p); err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func newAPILintFindings(findings []*storage.LintFinding) []*apiLintFinding {
+	v := []*apiLintFinding{}
+	for _, f := range findings {
+		v = append(v, &apiLintFinding{
+			Rule:     f.Rule.ID,
+			Severity: f.Rule.Severity,
+			Source:   fmt.Sprintf("%s %s", f.Rule.Source, f.Rule.Section),
+			Message:  f.Message,
+		})
+	}
+	return v
+}
+
+func newAPIValidationResults(r []*storage.ValidationResult) []*apiValidationResult {
+	results := []*apiValidationResult{}
+	for _, v := range r {
+		checks := []*apiValidationCheck{}
+		for _, k := range v.Checks {
+			checks = append(checks, &apiValidationCheck{
+				Name:    k.Name,
+				Passed:  k.Passed,
+				Message: k.Message,
+			})
+		}
+		results = append(results, &apiValidationResult{
+			CommonName: v.X509.Subject.CommonName,
+			Valid:      v.Err == "",
+			Error:      v.Err,
+			Checks:     checks,
+		})
+	}
+	return results
+}
+
+func (s *Server) apiValidate(c *gin.Context, p string) {
+	revocation, _ := strconv.ParseBool(c.Query("revocation"))
+	r, err := s.storage.ValidateCertificate(p, &storage.ValidateParams{
+		Purpose:    c.Query("purpose"),
+		Host:       c.Query("host"),
+		Time:       c.Query("time"),
+		Revocation: revocation,
+	})
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newAPIValidationResults(r))
+}
+
+func (s *Server) apiCheck(c *gin.Context) {
+	form := &apiCheckParams{}
+	if !s.apiBind(c, form) {
+		return
+	}
+	b, err := decodeAPIData(form.Certificate)
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	r, err := s.storage.CheckCertificate(&storage.CheckCertificateParams{
+		ValidateParams: form.ValidateParams,
+		Certificate:    b,
+		Password:       form.Password,
+	})
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	paths := [][]*apiValidationResult{}
+	for _, v := range r {
+		paths = append(paths, newAPIValidationResults(v))
+	}
+	c.JSON(http.StatusOK, paths)
+}
+
+func (s *Server) apiIssued(c *gin.Context, p string) {
+	issued, err := s.storage.GetIssuedSerials(p)
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	v := []*apiIssued{}
+	for _, i := range issued {
+		v = append(v, &apiIssued{
+			Serial:   i.SerialNumber.String(),
+			Subject:  i.Subject,
+			NotAfter: i.NotAfter,
+			Status:   i.Status,
+			Path:     i.Path,
+		})
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) apiExport(c *gin.Context, p string) {
+	b, mime, _, _, err := s.export(p, c.Query("f"))
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	c.Data(http.StatusOK, mime, b)
+}
+
+func (s *Server) apiKey(c *gin.Context, p string) {
+	b, err := s.storage.ExportPrivateKeyPEM(p)
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	c.Data(http.StatusOK, "application/x-pem-file", b)
+}
+
+func (s *Server) apiPKCS12(c *gin.Context, p string) {
+	form := &apiPKCS12Params{}
+	if !s.apiBind(c, form) {
+		return
+	}
+	b, err := s.storage.ExportCertificatePKCS12(p, &storage.ExportCertificatePKCS12Params{
+		Password:  form.Password,
+		UseLegacy: form.UseLegacy,
+	})
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	c.Data(http.StatusOK, "application/x-pkcs12", b)
+}
+
+func (s *Server) apiSign(c *gin.Context, p string) {
+	params, ok := s.apiNewParams(c)
+	if !ok {
+		return
+	}
+	form := &storage.SignCSRParams{CreateCertificateParams: *params}
+	if !s.apiBind(c, form) {
+		return
+	}
+	v, err := s.storage.SignCSR(p, form)
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	c.Header("Location", fmt.Sprintf("/api/v1/certs/%s", v.Path))
+	c.JSON(http.StatusCreated, newAPICert(v))
+}
+
+func (s *Server) apiImport(c *gin.Context, p string) {
+	form := &apiImportParams{}
+	if !s.apiBind(c, form) {
+		return
+	}
+	cert, err := decodeAPIData(form.Certificate)
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	key, err := decodeAPIData(form.PrivateKey)
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	v, err := s.storage.ImportCertificate(p, &storage.ImportCertificateParams{
+		Certificate: cert,
+		PrivateKey:  key,
+		Password:    form.Password,
+	})
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	c.Header("Location", fmt.Sprintf("/api/v1/certs/%s", v.Path))
+	c.JSON(http.StatusCreated, newAPICert(v))
+}
+
+func (s *Server) apiImportAnywhere(c *gin.Context) {
+	s.apiImport(c, "")
+}
+
+func (s *Server) apiRevoke(c *gin.Context, p string) {
+	form := &storage.RevokeCertificateParams{}
+	if !s.apiBind(c, form) {
+		return
+	}
+	if err := s.storage.RevokeCertificate(p, form); err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	s.apiGet(c, p)
+}
+
+func (s *Server) apiRenew(c *gin.Context, p string) {
+	s.apiRenewWith(c, p, s.storage.RenewCertificate)
+}
+
+func (s *Server) apiRekey(c *gin.Context, p string) {
+	s.apiRenewWith(c, p, s.storage.RekeyCertificate)
+}
+
+func (s *Server) apiRenewWith(
+	c *gin.Context,
+	p string,
+	action func(string, *storage.RenewCertificateParams) (*storage.Certificate, error),
+) {
+	form := &storage.RenewCertificateParams{
+		KeyType: storage.KeyTypeRSA,
+		KeySize: 2048,
+	}
+	if !s.apiBind(c, form) {
+		return
+	}
+	v, err := action(p, form)
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	c.Header("Location", fmt.Sprintf("/api/v1/certs/%s", v.Path))
+	c.JSON(http.StatusCreated, newAPICert(v))
+}
+
+func (s *Server) apiCRL(c *gin.Context, p string) {
+	b, err := s.storage.ExportCRLDER(p)
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	c.Data(http.StatusOK, "application/pkix-crl", b)
+}
+
+// apiRoutePath routes requests for /api/v1/certs/[cert]/[action] in much the
+// same way as routePath does for the web interface.
+func (s *Server) apiRoutePath(c *gin.Context) {
+	v := apiPathRegExp.FindStringSubmatch(c.Param("path"))
+	if len(v) < 2 {
+		s.apiFail(c, errAPINotFound)
+		return
+	}
+	m, ok := s.apiRoutes[v[2]]
+	if !ok {
+		s.apiFail(c, errAPINotFound)
+		return
+	}
+	r, ok := m[c.Request.Method]
+	if !ok {
+		s.apiFail(c, errAPIMethodNotAllowed)
+		return
+	}
+	if !s.authorizeRoute(c, v[1], r.perm, r.onIssuer) {
+		s.apiFail(c, errAPIForbidden)
+		return
+	}
+	r.handler(c, v[1])
+}
+
+// initAPI registers the JSON API routes.
+func (s *Server) initAPI(r gin.IRouter) {
+	s.apiRoutes = map[string]map[string]apiRoute{
+		"": {
+			http.MethodGet:    {perm: auth.PermView, handler: s.apiGet},
+			http.MethodDelete: {perm: auth.PermDelete, onIssuer: true, handler: s.apiDelete},
+		},
+		"children": {
+			http.MethodPost: {perm: auth.PermIssue, handler: s.apiCreate},
+		},
+		"validate": {
+			http.MethodGet: {perm: auth.PermView, handler: s.apiValidate},
+		},
+		"issued": {
+			http.MethodGet: {perm: auth.PermView, handler: s.apiIssued},
+		},
+		"export": {
+			http.MethodGet: {perm: auth.PermView, handler: s.apiExport},
+		},
+		"key": {
+			http.MethodGet: {perm: auth.PermExportKey, handler: s.apiKey},
+		},
+		"pkcs12": {
+			http.MethodPost: {perm: auth.PermExportKey, handler: s.apiPKCS12},
+		},
+		"sign": {
+			http.MethodPost: {perm: auth.PermIssue, handler: s.apiSign},
+		},
+		"import": {
+			http.MethodPost: {perm: auth.PermIssue, handler: s.apiImport},
+		},
+		"policy": {
+			http.MethodGet:    {perm: auth.PermView, handler: s.apiGetPolicy},
+			http.MethodPut:    {perm: permPolicy, onIssuer: true, handler: s.apiSetPolicy},
+			http.MethodDelete: {perm: permPolicy, onIssuer: true, handler: s.apiDeletePolicy},
+		},
+		"revoke": {
+			http.MethodPost: {perm: auth.PermIssue, onIssuer: true, handler: s.apiRevoke},
+		},
+		"renew": {
+			http.MethodPost: {perm: auth.PermIssue, onIssuer: true, handler: s.apiRenew},
+		},
+		"rekey": {
+			http.MethodPost: {perm: auth.PermIssue, onIssuer: true, handler: s.apiRekey},
+		},
+		"crl": {
+			http.MethodGet: {perm: auth.PermView, handler: s.apiCRL},
+		},
+	}
+	s.apiPageRoutes = map[string]map[string]apiPageRoute{
+		"/certs": {
+			http.MethodGet:  {handler: s.apiList},
+			http.MethodPost: {perm: auth.PermIssue, handler: s.apiCreateRoot},
+		},
+		"/import": {
+			http.MethodPost: {perm: auth.PermIssue, handler: s.apiImportAnywhere},
+		},
+		"/check": {
+			http.MethodPost: {perm: auth.PermView, handler: s.apiCheck},
+		},
+		"/lint": {
+			http.MethodGet: {handler: s.apiLint},
+		},
+		"/profiles": {
+			http.MethodGet: {handler: s.apiProfiles},
+		},
+		"/profiles/:id": {
+			http.MethodGet:    {handler: s.apiGetProfile},
+			http.MethodPut:    {perm: permProfiles, handler: s.apiSaveProfile},
+			http.MethodDelete: {perm: permProfiles, handler: s.apiDeleteProfile},
+		},
+		"/pending": {
+			http.MethodGet:  {perm: permPending, handler: s.apiPendingList},
+			http.MethodPost: {perm: permPending, handler: s.apiCreatePending},
+		},
+		"/pending/:id": {
+			http.MethodGet:    {perm: permPending, handler: s.apiGetPending},
+			http.MethodDelete: {perm: permPending, handler: s.apiDeletePending},
+		},
+		"/pending/:id/csr": {
+			http.MethodGet: {perm: permPending, handler: s.apiPendingCSR},
+		},
+		"/pending/:id/complete": {
+			http.MethodPost: {perm: permPending, handler: s.apiCompletePending},
+		},
+		"/seal": {
+			http.MethodGet:  {handler: s.apiSealStatus},
+			http.MethodPost: {perm: permSeal, handler: s.apiSeal},
+		},
+		"/unseal": {
+			http.MethodPost: {perm: permSeal, handler: s.apiUnseal},
+		},
+	}
+	g := r.Group("/api/v1")
+	g.GET("/openapi.json", s.apiOpenAPI)
+	g.Use(s.apiRequireUser)
+	g.Any("/certs/*path", s.apiRoutePath)
+	for _, p := range slices.Sorted(maps.Keys(s.apiPageRoutes)) {
+		for m, v := range s.apiPageRoutes[p] {
+			g.Handle(m, p, s.apiRequirePerm(v.perm), v.handler)
+		}
+	}
+}
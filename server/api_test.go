@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIAuthentication(t *testing.T) {
+	ts := newTestServer(t)
+
+	// Requests without valid credentials are rejected, except for the
+	// OpenAPI document
+	w := ts.apiResponse("", http.MethodGet, "/api/v1/certs", "")
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("list without credentials = %d, want 401 with a challenge", w.Code)
+	}
+	for _, target := range []string{
+		"/api/v1/certs/" + ts.leaf.Path,
+		"/api/v1/certs/" + ts.leaf.Path + "/export?f=cert_pem",
+		"/api/v1/pending",
+	} {
+		if v := ts.api("", http.MethodGet, target, ""); v != http.StatusUnauthorized {
+			t.Fatalf("GET %s without credentials = %d, want 401", target, v)
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/certs", nil)
+	req.SetBasicAuth(testAdmin, "wrong")
+	w = httptest.NewRecorder()
+	ts.handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("list with the wrong password = %d, want 401", w.Code)
+	}
+	if v := ts.api("", http.MethodGet, "/api/v1/openapi.json", ""); v != http.StatusOK {
+		t.Fatalf("OpenAPI document without credentials = %d, want 200", v)
+	}
+}
+
+func TestAPIPermissions(t *testing.T) {
+	ts := newTestServer(t)
+	var (
+		root  = "/api/v1/certs/" + issuerPath(ts.inter.Path)
+		inter = "/api/v1/certs/" + ts.inter.Path
+		leaf  = "/api/v1/certs/" + ts.leaf.Path
+		child = `{"commonName":"other.example.com","validity":"10m"}`
+	)
+
+	// Each request is checked against the grants for the path it acts on
+	for _, r := range []struct {
+		username, method, target, body string
+		status                         int
+	}{
+		{testViewer, http.MethodGet, root, "", http.StatusForbidden},
+		{testViewer, http.MethodGet, root + "/export?f=cert_pem", "", http.StatusForbidden},
+		{testViewer, http.MethodGet, inter, "", http.StatusOK},
+		{testViewer, http.MethodGet, leaf + "/export?f=cert_pem", "", http.StatusOK},
+		{testViewer, http.MethodPost, inter + "/children", child, http.StatusForbidden},
+		{testCAAdmin, http.MethodPost, root + "/children", child, http.StatusForbidden},
+		{testCAAdmin, http.MethodGet, root + "/key", "", http.StatusForbidden},
+		{testCAAdmin, http.MethodPost, inter + "/children", child, http.StatusCreated},
+		{testCAAdmin, http.MethodGet, leaf + "/key", "", http.StatusOK},
+		{testIssuer, http.MethodGet, leaf + "/key", "", http.StatusForbidden},
+		{testIssuer, http.MethodPost, root + "/children", child, http.StatusCreated},
+		{testIssuer, http.MethodPost, "/api/v1/certs", `{"commonName":"Other Root","validity":"10m"}`, http.StatusCreated},
+	} {
+		if v := ts.api(r.username, r.method, r.target, r.body); v != r.status {
+			t.Fatalf("%s: %s %s = %d, want %d", r.username, r.method, r.target, v, r.status)
+		}
+	}
+
+	// The list only includes the roots that the user may view, which for a
+	// grant below a root is none
+	var refs []*apiRef
+	w := ts.apiResponse(testViewer, http.MethodGet, "/api/v1/certs", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &refs); err != nil {
+		t.Fatalf("decode list: %v", err)
+	}
+	if w.Code != http.StatusOK || len(refs) != 0 {
+		t.Fatalf("list = %d with %d roots, want 200 with none", w.Code, len(refs))
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	ts := newTestServer(t)
+	var (
+		inter   = "/api/v1/certs/" + ts.inter.Path
+		leaf    = "/api/v1/certs/" + ts.leaf.Path
+		missing = "/api/v1/certs/000000000000"
+		leafPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.leaf.X509.Raw})
+	)
+	importBody, err := json.Marshal(&apiImportParams{Certificate: string(leafPEM)})
+	if err != nil {
+		t.Fatalf("encode import: %v", err)
+	}
+
+	// Errors are mapped to status codes for each type of failure
+	for _, r := range []struct {
+		method, target, body string
+		status               int
+	}{
+		{http.MethodPost, inter + "/children", `{"commonName":`, http.StatusBadRequest},
+		{http.MethodPost, inter + "/children", `{"commonName":"other.example.com","validity":"1q"}`, http.StatusBadRequest},
+		{http.MethodPost, inter + "/children", `{"commonName":"other.example.com","validity":"10m","keyType":"dsa"}`, http.StatusBadRequest},
+		{http.MethodPost, missing + "/children", `{"commonName":"other.example.com","validity":"10m"}`, http.StatusNotFound},
+		{http.MethodPost, leaf + "/children", `{"commonName":"other.example.com","validity":"10m"}`, http.StatusBadRequest},
+		{http.MethodPost, inter + "/unknown", "", http.StatusNotFound},
+		{http.MethodPut, inter + "/children", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, leaf + "/revoke", `{"reason":7}`, http.StatusBadRequest},
+		{http.MethodPost, missing + "/revoke", testRevoke, http.StatusNotFound},
+		{http.MethodGet, leaf + "/export?f=unknown", "", http.StatusBadRequest},
+		{http.MethodGet, leaf + "/export?f=priv_key", "", http.StatusBadRequest},
+		{http.MethodGet, missing + "/export?f=cert_pem", "", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/import", string(importBody), http.StatusConflict},
+		{http.MethodPost, "/api/v1/import", `{"certificate":"not base64"}`, http.StatusBadRequest},
+	} {
+		if v := ts.api(testAdmin, r.method, r.target, r.body); v != r.status {
+			t.Fatalf("%s %s = %d, want %d", r.method, r.target, v, r.status)
+		}
+	}
+
+	// Revoking twice conflicts with the state of the certificate
+	if v := ts.api(testAdmin, http.MethodPost, leaf+"/revoke", testRevoke); v != http.StatusOK {
+		t.Fatalf("revoke = %d, want 200", v)
+	}
+	if v := ts.api(testAdmin, http.MethodPost, leaf+"/revoke", testRevoke); v != http.StatusConflict {
+		t.Fatalf("second revoke = %d, want 409", v)
+	}
+
+	// Policy violations are listed in the error
+	if v := ts.api(testAdmin, http.MethodPut, inter+"/policy", `{"dnsSuffixes":["example.com"]}`); v != http.StatusOK {
+		t.Fatalf("set policy = %d, want 200", v)
+	}
+	w := ts.apiResponse(testAdmin, http.MethodPost, inter+"/children", `{"commonName":"www.example.org","validity":"10m","sans":"www.example.org"}`)
+	e := &apiError{}
+	if err := json.Unmarshal(w.Body.Bytes(), e); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if w.Code != http.StatusBadRequest || len(e.Violations) != 2 {
+		t.Fatalf("create with policy violations = %d with %d violations, want 400 with 2", w.Code, len(e.Violations))
+	}
+
+	// Exporting succeeds with the right content type
+	w = ts.apiResponse(testAdmin, http.MethodGet, leaf+"/export?f=cert_pem", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-pem-file" {
+		t.Fatalf("export = %d (%s), want 200 with PEM", w.Code, w.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/gin-gonic/gin"
+	"github.com/nathan-osman/certy/auth"
+	"github.com/nathan-osman/certy/storage"
+)
+
+const (
+	cookieSession = "certy_session"
+	contextUser   = "user"
+)
+
+var (
+	errAPIUnauthorized    = errors.New("authentication required")
+	errAPIForbidden       = errors.New("you do not have permission to perform this action")
+	errPasswordsDontMatch = errors.New("the new passwords do not match")
+	errWrongPassword      = errors.New("the current password is incorrect")
+)
+
+type loginForm struct {
+	Username string `form:"Username"`
+	Password string `form:"Password"`
+}
+
+type passwordForm struct {
+	Current string `form:"Current"`
+	New     string `form:"New"`
+	Confirm string `form:"Confirm"`
+}
+
+// html renders the template, adding the current user, seal status and
+// whether the user may manage profiles and the seal to the context.
+func (s *Server) html(c *gin.Context, code int, name string, ctx pongo2.Context) {
+	u := c.GetString(contextUser)
+	ctx["user"] = u
+	if u != "" || s.auth == nil {
+		ctx["encrypted"] = s.storage.Encrypted()
+		ctx["sealed"] = s.storage.Sealed()
+		ctx["manageProfiles"] = s.authorize(c, "", permProfiles)
+		ctx["manageSeal"] = s.authorize(c, "", permSeal)
+	}
+	c.HTML(code, name, ctx)
+}
+
+// isSecure indicates whether the request was made over HTTPS, either
+// directly or through a reverse proxy.
+func isSecure(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
+
+func (s *Server) setSessionCookie(c *gin.Context, token string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     cookieSession,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   isSecure(c),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// currentUser determines the user making the request from the session
+// cookie or, if allowBasic is true, HTTP basic authentication.
+func (s *Server) currentUser(c *gin.Context, allowBasic bool) (string, bool) {
+	if t, err := c.Cookie(cookieSession); err == nil {
+		if u, ok := s.auth.Session(t); ok {
+			return u, true
+		}
+	}
+	if allowBasic {
+		if u, p, ok := c.Request.BasicAuth(); ok {
+			if err := s.auth.Authenticate(u, p); err == nil {
+				return u, true
+			}
+		}
+	}
+	return "", false
+}
+
+// requireUser redirects requests without a valid session to the login page.
+func (s *Server) requireUser(c *gin.Context) {
+	if s.auth == nil {
+		return
+	}
+	u, ok := s.currentUser(c, false)
+	if !ok {
+		c.Redirect(
+			http.StatusSeeOther,
+			"/login?next="+url.QueryEscape(c.Request.URL.RequestURI()),
+		)
+		c.Abort()
+		return
+	}
+	c.Set(contextUser, u)
+}
+
+// apiRequireUser rejects API requests that are not authenticated with either
+// a session cookie or HTTP basic authentication.
+func (s *Server) apiRequireUser(c *gin.Context) {
+	if s.auth == nil {
+		return
+	}
+	u, ok := s.currentUser(c, true)
+	if !ok {
+		c.Header("WWW-Authenticate", `Basic realm="certy"`)
+		c.AbortWithStatusJSON(
+			http.StatusUnauthorized,
+			&apiError{Error: errAPIUnauthorized.Error()},
+		)
+		return
+	}
+	c.Set(contextUser, u)
+}
+
+// authorize determines whether the current user has the permission for the
+// certificate path. Every request that reads or modifies certificates must
+// pass through here.
+func (s *Server) authorize(c *gin.Context, certPath string, perm auth.Permission) bool {
+	if s.auth == nil {
+		return true
+	}
+	p := s.auth.Permissions(c.GetString(contextUser), certPath)
+	return p&perm == perm
+}
+
+// issuerPath returns the path of the certificate's issuer, which is empty
+// (the whole tree) for a root.
+func issuerPath(certPath string) string {
+	if i := strings.LastIndexByte(certPath, '/'); i != -1 {
+		return certPath[:i]
+	}
+	return ""
+}
+
+// authorizeRoute determines whether the current user has the permission for
+// the certificate path or, if onIssuer is true, for its issuer's path. A
+// grant for a CA therefore does not allow revoking, renewing or deleting the
+// CA itself or changing its policy.
+func (s *Server) authorizeRoute(c *gin.Context, certPath string, perm auth.Permission, onIssuer bool) bool {
+	if onIssuer {
+		certPath = issuerPath(certPath)
+	}
+	return s.authorize(c, certPath, perm)
+}
+
+// requirePerm returns middleware that only lets the request through if the
+// current user has the permission for the whole tree.
+func (s *Server) requirePerm(perm auth.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.authorize(c, "", perm) {
+			s.e403Handler(c)
+			c.Abort()
+		}
+	}
+}
+
+// apiRequirePerm is the API equivalent of requirePerm.
+func (s *Server) apiRequirePerm(perm auth.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.authorize(c, "", perm) {
+			s.apiFail(c, errAPIForbidden)
+		}
+	}
+}
+
+// visibleRoots filters the root certificates down to those the current user
+// may view.
+func (s *Server) visibleRoots(c *gin.Context) []*storage.Ref {
+	refs := []*storage.Ref{}
+	for _, r := range s.storage.GetRootCertificates() {
+		if s.authorize(c, r.Path, auth.PermView) {
+			refs = append(refs, r)
+		}
+	}
+	return refs
+}
+
+// safeRedirect ensures that the path to redirect to after logging in stays on
+// this site.
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") ||
+		strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func (s *Server) login(c *gin.Context) {
+	var (
+		form = &loginForm{}
+		next = safeRedirect(c.Query("next"))
+		msg  string
+	)
+	if c.Request.Method == http.MethodPost {
+		if err := c.ShouldBind(form); err != nil {
+			panic(err)
+		}
+		t, err := s.auth.Login(form.Username, form.Password)
+		if err == nil {
+			s.logger.Info("user logged in", "user", form.Username)
+			s.setSessionCookie(c, t, 0)
+			c.Redirect(http.StatusSeeOther, next)
+			return
+		}
+		s.logger.Warn("failed login", "user", form.Username, "addr", c.ClientIP())
+		msg = err.Error()
+		form.Password = ""
+	}
+	users, err := s.auth.Usernames()
+	if err != nil {
+		panic(err)
+	}
+	s.html(c, http.StatusOK, "login.html", pongo2.Context{
+		"title":   "Log In",
+		"desc":    "Log in to manage certificates",
+		"form":    form,
+		"next":    next,
+		"msg":     msg,
+		"noUsers": len(users) == 0,
+	})
+}
+
+func (s *Server) logout(c *gin.Context) {
+	if t, err := c.Cookie(cookieSession); err == nil {
+		s.auth.Logout(t)
+	}
+	s.setSessionCookie(c, "", -1)
+	c.Redirect(http.StatusSeeOther, "/login")
+}
+
+func (s *Server) password(c *gin.Context) {
+	var (
+		form = &passwordForm{}
+		u    = c.GetString(contextUser)
+		msg  string
+	)
+	if c.Request.Method == http.MethodPost {
+		if err := c.ShouldBind(form); err != nil {
+			panic(err)
+		}
+		err := s.auth.Authenticate(u, form.Current)
+		if err != nil {
+			err = errWrongPassword
+		}
+		if err == nil && form.New != form.Confirm {
+			err = errPasswordsDontMatch
+		}
+		if err == nil {
+			err = s.auth.SetPassword(u, form.New)
+		}
+		if err == nil {
+
+			// Changing the password ends all sessions, so start a new one
+			t, err := s.auth.Login(u, form.New)
+			if err != nil {
+				panic(err)
+			}
+			s.setSessionCookie(c, t, 0)
+			c.Redirect(http.StatusSeeOther, "/")
+			return
+		}
+		msg = err.Error()
+	}
+	s.html(c, http.StatusOK, "password.html", pongo2.Context{
+		"title": "Change Password",
+		"desc":  "Change the password used to log in",
+		"form":  &passwordForm{},
+		"msg":   msg,
+	})
+}
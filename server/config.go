@@ -3,21 +3,36 @@ package server
 import (
 	"log/slog"
 
+	"github.com/nathan-osman/certy/acme"
+	"github.com/nathan-osman/certy/auth"
 	"github.com/nathan-osman/certy/storage"
 )
 
 // Config provides configuration for Server.
 type Config struct {
 
+	// ACME is an optional ACME instance whose routes are added to the
+	// server.
+	ACME *acme.ACME
+
 	// Addr is the address the server should listen on.
 	Addr string
 
+	// Auth is used to authenticate users. If nil, no authentication is
+	// performed and anyone can access the web interface and API.
+	Auth *auth.Auth
+
 	// Debug indicates that debug mode is enabled.
 	Debug bool
 
 	// Logger can be used to capture log messages.
 	Logger *slog.Logger
 
+	// PublicURL is the external URL of the server, such as
+	// https://ca.example.com. If provided, certificates include the URLs of
+	// their issuer's certificate, the OCSP responder and the issuer's CRL.
+	PublicURL string
+
 	// Storage is a pointer to a Storage instance.
 	Storage *storage.Storage
 }
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/gin-gonic/gin"
+	"github.com/nathan-osman/certy/auth"
+	"github.com/nathan-osman/certy/storage"
+)
+
+// apiLintReport is the JSON representation of the findings for a single
+// certificate in the lint report.
+type apiLintReport struct {
+	Path       string            `json:"path"`
+	CommonName string            `json:"commonName"`
+	NotAfter   time.Time         `json:"notAfter"`
+	Findings   []*apiLintFinding `json:"findings"`
+}
+
+// visibleLintReports returns the lint reports for the certificates that the
+// user may view.
+func (s *Server) visibleLintReports(c *gin.Context) []*storage.LintReport {
+	reports := []*storage.LintReport{}
+	for _, r := range s.storage.LintCertificates() {
+		if s.authorize(c, r.Ref.Path, auth.PermView) {
+			reports = append(reports, r)
+		}
+	}
+	return reports
+}
+
+func (s *Server) lintReport(c *gin.Context) {
+	var (
+		reports = s.visibleLintReports(c)
+		counts  = map[string]int{}
+	)
+	for _, r := range reports {
+		for _, f := range r.Findings {
+			counts[f.Rule.ID]++
+		}
+	}
+	s.html(c, http.StatusOK, "lint.html", pongo2.Context{
+		"title":   "Lint Report",
+		"desc":    "Problems found in the stored certificates",
+		"rules":   storage.LintRules,
+		"counts":  counts,
+		"reports": reports,
+	})
+}
+
+func (s *Server) apiLint(c *gin.Context) {
+	reports := []*apiLintReport{}
+	for _, r := range s.visibleLintReports(c) {
+		reports = append(reports, &apiLintReport{
+			Path:       r.Ref.Path,
+			CommonName: r.Ref.X509.Subject.CommonName,
+			NotAfter:   r.Ref.X509.NotAfter,
+			Findings:   newAPILintFindings(r.Findings),
+		})
+	}
+	c.JSON(http.StatusOK, reports)
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	mimeOCSPResponse = "application/ocsp-response"
+
+	// RFC 6960 does not impose a limit but real-world requests are tiny
+	maxOCSPRequestSize = 10 * 1024
+)
+
+// ocspRequest extracts the DER-encoded request from the body (POST) or the
+// URL (GET) as described in RFC 6960, appendix A.1.
+func ocspRequest(c *gin.Context) ([]byte, error) {
+	if c.Request.Method == http.MethodPost {
+		return io.ReadAll(io.LimitReader(c.Request.Body, maxOCSPRequestSize))
+	}
+	v, err := url.PathUnescape(strings.TrimPrefix(c.Param("req"), "/"))
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(v)
+}
+
+func (s *Server) ocsp(c *gin.Context) {
+	b, err := ocspRequest(c)
+	if err != nil {
+		c.Data(http.StatusOK, mimeOCSPResponse, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	r, err := s.storage.RespondOCSP(b)
+	if err != nil {
+		s.logger.Error(err.Error())
+		c.Data(http.StatusOK, mimeOCSPResponse, ocsp.InternalErrorErrorResponse)
+		return
+	}
+	c.Data(http.StatusOK, mimeOCSPResponse, r)
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/gin-gonic/gin"
+	"github.com/nathan-osman/certy/auth"
+	"github.com/nathan-osman/certy/storage"
+)
+
+// Pending certificates are placed wherever the external CA's chain leads, so
+// requesting and completing them requires issuing anywhere in the tree.
+const permPending = auth.PermIssue
+
+// apiPending is the JSON representation of a key waiting for a certificate
+// from an external CA.
+type apiPending struct {
+	ID          string    `json:"id"`
+	Subject     *apiName  `json:"subject"`
+	DNSNames    []string  `json:"dnsNames"`
+	IPAddresses []string  `json:"ipAddresses"`
+	Emails      []string  `json:"emailAddresses"`
+	URIs        []string  `json:"uris"`
+	UPNs        []string  `json:"upns"`
+	PrivateKey  *apiKey   `json:"privateKey"`
+	Created     time.Time `json:"created"`
+}
+
+// apiCompleteParams is the JSON body for uploading the certificate signed by
+// the external CA, which is in any of the formats accepted for import.
+type apiCompleteParams struct {
+	Certificate string `json:"certificate"`
+}
+
+// completeForm holds the certificate uploaded on the pending certificate
+// page.
+type completeForm struct {
+	Certificate string `form:"Certificate"`
+}
+
+func newAPIPending(p *storage.PendingCertificate) *apiPending {
+	v := &apiPending{
+		ID:          p.ID,
+		Subject:     newAPIName(p.CSR.X509.Subject),
+		DNSNames:    []string{},
+		IPAddresses: []string{},
+		Emails:      []string{},
+		URIs:        []string{},
+		UPNs:        []string{},
+		Created:     p.Created,
+	}
+	for _, san := range p.CSR.SANs() {
+		switch san.Type {
+		case storage.SANTypeDNS:
+			v.DNSNames = append(v.DNSNames, san.Value)
+		case storage.SANTypeIP:
+			v.IPAddresses = append(v.IPAddresses, san.Value)
+		case storage.SANTypeEmail:
+			v.Emails = append(v.Emails, san.Value)
+		case storage.SANTypeURI:
+			v.URIs = append(v.URIs, san.Value)
+		case storage.SANTypeUPN:
+			v.UPNs = append(v.UPNs, san.Value)
+		}
+	}
+	if k := p.CSR.Key; k != nil {
+		v.PrivateKey = &apiKey{
+			Algorithm: k.Algorithm,
+			Curve:     k.Curve,
+			Size:      k.Size,
+			Token:     k.Token,
+		}
+	}
+	return v
+}
+
+// downloadCSR sends the request of the pending certificate as a file named
+// after its common name.
+func downloadCSR(c *gin.Context, p *storage.PendingCertificate, b []byte) {
+	c.Header(
+		"Content-Disposition",
+		fmt.Sprintf(
+			`attachment; filename="%s.csr"`,
+			strings.ReplaceAll(p.CSR.X509.Subject.CommonName, " ", "_"),
+		),
+	)
+	c.Header("Content-Length", strconv.Itoa(len(b)))
+	c.Data(http.StatusOK, "application/pkcs10", b)
+}
+
+func (s *Server) pendingList(c *gin.Context) {
+	pending, err := s.storage.GetPendingCertificates()
+	if err != nil {
+		panic(err)
+	}
+	s.html(c, http.StatusOK, "pending.html", pongo2.Context{
+		"title":   "External CA",
+		"desc":    "Keys waiting for a certificate from an external CA",
+		"pending": pending,
+	})
+}
+
+func (s *Server) pendingNew(c *gin.Context) {
+	var (
+		violations []*storage.PolicyViolation
+		msg        string
+	)
+	form, err := s.newParams(c.Query("Profile"))
+	if err != nil {
+		panic(err)
+	}
+	if c.Request.Method == http.MethodPost {
+
+		// See certNew for why the defaults are not used here
+		form = &storage.CreateCertificateParams{}
+		if err := c.ShouldBind(form); err != nil {
+			panic(err)
+		}
+		v, err := s.storage.CreatePendingCertificate(form)
+		if err == nil {
+			s.logger.Info("pending certificate created", "pending", v.ID, "user", c.GetString(contextUser))
+			c.Redirect(http.StatusSeeOther, fmt.Sprintf("/pending/%s", v.ID))
+			return
+		}
+		violations, msg = formError(err)
+	} else if form.Profile == "" {
+		form.CanSign = true
+	}
+	ctx := pongo2.Context{
+		"title":        "New Request",
+		"desc":         "Generate a key and a certificate signing request for an external CA",
+		"form":         form,
+		"violations":   violations,
+		"msg":          msg,
+		"pending":      true,
+		"allowSubCAs":  true,
+		"keyTypes":     keyTypeOptions,
+		"keyUsages":    keyUsageOptions,
+		"extKeyUsages": extKeyUsageOptions,
+		"pkcs11":       s.storage.PKCS11Enabled(),
+	}
+	s.profileContext(ctx, form)
+	s.html(c, http.StatusOK, "cert_new.html", ctx)
+}
+
+func (s *Server) pendingView(c *gin.Context) {
+	id := c.Param("id")
+	p, err := s.storage.GetPendingCertificate(id)
+	if err != nil {
+		panic(err)
+	}
+	var (
+		form = &completeForm{}
+		msg  string
+	)
+	if c.Request.Method == http.MethodPost {
+		if err := c.ShouldBind(form); err != nil {
+			panic(err)
+		}
+		b := []byte(form.Certificate)
+		if v, err := formFile(c, "CertificateFile"); err != nil {
+			panic(err)
+		} else if v != nil {
+			b = v
+		}
+		v, err := s.storage.CompletePendingCertificate(id, b)
+		if err == nil {
+			s.logger.Info("pending certificate completed", "pending", id, "user", c.GetString(contextUser))
+			c.Redirect(http.StatusSeeOther, fmt.Sprintf("/%s", v.Path))
+			return
+		}
+		_, msg = formError(err)
+	}
+	csr, err := s.storage.ExportPendingCSR(id)
+	if err != nil {
+		panic(err)
+	}
+	s.html(c, http.StatusOK, "pending_view.html", pongo2.Context{
+		"title":     p.CSR.X509.Subject.CommonName,
+		"desc":      "Waiting for a certificate from an external CA",
+		"pending":   p,
+		"csr":       string(csr),
+		"sanLabels": sanTypeLabels,
+		"form":      form,
+		"msg":       msg,
+	})
+}
+
+func (s *Server) pendingCSR(c *gin.Context) {
+	p, err := s.storage.GetPendingCertificate(c.Param("id"))
+	if err != nil {
+		panic(err)
+	}
+	b, err := s.storage.ExportPendingCSR(p.ID)
+	if err != nil {
+		panic(err)
+	}
+	downloadCSR(c, p, b)
+}
+
+func (s *Server) pendingDelete(c *gin.Context) {
+	id := c.Param("id")
+	if err := s.storage.DeletePendingCertificate(id); err != nil {
+		panic(err)
+	}
+	s.logger.Info("pending certificate deleted", "pending", id, "user", c.GetString(contextUser))
+	c.Redirect(http.StatusSeeOther, "/pending")
+}
+
+func (s *Server) apiPendingList(c *gin.Context) {
+	pending, err := s.storage.GetPendingCertificates()
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	v := []*apiPending{}
+	for _, p := range pending {
+		v = append(v, newAPIPending(p))
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) apiCreatePending(c *gin.Context) {
+	form, ok := s.apiNewParams(c)
+	if !ok || !s.apiBind(c, form) {
+		return
+	}
+	p, err := s.storage.CreatePendingCertificate(form)
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	s.logger.Info("pending certificate created", "pending", p.ID, "user", c.GetString(contextUser))
+	c.Header("Location", fmt.Sprintf("/api/v1/pending/%s", p.ID))
+	c.JSON(http.StatusCreated, newAPIPending(p))
+}
+
+func (s *Server) apiGetPending(c *gin.Context) {
+	p, err := s.storage.GetPendingCertificate(c.Param("id"))
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newAPIPending(p))
+}
+
+func (s *Server) apiPendingCSR(c *gin.Context) {
+	p, err := s.storage.GetPendingCertificate(c.Param("id"))
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	b, err := s.storage.ExportPendingCSR(p.ID)
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	downloadCSR(c, p, b)
+}
+
+func (s *Server) apiCompletePending(c *gin.Context) {
+	form := &apiCompleteParams{}
+	if !s.apiBind(c, form) {
+		return
+	}
+	b, err := decodeAPIData(form.Certificate)
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	id := c.Param("id")
+	v, err := s.storage.CompletePendingCertificate(id, b)
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	s.logger.Info("pending certificate completed", "pending", id, "user", c.GetString(contextUser))
+	c.Header("Location", fmt.Sprintf("/api/v1/certs/%s", v.Path))
+	c.JSON(http.StatusCreated, newAPICert(v))
+}
+
+func (s *Server) apiDeletePending(c *gin.Context) {
+	id := c.Param("id")
+	if err := s.storage.DeletePendingCertificate(id); err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	s.logger.Info("pending certificate deleted", "pending", id, "user", c.GetString(contextUser))
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/gin-gonic/gin"
+	"github.com/nathan-osman/certy/auth"
+	"github.com/nathan-osman/certy/storage"
+)
+
+// Policies restrict what issuers may do, so changing one requires the admin
+// role for the CA's issuer (the whole tree for a root) rather than for the CA
+// whose certificates it restricts.
+const permPolicy = auth.PermIssue | auth.PermDelete
+
+var errAPINoPolicy = errors.New("the certificate does not have an issuance policy")
+
+type policyForm struct {
+	DNSSuffixes     string   `form:"DNSSuffixes"`
+	IPRanges        string   `form:"IPRanges"`
+	MaxValidity     string   `form:"MaxValidity"`
+	MinRSAKeySize   int      `form:"MinRSAKeySize"`
+	MinECDSAKeySize int      `form:"MinECDSAKeySize"`
+	AllowedEKUs     []string `form:"AllowedEKUs"`
+	AllowSubCAs     bool     `form:"AllowSubCAs"`
+}
+
+// splitList splits a comma or space separated list.
+func splitList(v string) []string {
+	return strings.FieldsFunc(v, func(c rune) bool {
+		return c == ',' || unicode.IsSpace(c)
+	})
+}
+
+// allowSubCAs indicates whether the CA may issue certificates that can sign
+// others, so that the option can be hidden when it cannot.
+func (s *Server) allowSubCAs(cert *storage.Certificate) bool {
+	if cert == nil {
+		return true
+	}
+	if cert.X509.MaxPathLen == 0 && cert.X509.MaxPathLenZero {
+		return false
+	}
+	p, err := s.storage.GetPolicy(cert.Path)
+	if err != nil {
+		panic(err)
+	}
+	return p == nil || p.AllowSubCAs
+}
+
+// formError returns the violations if err is a policy error or the message
+// if it is any other input error so that they can be shown on the form; it
+// panics for any other error.
+func formError(err error) ([]*storage.PolicyViolation, string) {
+	var e *storage.PolicyError
+	if errors.As(err, &e) {
+		return e.Violations, ""
+	}
+	if !errors.Is(err, storage.ErrInvalidInput) {
+		panic(err)
+	}
+	return nil, err.Error()
+}
+
+func (s *Server) certPolicy(c *gin.Context, p string) {
+	v, err := s.storage.GetCertificate(p)
+	if err != nil {
+		panic(err)
+	}
+	var (
+		form = &policyForm{
+			AllowSubCAs: v.X509.MaxPathLen != 0 || !v.X509.MaxPathLenZero,
+		}
+		msg string
+	)
+	if c.Request.Method == http.MethodPost {
+		var policy *storage.Policy
+		if c.PostForm("action") != "remove" {
+			if err := c.ShouldBind(form); err != nil {
+				panic(err)
+			}
+			policy = &storage.Policy{
+				DNSSuffixes:     splitList(form.DNSSuffixes),
+				IPRanges:        splitList(form.IPRanges),
+				MaxValidity:     form.MaxValidity,
+				MinRSAKeySize:   form.MinRSAKeySize,
+				MinECDSAKeySize: form.MinECDSAKeySize,
+				AllowedEKUs:     form.AllowedEKUs,
+				AllowSubCAs:     form.AllowSubCAs,
+			}
+		}
+		err := s.storage.SetPolicy(p, policy)
+		if err == nil {
+			s.logger.Info("policy changed", "cert", p, "user", c.GetString(contextUser))
+			c.Redirect(
+				http.StatusSeeOther,
+				fmt.Sprintf("/%s", v.Path),
+			)
+			return
+		}
+		if !errors.Is(err, storage.ErrInvalidInput) {
+			panic(err)
+		}
+		msg = err.Error()
+	} else {
+		policy, err := s.storage.GetPolicy(p)
+		if err != nil {
+			panic(err)
+		}
+		if policy != nil {
+			form = &policyForm{
+				DNSSuffixes:     strings.Join(policy.DNSSuffixes, ", "),
+				IPRanges:        strings.Join(policy.IPRanges, ", "),
+				MaxValidity:     policy.MaxValidity,
+				MinRSAKeySize:   policy.MinRSAKeySize,
+				MinECDSAKeySize: policy.MinECDSAKeySize,
+				AllowedEKUs:     policy.AllowedEKUs,
+				AllowSubCAs:     policy.AllowSubCAs,
+			}
+		}
+	}
+	s.html(c, http.StatusOK, "cert_policy.html", pongo2.Context{
+		"title": "Issuance Policy",
+		"desc": fmt.Sprintf(
+			"Restrict the certificates that %s issues",
+			v.X509.Subject.CommonName,
+		),
+		"cert": v,
+		"form": form,
+		"msg":  msg,
+		"ekus": extKeyUsageOptions,
+		"page": "Issuance Policy",
+	})
+}
+
+func (s *Server) apiGetPolicy(c *gin.Context, p string) {
+	v, err := s.storage.GetPolicy(p)
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	if v == nil {
+		s.apiFail(c, errAPINoPolicy)
+		return
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (s *Server) apiSetPolicy(c *gin.Context, p string) {
+	v := &storage.Policy{}
+	if !s.apiBind(c, v) {
+		return
+	}
+	if err := s.storage.SetPolicy(p, v); err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	s.logger.Info("policy changed", "cert", p, "user", c.GetString(contextUser))
+	s.apiGetPolicy(c, p)
+}
+
+func (s *Server) apiDeletePolicy(c *gin.Context, p string) {
+	if err := s.storage.SetPolicy(p, nil); err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	s.logger.Info("policy removed", "cert", p, "user", c.GetString(contextUser))
+	c.Status(http.StatusNoContent)
+}
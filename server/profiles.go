@@ -0,0 +1,278 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/gin-gonic/gin"
+	"github.com/nathan-osman/certy/auth"
+	"github.com/nathan-osman/certy/storage"
+)
+
+// Profiles apply to certificates issued anywhere in the tree, so managing
+// them requires the admin role for the whole tree.
+const permProfiles = auth.PermIssue | auth.PermDelete
+
+var (
+	errProfileExists = errors.New("a profile with this ID already exists")
+
+	profileFieldLabels = map[string]string{
+		"validity":            "Validity",
+		"backdate":            "Backdate",
+		"parentExpiry":        "Validity outside the issuer's",
+		"keyType":             "Key algorithm",
+		"keySize":             "Key size",
+		"pkcs11":              "PKCS#11 token",
+		"canSign":             "Can sign certificates",
+		"allowChaining":       "Allow chaining",
+		"codeSigning":         "Code signing",
+		"clientAuth":          "Client auth",
+		"serverAuth":          "Server auth",
+		"keyUsages":           "Key usages",
+		"extKeyUsages":        "Extended key usages",
+		"certificatePolicies": "Certificate policies",
+		"mustStaple":          "OCSP must-staple",
+		"extensions":          "Custom extensions",
+		"organization":        "Organization",
+		"organizationalUnit":  "Organizational unit",
+		"country":             "Country code",
+		"province":            "Province or State",
+		"locality":            "City",
+		"streetAddress":       "Street address",
+		"postalCode":          "Postal or zip code",
+	}
+
+	sanTypeOptions = []option{
+		{Value: storage.SANTypeDNS, Label: "DNS names"},
+		{Value: storage.SANTypeIP, Label: "IP addresses"},
+		{Value: storage.SANTypeEmail, Label: "Email addresses"},
+		{Value: storage.SANTypeURI, Label: "URIs"},
+		{Value: storage.SANTypeUPN, Label: "UPNs"},
+	}
+)
+
+// profileForm holds the fields of the profile editor; the embedded
+// parameters are the profile's defaults.
+type profileForm struct {
+	ID               string   `form:"ID"`
+	Name             string   `form:"Name"`
+	Description      string   `form:"Description"`
+	Fixed            []string `form:"Fixed"`
+	RequireSANs      bool     `form:"RequireSANs"`
+	SANTypes         []string `form:"SANTypes"`
+	CommonNameInSANs bool     `form:"CommonNameInSANs"`
+	storage.CreateCertificateParams
+}
+
+// profileFieldOptions returns the parameters that a profile can fix.
+func profileFieldOptions() []option {
+	options := []option{}
+	for _, f := range storage.ProfileFields {
+		options = append(options, option{Value: f, Label: profileFieldLabels[f]})
+	}
+	return options
+}
+
+// fixedLabels returns the labels of the parameters the profile fixes.
+func fixedLabels(p *storage.Profile) []string {
+	labels := []string{}
+	for _, f := range storage.ProfileFields {
+		if p.IsFixed(f) {
+			labels = append(labels, profileFieldLabels[f])
+		}
+	}
+	return labels
+}
+
+// profileOptions returns the profiles for selecting on the new certificate
+// page, starting with the option to not use a profile.
+func (s *Server) profileOptions() []option {
+	options := []option{{Value: "", Label: "None"}}
+	for _, p := range s.storage.GetProfiles() {
+		options = append(options, option{Value: p.ID, Label: p.Name})
+	}
+	return options
+}
+
+// newParams returns the initial parameters for a new certificate, which are
+// the defaults of the named profile if one is provided.
+func (s *Server) newParams(profile string) (*storage.CreateCertificateParams, error) {
+	if profile == "" {
+		return &storage.CreateCertificateParams{
+			KeyType: storage.KeyTypeRSA,
+			KeySize: 2048,
+		}, nil
+	}
+	p, err := s.storage.GetProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+	return p.Params(), nil
+}
+
+// profileContext adds the profile selected by params (if any) to ctx.
+func (s *Server) profileContext(ctx pongo2.Context, params *storage.CreateCertificateParams) {
+	ctx["profiles"] = s.profileOptions()
+	if params.Profile == "" {
+		return
+	}
+	p, err := s.storage.GetProfile(params.Profile)
+	if err != nil {
+		panic(err)
+	}
+	ctx["profile"] = p
+	ctx["fixed"] = fixedLabels(p)
+}
+
+func (s *Server) profileList(c *gin.Context) {
+	profiles := s.storage.GetProfiles()
+	fixed := map[string][]string{}
+	for _, p := range profiles {
+		fixed[p.ID] = fixedLabels(p)
+	}
+	s.html(c, http.StatusOK, "profiles.html", pongo2.Context{
+		"title":    "Profiles",
+		"desc":     "Manage the profiles used to fill in and restrict new certificates",
+		"profiles": profiles,
+		"fixed":    fixed,
+	})
+}
+
+func (s *Server) profileEdit(c *gin.Context) {
+	var (
+		id   = c.Param("id")
+		form = &profileForm{
+			CreateCertificateParams: storage.CreateCertificateParams{
+				KeyType: storage.KeyTypeRSA,
+				KeySize: 2048,
+			},
+		}
+		title = "New Profile"
+		msg   string
+	)
+	if id != "" {
+		p, err := s.storage.GetProfile(id)
+		if err != nil {
+			panic(err)
+		}
+		form = &profileForm{
+			ID:                      p.ID,
+			Name:                    p.Name,
+			Description:             p.Description,
+			Fixed:                   p.Fixed,
+			RequireSANs:             p.RequireSANs,
+			SANTypes:                p.SANTypes,
+			CommonNameInSANs:        p.CommonNameInSANs,
+			CreateCertificateParams: p.Defaults,
+		}
+		title = p.Name
+	}
+	if c.Request.Method == http.MethodPost {
+		form = &profileForm{}
+		if err := c.ShouldBind(form); err != nil {
+			panic(err)
+		}
+		var err error
+		if id != "" {
+			form.ID = id
+		} else if _, e := s.storage.GetProfile(form.ID); e == nil {
+			err = errProfileExists
+		}
+		if err == nil {
+			err = s.storage.SaveProfile(&storage.Profile{
+				ID:               form.ID,
+				Name:             form.Name,
+				Description:      form.Description,
+				Defaults:         form.CreateCertificateParams,
+				Fixed:            form.Fixed,
+				RequireSANs:      form.RequireSANs,
+				SANTypes:         form.SANTypes,
+				CommonNameInSANs: form.CommonNameInSANs,
+			})
+		}
+		if err == nil {
+			s.logger.Info("profile saved", "profile", form.ID, "user", c.GetString(contextUser))
+			c.Redirect(http.StatusSeeOther, "/profiles")
+			return
+		}
+		msg = err.Error()
+	}
+	s.html(c, http.StatusOK, "profile_edit.html", pongo2.Context{
+		"title":        title,
+		"desc":         "Choose the defaults for new certificates and which of them cannot be changed",
+		"id":           id,
+		"form":         form,
+		"msg":          msg,
+		"fields":       profileFieldOptions(),
+		"sanTypes":     sanTypeOptions,
+		"keyTypes":     keyTypeOptions,
+		"keyUsages":    keyUsageOptions,
+		"extKeyUsages": extKeyUsageOptions,
+		"pkcs11":       s.storage.PKCS11Enabled(),
+		"parentExpiry": parentExpiryOptions,
+	})
+}
+
+func (s *Server) profileDelete(c *gin.Context) {
+	id := c.Param("id")
+	if err := s.storage.DeleteProfile(id); err != nil {
+		panic(err)
+	}
+	s.logger.Info("profile deleted", "profile", id, "user", c.GetString(contextUser))
+	c.Redirect(http.StatusSeeOther, "/profiles")
+}
+
+// apiNewParams returns the initial parameters for a new certificate using
+// the profile named in the JSON request body, which must then be bound with
+// apiBind.
+func (s *Server) apiNewParams(c *gin.Context) (*storage.CreateCertificateParams, bool) {
+	v := &struct {
+		Profile string `json:"profile"`
+	}{}
+	if !s.apiBind(c, v) {
+		return nil, false
+	}
+	params, err := s.newParams(v.Profile)
+	if err != nil {
+		s.apiFail(c, err)
+		return nil, false
+	}
+	return params, true
+}
+
+func (s *Server) apiProfiles(c *gin.Context) {
+	c.JSON(http.StatusOK, s.storage.GetProfiles())
+}
+
+func (s *Server) apiGetProfile(c *gin.Context) {
+	p, err := s.storage.GetProfile(c.Param("id"))
+	if err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+func (s *Server) apiSaveProfile(c *gin.Context) {
+	p := &storage.Profile{}
+	if !s.apiBind(c, p) {
+		return
+	}
+	p.ID = c.Param("id")
+	if err := s.storage.SaveProfile(p); err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	s.logger.Info("profile saved", "profile", p.ID, "user", c.GetString(contextUser))
+	s.apiGetProfile(c)
+}
+
+func (s *Server) apiDeleteProfile(c *gin.Context) {
+	if err := s.storage.DeleteProfile(c.Param("id")); err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	s.logger.Info("profile deleted", "profile", c.Param("id"), "user", c.GetString(contextUser))
+	c.Status(http.StatusNoContent)
+}
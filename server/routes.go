@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 
 	"github.com/flosch/pongo2/v6"
 	"github.com/gin-gonic/gin"
@@ -15,12 +16,19 @@ var (
 )
 
 func (s *Server) e404Handler(c *gin.Context) {
-	c.HTML(http.StatusNotFound, "404.html", pongo2.Context{
+	s.html(c, http.StatusNotFound, "404.html", pongo2.Context{
 		"title": "Page Not Found",
 		"desc":  "The page you are attempting to view does not exist",
 	})
 }
 
+func (s *Server) e403Handler(c *gin.Context) {
+	s.html(c, http.StatusForbidden, "403.html", pongo2.Context{
+		"title": "Access Denied",
+		"desc":  "You do not have permission to perform this action",
+	})
+}
+
 func (s *Server) errorHandler(c *gin.Context, err any) {
 	msg := "an unknown error has occurred"
 	switch v := err.(type) {
@@ -29,7 +37,7 @@ func (s *Server) errorHandler(c *gin.Context, err any) {
 	case error:
 		msg = v.Error()
 	}
-	c.HTML(http.StatusInternalServerError, "error.html", pongo2.Context{
+	s.html(c, http.StatusInternalServerError, "error.html", pongo2.Context{
 		"title": "Something Went Wrong",
 		"desc":  "An error was encountered while trying to display the page",
 		"msg":   msg,
@@ -39,10 +47,10 @@ func (s *Server) errorHandler(c *gin.Context, err any) {
 }
 
 func (s *Server) index(c *gin.Context) {
-	c.HTML(http.StatusOK, "index.html", pongo2.Context{
+	s.html(c, http.StatusOK, "index.html", pongo2.Context{
 		"title": "Root Certificates",
 		"desc":  "View root certificates currently managed by Certy",
-		"refs":  s.storage.GetRootCertificates(),
+		"refs":  s.visibleRoots(c),
 	})
 }
 
@@ -51,20 +59,38 @@ func (s *Server) certView(c *gin.Context, p string) {
 	if err != nil {
 		panic(err)
 	}
-	c.HTML(http.StatusOK, "cert_view.html", pongo2.Context{
+	ctx := pongo2.Context{
 		"title":          v.X509.Subject.CommonName,
 		"desc":           "View and manage this certificate and its children",
 		"cert":           v,
+		"clamped":        c.Query("clamped") != "",
 		"combineAddress": combineAddress,
-	})
+		"sanLabels":      sanTypeLabels,
+	}
+
+	// Show a page of the CA's index of issued certificates, newest first
+	if v.MaySign() {
+		issued, err := s.storage.GetIssuedSerials(p)
+		if err != nil {
+			panic(err)
+		}
+		slices.Reverse(issued)
+		ctx["issued"] = paginate(c, issued, ctx)
+		policy, err := s.storage.GetPolicy(p)
+		if err != nil {
+			panic(err)
+		}
+		ctx["policy"] = policy
+		ctx["ekus"] = extKeyUsageOptions
+	}
+	s.html(c, http.StatusOK, "cert_view.html", ctx)
 }
 
 func (s *Server) certNew(c *gin.Context, p string) {
 	var (
-		cert *storage.Certificate
-		form = &storage.CreateCertificateParams{
-			KeySize: 2048,
-		}
+		cert       *storage.Certificate
+		violations []*storage.PolicyViolation
+		msg        string
 	)
 	if p != "" {
 		v, err := s.storage.GetCertificate(p)
@@ -73,30 +99,38 @@ func (s *Server) certNew(c *gin.Context, p string) {
 		}
 		cert = v
 	}
+
+	// Start with the defaults from the selected profile (if any); fields
+	// fixed by the profile are enforced when the certificate is created
+	form, err := s.newParams(c.Query("Profile"))
+	if err != nil {
+		panic(err)
+	}
 	if c.Request.Method == http.MethodPost {
+
+		// Unchecked boxes are not submitted, so the defaults must not be
+		// used here
+		form = &storage.CreateCertificateParams{}
 		if err := c.ShouldBind(form); err != nil {
 			panic(err)
 		}
 		v, err := s.storage.CreateCertificate(p, form)
-		if err != nil {
-			panic(err)
+		if err == nil {
+			redirectIssued(c, v)
+			return
 		}
-		c.Redirect(
-			http.StatusSeeOther,
-			fmt.Sprintf("/%s", v.Path),
-		)
-		return
+		violations, msg = formError(err)
 	} else {
 		if cert != nil {
 			sub := cert.X509.Subject
-			form.Organization = ifPresent(sub.Organization)
-			form.OrganizationalUnit = ifPresent(sub.OrganizationalUnit)
-			form.Country = ifPresent(sub.Country)
-			form.Province = ifPresent(sub.Province)
-			form.Locality = ifPresent(sub.Locality)
-			form.StreetAddress = ifPresent(sub.StreetAddress)
-			form.PostalCode = ifPresent(sub.PostalCode)
-		} else {
+			setIfEmpty(&form.Organization, ifPresent(sub.Organization))
+			setIfEmpty(&form.OrganizationalUnit, ifPresent(sub.OrganizationalUnit))
+			setIfEmpty(&form.Country, ifPresent(sub.Country))
+			setIfEmpty(&form.Province, ifPresent(sub.Province))
+			setIfEmpty(&form.Locality, ifPresent(sub.Locality))
+			setIfEmpty(&form.StreetAddress, ifPresent(sub.StreetAddress))
+			setIfEmpty(&form.PostalCode, ifPresent(sub.PostalCode))
+		} else if form.Profile == "" {
 			form.CanSign = true
 			form.AllowChaining = true
 		}
@@ -110,45 +144,242 @@ func (s *Server) certNew(c *gin.Context, p string) {
 	} else {
 		desc = "Create a new root certificate"
 	}
-	c.HTML(http.StatusOK, "cert_new.html", pongo2.Context{
-		"title": "New Certificate",
+	ctx := pongo2.Context{
+		"title":        "New Certificate",
+		"desc":         desc,
+		"cert":         cert,
+		"form":         form,
+		"violations":   violations,
+		"msg":          msg,
+		"allowSubCAs":  s.allowSubCAs(cert),
+		"page":         "New Certificate",
+		"keyTypes":     keyTypeOptions,
+		"keyUsages":    keyUsageOptions,
+		"extKeyUsages": extKeyUsageOptions,
+		"parentExpiry": parentExpiryOptions,
+		"pkcs11":       s.storage.PKCS11Enabled(),
+	}
+	s.profileContext(ctx, form)
+	s.html(c, http.StatusOK, "cert_new.html", ctx)
+}
+
+func (s *Server) certSign(c *gin.Context, p string) {
+	v, err := s.storage.GetCertificate(p)
+	if err != nil {
+		panic(err)
+	}
+	var (
+		form       = &storage.SignCSRParams{}
+		csr        *storage.CSR
+		violations []*storage.PolicyViolation
+		msg        string
+	)
+	if c.Request.Method == http.MethodPost {
+		if err := c.ShouldBind(form); err != nil {
+			panic(err)
+		}
+		if b, err := formFile(c, "CSRFile"); err != nil {
+			panic(err)
+		} else if b != nil {
+			form.CSR = string(b)
+		}
+		r, err := storage.ParseCSR([]byte(form.CSR))
+		if err != nil {
+			panic(err)
+		}
+		csr = r
+		if c.PostForm("action") == "sign" {
+			v, err := s.storage.SignCSR(p, form)
+			if err == nil {
+				redirectIssued(c, v)
+				return
+			}
+			violations, msg = formError(err)
+		} else {
+
+			// Pre-fill the form with the defaults from the profile and the
+			// values from the CSR for review
+			params, err := s.newParams(form.Profile)
+			if err != nil {
+				panic(err)
+			}
+			form.CreateCertificateParams = *params
+			sub := r.X509.Subject
+			form.CommonName = sub.CommonName
+			setIfEmpty(&form.Organization, ifPresent(sub.Organization))
+			setIfEmpty(&form.OrganizationalUnit, ifPresent(sub.OrganizationalUnit))
+			setIfEmpty(&form.Country, ifPresent(sub.Country))
+			setIfEmpty(&form.Province, ifPresent(sub.Province))
+			setIfEmpty(&form.Locality, ifPresent(sub.Locality))
+			setIfEmpty(&form.StreetAddress, ifPresent(sub.StreetAddress))
+			setIfEmpty(&form.PostalCode, ifPresent(sub.PostalCode))
+			form.SANs = formatSANs(r.SANs())
+		}
+	}
+	ctx := pongo2.Context{
+		"title": "Sign CSR",
+		"desc": fmt.Sprintf(
+			"Sign a certificate signing request with %s",
+			v.X509.Subject.CommonName,
+		),
+		"cert":         v,
+		"csr":          csr,
+		"sanLabels":    sanTypeLabels,
+		"form":         form,
+		"violations":   violations,
+		"msg":          msg,
+		"allowSubCAs":  s.allowSubCAs(v),
+		"keyUsages":    keyUsageOptions,
+		"extKeyUsages": extKeyUsageOptions,
+		"parentExpiry": parentExpiryOptions,
+		"page":         "Sign CSR",
+	}
+	s.profileContext(ctx, &form.CreateCertificateParams)
+	s.html(c, http.StatusOK, "cert_sign.html", ctx)
+}
+
+// importForm holds pasted PEM data; uploaded files take precedence.
+type importForm struct {
+	Certificate string `form:"Certificate"`
+	PrivateKey  string `form:"PrivateKey"`
+	Password    string `form:"Password"`
+}
+
+func (s *Server) certImport(c *gin.Context, p string) {
+	var (
+		cert *storage.Certificate
+		form = &importForm{}
+	)
+	if p != "" {
+		v, err := s.storage.GetCertificate(p)
+		if err != nil {
+			panic(err)
+		}
+		cert = v
+	}
+	if c.Request.Method == http.MethodPost {
+		if err := c.ShouldBind(form); err != nil {
+			panic(err)
+		}
+		params := &storage.ImportCertificateParams{
+			Certificate: []byte(form.Certificate),
+			PrivateKey:  []byte(form.PrivateKey),
+			Password:    form.Password,
+		}
+		if b, err := formFile(c, "CertificateFile"); err != nil {
+			panic(err)
+		} else if b != nil {
+			params.Certificate = b
+		}
+		if b, err := formFile(c, "PrivateKeyFile"); err != nil {
+			panic(err)
+		} else if b != nil {
+			params.PrivateKey = b
+		}
+		v, err := s.storage.ImportCertificate(p, params)
+		if err != nil {
+			panic(err)
+		}
+		c.Redirect(
+			http.StatusSeeOther,
+			fmt.Sprintf("/%s", v.Path),
+		)
+		return
+	}
+	var desc string
+	if cert != nil {
+		desc = fmt.Sprintf(
+			"Import an existing certificate issued by %s or one of its descendants",
+			cert.X509.Subject.CommonName,
+		)
+	} else {
+		desc = "Import an existing certificate or CA"
+	}
+	s.html(c, http.StatusOK, "cert_import.html", pongo2.Context{
+		"title": "Import Certificate",
 		"desc":  desc,
 		"cert":  cert,
 		"form":  form,
-		"page":  "New Certificate",
+		"page":  "Import",
 	})
 }
 
 func (s *Server) certValidate(c *gin.Context, p string) {
+	form := &storage.ValidateParams{}
 	v, err := s.storage.GetCertificate(p)
 	if err != nil {
 		panic(err)
 	}
-	r, err := s.storage.ValidateCertificate(v.Path)
-	if err != nil {
+	if err := c.ShouldBind(form); err != nil {
 		panic(err)
 	}
-	c.HTML(http.StatusOK, "cert_validate.html", pongo2.Context{
-		"title":   "Validation Results",
-		"desc":    "The results of your certificate validation are shown below",
-		"cert":    v,
-		"results": r,
-		"page":    "Validation",
+	var msg string
+	r, err := s.storage.ValidateCertificate(v.Path, form)
+	if err != nil {
+		_, msg = formError(err)
+	}
+	s.html(c, http.StatusOK, "cert_validate.html", pongo2.Context{
+		"title":    "Validation Results",
+		"desc":     "The results of your certificate validation are shown below",
+		"cert":     v,
+		"form":     form,
+		"msg":      msg,
+		"purposes": purposeOptions,
+		"results":  r,
+		"page":     "Validation",
 	})
 }
 
-func (s *Server) certExport(c *gin.Context, p string) {
-	v, err := s.storage.GetCertificate(p)
-	if err != nil {
-		panic(err)
-	}
+// checkForm holds the pasted certificates and the conditions to check them
+// against; an uploaded file takes precedence.
+type checkForm struct {
+	storage.ValidateParams
+	Certificate string `form:"Certificate"`
+	Password    string `form:"Password"`
+}
+
+func (s *Server) certCheck(c *gin.Context) {
 	var (
-		b         []byte
-		suffix    string
-		extension string
-		mime      = "application/x-pem-file"
+		form  = &checkForm{}
+		paths [][]*storage.ValidationResult
+		msg   string
 	)
-	switch c.Query("f") {
+	if c.Request.Method == http.MethodPost {
+		if err := c.ShouldBind(form); err != nil {
+			panic(err)
+		}
+		params := &storage.CheckCertificateParams{
+			ValidateParams: form.ValidateParams,
+			Certificate:    []byte(form.Certificate),
+			Password:       form.Password,
+		}
+		if b, err := formFile(c, "CertificateFile"); err != nil {
+			panic(err)
+		} else if b != nil {
+			params.Certificate = b
+		}
+		v, err := s.storage.CheckCertificate(params)
+		if err != nil {
+			_, msg = formError(err)
+		}
+		paths = v
+	}
+	s.html(c, http.StatusOK, "cert_check.html", pongo2.Context{
+		"title":    "Check a Certificate",
+		"desc":     "Find out whether a certificate chains to a managed CA",
+		"form":     form,
+		"msg":      msg,
+		"purposes": purposeOptions,
+		"paths":    paths,
+		"page":     "Check",
+	})
+}
+
+// export returns the specified certificate or key in the requested format
+// along with the information needed to download it.
+func (s *Server) export(p, f string) (b []byte, mime, suffix, extension string, err error) {
+	mime = "application/x-pem-file"
+	switch f {
 	case "cert_pem":
 		b, err = s.storage.ExportCertificatePEM(p)
 		extension = "pem"
@@ -164,21 +395,50 @@ func (s *Server) certExport(c *gin.Context, p string) {
 		b, err = s.storage.ExportCertificateChainPEM(p)
 		suffix = "-chain"
 		extension = "pem"
+	case "crl_pem":
+		b, err = s.storage.ExportCRLPEM(p)
+		suffix = "-crl"
+		extension = "crl"
+	case "crl_der":
+		b, err = s.storage.ExportCRLDER(p)
+		suffix = "-crl"
+		extension = "crl"
+		mime = "application/pkix-crl"
 	case "pub_key":
 		b, err = s.storage.ExportPublicKeyPEM(p)
 		extension = "pub"
-	case "priv_key":
-		b, err = s.storage.ExportPrivateKeyPEM(p)
-		extension = "key"
 	default:
-		panic(errInvalidFmt)
+		err = errInvalidFmt
 	}
+	return
+}
+
+func (s *Server) certExport(c *gin.Context, p string) {
+	v, err := s.storage.GetCertificate(p)
+	if err != nil {
+		panic(err)
+	}
+	b, mime, suffix, extension, err := s.export(p, c.Query("f"))
 	if err != nil {
 		panic(err)
 	}
 	downloadCert(c, mime, b, v, suffix, extension)
 }
 
+// certKey exports the private key, which has its own route so that it
+// requires permission to export keys.
+func (s *Server) certKey(c *gin.Context, p string) {
+	v, err := s.storage.GetCertificate(p)
+	if err != nil {
+		panic(err)
+	}
+	b, err := s.storage.ExportPrivateKeyPEM(p)
+	if err != nil {
+		panic(err)
+	}
+	downloadCert(c, "application/x-pem-file", b, v, "", "key")
+}
+
 func (s *Server) certPKCS12(c *gin.Context, p string) {
 	form := &storage.ExportCertificatePKCS12Params{}
 	v, err := s.storage.GetCertificate(p)
@@ -200,7 +460,7 @@ func (s *Server) certPKCS12(c *gin.Context, p string) {
 		"Export %s and its private key in PKCS#12 format",
 		v.X509.Subject.CommonName,
 	)
-	c.HTML(http.StatusOK, "cert_pkcs12.html", pongo2.Context{
+	s.html(c, http.StatusOK, "cert_pkcs12.html", pongo2.Context{
 		"title": "Export PKCS#12",
 		"desc":  desc,
 		"cert":  v,
@@ -209,6 +469,96 @@ func (s *Server) certPKCS12(c *gin.Context, p string) {
 	})
 }
 
+func (s *Server) certCRL(c *gin.Context, p string) {
+	b, err := s.storage.ExportCRLDER(p)
+	if err != nil {
+		panic(err)
+	}
+	c.Data(http.StatusOK, "application/pkix-crl", b)
+}
+
+func (s *Server) certRevoke(c *gin.Context, p string) {
+	form := &storage.RevokeCertificateParams{}
+	v, err := s.storage.GetCertificate(p)
+	if err != nil {
+		panic(err)
+	}
+	if c.Request.Method == http.MethodPost {
+		if err := c.ShouldBind(form); err != nil {
+			panic(err)
+		}
+		if err := s.storage.RevokeCertificate(p, form); err != nil {
+			panic(err)
+		}
+		c.Redirect(
+			http.StatusSeeOther,
+			fmt.Sprintf("/%s", v.Path),
+		)
+		return
+	}
+	s.html(c, http.StatusOK, "cert_revoke.html", pongo2.Context{
+		"title":   fmt.Sprintf("Revoke %s", v.X509.Subject.CommonName),
+		"desc":    "Revoke certificate and publish the revocation",
+		"cert":    v,
+		"form":    form,
+		"reasons": reasonOptions(),
+		"page":    "Revoke",
+	})
+}
+
+func (s *Server) certRenew(c *gin.Context, p string) {
+	s.renew(c, p, false)
+}
+
+func (s *Server) certRekey(c *gin.Context, p string) {
+	s.renew(c, p, true)
+}
+
+// renew shows the form for renewing or re-keying a certificate and issues
+// the new version.
+func (s *Server) renew(c *gin.Context, p string, rekey bool) {
+	var (
+		form = &storage.RenewCertificateParams{
+			KeyType: storage.KeyTypeRSA,
+			KeySize: 2048,
+		}
+		title  = "Renew"
+		action = s.storage.RenewCertificate
+		msg    string
+	)
+	if rekey {
+		title = "Re-key"
+		action = s.storage.RekeyCertificate
+	}
+	v, err := s.storage.GetCertificate(p)
+	if err != nil {
+		panic(err)
+	}
+	if c.Request.Method == http.MethodPost {
+		if err := c.ShouldBind(form); err != nil {
+			panic(err)
+		}
+		n, err := action(p, form)
+		if err == nil {
+			redirectIssued(c, n)
+			return
+		}
+		_, msg = formError(err)
+	}
+	s.html(c, http.StatusOK, "cert_renew.html", pongo2.Context{
+		"title":        fmt.Sprintf("%s %s", title, v.X509.Subject.CommonName),
+		"desc":         "Issue a new version of this certificate",
+		"cert":         v,
+		"form":         form,
+		"msg":          msg,
+		"rekey":        rekey,
+		"keyTypes":     keyTypeOptions,
+		"parentExpiry": parentExpiryOptions,
+		"pkcs11":       s.storage.PKCS11Enabled(),
+		"page":         title,
+	})
+}
+
 func (s *Server) certDelete(c *gin.Context, p string) {
 	v, err := s.storage.GetCertificate(p)
 	if err != nil {
@@ -231,7 +581,7 @@ func (s *Server) certDelete(c *gin.Context, p string) {
 		}
 		return
 	}
-	c.HTML(http.StatusOK, "cert_delete.html", pongo2.Context{
+	s.html(c, http.StatusOK, "cert_delete.html", pongo2.Context{
 		"title": fmt.Sprintf("Delete %s", v.X509.Subject.CommonName),
 		"desc":  "Delete certificate and private key",
 		"cert":  v,
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/gin-gonic/gin"
+	"github.com/nathan-osman/certy/auth"
+)
+
+// Sealing and unsealing affects every certificate and sealing blocks issuing
+// for everyone, so it requires the admin role for the whole tree.
+const permSeal = auth.PermIssue | auth.PermDelete
+
+type unsealForm struct {
+	Passphrase string `form:"Passphrase"`
+}
+
+// apiSealStatus is the JSON representation of the seal status.
+type apiSealStatus struct {
+	Encrypted bool `json:"encrypted"`
+	Sealed    bool `json:"sealed"`
+}
+
+// apiUnsealParams is the JSON body for unsealing.
+type apiUnsealParams struct {
+	Passphrase string `json:"passphrase"`
+}
+
+func (s *Server) unseal(c *gin.Context) {
+	var (
+		form = &unsealForm{}
+		msg  string
+	)
+	if c.Request.Method == http.MethodPost {
+		if err := c.ShouldBind(form); err != nil {
+			panic(err)
+		}
+		err := s.storage.Unseal(form.Passphrase)
+		if err == nil {
+			s.logger.Info("storage unsealed", "user", c.GetString(contextUser))
+			c.Redirect(http.StatusSeeOther, "/")
+			return
+		}
+		msg = err.Error()
+	}
+	s.html(c, http.StatusOK, "unseal.html", pongo2.Context{
+		"title": "Unseal",
+		"desc":  "Enter the passphrase to make private keys available",
+		"form":  &unsealForm{},
+		"msg":   msg,
+	})
+}
+
+func (s *Server) seal(c *gin.Context) {
+	if err := s.storage.Seal(); err != nil {
+		panic(err)
+	}
+	s.logger.Info("storage sealed", "user", c.GetString(contextUser))
+	c.Redirect(http.StatusSeeOther, "/")
+}
+
+func (s *Server) apiSealStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, &apiSealStatus{
+		Encrypted: s.storage.Encrypted(),
+		Sealed:    s.storage.Sealed(),
+	})
+}
+
+func (s *Server) apiUnseal(c *gin.Context) {
+	params := &apiUnsealParams{}
+	if !s.apiBind(c, params) {
+		return
+	}
+	if err := s.storage.Unseal(params.Passphrase); err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	s.logger.Info("storage unsealed", "user", c.GetString(contextUser))
+	s.apiSealStatus(c)
+}
+
+func (s *Server) apiSeal(c *gin.Context) {
+	if err := s.storage.Seal(); err != nil {
+		s.apiFail(c, err)
+		return
+	}
+	s.logger.Info("storage sealed", "user", c.GetString(contextUser))
+	s.apiSealStatus(c)
+}
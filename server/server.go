@@ -6,15 +6,18 @@ import (
 	"errors"
 	"io/fs"
 	"log/slog"
+	"maps"
 	"net/http"
 	"regexp"
 	"runtime"
 	"runtime/debug"
 	"slices"
+	"strings"
 
 	"github.com/flosch/pongo2/v6"
 	"github.com/gin-contrib/static"
 	"github.com/gin-gonic/gin"
+	"github.com/nathan-osman/certy/auth"
 	"github.com/nathan-osman/certy/storage"
 	loader "github.com/nathan-osman/pongo2-embed-loader"
 	"gitlab.com/go-box/pongo2gin/v6"
@@ -40,20 +43,52 @@ func init() {
 	pongo2.RegisterFilter("formatBytes", formatBytes)
 	pongo2.RegisterFilter("formatDate", formatDate)
 	pongo2.RegisterFilter("formatDuration", formatDuration)
+	pongo2.RegisterFilter("otherEKUs", otherEKUs)
 }
 
+// internalRoute is an action on a certificate. The permission is checked on
+// the certificate's issuer instead if onIssuer is set, for actions that are
+// performed with the issuer's key or undo what it has signed.
 type internalRoute struct {
+	methods  []string
+	perm     auth.Permission
+	onIssuer bool
+	handler  func(*gin.Context, string)
+}
+
+// apiRoute is the API equivalent of internalRoute for a single method.
+type apiRoute struct {
+	perm     auth.Permission
+	onIssuer bool
+	handler  func(*gin.Context, string)
+}
+
+// pageRoute is a route that is not below a certificate, so its permission is
+// checked for the whole tree; a zero permission lets every user through, for
+// pages that only show what the user may view.
+type pageRoute struct {
 	methods []string
-	handler func(*gin.Context, string)
+	perm    auth.Permission
+	handler gin.HandlerFunc
+}
+
+// apiPageRoute is the API equivalent of pageRoute for a single method.
+type apiPageRoute struct {
+	perm    auth.Permission
+	handler gin.HandlerFunc
 }
 
 // Server provides the web interface for interacting with the CA and
 // certificate functions in the storage package.
 type Server struct {
-	server  http.Server
-	logger  *slog.Logger
-	storage *storage.Storage
-	routes  map[string]internalRoute
+	server        http.Server
+	logger        *slog.Logger
+	auth          *auth.Auth
+	storage       *storage.Storage
+	routes        map[string]internalRoute
+	apiRoutes     map[string]map[string]apiRoute
+	pageRoutes    map[string]pageRoute
+	apiPageRoutes map[string]map[string]apiPageRoute
 }
 
 // New create a new Server instance.
@@ -72,6 +107,7 @@ func New(cfg *Config) (*Server, error) {
 				Handler: r,
 			},
 			logger:  cfg.Logger,
+			auth:    cfg.Auth,
 			storage: cfg.Storage,
 		}
 	)
@@ -82,6 +118,11 @@ func New(cfg *Config) (*Server, error) {
 	}
 	s.logger = s.logger.With("package", "server")
 
+	// Have new certificates point at the endpoints for their issuer
+	if cfg.PublicURL != "" {
+		s.storage.SetIssuerURLs(issuerURLs(cfg.PublicURL))
+	}
+
 	// If debug mode is enabled, use the templates directly from the
 	// filesystem; otherwise, use the built-in ones
 	var tmplLoader pongo2.TemplateLoader
@@ -124,16 +165,19 @@ func New(cfg *Config) (*Server, error) {
 	// Handle 404 page not found
 	r.NoRoute(s.e404Handler)
 
-	// In order to provide URLs of the format:
-	//
-	// / [root] / [intermediate] / [leaf] / [action]
-	//
-	// ...some custom routing logic is required (unfortunately)
-	r.Use(func(c *gin.Context) {
-		if s.routePath(c) {
-			c.Abort()
-		}
-	})
+	// OCSP, AIA, CRL and ACME requests are public and routed normally; they
+	// must be registered before the custom routing logic below so that it
+	// does not apply to them
+	r.POST("/ocsp", s.ocsp)
+	r.GET("/ocsp/*req", s.ocsp)
+	r.GET("/ca/*ref", s.caCert)
+	r.GET("/crl/*ref", s.caCRL)
+	if cfg.ACME != nil {
+		cfg.ACME.Register(r)
+	}
+
+	// API requests require authentication but are otherwise routed normally
+	s.initAPI(r)
 
 	// Static files (use FS if running in debug)
 	var serveFS static.ServeFileSystem
@@ -146,33 +190,195 @@ func New(cfg *Config) (*Server, error) {
 		}
 		serveFS = f
 	}
-	r.Use(static.Serve("/static", serveFS))
+	// The embedded filesystem reports that "/" exists, so requests outside of
+	// /static must not reach the handler
+	serveStatic := static.Serve("/static", serveFS)
+	r.Use(func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, "/static/") {
+			serveStatic(c)
+		}
+	})
+
+	// Everything else requires the user to log in first
+	if s.auth != nil {
+		r.GET("/login", s.login)
+		r.POST("/login", s.login)
+		r.POST("/logout", s.logout)
+		r.Use(s.requireUser)
+		r.GET("/password", s.password)
+		r.POST("/password", s.password)
+	}
+	// Pages that are not below a certificate
+	s.pageRoutes = map[string]pageRoute{
+		"/new": {
+			methods: methodsGetPost,
+			perm:    auth.PermIssue,
+			handler: func(c *gin.Context) { s.certNew(c, "") },
+		},
+		"/import": {
+			methods: methodsGetPost,
+			perm:    auth.PermIssue,
+			handler: func(c *gin.Context) { s.certImport(c, "") },
+		},
+		"/check": {
+			methods: methodsGetPost,
+			perm:    auth.PermView,
+			handler: s.certCheck,
+		},
+		"/unseal": {
+			methods: methodsGetPost,
+			perm:    permSeal,
+			handler: s.unseal,
+		},
+		"/seal": {
+			methods: methodsPost,
+			perm:    permSeal,
+			handler: s.seal,
+		},
+		"/profiles": {
+			methods: methodsGet,
+			perm:    permProfiles,
+			handler: s.profileList,
+		},
+		"/profiles/new": {
+			methods: methodsGetPost,
+			perm:    permProfiles,
+			handler: s.profileEdit,
+		},
+		"/profiles/:id": {
+			methods: methodsGetPost,
+			perm:    permProfiles,
+			handler: s.profileEdit,
+		},
+		"/profiles/:id/delete": {
+			methods: methodsPost,
+			perm:    permProfiles,
+			handler: s.profileDelete,
+		},
+		"/lint": {
+			methods: methodsGet,
+			handler: s.lintReport,
+		},
+		"/pending": {
+			methods: methodsGet,
+			perm:    permPending,
+			handler: s.pendingList,
+		},
+		"/pending/new": {
+			methods: methodsGetPost,
+			perm:    permPending,
+			handler: s.pendingNew,
+		},
+		"/pending/:id": {
+			methods: methodsGetPost,
+			perm:    permPending,
+			handler: s.pendingView,
+		},
+		"/pending/:id/csr": {
+			methods: methodsGet,
+			perm:    permPending,
+			handler: s.pendingCSR,
+		},
+		"/pending/:id/delete": {
+			methods: methodsPost,
+			perm:    permPending,
+			handler: s.pendingDelete,
+		},
+	}
+	for _, p := range slices.Sorted(maps.Keys(s.pageRoutes)) {
+		v := s.pageRoutes[p]
+		for _, m := range v.methods {
+			r.Handle(m, p, s.requirePerm(v.perm), v.handler)
+		}
+	}
+
+	// In order to provide URLs of the format:
+	//
+	// / [root] / [intermediate] / [leaf] / [action]
+	//
+	// ...some custom routing logic is required (unfortunately)
+	r.Use(func(c *gin.Context) {
+		if s.routePath(c) {
+			c.Abort()
+		}
+	})
 
 	// Populate the route map
 	s.routes = map[string]internalRoute{
 		"": {
 			methods: methodsGet,
+			perm:    auth.PermView,
 			handler: s.certView,
 		},
 		"validate": {
 			methods: methodsPost,
+			perm:    auth.PermView,
 			handler: s.certValidate,
 		},
 		"export": {
 			methods: methodsPost,
+			perm:    auth.PermView,
 			handler: s.certExport,
 		},
 		"new": {
 			methods: methodsGetPost,
+			perm:    auth.PermIssue,
 			handler: s.certNew,
 		},
+		"sign": {
+			methods: methodsGetPost,
+			perm:    auth.PermIssue,
+			handler: s.certSign,
+		},
+		"import": {
+			methods: methodsGetPost,
+			perm:    auth.PermIssue,
+			handler: s.certImport,
+		},
+		"policy": {
+			methods:  methodsGetPost,
+			perm:     permPolicy,
+			onIssuer: true,
+			handler:  s.certPolicy,
+		},
+		"key": {
+			methods: methodsPost,
+			perm:    auth.PermExportKey,
+			handler: s.certKey,
+		},
 		"pkcs12": {
 			methods: methodsGetPost,
+			perm:    auth.PermExportKey,
 			handler: s.certPKCS12,
 		},
+		"crl": {
+			methods: methodsGet,
+			perm:    auth.PermView,
+			handler: s.certCRL,
+		},
+		"revoke": {
+			methods:  methodsGetPost,
+			perm:     auth.PermIssue,
+			onIssuer: true,
+			handler:  s.certRevoke,
+		},
+		"renew": {
+			methods:  methodsGetPost,
+			perm:     auth.PermIssue,
+			onIssuer: true,
+			handler:  s.certRenew,
+		},
+		"rekey": {
+			methods:  methodsGetPost,
+			perm:     auth.PermIssue,
+			onIssuer: true,
+			handler:  s.certRekey,
+		},
 		"delete": {
-			methods: methodsGetPost,
-			handler: s.certDelete,
+			methods:  methodsGetPost,
+			perm:     auth.PermDelete,
+			onIssuer: true,
+			handler:  s.certDelete,
 		},
 	}
 
@@ -198,12 +404,6 @@ func (s *Server) routePath(c *gin.Context) bool {
 		return true
 	}
 
-	// Page for creating new root certificates
-	if p == "/new" && slices.Contains(methodsGetPost, c.Request.Method) {
-		s.certNew(c, "")
-		return true
-	}
-
 	// Split the path into / [cert] / [action]
 	v := splitPathRegExp.FindStringSubmatch(p)
 	if len(v) < 2 {
@@ -221,6 +421,12 @@ func (s *Server) routePath(c *gin.Context) bool {
 		return false
 	}
 
+	// Check that the user is allowed to perform the action
+	if !s.authorizeRoute(c, v[1], r.perm, r.onIssuer) {
+		s.e403Handler(c)
+		return true
+	}
+
 	// Route the request
 	r.handler(c, v[1])
 	return true
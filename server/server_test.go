@@ -0,0 +1,327 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/nathan-osman/certy/auth"
+	"github.com/nathan-osman/certy/storage"
+)
+
+const (
+	testViewer   = "viewer"
+	testCAAdmin  = "ca-admin"
+	testIssuer   = "issuer"
+	testAdmin    = "admin"
+	testPassword = "password"
+	testRevoke   = `{"reason":0}`
+)
+
+// testServer holds a server with a root CA, an intermediate CA and a leaf
+// below it, a viewer and an admin scoped to the intermediate CA and an issuer
+// and an admin for the whole tree.
+type testServer struct {
+	t       *testing.T
+	handler http.Handler
+	auth    *auth.Auth
+	storage *storage.Storage
+	inter   *storage.Certificate
+	leaf    *storage.Certificate
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	dataDir := t.TempDir()
+	st, err := storage.New(&storage.Config{DataDir: dataDir})
+	if err != nil {
+		t.Fatalf("new storage: %v", err)
+	}
+	a, err := auth.New(&auth.Config{DataDir: dataDir})
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	root, err := st.CreateCertificate("", &storage.CreateCertificateParams{
+		CommonName:    "Root",
+		Validity:      "1d",
+		CanSign:       true,
+		AllowChaining: true,
+		KeyType:       storage.KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root certificate: %v", err)
+	}
+	inter, err := st.CreateCertificate(root.Path, &storage.CreateCertificateParams{
+		CommonName: "Intermediate",
+		Validity:   "1h",
+		CanSign:    true,
+		KeyType:    storage.KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create intermediate certificate: %v", err)
+	}
+	leaf, err := st.CreateCertificate(inter.Path, &storage.CreateCertificateParams{
+		CommonName: "leaf.example.com",
+		Validity:   "30m",
+		ServerAuth: true,
+		KeyType:    storage.KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create leaf certificate: %v", err)
+	}
+	for _, u := range []struct {
+		name  string
+		grant auth.Grant
+	}{
+		{testViewer, auth.Grant{Role: auth.RoleViewer, Path: inter.Path}},
+		{testCAAdmin, auth.Grant{Role: auth.RoleAdmin, Path: inter.Path}},
+		{testIssuer, auth.Grant{Role: auth.RoleIssuer}},
+		{testAdmin, auth.Grant{Role: auth.RoleAdmin}},
+	} {
+		if err := a.AddUser(u.name, testPassword); err != nil {
+			t.Fatalf("add user: %v", err)
+		}
+		if err := a.AddGrant(u.name, u.grant); err != nil {
+			t.Fatalf("add grant: %v", err)
+		}
+	}
+	s, err := New(&Config{
+		Addr:    "127.0.0.1:0",
+		Auth:    a,
+		Storage: st,
+	})
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return &testServer{
+		t:       t,
+		handler: s.server.Handler,
+		auth:    a,
+		storage: st,
+		inter:   inter,
+		leaf:    leaf,
+	}
+}
+
+// ui makes a request to the web interface as the user, posting the form
+// values (if any), and returns the status code.
+func (ts *testServer) ui(username, method, target string, form url.Values) int {
+	ts.t.Helper()
+	token, err := ts.auth.Login(username, testPassword)
+	if err != nil {
+		ts.t.Fatalf("login: %v", err)
+	}
+	req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: cookieSession, Value: token})
+	w := httptest.NewRecorder()
+	ts.handler.ServeHTTP(w, req)
+	return w.Code
+}
+
+// api makes a request to the API as the user with the JSON body (if any)
+// and returns the status code.
+func (ts *testServer) api(username, method, target, body string) int {
+	ts.t.Helper()
+	return ts.apiResponse(username, method, target, body).Code
+}
+
+// apiResponse is like api but returns the whole response; no credentials
+// are sent if the username is empty.
+func (ts *testServer) apiResponse(username, method, target, body string) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if username != "" {
+		req.SetBasicAuth(username, testPassword)
+	}
+	w := httptest.NewRecorder()
+	ts.handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestScopedViewer(t *testing.T) {
+	ts := newTestServer(t)
+	var (
+		inter = "/" + ts.inter.Path
+		leaf  = "/" + ts.leaf.Path
+		issue = url.Values{
+			"CommonName": {"other.example.com"},
+			"Validity":   {"10m"},
+			"KeyType":    {storage.KeyTypeECDSAP256},
+		}
+	)
+
+	// The viewer may view the certificates in scope...
+	if v := ts.ui(testViewer, http.MethodGet, leaf, nil); v != http.StatusOK {
+		t.Fatalf("view leaf = %d, want 200", v)
+	}
+	if v := ts.api(testViewer, http.MethodGet, "/api/v1/certs"+leaf, ""); v != http.StatusOK {
+		t.Fatalf("API view leaf = %d, want 200", v)
+	}
+
+	// ...but not issue, export keys, revoke or delete anything
+	for _, r := range []struct {
+		method, target string
+		form           url.Values
+	}{
+		{http.MethodPost, inter + "/new", issue},
+		{http.MethodPost, "/new", issue},
+		{http.MethodPost, leaf + "/key", nil},
+		{http.MethodPost, leaf + "/pkcs12", url.Values{"Password": {testPassword}}},
+		{http.MethodPost, leaf + "/revoke", url.Values{"Reason": {"0"}}},
+		{http.MethodPost, leaf + "/delete", nil},
+		{http.MethodGet, "/pending", nil},
+		{http.MethodPost, "/pending/new", issue},
+		{http.MethodGet, "/profiles", nil},
+		{http.MethodPost, "/seal", nil},
+	} {
+		if v := ts.ui(testViewer, r.method, r.target, r.form); v != http.StatusForbidden {
+			t.Fatalf("%s %s = %d, want 403", r.method, r.target, v)
+		}
+	}
+	for _, r := range []struct {
+		method, target, body string
+	}{
+		{http.MethodPost, "/api/v1/certs" + inter + "/children", `{"commonName":"other.example.com","validity":"10m"}`},
+		{http.MethodPost, "/api/v1/certs", `{"commonName":"Other Root","validity":"10m"}`},
+		{http.MethodGet, "/api/v1/certs" + leaf + "/key", ""},
+		{http.MethodPost, "/api/v1/certs" + leaf + "/pkcs12", `{"password":"password"}`},
+		{http.MethodPost, "/api/v1/certs" + leaf + "/revoke", testRevoke},
+		{http.MethodDelete, "/api/v1/certs" + leaf, ""},
+		{http.MethodGet, "/api/v1/pending", ""},
+		{http.MethodPut, "/api/v1/profiles/test", `{"name":"Test"}`},
+		{http.MethodPost, "/api/v1/seal", ""},
+	} {
+		if v := ts.api(testViewer, r.method, r.target, r.body); v != http.StatusForbidden {
+			t.Fatalf("API %s %s = %d, want 403", r.method, r.target, v)
+		}
+	}
+
+	// The private key cannot be reached through the generic export route
+	if v := ts.ui(testViewer, http.MethodPost, leaf+"/export?f=priv_key", nil); v == http.StatusOK {
+		t.Fatal("expected the private key export format to be rejected")
+	}
+	if v := ts.api(testViewer, http.MethodGet, "/api/v1/certs"+leaf+"/export?f=priv_key", ""); v == http.StatusOK {
+		t.Fatal("expected the private key export format to be rejected by the API")
+	}
+
+	// The leaf was neither revoked nor deleted
+	v, err := ts.storage.GetCertificate(ts.leaf.Path)
+	if err != nil {
+		t.Fatalf("get leaf: %v", err)
+	}
+	if v.Revocation != nil {
+		t.Fatal("expected the leaf not to be revoked")
+	}
+}
+
+func TestAdminExportKey(t *testing.T) {
+	ts := newTestServer(t)
+	leaf := "/" + ts.leaf.Path
+	if v := ts.ui(testAdmin, http.MethodPost, leaf+"/key", nil); v != http.StatusOK {
+		t.Fatalf("export key = %d, want 200", v)
+	}
+	if v := ts.api(testAdmin, http.MethodGet, "/api/v1/certs"+leaf+"/key", ""); v != http.StatusOK {
+		t.Fatalf("API export key = %d, want 200", v)
+	}
+	if v := ts.api(testAdmin, http.MethodGet, "/api/v1/pending", ""); v != http.StatusOK {
+		t.Fatalf("API pending = %d, want 200", v)
+	}
+	if v := ts.ui(testAdmin, http.MethodGet, "/profiles", nil); v != http.StatusOK {
+		t.Fatalf("profiles = %d, want 200", v)
+	}
+}
+
+func TestScopedAdmin(t *testing.T) {
+	ts := newTestServer(t)
+	var (
+		inter = "/" + ts.inter.Path
+		leaf  = "/" + ts.leaf.Path
+	)
+
+	// An admin for a CA cannot have its issuer revoke, renew or delete it or
+	// change the policy that restricts it...
+	for _, r := range []struct {
+		method, target string
+		form           url.Values
+	}{
+		{http.MethodPost, inter + "/revoke", url.Values{"Reason": {"0"}}},
+		{http.MethodPost, inter + "/renew", url.Values{"Validity": {"1h"}}},
+		{http.MethodPost, inter + "/rekey", url.Values{"Validity": {"1h"}}},
+		{http.MethodPost, inter + "/delete", nil},
+		{http.MethodGet, inter + "/policy", nil},
+		{http.MethodPost, inter + "/policy", url.Values{"DNSSuffixes": {"example.org"}}},
+	} {
+		if v := ts.ui(testCAAdmin, r.method, r.target, r.form); v != http.StatusForbidden {
+			t.Fatalf("%s %s = %d, want 403", r.method, r.target, v)
+		}
+	}
+	for _, r := range []struct {
+		method, target, body string
+	}{
+		{http.MethodPost, "/api/v1/certs" + inter + "/revoke", testRevoke},
+		{http.MethodPost, "/api/v1/certs" + inter + "/renew", `{"validity":"1h"}`},
+		{http.MethodPost, "/api/v1/certs" + inter + "/rekey", `{"validity":"1h"}`},
+		{http.MethodDelete, "/api/v1/certs" + inter, ""},
+		{http.MethodPut, "/api/v1/certs" + inter + "/policy", `{"dnsSuffixes":["example.org"]}`},
+		{http.MethodDelete, "/api/v1/certs" + inter + "/policy", ""},
+	} {
+		if v := ts.api(testCAAdmin, r.method, r.target, r.body); v != http.StatusForbidden {
+			t.Fatalf("API %s %s = %d, want 403", r.method, r.target, v)
+		}
+	}
+	v, err := ts.storage.GetCertificate(ts.inter.Path)
+	if err != nil {
+		t.Fatalf("get intermediate: %v", err)
+	}
+	if v.Revocation != nil {
+		t.Fatal("expected the intermediate not to be revoked")
+	}
+
+	// ...but may still view the policy and act on the certificates it issued
+	if v := ts.api(testAdmin, http.MethodPut, "/api/v1/certs"+inter+"/policy", `{"dnsSuffixes":["example.com"]}`); v != http.StatusOK {
+		t.Fatalf("API set policy as admin = %d, want 200", v)
+	}
+	if v := ts.api(testCAAdmin, http.MethodGet, "/api/v1/certs"+inter+"/policy", ""); v != http.StatusOK {
+		t.Fatalf("API get policy = %d, want 200", v)
+	}
+	if v := ts.api(testCAAdmin, http.MethodPost, "/api/v1/certs"+leaf+"/renew", `{"validity":"10m"}`); v != http.StatusCreated {
+		t.Fatalf("API renew leaf = %d, want 201", v)
+	}
+	if v := ts.api(testCAAdmin, http.MethodPost, "/api/v1/certs"+leaf+"/revoke", testRevoke); v != http.StatusOK {
+		t.Fatalf("API revoke leaf = %d, want 200", v)
+	}
+	if v := ts.ui(testCAAdmin, http.MethodPost, leaf+"/delete", nil); v != http.StatusSeeOther {
+		t.Fatalf("delete leaf = %d, want 303", v)
+	}
+}
+
+func TestSealRequiresAdmin(t *testing.T) {
+	ts := newTestServer(t)
+	if err := ts.storage.EncryptKeys(testPassword); err != nil {
+		t.Fatalf("encrypt keys: %v", err)
+	}
+
+	// Issuing everywhere is not enough to seal the keys for everyone...
+	if v := ts.ui(testIssuer, http.MethodPost, "/seal", nil); v != http.StatusForbidden {
+		t.Fatalf("seal = %d, want 403", v)
+	}
+	if v := ts.api(testIssuer, http.MethodPost, "/api/v1/seal", ""); v != http.StatusForbidden {
+		t.Fatalf("API seal = %d, want 403", v)
+	}
+	if ts.storage.Sealed() {
+		t.Fatal("expected the keys not to be sealed")
+	}
+
+	// ...which admins for the whole tree may do
+	if v := ts.api(testAdmin, http.MethodPost, "/api/v1/seal", ""); v >= http.StatusBadRequest {
+		t.Fatalf("API seal as admin = %d", v)
+	}
+	if !ts.storage.Sealed() {
+		t.Fatal("expected the keys to be sealed")
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"crypto/x509/pkix"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -20,6 +22,47 @@ const (
 	durYear = 365 * durDay
 )
 
+// option represents a single choice in a select element.
+type option struct {
+	Value string
+	Label string
+}
+
+var keyTypeOptions = []option{
+	{Value: storage.KeyTypeRSA, Label: "RSA"},
+	{Value: storage.KeyTypeECDSAP256, Label: "ECDSA P-256"},
+	{Value: storage.KeyTypeECDSAP384, Label: "ECDSA P-384"},
+	{Value: storage.KeyTypeECDSAP521, Label: "ECDSA P-521"},
+	{Value: storage.KeyTypeEd25519, Label: "Ed25519"},
+}
+
+var sanTypeLabels = map[string]string{
+	storage.SANTypeDNS:   "DNS",
+	storage.SANTypeIP:    "IP",
+	storage.SANTypeEmail: "Email",
+	storage.SANTypeURI:   "URI",
+	storage.SANTypeUPN:   "UPN",
+}
+
+var (
+	keyUsageOptions    = usageOptions(storage.KeyUsages)
+	extKeyUsageOptions = usageOptions(storage.ExtKeyUsages)
+)
+
+// purposeOptions lists the extended key usages that a chain can be validated
+// for, leaving out "any" since that is the default.
+var purposeOptions = append(
+	[]option{{Value: "", Label: "Any purpose"}},
+	slices.DeleteFunc(usageOptions(storage.ExtKeyUsages), func(o option) bool {
+		return o.Value == storage.EKUAny
+	})...,
+)
+
+var parentExpiryOptions = []option{
+	{Value: storage.ParentExpiryClamp, Label: "Shorten it to fit within the issuer's validity"},
+	{Value: storage.ParentExpiryReject, Label: "Refuse to issue it"},
+}
+
 // When capturing the stack, we need to skip five frames:
 // - runtime.Callers() itself
 // - captureStack()
@@ -104,6 +147,29 @@ func formatDuration(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pong
 	), nil
 }
 
+// otherEKUs joins the extended key usages that are OIDs rather than names,
+// since they are entered as text instead of with checkboxes.
+func otherEKUs(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	v, ok := in.Interface().([]string)
+	if !ok {
+		return nil, &pongo2.Error{
+			Sender:    "filter:otherEKUs",
+			OrigError: errors.New("[]string required"),
+		}
+	}
+	values := []string{}
+	for _, u := range v {
+		for _, n := range splitList(u) {
+			if !storage.IsExtKeyUsageName(n) {
+				values = append(values, n)
+			}
+		}
+	}
+	return pongo2.AsValue(
+		strings.Join(values, ", "),
+	), nil
+}
+
 func ifPresent(v []string) string {
 	if len(v) > 0 {
 		return v[0]
@@ -111,6 +177,14 @@ func ifPresent(v []string) string {
 	return ""
 }
 
+// setIfEmpty sets dst to v unless it already has a value, such as a default
+// from a profile.
+func setIfEmpty(dst *string, v string) {
+	if *dst == "" {
+		*dst = v
+	}
+}
+
 func combineAddress(n pkix.Name) string {
 	parts := []string{}
 	for _, v := range [][]string{
@@ -127,6 +201,79 @@ func combineAddress(n pkix.Name) string {
 	return strings.Join(parts, ", ")
 }
 
+// usageOptions returns the usages as options, capitalizing their labels.
+func usageOptions(usages []*storage.Usage) []option {
+	options := []option{}
+	for _, u := range usages {
+		options = append(options, option{
+			Value: u.Name,
+			Label: strings.ToUpper(u.Label[:1]) + u.Label[1:],
+		})
+	}
+	return options
+}
+
+func reasonOptions() []option {
+	var (
+		reasons = []int{}
+		options = []option{}
+	)
+	for k := range storage.ReasonNames {
+		reasons = append(reasons, k)
+	}
+	slices.Sort(reasons)
+	for _, r := range reasons {
+		options = append(options, option{
+			Value: strconv.Itoa(r),
+			Label: storage.ReasonNames[r],
+		})
+	}
+	return options
+}
+
+// formatSANs formats the SANs for the SANs field, only adding the type where
+// it cannot be detected.
+func formatSANs(sans []*storage.SAN) string {
+	v := []string{}
+	for _, s := range sans {
+		if s.Type == storage.SANTypeUPN {
+			v = append(v, s.String())
+		} else {
+			v = append(v, s.Value)
+		}
+	}
+	return strings.Join(v, ", ")
+}
+
+// formFile returns the contents of the uploaded file with the specified name
+// or nil if no file was uploaded.
+func formFile(c *gin.Context, name string) ([]byte, error) {
+	h, err := c.FormFile(name)
+	if err != nil {
+		if errors.Is(err, http.ErrMissingFile) ||
+			errors.Is(err, http.ErrNotMultipart) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	f, err := h.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// redirectIssued shows a newly issued certificate, noting on the page if its
+// validity was shortened to fit its issuer's.
+func redirectIssued(c *gin.Context, cert *storage.Certificate) {
+	u := fmt.Sprintf("/%s", cert.Path)
+	if cert.Clamped {
+		u += "?clamped=1"
+	}
+	c.Redirect(http.StatusSeeOther, u)
+}
+
 func downloadCert(
 	c *gin.Context,
 	mime string,
@@ -151,3 +298,18 @@ func downloadCert(
 	c.Header("Content-Length", strconv.Itoa(len(b)))
 	c.Data(http.StatusOK, mime, b)
 }
+
+const pageSize = 25
+
+// paginate returns the page of items selected by the "page" query parameter,
+// adding the page number and count to ctx for rendering page links.
+func paginate[T any](c *gin.Context, items []T, ctx pongo2.Context) []T {
+	var (
+		pages   = max(1, (len(items)+pageSize-1)/pageSize)
+		page, _ = strconv.Atoi(c.Query("page"))
+	)
+	page = min(max(1, page), pages)
+	ctx["pageNum"] = page
+	ctx["pageCount"] = pages
+	return items[(page-1)*pageSize : min(page*pageSize, len(items))]
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/x509"
+)
+
+var errNoCAWithKeyID = notFoundError("no CA has the specified key ID")
+
+// IssuerURLs are embedded in the certificates that a CA issues so that
+// clients can download the CA certificate (AIA caIssuers), check the status
+// of the certificate (AIA OCSP) and download the CA's CRL (CRL distribution
+// point). Empty URLs are left out.
+type IssuerURLs struct {
+	CAIssuers string
+	OCSP      string
+	CRL       string
+}
+
+// SetIssuerURLs sets the function that provides the URLs to embed in the
+// certificates issued by a CA, replacing those of a certificate being
+// renewed. No URLs are added if fn is nil.
+func (s *Storage) SetIssuerURLs(fn func(issuer *Ref) *IssuerURLs) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.issuerURLs = fn
+}
+
+// setIssuerURLs adds the URLs for the issuer p to the template.
+func (s *Storage) setIssuerURLs(p *storageCert, cert *x509.Certificate) {
+	if s.issuerURLs == nil {
+		return
+	}
+	u := s.issuerURLs(newRef(p))
+	cert.IssuingCertificateURL = ifProvided(u.CAIssuers)
+	cert.OCSPServer = ifProvided(u.OCSP)
+	cert.CRLDistributionPoints = ifProvided(u.CRL)
+}
+
+// GetCAByKeyID returns the CA with the provided subject key ID. If the CA was
+// renewed without changing its key, the most recent certificate is returned.
+func (s *Storage) GetCAByKeyID(keyID []byte) (*Certificate, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var found *storageCert
+	walk(s.rootCerts, func(c *storageCert) {
+		if !c.maySign() || len(keyID) == 0 || !bytes.Equal(c.cert.SubjectKeyId, keyID) {
+			return
+		}
+		if found == nil || c.cert.NotBefore.After(found.cert.NotBefore) ||
+			(c.lineage != nil && c.lineage.Previous == found.id) {
+			found = c
+		}
+	})
+	if found == nil {
+		return nil, errNoCAWithKeyID
+	}
+	return convertCert(found), nil
+}
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestIssuerURLs(t *testing.T) {
+	s := newTestStorage(t, t.TempDir())
+
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1h",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root certificate: %v", err)
+	}
+
+	// Certificates issued before the URLs are set do not include any
+	c, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "30m",
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	if len(c.X509.IssuingCertificateURL) != 0 || len(c.X509.CRLDistributionPoints) != 0 {
+		t.Fatal("expected no issuer URLs")
+	}
+
+	s.SetIssuerURLs(func(issuer *Ref) *IssuerURLs {
+		id := hex.EncodeToString(issuer.X509.SubjectKeyId)
+		return &IssuerURLs{
+			CAIssuers: "http://ca.test/ca/" + id + ".crt",
+			OCSP:      "http://ca.test/ocsp",
+			CRL:       "http://ca.test/crl/" + id + ".crl",
+		}
+	})
+	id := hex.EncodeToString(root.X509.SubjectKeyId)
+	check := func(c *Certificate) {
+		t.Helper()
+		if !slices.Equal(c.X509.IssuingCertificateURL, []string{"http://ca.test/ca/" + id + ".crt"}) ||
+			!slices.Equal(c.X509.OCSPServer, []string{"http://ca.test/ocsp"}) ||
+			!slices.Equal(c.X509.CRLDistributionPoints, []string{"http://ca.test/crl/" + id + ".crl"}) {
+			t.Fatalf("unexpected issuer URLs: %v %v %v",
+				c.X509.IssuingCertificateURL, c.X509.OCSPServer, c.X509.CRLDistributionPoints)
+		}
+	}
+
+	// New and renewed certificates include the URLs but roots do not
+	c, err = s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "30m",
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	check(c)
+	renewed, err := s.RenewCertificate(c.Path, &RenewCertificateParams{})
+	if err != nil {
+		t.Fatalf("renew certificate: %v", err)
+	}
+	check(renewed)
+	renewedRoot, err := s.RenewCertificate(root.Path, &RenewCertificateParams{})
+	if err != nil {
+		t.Fatalf("renew root certificate: %v", err)
+	}
+	if len(renewedRoot.X509.OCSPServer) != 0 {
+		t.Fatal("expected no issuer URLs for a root")
+	}
+
+	// The CA is found by its key ID, preferring the renewed certificate
+	v, err := s.GetCAByKeyID(root.X509.SubjectKeyId)
+	if err != nil {
+		t.Fatalf("get CA by key ID: %v", err)
+	}
+	if v.Path != renewedRoot.Path {
+		t.Fatalf("GetCAByKeyID returned %s, want %s", v.Path, renewedRoot.Path)
+	}
+	if _, err := s.GetCAByKeyID(c.X509.SubjectKeyId); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a leaf not to be found, got %v", err)
+	}
+}
+
+func TestCRLByKeyIDAfterRenewal(t *testing.T) {
+	s := newTestStorage(t, t.TempDir())
+
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "2h",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root certificate: %v", err)
+	}
+	var children []*Certificate
+	for range 2 {
+		c, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+			CommonName: childCertCN,
+			Validity:   "30m",
+			KeyType:    KeyTypeECDSAP256,
+		})
+		if err != nil {
+			t.Fatalf("create certificate: %v", err)
+		}
+		children = append(children, c)
+	}
+	revoke := func(c *Certificate) {
+		t.Helper()
+		if err := s.RevokeCertificate(c.Path, &RevokeCertificateParams{
+			Reason: ReasonKeyCompromise,
+		}); err != nil {
+			t.Fatalf("revoke certificate: %v", err)
+		}
+	}
+	serials := func() []string {
+		t.Helper()
+		ca, err := s.GetCAByKeyID(root.X509.SubjectKeyId)
+		if err != nil {
+			t.Fatalf("get CA by key ID: %v", err)
+		}
+		b, err := s.ExportCRLDER(ca.Path)
+		if err != nil {
+			t.Fatalf("export CRL: %v", err)
+		}
+		l, err := x509.ParseRevocationList(b)
+		if err != nil {
+			t.Fatalf("parse CRL: %v", err)
+		}
+		if err := l.CheckSignatureFrom(ca.X509); err != nil {
+			t.Fatalf("CRL signature: %v", err)
+		}
+		v := []string{}
+		for _, e := range l.RevokedCertificateEntries {
+			v = append(v, e.SerialNumber.String())
+		}
+		slices.Sort(v)
+		return v
+	}
+
+	// Revocations made before and after renewing the CA with the same key
+	// are both listed in the CRL found by key ID, which is the new version's
+	revoke(children[0])
+	renewed, err := s.RenewCertificate(root.Path, &RenewCertificateParams{})
+	if err != nil {
+		t.Fatalf("renew root: %v", err)
+	}
+	if ca, err := s.GetCAByKeyID(root.X509.SubjectKeyId); err != nil || ca.Path != renewed.Path {
+		t.Fatalf("expected the renewed CA to be found by key ID (%v)", err)
+	}
+	if v := serials(); !slices.Equal(v, []string{children[0].X509.SerialNumber.String()}) {
+		t.Fatalf("CRL serials = %v, want the first child", v)
+	}
+	revoke(children[1])
+	want := []string{
+		children[0].X509.SerialNumber.String(),
+		children[1].X509.SerialNumber.String(),
+	}
+	slices.Sort(want)
+	if v := serials(); !slices.Equal(v, want) {
+		t.Fatalf("CRL serials = %v, want %v", v, want)
+	}
+}
@@ -1,17 +1,16 @@
 package storage
 
 import (
+	"crypto"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/hex"
 	"encoding/pem"
-	"math/big"
-	"net"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
-	"unicode"
 
 	"go.mozilla.org/pkcs7"
 	"software.sslmate.com/src/go-pkcs12"
@@ -24,11 +23,6 @@ type Ref struct {
 	X509 *x509.Certificate
 }
 
-// Private key holds information about a certificate's private key.
-type PrivateKey struct {
-	Size int
-}
-
 // Certificate represents an X.509 certificate in a format suitable for
 // rendering to templates.
 type Certificate struct {
@@ -39,6 +33,21 @@ type Certificate struct {
 	X509        *x509.Certificate
 	Children    []*Ref
 	PrivateKey  *PrivateKey
+	Revocation  *Revocation
+
+	// Previous is the certificate this one renewed or re-keyed and Next
+	// lists the certificates that renewed or re-keyed this one; these are
+	// only set by GetCertificate.
+	Previous *Ref
+	Next     []*Ref
+
+	// Clamped indicates that the requested validity was shortened so that
+	// the certificate starts and expires within its issuer's validity; this
+	// is only set by the methods that issue certificates.
+	Clamped bool
+
+	// Findings are the lint rules that the certificate does not pass.
+	Findings []*LintFinding
 }
 
 // IsExpired indicates whether the certificate is expired or not.
@@ -59,27 +68,12 @@ func (c *Certificate) CanSign() bool {
 	return c.MaySign() && c.PrivateKey != nil
 }
 
-// KeyUsage provides a human-friendly list of possible uses.
-func (c *Certificate) KeyUsage() []string {
-	usages := []string{}
-	if c.X509.KeyUsage&x509.KeyUsageCertSign != 0 {
-		usages = append(usages, "certificate signing")
+func newRef(c *storageCert) *Ref {
+	return &Ref{
+		ID:   c.id,
+		Path: c.vPath,
+		X509: c.cert,
 	}
-	if c.X509.KeyUsage&x509.KeyUsageDigitalSignature != 0 {
-		usages = append(usages, "digital signature")
-	}
-	if c.X509.KeyUsage&x509.KeyUsageKeyEncipherment != 0 {
-		usages = append(usages, "key encipherment")
-	}
-	for _, u := range c.X509.ExtKeyUsage {
-		switch u {
-		case x509.ExtKeyUsageClientAuth:
-			usages = append(usages, "client auth")
-		case x509.ExtKeyUsageServerAuth:
-			usages = append(usages, "server auth")
-		}
-	}
-	return usages
 }
 
 func parentList(p *storageCert) []*Ref {
@@ -88,13 +82,7 @@ func parentList(p *storageCert) []*Ref {
 		v       = p
 	)
 	for v != nil {
-		parents = append([]*Ref{
-			{
-				ID:   v.id,
-				Path: v.vPath,
-				X509: v.cert,
-			},
-		}, parents...)
+		parents = append([]*Ref{newRef(v)}, parents...)
 		v = v.parent
 	}
 	return parents
@@ -102,17 +90,13 @@ func parentList(p *storageCert) []*Ref {
 
 func childList(m map[string]*storageCert) []*Ref {
 	children := []*Ref{}
-	for k, v := range m {
-		children = append(children, &Ref{
-			ID:   k,
-			Path: v.vPath,
-			X509: v.cert,
-		})
+	for _, v := range m {
+		children = append(children, newRef(v))
 	}
 	return children
 }
 
-func convertCert(cert *storageCert, key *rsa.PrivateKey) *Certificate {
+func convertCert(cert *storageCert) *Certificate {
 	c := &Certificate{
 		ID:          cert.id,
 		Path:        cert.vPath,
@@ -120,10 +104,12 @@ func convertCert(cert *storageCert, key *rsa.PrivateKey) *Certificate {
 		Fingerprint: cert.fingerprint,
 		X509:        cert.cert,
 		Children:    childList(cert.children),
+		Findings:    lint(cert.cert),
 	}
-	if key != nil {
-		c.PrivateKey = &PrivateKey{
-			Size: key.Size() * 8,
+	if cert.hasKey {
+		c.PrivateKey = describePublicKey(cert.cert.PublicKey)
+		if c.PrivateKey != nil && cert.keyRef != nil {
+			c.PrivateKey.Token = cert.keyRef.Token
 		}
 	}
 	return c
@@ -144,65 +130,41 @@ func (s *Storage) GetCertificate(certPath string) (*Certificate, error) {
 	if err != nil {
 		return nil, err
 	}
-	var key *rsa.PrivateKey
-	if c.hasKey {
-		k, err := loadPrivateKey(filepath.Join(c.fPath, filenamePrivateKey))
-		if err != nil {
-			return nil, err
-		}
-		key = k
+	r, err := s.getRevocation(c)
+	if err != nil {
+		return nil, err
 	}
-	return convertCert(c, key), nil
+	v := convertCert(c)
+	v.Revocation = r
+	s.setLineage(c, v)
+	return v, nil
 }
 
-// ValidationResult indicates the validity of a single certificate in a chain
-// represented by Err being nil or not.
-type ValidationResult struct {
-	X509 *x509.Certificate
-	Err  string
-}
-
-// ValidateCertificate attempts to validate the specified certificate. The
-// result is returned as a slice indicating the validity of each link in the
-// chain of trust.
-func (s *Storage) ValidateCertificate(certPath string) ([]*ValidationResult, error) {
+// FindCertificate locates a managed certificate by its DER-encoded contents.
+// The private key is not included.
+func (s *Storage) FindCertificate(der []byte) (*Certificate, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
-	c, err := s.getCert(certPath)
-	if err != nil {
-		return nil, err
-	}
 	var (
-		results    []*ValidationResult
-		pRoot      = x509.NewCertPool()
-		pImed      = x509.NewCertPool()
-		foundError bool
+		h           = sha256.Sum256(der)
+		fingerprint = hex.EncodeToString(h[:])
+		found       *storageCert
 	)
-	for i, c := range c.chain() {
-		result := &ValidationResult{
-			X509: c.cert,
-		}
-		if i == 0 {
-			pRoot.AddCert(c.cert)
-		}
-		if _, err := c.cert.Verify(x509.VerifyOptions{
-			Roots:         pRoot,
-			Intermediates: pImed,
-			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
-		}); err != nil {
-			if foundError {
-				result.Err = "cannot validate because parent certificate failed to validate"
-			} else {
-				result.Err = err.Error()
-				foundError = true
-			}
-		}
-		results = append(results, result)
-		if i > 0 {
-			pImed.AddCert(c.cert)
+	walk(s.rootCerts, func(c *storageCert) {
+		if c.fingerprint == fingerprint {
+			found = c
 		}
+	})
+	if found == nil {
+		return nil, errCertDoesNotExist
 	}
-	return results, nil
+	r, err := s.getRevocation(found)
+	if err != nil {
+		return nil, err
+	}
+	v := convertCert(found)
+	v.Revocation = r
+	return v, nil
 }
 
 // ExportCertificatePEM exports the specified certificate as a PEM-encoded
@@ -287,7 +249,10 @@ func (s *Storage) ExportCertificatePKCS12(
 	if err != nil {
 		return nil, err
 	}
-	k, err := loadPrivateKey(filepath.Join(c.fPath, filenamePrivateKey))
+	if c.keyRef != nil {
+		return nil, errKeyNotExportable
+	}
+	k, err := s.loadSigner(c)
 	if err != nil {
 		return nil, err
 	}
@@ -317,11 +282,7 @@ func (s *Storage) ExportPublicKeyPEM(certPath string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	k, err := loadPrivateKey(filepath.Join(c.fPath, filenamePrivateKey))
-	if err != nil {
-		return nil, err
-	}
-	b, err := x509.MarshalPKIXPublicKey(&k.PublicKey)
+	b, err := x509.MarshalPKIXPublicKey(c.cert.PublicKey)
 	if err != nil {
 		return nil, err
 	}
@@ -340,32 +301,78 @@ func (s *Storage) ExportPrivateKeyPEM(certPath string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	b, err := os.ReadFile(filepath.Join(c.fPath, filenamePrivateKey))
+	if c.keyRef != nil {
+		return nil, errKeyNotExportable
+	}
+	k, err := s.loadSigner(c)
 	if err != nil {
 		return nil, err
 	}
-	return b, nil
+
+	// Keys are always exported unencrypted
+	return encodePrivateKey(k, nil, nil)
 }
 
 // CreateCertificateParams provides CreateCertificate with parameters for
 // creating a new X.509 certificate and private key.
 type CreateCertificateParams struct {
-	CommonName         string
-	Organization       string
-	OrganizationalUnit string
-	Country            string
-	Province           string
-	Locality           string
-	StreetAddress      string
-	PostalCode         string
-	Validity           string
-	CanSign            bool
-	AllowChaining      bool
-	CodeSigning        bool
-	ClientAuth         bool
-	ServerAuth         bool
-	SANs               string
-	KeySize            int
+	CommonName         string `json:"commonName"`
+	Organization       string `json:"organization"`
+	OrganizationalUnit string `json:"organizationalUnit"`
+	Country            string `json:"country"`
+	Province           string `json:"province"`
+	Locality           string `json:"locality"`
+	StreetAddress      string `json:"streetAddress"`
+	PostalCode         string `json:"postalCode"`
+	Validity           string `json:"validity"`
+	CanSign            bool   `json:"canSign"`
+	AllowChaining      bool   `json:"allowChaining"`
+	CodeSigning        bool   `json:"codeSigning"`
+	ClientAuth         bool   `json:"clientAuth"`
+	ServerAuth         bool   `json:"serverAuth"`
+	SANs               string `json:"sans"`
+	KeyType            string `json:"keyType"`
+	KeySize            int    `json:"keySize"`
+	PKCS11             bool   `json:"pkcs11"`
+
+	// KeyUsages (KeyUsage* values) and ExtKeyUsages (EKU* values or OIDs) are
+	// added to the usages requested by the flags above. CertificatePolicies
+	// has one policy per line: its OID followed by any CPS URIs. MustStaple
+	// adds the TLS feature extension requiring OCSP stapling. Extensions has
+	// one custom extension per line: its OID, "critical" if it is critical
+	// and its DER encoded value in hex.
+	KeyUsages           []string `json:"keyUsages"`
+	ExtKeyUsages        []string `json:"extKeyUsages"`
+	CertificatePolicies string   `json:"certificatePolicies"`
+	MustStaple          bool     `json:"mustStaple"`
+	Extensions          string   `json:"extensions"`
+
+	// The validity is counted from NotBefore (or now if empty) unless
+	// NotAfter is provided; both are RFC 3339 dates or dates and times in
+	// UTC. Backdate moves the start back to allow for clock skew and
+	// ParentExpiry is one of the ParentExpiry* values.
+	NotBefore    string `json:"notBefore"`
+	NotAfter     string `json:"notAfter"`
+	Backdate     string `json:"backdate"`
+	ParentExpiry string `json:"parentExpiry"`
+
+	// Name constraints restrict the names that a CA and the CAs below it may
+	// issue certificates for. Each is a comma or space separated list of DNS
+	// domains (a leading "." or "*." only matches names below the domain),
+	// IP ranges in CIDR notation, email addresses or domains and URI hosts.
+	PermittedDNSDomains     string `json:"permittedDNSDomains"`
+	ExcludedDNSDomains      string `json:"excludedDNSDomains"`
+	PermittedIPRanges       string `json:"permittedIPRanges"`
+	ExcludedIPRanges        string `json:"excludedIPRanges"`
+	PermittedEmailAddresses string `json:"permittedEmailAddresses"`
+	ExcludedEmailAddresses  string `json:"excludedEmailAddresses"`
+	PermittedURIDomains     string `json:"permittedURIDomains"`
+	ExcludedURIDomains      string `json:"excludedURIDomains"`
+	NameConstraintsCritical bool   `json:"nameConstraintsCritical"`
+
+	// Profile is the ID of the profile to issue the certificate with; the
+	// fields it fixes replace the values above and its SAN rules apply.
+	Profile string `json:"profile"`
 }
 
 // CreateCertificate creates a new certificate & private key. The newly
@@ -377,18 +384,19 @@ func (s *Storage) CreateCertificate(
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	// Apply the profile before anything is created
+	params, err := s.applyProfile(params)
+	if err != nil {
+		return nil, err
+	}
+
 	// Begin by loading the parent certificate (if supplied)
-	var (
-		p         *storageCert
-		parentDir = s.certDir
-	)
-	if certPath != "" {
-		v, err := s.getCert(certPath)
-		if err != nil {
-			return nil, err
-		}
-		p = v
-		parentDir = v.fPath
+	p, parentDir, err := s.getParent(certPath)
+	if err != nil {
+		return nil, err
+	}
+	if p != nil && (!p.hasKey || !p.maySign()) {
+		return nil, errParentCantSign
 	}
 
 	// The directory for the certificate and private key needs to be created
@@ -402,74 +410,148 @@ func (s *Storage) CreateCertificate(
 	}
 	defer os.RemoveAll(d)
 
-	// Parse the validity duration
-	v, err := parseDuration(params.Validity)
+	// Generate a new private key; it must not be left behind on the token if
+	// the certificate cannot be created (tokenKey is cleared on success)
+	k, tokenKey, err := s.generateKey(d, params.KeyType, params.KeySize, params.PKCS11)
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if tokenKey != nil {
+			s.token.deleteKey(tokenKey)
+		}
+	}()
+	var writeKey func(string, string) error
+	if tokenKey == nil {
+		writeKey = s.keyWriter(k)
+	}
 
-	// Generate a new private key
-	k, err := generatePrivateKey(
-		filepath.Join(d, filenamePrivateKey),
-		params.KeySize,
-	)
+	// Create the certificate and add it to the tree
+	c, err := s.issueCertificate(p, d, params, k.Public(), k, writeKey)
 	if err != nil {
 		return nil, err
 	}
+	tokenKey = nil
 
-	// Use the new key if this is a root CA; otherwise, load the parent's
-	certPrivateKey := k
-	if p != nil {
-		k, err := loadPrivateKey(filepath.Join(parentDir, filenamePrivateKey))
-		if err != nil {
-			return nil, err
-		}
-		certPrivateKey = k
+	// Return the new certificate
+	return c, nil
+}
+
+// generateKey creates a new private key for the certificate being created in
+// d, either on the token or in memory. The reference to the key on the token
+// is returned so that the key can be removed if the certificate cannot be
+// created; a key in memory is written with keyWriter once the ID of its
+// owner is known (which is not possible if keys are encrypted and sealed).
+func (s *Storage) generateKey(
+	d, keyType string,
+	bits int,
+	onToken bool,
+) (crypto.Signer, *keyRef, error) {
+	if onToken {
+		return s.generateTokenKey(d, keyType, bits)
+	}
+	if _, err := s.writeMasterKey(); err != nil {
+		return nil, nil, err
+	}
+	k, err := newSigner(keyType, bits)
+	if err != nil {
+		return nil, nil, err
 	}
+	return k, nil, nil
+}
 
-	// Use 1 for the serial if this is a root CA; otherwise, allocate the next
-	// serial number from the parent
-	var serial int64 = 1
-	if p != nil {
-		v, err := s.allocNextSerial(parentDir)
+// keyWriter returns a function that writes k to the directory of the
+// certificate with the specified ID or nil if there is no key.
+func (s *Storage) keyWriter(k crypto.Signer) func(string, string) error {
+	if k == nil {
+		return nil
+	}
+	return func(d, id string) error {
+		masterKey, err := s.writeMasterKey()
 		if err != nil {
-			return nil, err
+			return err
 		}
-		serial = v
+		return writePrivateKey(filepath.Join(d, filenamePrivateKey), k, masterKey, id)
+	}
+}
+
+// getParent looks up the certificate that will sign a new certificate along
+// with the directory it will be stored in. An empty path indicates a root.
+func (s *Storage) getParent(certPath string) (*storageCert, string, error) {
+	if certPath == "" {
+		return nil, s.certDir, nil
+	}
+	p, err := s.getCert(certPath)
+	if err != nil {
+		return nil, "", err
+	}
+	return p, p.fPath, nil
+}
+
+// issueCertificate builds a certificate from the provided parameters and
+// stores it using storeCertificate.
+func (s *Storage) issueCertificate(
+	p *storageCert,
+	d string,
+	params *CreateCertificateParams,
+	publicKey crypto.PublicKey,
+	selfKey crypto.Signer,
+	writeKey func(string, string) error,
+) (*Certificate, error) {
+
+	// Determine the validity period
+	notBefore, notAfter, err := validityPeriod(params)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create the certificate template
-	var (
-		n    = time.Now()
-		cert = &x509.Certificate{
-			SerialNumber: big.NewInt(serial),
-			Subject: pkix.Name{
-				Country:            ifProvided(params.Country),
-				Organization:       ifProvided(params.Organization),
-				OrganizationalUnit: ifProvided(params.OrganizationalUnit),
-				Locality:           ifProvided(params.Locality),
-				Province:           ifProvided(params.Province),
-				StreetAddress:      ifProvided(params.StreetAddress),
-				PostalCode:         ifProvided(params.PostalCode),
-				CommonName:         params.CommonName,
-			},
-			NotBefore:             n,
-			NotAfter:              n.Add(v),
-			BasicConstraintsValid: true,
-			IsCA:                  params.CanSign,
-		}
-	)
+	cert, err := newTemplate(params, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	cert.NotBefore = notBefore
+	cert.NotAfter = notAfter
 
-	// Use the certificate as its own parent if this is a root CA; otherwise,
-	// use the parent's certificate
-	parentCert := cert
-	if p != nil {
-		parentCert = p.cert
+	// Make sure the certificate does not outlive its issuer
+	clamped, err := fitIssuer(p, cert, params.ParentExpiry)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := s.storeCertificate(p, d, cert, publicKey, selfKey, writeKey)
+	if err != nil {
+		return nil, err
+	}
+	v := convertCert(c)
+	v.Clamped = clamped
+	return v, nil
+}
+
+// newTemplate builds the subject and extensions of a new certificate from
+// the parameters; the caller sets the validity.
+func newTemplate(
+	params *CreateCertificateParams,
+	publicKey crypto.PublicKey,
+) (*x509.Certificate, error) {
+	cert := &x509.Certificate{
+		Subject: pkix.Name{
+			Country:            ifProvided(params.Country),
+			Organization:       ifProvided(params.Organization),
+			OrganizationalUnit: ifProvided(params.OrganizationalUnit),
+			Locality:           ifProvided(params.Locality),
+			Province:           ifProvided(params.Province),
+			StreetAddress:      ifProvided(params.StreetAddress),
+			PostalCode:         ifProvided(params.PostalCode),
+			CommonName:         params.CommonName,
+		},
+		BasicConstraintsValid: true,
+		IsCA:                  params.CanSign,
 	}
 
 	// Set the flags
 	if params.CanSign {
-		cert.KeyUsage |= x509.KeyUsageCertSign
+		cert.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
 	}
 	if cert.IsCA && !params.AllowChaining {
 		cert.MaxPathLenZero = true
@@ -489,60 +571,150 @@ func (s *Storage) CreateCertificate(
 		)
 	}
 	if params.ServerAuth {
-		cert.KeyUsage |=
-			x509.KeyUsageDigitalSignature |
-				x509.KeyUsageKeyEncipherment
+		cert.KeyUsage |= x509.KeyUsageDigitalSignature
+		// Key encipherment only makes sense for RSA keys
+		if _, ok := publicKey.(*rsa.PublicKey); ok {
+			cert.KeyUsage |= x509.KeyUsageKeyEncipherment
+		}
 		cert.ExtKeyUsage = append(
 			cert.ExtKeyUsage,
 			x509.ExtKeyUsageServerAuth,
 		)
 	}
 
+	// Add the other usages and extensions that were requested
+	if err := setExtensions(cert, params); err != nil {
+		return nil, err
+	}
+
 	// If SANs were provided (usually required for web servers), include them
-	// as well; check each value to see if it is an IP address or domain
-	if params.SANs != "" {
-		for _, v := range strings.FieldsFunc(
-			params.SANs,
-			func(c rune) bool {
-				return c == ',' || unicode.IsSpace(c)
-			},
-		) {
-			i := net.ParseIP(v)
-			if i != nil {
-				cert.IPAddresses = append(cert.IPAddresses, i)
-			} else {
-				cert.DNSNames = append(cert.DNSNames, v)
-			}
+	// as well
+	sans, err := ParseSANs(params.SANs)
+	if err != nil {
+		return nil, err
+	}
+	if err := setSANs(cert, sans); err != nil {
+		return nil, err
+	}
+
+	// Add the name constraints, which are only allowed for CAs
+	if err := setNameConstraints(cert, params); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// storeCertificate signs the template with the parent's private key (or
+// selfKey if p is nil), writes it to the temporary directory d and then moves
+// the directory into its final place in the tree. A random serial number is
+// assigned to the template and recorded in the issuer's index. The private
+// key (if any) is added to the directory with writeKey, which is passed the
+// ID of the new certificate.
+func (s *Storage) storeCertificate(
+	p *storageCert,
+	d string,
+	cert *x509.Certificate,
+	publicKey crypto.PublicKey,
+	selfKey crypto.Signer,
+	writeKey func(string, string) error,
+) (*storageCert, error) {
+
+	// Use the new key if this is a root CA; otherwise, check the parent's
+	// policy, add its URLs and load its key
+	certPrivateKey := selfKey
+	if p != nil {
+		if err := s.checkPolicy(p, cert, publicKey); err != nil {
+			return nil, err
+		}
+		s.setIssuerURLs(p, cert)
+		k, err := s.loadSigner(p)
+		if err != nil {
+			return nil, err
+		}
+		certPrivateKey = k
+	}
+
+	// Generate a random serial number that the issuer has not used before;
+	// a new root starts its own (empty) index
+	var (
+		issued   = []*IssuedSerial{}
+		indexDir = d
+	)
+	if p != nil {
+		v, err := s.loadIndex(p)
+		if err != nil {
+			return nil, err
 		}
+		issued = v
+		indexDir = p.fPath
+	}
+	serial, err := newSerial(issued)
+	if err != nil {
+		return nil, err
+	}
+	cert.SerialNumber = serial
+
+	// Use the certificate as its own parent if this is a root CA; otherwise,
+	// use the parent's certificate
+	parentCert := cert
+	if p != nil {
+		parentCert = p.cert
 	}
 
 	// FINALLY, create the actual certificate
-	if err := s.createCertificate(
-		d,
+	if err := s.writeCertificate(
+		filepath.Join(d, filenameCert),
 		cert,
 		parentCert,
-		&k.PublicKey,
+		publicKey,
 		certPrivateKey,
 	); err != nil {
 		return nil, err
 	}
 
+	// Add the private key now that the certificate's ID is known
+	if writeKey != nil {
+		x, err := loadCertificate(filepath.Join(d, filenameCert))
+		if err != nil {
+			return nil, err
+		}
+		if err := writeKey(d, certID(x)); err != nil {
+			return nil, err
+		}
+	}
+
 	// ...and load it from disk
 	c, err := s.loadCert(d, p)
 	if err != nil {
 		return nil, err
 	}
 
+	// Record the serial in the issuer's index
+	if err := saveIssued(indexDir, append(issued, newIssuedSerial(c))); err != nil {
+		return nil, err
+	}
+
+	if err := s.addCert(p, d, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// addCert moves the temporary directory d of a new certificate (loaded from
+// d) into its final place in the tree and adds it to p (or the roots if p is
+// nil).
+func (s *Storage) addCert(p *storageCert, d string, c *storageCert) error {
+
 	// The order of the next two tasks is important - the rename should be the
 	// last action that can fail (return error) since (basically) everything
 	// up until this point will be destroyed by the defer RemoveAll() call
-	// above on failure; and adding the storageCert to its parent should only
-	// be done when the layout on disk is complete
+	// in the caller on failure; and adding the storageCert to its parent
+	// should only be done when the layout on disk is complete
 
 	// Rename the directory to the certificate's ID
-	newDir := filepath.Join(parentDir, c.id)
+	newDir := filepath.Join(filepath.Dir(d), c.id)
 	if err := os.Rename(d, newDir); err != nil {
-		return nil, err
+		return err
 	}
 
 	// ...update the certificate's fPath to point to the new directory...
@@ -555,13 +727,16 @@ func (s *Storage) CreateCertificate(
 		p.children[c.id] = c
 	}
 
-	// Return the new certificate
-	return convertCert(c, k), nil
+	// Cached OCSP responses may report the serial as unknown
+	s.invalidateOCSP()
+
+	return nil
 }
 
 // DeleteCertificate removes a certificate and its private key from disk. Note
 // that this will also delete all stored certificates and private keys signed
-// by it. This will not revoke the certificate.
+// by it. This will not revoke the certificate; use RevokeCertificate first if
+// that is required.
 func (s *Storage) DeleteCertificate(
 	certPath string,
 ) error {
@@ -585,6 +760,10 @@ func (s *Storage) DeleteCertificate(
 	} else {
 		delete(s.rootCerts, c.id)
 	}
+	s.invalidateOCSP()
+
+	// ...and remove any keys it and its children have on the token
+	s.deleteTokenKeys(c)
 
 	// Successfully deleted
 	return nil
@@ -1,8 +1,8 @@
 package storage
 
 import (
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/hex"
@@ -21,7 +21,7 @@ const (
 
 var (
 	errNotACert         = errors.New("file is not a PEM-encoded certificate")
-	errCertDoesNotExist = errors.New("certificate does not exist")
+	errCertDoesNotExist = notFoundError("certificate does not exist")
 )
 
 // Note: vPath refers to a certificate via the internal storage map. fPath
@@ -36,6 +36,12 @@ type storageCert struct {
 	cert        *x509.Certificate
 	children    map[string]*storageCert
 	hasKey      bool
+	keyRef      *keyRef
+	lineage     *lineage
+}
+
+func (s *storageCert) maySign() bool {
+	return s.cert.IsCA && s.cert.KeyUsage&x509.KeyUsageCertSign != 0
 }
 
 func (s *storageCert) chain() []*storageCert {
@@ -53,6 +59,14 @@ func (s *storageCert) chain() []*storageCert {
 	return certs
 }
 
+// walk invokes fn for every certificate in the tree.
+func walk(m map[string]*storageCert, fn func(*storageCert)) {
+	for _, c := range m {
+		fn(c)
+		walk(c.children, fn)
+	}
+}
+
 func (s *Storage) loadCerts(
 	dir string,
 	parent *storageCert,
@@ -81,8 +95,8 @@ func (s *Storage) loadCerts(
 	return certs, nil
 }
 
-func (s *Storage) loadCert(dir string, parent *storageCert) (*storageCert, error) {
-	b, err := os.ReadFile(filepath.Join(dir, filenameCert))
+func loadCertificate(filename string) (*x509.Certificate, error) {
+	b, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
@@ -90,7 +104,18 @@ func (s *Storage) loadCert(dir string, parent *storageCert) (*storageCert, error
 	if block == nil || block.Type != typeCertificate {
 		return nil, errNotACert
 	}
-	x, err := x509.ParseCertificate(block.Bytes)
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// certID returns the ID of the certificate, which is used as the name of its
+// directory.
+func certID(x *x509.Certificate) string {
+	h := sha256.Sum256(x.Raw)
+	return hex.EncodeToString(h[:6])
+}
+
+func (s *Storage) loadCert(dir string, parent *storageCert) (*storageCert, error) {
+	x, err := loadCertificate(filepath.Join(dir, filenameCert))
 	if err != nil {
 		return nil, err
 	}
@@ -98,9 +123,17 @@ func (s *Storage) loadCert(dir string, parent *storageCert) (*storageCert, error
 	if err != nil {
 		return nil, err
 	}
+	r, err := loadKeyRef(filepath.Join(dir, filenameKeyRef))
+	if err != nil {
+		return nil, err
+	}
+	l, err := loadLineage(dir)
+	if err != nil {
+		return nil, err
+	}
 	var (
 		h       = sha256.Sum256(x.Raw)
-		id      = hex.EncodeToString(h[:6])
+		id      = certID(x)
 		vPrefix string
 	)
 	if parent != nil {
@@ -114,7 +147,9 @@ func (s *Storage) loadCert(dir string, parent *storageCert) (*storageCert, error
 			parent:      parent,
 			fingerprint: hex.EncodeToString(h[:]),
 			cert:        x,
-			hasKey:      e,
+			hasKey:      e || r != nil,
+			keyRef:      r,
+			lineage:     l,
 		}
 	)
 	certs, err := s.loadCerts(dir, c)
@@ -142,11 +177,11 @@ func (s *Storage) getCert(vPath string) (*storageCert, error) {
 	return c, nil
 }
 
-func (s *Storage) createCertificate(
-	dir string,
+func (s *Storage) writeCertificate(
+	filename string,
 	template, parent *x509.Certificate,
-	publicKey *rsa.PublicKey,
-	privateKey *rsa.PrivateKey,
+	publicKey crypto.PublicKey,
+	privateKey crypto.Signer,
 ) error {
 	c, err := x509.CreateCertificate(
 		rand.Reader,
@@ -163,7 +198,7 @@ func (s *Storage) createCertificate(
 		Bytes: c,
 	})
 	f, err := os.OpenFile(
-		filepath.Join(dir, filenameCert),
+		filename,
 		os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
 		0600,
 	)
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/x509"
+	"slices"
+)
+
+// Limits on the paths built when checking a certificate, which guard against
+// bundles with many cross-signed intermediates.
+const (
+	maxCheckPaths = 16
+	maxCheckDepth = 8
+)
+
+// CheckCertificateParams provides CheckCertificate with the certificate to
+// check and the conditions that it must meet.
+type CheckCertificateParams struct {
+	ValidateParams
+
+	// Certificate holds the certificate along with any intermediates in any
+	// of the formats accepted by ImportCertificate. Private keys are ignored.
+	Certificate []byte
+
+	// Password decrypts a PKCS#12 bundle.
+	Password string
+}
+
+// findLeaf returns the first certificate that did not sign any of the
+// others, which is the one being checked.
+func findLeaf(certs []*x509.Certificate) *x509.Certificate {
+	for _, c := range certs {
+		if !slices.ContainsFunc(certs, func(x *x509.Certificate) bool {
+			return x != c && isIssuer(c, x)
+		}) {
+			return c
+		}
+	}
+	return certs[0]
+}
+
+// findManaged returns the managed certificate identical to x or nil.
+func (s *Storage) findManaged(x *x509.Certificate) *storageCert {
+	var found *storageCert
+	walk(s.rootCerts, func(c *storageCert) {
+		if bytes.Equal(c.cert.Raw, x.Raw) {
+			found = c
+		}
+	})
+	return found
+}
+
+// inChain indicates whether x is already one of the links.
+func inChain(links []*chainLink, x *x509.Certificate) bool {
+	return slices.ContainsFunc(links, func(l *chainLink) bool {
+		return bytes.Equal(l.cert.Raw, x.Raw)
+	})
+}
+
+// findPaths returns every chain from a managed CA down to the links, which
+// are built upwards from the certificate being checked using the supplied
+// intermediates.
+func (s *Storage) findPaths(links []*chainLink, supplied []*x509.Certificate) [][]*chainLink {
+	top := links[0].cert
+	if c := s.findManaged(top); c != nil {
+		return [][]*chainLink{append(managedChain(c), links[1:]...)}
+	}
+	paths := [][]*chainLink{}
+	walk(s.rootCerts, func(c *storageCert) {
+		if len(paths) < maxCheckPaths && c.cert.IsCA && isIssuer(c.cert, top) {
+			paths = append(paths, slices.Concat(
+				managedChain(c),
+				[]*chainLink{{cert: top, issuer: c}},
+				links[1:],
+			))
+		}
+	})
+	if len(links) == maxCheckDepth {
+		return paths
+	}
+	for _, x := range supplied {
+		if len(paths) == maxCheckPaths {
+			break
+		}
+		if inChain(links, x) || !isIssuer(x, top) || s.findManaged(x) != nil {
+			continue
+		}
+		paths = append(paths, s.findPaths(
+			append([]*chainLink{{cert: x}}, links...),
+			supplied,
+		)...)
+	}
+	return paths[:min(len(paths), maxCheckPaths)]
+}
+
+// suppliedChain builds the chain for the certificate from the supplied
+// intermediates alone, for showing where a chain that does not lead to a
+// managed CA ends.
+func suppliedChain(leaf *x509.Certificate, supplied []*x509.Certificate) []*chainLink {
+	links := []*chainLink{{cert: leaf}}
+	for len(links) < maxCheckDepth {
+		i := slices.IndexFunc(supplied, func(x *x509.Certificate) bool {
+			return !inChain(links, x) && isIssuer(x, links[0].cert)
+		})
+		if i == -1 {
+			break
+		}
+		links = append([]*chainLink{{cert: supplied[i]}}, links...)
+	}
+	return links
+}
+
+// CheckCertificate validates a certificate that was not necessarily issued
+// by Certy, such as one received from a vendor, using the managed CAs as
+// trust anchors along with any intermediates provided with it. Every path
+// from a managed CA to the certificate is validated the same way as
+// ValidateCertificate, with valid paths first. If there is no such path, the
+// chain built from the supplied certificates is returned with its root
+// failing validation.
+func (s *Storage) CheckCertificate(params *CheckCertificateParams) ([][]*ValidationResult, error) {
+	o, err := params.options()
+	if err != nil {
+		return nil, err
+	}
+	certs, _, err := parseImport(params.Certificate, params.Password)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errImportNoCert
+	}
+	leaf := findLeaf(certs)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var (
+		paths    = s.findPaths([]*chainLink{{cert: leaf}}, certs)
+		anchored = len(paths) > 0
+		results  = [][]*ValidationResult{}
+	)
+	if !anchored {
+		paths = [][]*chainLink{suppliedChain(leaf, certs)}
+	}
+	for _, p := range paths {
+		r, err := s.validateChain(p, o, anchored)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	slices.SortStableFunc(results, func(a, b []*ValidationResult) int {
+		va, vb := a[len(a)-1].Err == "", b[len(b)-1].Err == ""
+		switch {
+		case va && !vb:
+			return -1
+		case vb && !va:
+			return 1
+		}
+		return 0
+	})
+	return results, nil
+}
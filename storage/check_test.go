@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"slices"
+	"testing"
+
+	"go.mozilla.org/pkcs7"
+)
+
+func TestCheckCertificate(t *testing.T) {
+	s := newTestStorage(t, t.TempDir())
+
+	var keys []*ecdsa.PrivateKey
+	for range 3 {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		keys = append(keys, k)
+	}
+	var (
+		root  = newExternalCert(t, rootCertCN, true, keys[0], nil, nil)
+		imed  = newExternalCert(t, "Vendor CA", true, keys[1], root, keys[0])
+		leaf  = newExternalCert(t, childCertCN, false, keys[2], imed, keys[1])
+		other = newExternalCert(t, "Other Root", true, keys[1], nil, nil)
+	)
+	if _, err := s.ImportCertificate("", &ImportCertificateParams{
+		Certificate: encodeCertPEM(root),
+	}); err != nil {
+		t.Fatalf("import root: %v", err)
+	}
+
+	check := func(b []byte) [][]*ValidationResult {
+		t.Helper()
+		paths, err := s.CheckCertificate(&CheckCertificateParams{
+			ValidateParams: ValidateParams{Revocation: true},
+			Certificate:    b,
+		})
+		if err != nil {
+			t.Fatalf("check certificate: %v", err)
+		}
+		return paths
+	}
+	commonNames := func(path []*ValidationResult) []string {
+		v := []string{}
+		for _, r := range path {
+			v = append(v, r.X509.Subject.CommonName)
+		}
+		return v
+	}
+
+	// The supplied intermediate links the leaf to the managed root, in
+	// whatever order the bundle has them
+	p7, err := pkcs7.NewSignedData(nil)
+	if err != nil {
+		t.Fatalf("create PKCS#7: %v", err)
+	}
+	p7.AddCertificate(imed)
+	p7.AddCertificate(leaf)
+	b, err := p7.Finish()
+	if err != nil {
+		t.Fatalf("encode PKCS#7: %v", err)
+	}
+	for _, b := range [][]byte{
+		slices.Concat(encodeCertPEM(leaf), encodeCertPEM(imed)),
+		b,
+	} {
+		paths := check(b)
+		if len(paths) != 1 {
+			t.Fatalf("got %d paths, want 1", len(paths))
+		}
+		want := []string{rootCertCN, "Vendor CA", childCertCN}
+		if v := commonNames(paths[0]); !slices.Equal(v, want) {
+			t.Fatalf("path = %v, want %v", v, want)
+		}
+		for _, r := range paths[0] {
+			if r.Err != "" {
+				t.Fatalf("validation failed: %s", r.Err)
+			}
+		}
+	}
+
+	// Without the intermediate (or with an unrelated root) the chain does not
+	// lead to a managed CA
+	for _, b := range [][]byte{
+		encodeCertPEM(leaf),
+		encodeCertPEM(other),
+	} {
+		paths := check(b)
+		if len(paths) != 1 || len(paths[0]) != 1 {
+			t.Fatalf("expected a single unanchored link, got %d paths", len(paths))
+		}
+		if v := failedCheck(paths[0][0]); v != CheckSignature {
+			t.Fatalf("unanchored certificate failed %q", v)
+		}
+	}
+
+	// Managed certificates use their own chain
+	paths := check(encodeCertPEM(root))
+	if len(paths) != 1 || len(paths[0]) != 1 || paths[0][0].Err != "" {
+		t.Fatal("expected the managed root to be valid")
+	}
+
+	if _, err := s.CheckCertificate(&CheckCertificateParams{
+		Certificate: []byte("invalid"),
+	}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid data to be rejected, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"log/slog"
+	"time"
 )
 
 // Config provides configuration for Storage.
@@ -11,6 +12,29 @@ type Config struct {
 	// An empty value indicates the current directory.
 	DataDir string
 
+	// CRLValidity specifies how long each generated CRL is valid for (the
+	// interval between its thisUpdate and nextUpdate fields). A zero value
+	// indicates the default of seven days.
+	CRLValidity time.Duration
+
+	// OCSPValidity specifies how long each OCSP response is valid for. A zero
+	// value indicates the default of one day.
+	OCSPValidity time.Duration
+
+	// OCSPDelegate indicates that OCSP responses should be signed by a
+	// delegated OCSP signing certificate issued by each CA instead of the CA
+	// key itself.
+	OCSPDelegate bool
+
+	// Passphrase is used to unseal storage on startup when private keys are
+	// encrypted. If empty, storage starts sealed and Unseal must be called
+	// before private keys can be used.
+	Passphrase string
+
+	// PKCS11 enables generating private keys on a PKCS#11 token. If nil,
+	// private keys are only stored on disk.
+	PKCS11 *PKCS11Config
+
 	// Logger can be used to capture log messages.
 	Logger *slog.Logger
 }
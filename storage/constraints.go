@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+var errConstraintsNotCA = inputError("name constraints can only be added to certificates that can sign others")
+
+// NameConstraint lists the names of one type (SANTypeDNS, SANTypeIP,
+// SANTypeEmail or SANTypeURI) that a CA and the CAs below it
+// may (if Permitted is not empty) and may not issue certificates for.
+type NameConstraint struct {
+	Type      string
+	Permitted []string
+	Excluded  []string
+}
+
+// NameConstraints returns the name constraints of the certificate, one entry
+// for each type of name that is constrained.
+func (c *Certificate) NameConstraints() []*NameConstraint {
+	v := []*NameConstraint{}
+	for _, s := range constrainedSets(c.X509) {
+		v = append(v, &NameConstraint{
+			Type:      s.nameType,
+			Permitted: s.permitted,
+			Excluded:  s.excluded,
+		})
+	}
+	return v
+}
+
+// constraintSet holds the constraints of a CA for one type of name along
+// with the names of that type in the certificate being checked.
+type constraintSet struct {
+	nameType  string
+	names     []string
+	permitted []string
+	excluded  []string
+	match     func(name, constraint string) bool
+}
+
+// constraintSets returns the name constraints of ca for each type of name;
+// the names are taken from cert if it is provided.
+func constraintSets(ca, cert *x509.Certificate) []*constraintSet {
+	s := []*constraintSet{
+		{
+			nameType:  SANTypeDNS,
+			permitted: ca.PermittedDNSDomains,
+			excluded:  ca.ExcludedDNSDomains,
+			match:     matchDomain,
+		},
+		{
+			nameType:  SANTypeIP,
+			permitted: ipNetStrings(ca.PermittedIPRanges),
+			excluded:  ipNetStrings(ca.ExcludedIPRanges),
+			match:     matchIP,
+		},
+		{
+			nameType:  SANTypeEmail,
+			permitted: ca.PermittedEmailAddresses,
+			excluded:  ca.ExcludedEmailAddresses,
+			match:     matchEmail,
+		},
+		{
+			nameType:  SANTypeURI,
+			permitted: ca.PermittedURIDomains,
+			excluded:  ca.ExcludedURIDomains,
+			match:     matchURI,
+		},
+	}
+	if cert != nil {
+		s[0].names = cert.DNSNames
+		for _, i := range cert.IPAddresses {
+			s[1].names = append(s[1].names, i.String())
+		}
+		s[2].names = cert.EmailAddresses
+		for _, u := range cert.URIs {
+			s[3].names = append(s[3].names, u.String())
+		}
+	}
+	return s
+}
+
+func ipNetStrings(v []*net.IPNet) []string {
+	s := []string{}
+	for _, n := range v {
+		s = append(s, n.String())
+	}
+	return s
+}
+
+// matchDomain indicates whether the name is within the domain; a domain
+// starting with "." only matches the names below it.
+func matchDomain(name, domain string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	domain = strings.ToLower(domain)
+	if strings.HasPrefix(domain, ".") {
+		return strings.HasSuffix(name, domain)
+	}
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+// matchHost is like matchDomain but a domain not starting with "." only
+// matches the host itself, as is the case for email and URI constraints.
+func matchHost(host, domain string) bool {
+	if strings.HasPrefix(domain, ".") {
+		return matchDomain(host, domain)
+	}
+	return strings.EqualFold(host, domain)
+}
+
+func matchIP(ip, ipRange string) bool {
+	_, n, err := net.ParseCIDR(ipRange)
+	return err == nil && n.Contains(net.ParseIP(ip))
+}
+
+func matchEmail(addr, constraint string) bool {
+	if strings.Contains(constraint, "@") {
+		return strings.EqualFold(addr, constraint)
+	}
+	i := strings.LastIndex(addr, "@")
+	return i != -1 && matchHost(addr[i+1:], constraint)
+}
+
+func matchURI(uri, domain string) bool {
+	u, err := url.Parse(uri)
+	return err == nil && u.Hostname() != "" && matchHost(u.Hostname(), domain)
+}
+
+func parseConstraintDomains(v string) ([]string, error) {
+	d := []string{}
+	for _, n := range splitSANs(strings.ToLower(v)) {
+		n = strings.TrimPrefix(n, "*")
+		if strings.ContainsAny(n, "@/:") || strings.Trim(n, ".") == "" {
+			return nil, inputError(fmt.Sprintf("invalid domain %s in name constraints", n))
+		}
+		d = append(d, n)
+	}
+	return d, nil
+}
+
+func parseConstraintRanges(v string) ([]*net.IPNet, error) {
+	r := []*net.IPNet{}
+	for _, n := range splitSANs(v) {
+		i, err := parseIPRange(n)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, i)
+	}
+	return r, nil
+}
+
+// parseConstraintEmails parses a list of email addresses and domains; a
+// domain matches every address at it.
+func parseConstraintEmails(v string) ([]string, error) {
+	e := []string{}
+	for _, n := range splitSANs(v) {
+		i := strings.LastIndex(n, "@")
+		if i == 0 || strings.Trim(n[i+1:], ".") == "" {
+			return nil, inputError(fmt.Sprintf("invalid email address %s in name constraints", n))
+		}
+		e = append(e, n)
+	}
+	return e, nil
+}
+
+// setNameConstraints adds the name constraints from params to the template,
+// which must be a CA if there are any.
+func setNameConstraints(cert *x509.Certificate, params *CreateCertificateParams) error {
+	var err error
+	if cert.PermittedDNSDomains, err = parseConstraintDomains(params.PermittedDNSDomains); err != nil {
+		return err
+	}
+	if cert.ExcludedDNSDomains, err = parseConstraintDomains(params.ExcludedDNSDomains); err != nil {
+		return err
+	}
+	if cert.PermittedIPRanges, err = parseConstraintRanges(params.PermittedIPRanges); err != nil {
+		return err
+	}
+	if cert.ExcludedIPRanges, err = parseConstraintRanges(params.ExcludedIPRanges); err != nil {
+		return err
+	}
+	if cert.PermittedEmailAddresses, err = parseConstraintEmails(params.PermittedEmailAddresses); err != nil {
+		return err
+	}
+	if cert.ExcludedEmailAddresses, err = parseConstraintEmails(params.ExcludedEmailAddresses); err != nil {
+		return err
+	}
+	if cert.PermittedURIDomains, err = parseConstraintDomains(params.PermittedURIDomains); err != nil {
+		return err
+	}
+	if cert.ExcludedURIDomains, err = parseConstraintDomains(params.ExcludedURIDomains); err != nil {
+		return err
+	}
+	if len(constrainedSets(cert)) == 0 {
+		return nil
+	}
+	if !cert.IsCA {
+		return errConstraintsNotCA
+	}
+	cert.PermittedDNSDomainsCritical = params.NameConstraintsCritical
+	return nil
+}
+
+// constrainedSets returns the constraint sets of the CA that restrict
+// anything.
+func constrainedSets(ca *x509.Certificate) []*constraintSet {
+	v := []*constraintSet{}
+	for _, s := range constraintSets(ca, nil) {
+		if len(s.permitted) != 0 || len(s.excluded) != 0 {
+			v = append(v, s)
+		}
+	}
+	return v
+}
+
+// constraintViolations checks the names in the certificate template against
+// the name constraints of p and every CA above it.
+func constraintViolations(p *storageCert, cert *x509.Certificate) []*PolicyViolation {
+	violations := []*PolicyViolation{}
+	for c := p; c != nil; c = c.parent {
+		for _, s := range constraintSets(c.cert, cert) {
+			for _, n := range s.names {
+				matches := func(constraint string) bool {
+					return s.match(n, constraint)
+				}
+				var reason string
+				switch {
+				case slices.ContainsFunc(s.excluded, matches):
+					reason = "excluded"
+				case len(s.permitted) != 0 && !slices.ContainsFunc(s.permitted, matches):
+					reason = "not permitted"
+				default:
+					continue
+				}
+				violations = append(violations, &PolicyViolation{
+					Field: "sans",
+					Message: fmt.Sprintf(
+						"%s is %s by the name constraints of %s",
+						n,
+						reason,
+						c.cert.Subject.CommonName,
+					),
+				})
+			}
+		}
+	}
+	return violations
+}
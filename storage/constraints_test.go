@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNameConstraints(t *testing.T) {
+	s := newTestStorage(t, t.TempDir())
+
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName:    rootCertCN,
+		Validity:      "1h",
+		CanSign:       true,
+		AllowChaining: true,
+		KeyType:       KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root certificate: %v", err)
+	}
+	partner, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName:              "Partner CA",
+		Validity:                "30m",
+		CanSign:                 true,
+		AllowChaining:           true,
+		KeyType:                 KeyTypeECDSAP256,
+		PermittedDNSDomains:     "*.partner.example.com",
+		ExcludedDNSDomains:      "secret.partner.example.com",
+		PermittedIPRanges:       "10.20.0.0/16",
+		NameConstraintsCritical: true,
+	})
+	if err != nil {
+		t.Fatalf("create constrained CA: %v", err)
+	}
+	x := partner.X509
+	if len(x.PermittedDNSDomains) != 1 || x.PermittedDNSDomains[0] != ".partner.example.com" ||
+		len(x.PermittedIPRanges) != 1 || !x.PermittedDNSDomainsCritical {
+		t.Fatal("expected name constraints in the certificate")
+	}
+	if v := partner.NameConstraints(); len(v) != 2 || v[0].Type != SANTypeDNS || v[1].Type != SANTypeIP {
+		t.Fatalf("unexpected name constraints: %v", v)
+	}
+
+	// Names within the constraints are allowed
+	if _, err := s.CreateCertificate(partner.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "10m",
+		SANs:       "www.partner.example.com 10.20.1.1",
+		KeyType:    KeyTypeECDSAP256,
+	}); err != nil {
+		t.Fatalf("create allowed certificate: %v", err)
+	}
+
+	// Every name outside of them is reported, including for CAs below the
+	// constrained CA
+	_, err = s.CreateCertificate(partner.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "10m",
+		SANs:       "partner.example.com www.example.com a.secret.partner.example.com 10.21.0.1",
+		KeyType:    KeyTypeECDSAP256,
+	})
+	var e *PolicyError
+	if !errors.As(err, &e) || len(e.Violations) != 4 {
+		t.Fatalf("expected four violations, got %v", err)
+	}
+	sub, err := s.CreateCertificate(partner.Path, &CreateCertificateParams{
+		CommonName: "Partner Sub CA",
+		Validity:   "20m",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create sub CA: %v", err)
+	}
+	_, err = s.CreateCertificate(sub.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "10m",
+		SANs:       "www.example.com",
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if !errors.As(err, &e) || !strings.Contains(e.Violations[0].Message, "Partner CA") {
+		t.Fatalf("expected inherited constraint violation, got %v", err)
+	}
+
+	// Only CAs can have name constraints and they must be valid
+	for _, params := range []*CreateCertificateParams{
+		{PermittedDNSDomains: "example.com"},
+		{CanSign: true, PermittedIPRanges: "10.0.0.0/33"},
+		{CanSign: true, ExcludedEmailAddresses: "@example.com"},
+		{CanSign: true, PermittedURIDomains: "https://example.com"},
+	} {
+		params.CommonName = "Invalid"
+		params.Validity = "10m"
+		params.KeyType = KeyTypeECDSAP256
+		if _, err := s.CreateCertificate(root.Path, params); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("expected %+v to be rejected, got %v", params, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+)
+
+const (
+	typeCertificateRequest    = "CERTIFICATE REQUEST"
+	typeNewCertificateRequest = "NEW CERTIFICATE REQUEST"
+)
+
+var (
+	errNotACSR        = inputError("data is not a PEM-encoded certificate signing request")
+	errCSRNoParent    = inputError("certificate signing requests must be signed by an existing certificate")
+	errParentCantSign = inputError("parent certificate cannot sign certificates")
+)
+
+// CSR holds information about a PKCS#10 certificate signing request.
+type CSR struct {
+	X509 *x509.CertificateRequest
+	Key  *PrivateKey
+}
+
+// ParseCSR parses a PEM-encoded certificate signing request and verifies its
+// signature.
+func ParseCSR(b []byte) (*CSR, error) {
+	block, _ := pem.Decode(b)
+	if block == nil || (block.Type != typeCertificateRequest &&
+		block.Type != typeNewCertificateRequest) {
+		return nil, errNotACSR
+	}
+	r, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, inputError(err.Error())
+	}
+	if err := r.CheckSignature(); err != nil {
+		return nil, inputError(err.Error())
+	}
+	return &CSR{
+		X509: r,
+		Key:  describePublicKey(r.PublicKey),
+	}, nil
+}
+
+// SignCSRParams provides SignCSR with parameters for signing a certificate
+// signing request. The key type and size are ignored since the key is
+// supplied by the request.
+type SignCSRParams struct {
+	CreateCertificateParams
+	CSR string
+}
+
+// SignCSR signs a PEM-encoded certificate signing request with the specified
+// certificate. The subject and extensions are taken from params rather than
+// the request, allowing them to be reviewed and overridden. The new
+// certificate is stored without a private key.
+func (s *Storage) SignCSR(
+	certPath string,
+	params *SignCSRParams,
+) (*Certificate, error) {
+	r, err := ParseCSR([]byte(params.CSR))
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Load the parent, which must be able to sign certificates
+	if certPath == "" {
+		return nil, errCSRNoParent
+	}
+	p, parentDir, err := s.getParent(certPath)
+	if err != nil {
+		return nil, err
+	}
+	if !p.hasKey || !p.maySign() {
+		return nil, errParentCantSign
+	}
+	createParams, err := s.applyProfile(&params.CreateCertificateParams)
+	if err != nil {
+		return nil, err
+	}
+
+	// See CreateCertificate for an explanation of the temporary directory
+	d, err := os.MkdirTemp(parentDir, "temp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(d)
+
+	return s.issueCertificate(
+		p,
+		d,
+		createParams,
+		r.X509.PublicKey,
+		nil,
+		nil,
+	)
+}
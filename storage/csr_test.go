@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"testing"
+)
+
+func newTestCSR(t *testing.T, cn string) []byte {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	b, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cn},
+		DNSNames: []string{cn},
+	}, k)
+	if err != nil {
+		t.Fatalf("create CSR: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  typeCertificateRequest,
+		Bytes: b,
+	})
+}
+
+func TestSignCSR(t *testing.T) {
+	s := newTestStorage(t, t.TempDir())
+
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1h",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root certificate: %v", err)
+	}
+
+	csr := newTestCSR(t, childCertCN)
+	r, err := ParseCSR(csr)
+	if err != nil {
+		t.Fatalf("parse CSR: %v", err)
+	}
+	if r.X509.Subject.CommonName != childCertCN || r.Key.Algorithm != AlgorithmECDSA {
+		t.Fatalf("parsed CSR = %s/%s, want %s/%s",
+			r.X509.Subject.CommonName, r.Key.Algorithm, childCertCN, AlgorithmECDSA)
+	}
+
+	// Sign the CSR with an overridden common name
+	c, err := s.SignCSR(root.Path, &SignCSRParams{
+		CreateCertificateParams: CreateCertificateParams{
+			CommonName: "override.example.test",
+			Validity:   "30m",
+			ServerAuth: true,
+			SANs:       childCertCN,
+		},
+		CSR: string(csr),
+	})
+	if err != nil {
+		t.Fatalf("sign CSR: %v", err)
+	}
+	if c.PrivateKey != nil {
+		t.Fatal("certificate signed from CSR should not have a private key")
+	}
+	if c.X509.Subject.CommonName != "override.example.test" {
+		t.Fatalf("common name = %q, want override", c.X509.Subject.CommonName)
+	}
+	if !c.X509.PublicKey.(*ecdsa.PublicKey).Equal(r.X509.PublicKey) {
+		t.Fatal("certificate public key does not match CSR")
+	}
+
+	// A root cannot be created from a CSR
+	if _, err := s.SignCSR("", &SignCSRParams{CSR: string(csr)}); !errors.Is(err, errCSRNoParent) {
+		t.Fatalf("sign CSR without parent error = %v, want %v", err, errCSRNoParent)
+	}
+
+	// ...nor can a certificate without a private key sign one
+	if _, err := s.SignCSR(c.Path, &SignCSRParams{CSR: string(csr)}); !errors.Is(err, errParentCantSign) {
+		t.Fatalf("sign CSR with leaf error = %v, want %v", err, errParentCantSign)
+	}
+}
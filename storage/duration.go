@@ -1,47 +1,65 @@
 package storage
 
 import (
-	"errors"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
 const (
-	durHour = 60 * time.Minute
-	durDay  = 24 * durHour
-	durWeek = 7 * durDay
-	durYear = 365 * durDay
+	durHour  = 60 * time.Minute
+	durDay   = 24 * durHour
+	durWeek  = 7 * durDay
+	durMonth = 30 * durDay
+	durYear  = 365 * durDay
 )
 
 var (
-	validityRegExp = regexp.MustCompile(`^(\d+)(\w+)$`)
+	validityRegExp     = regexp.MustCompile(`^(\d+[a-z]+)+$`)
+	validityPartRegExp = regexp.MustCompile(`(\d+)([a-z]+)`)
 
-	errInvalidDuration = errors.New("invalid duration specified")
-	errInvalidUnit     = errors.New("invalid unit specified")
+	durationUnits = map[string]time.Duration{
+		"m":  time.Minute,
+		"h":  durHour,
+		"d":  durDay,
+		"w":  durWeek,
+		"mo": durMonth,
+		"y":  durYear,
+	}
+
+	errInvalidDuration = inputError("invalid duration specified")
+	errInvalidUnit     = inputError("invalid unit specified")
+	errAmbiguousUnit   = inputError("m is minutes and cannot be combined with years or months; use mo for months")
 )
 
+// parseDuration parses one or more <n><unit> terms such as "90d" or "1y6mo",
+// ignoring whitespace. Note that "m" is always minutes and "mo" is months (30
+// days). Since "1y6m" is far more likely to be a mistake for "1y6mo" than a
+// year and six minutes, minutes cannot be combined with years or months.
 func parseDuration(v string) (time.Duration, error) {
-	m := validityRegExp.FindStringSubmatch(v)
-	if len(m) != 3 {
+	v = strings.Join(strings.Fields(v), "")
+	if !validityRegExp.MatchString(v) {
 		return 0, errInvalidDuration
 	}
-	n, err := strconv.Atoi(m[1])
-	if err != nil {
-		return 0, err
+	var (
+		d     time.Duration
+		units = map[string]bool{}
+	)
+	for _, m := range validityPartRegExp.FindAllStringSubmatch(v, -1) {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return 0, err
+		}
+		u, ok := durationUnits[m[2]]
+		if !ok {
+			return 0, errInvalidUnit
+		}
+		d += time.Duration(n) * u
+		units[m[2]] = true
 	}
-	switch m[2] {
-	case "m":
-		return time.Duration(n) * time.Minute, nil
-	case "h":
-		return time.Duration(n) * durHour, nil
-	case "d":
-		return time.Duration(n) * durDay, nil
-	case "w":
-		return time.Duration(n) * durWeek, nil
-	case "y":
-		return time.Duration(n) * durYear, nil
-	default:
-		return 0, errInvalidUnit
+	if units["m"] && (units["y"] || units["mo"]) {
+		return 0, errAmbiguousUnit
 	}
+	return d, nil
 }
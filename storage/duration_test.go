@@ -20,8 +20,18 @@ func TestParseDuration(t *testing.T) {
 		{name: "days", in: "3d", want: 3 * durDay},
 		{name: "weeks", in: "4w", want: 4 * durWeek},
 		{name: "years", in: "5y", want: 5 * durYear},
+		{name: "months", in: "6mo", want: 6 * durMonth},
+		{name: "compound", in: "1y 6mo", want: durYear + 6*durMonth},
+		{name: "minutes after days", in: "1d6m", want: durDay + 6*time.Minute},
+		{name: "hours and minutes", in: "1h30m", want: durHour + 30*time.Minute},
 		{name: "invalid format", in: "abc", err: errInvalidDuration},
 		{name: "unknown unit", in: "30q", err: errInvalidUnit},
+		{name: "unknown unit in compound", in: "1y2q", err: errInvalidUnit},
+		{name: "missing unit", in: "1y6", err: errInvalidDuration},
+		{name: "minutes after years", in: "1y6m", err: errAmbiguousUnit},
+		{name: "spaced minutes after years", in: "2y 3m", err: errAmbiguousUnit},
+		{name: "minutes before years", in: "6m1y", err: errAmbiguousUnit},
+		{name: "minutes with months", in: "1mo30m", err: errAmbiguousUnit},
 	}
 
 	for _, tt := range tests {
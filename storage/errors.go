@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"errors"
+)
+
+var (
+	// ErrNotFound is matched (using errors.Is) by errors returned when the
+	// requested certificate does not exist.
+	ErrNotFound = errors.New("not found")
+
+	// ErrInvalidInput is matched (using errors.Is) by errors caused by invalid
+	// parameters or data supplied by the caller rather than a failure to
+	// read or write storage.
+	ErrInvalidInput = errors.New("invalid input")
+
+	// ErrSealed is matched (using errors.Is) by errors returned when an
+	// operation requires a private key but the keys are encrypted and
+	// storage has not been unsealed.
+	ErrSealed = errors.New("sealed")
+
+	// ErrConflict is matched (using errors.Is) by errors returned when the
+	// request conflicts with the current state of a certificate, such as
+	// revoking one that was already revoked. These errors also match
+	// ErrInvalidInput.
+	ErrConflict = errors.New("conflict")
+)
+
+// kindError is an error that belongs to one of the categories above.
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind || (e.kind == ErrConflict && target == ErrInvalidInput)
+}
+
+func notFoundError(msg string) error {
+	return &kindError{kind: ErrNotFound, msg: msg}
+}
+
+func inputError(msg string) error {
+	return &kindError{kind: ErrInvalidInput, msg: msg}
+}
+
+func sealedError(msg string) error {
+	return &kindError{kind: ErrSealed, msg: msg}
+}
+
+func conflictError(msg string) error {
+	return &kindError{kind: ErrConflict, msg: msg}
+}
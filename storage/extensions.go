@@ -0,0 +1,455 @@
+package storage
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Key usages, named as in RFC 5280.
+const (
+	KeyUsageDigitalSignature  = "digitalSignature"
+	KeyUsageContentCommitment = "contentCommitment"
+	KeyUsageKeyEncipherment   = "keyEncipherment"
+	KeyUsageDataEncipherment  = "dataEncipherment"
+	KeyUsageKeyAgreement      = "keyAgreement"
+	KeyUsageCertSign          = "keyCertSign"
+	KeyUsageCRLSign           = "cRLSign"
+	KeyUsageEncipherOnly      = "encipherOnly"
+	KeyUsageDecipherOnly      = "decipherOnly"
+)
+
+// Extended key usages; the first three names match the parameters that also
+// request them.
+const (
+	EKUServerAuth                     = "serverAuth"
+	EKUClientAuth                     = "clientAuth"
+	EKUCodeSigning                    = "codeSigning"
+	EKUEmailProtection                = "emailProtection"
+	EKUTimeStamping                   = "timeStamping"
+	EKUOCSPSigning                    = "ocspSigning"
+	EKUIPSECEndSystem                 = "ipsecEndSystem"
+	EKUIPSECTunnel                    = "ipsecTunnel"
+	EKUIPSECUser                      = "ipsecUser"
+	EKUMicrosoftServerGatedCrypto     = "msSGC"
+	EKUNetscapeServerGatedCrypto      = "nsSGC"
+	EKUMicrosoftCommercialCodeSigning = "msCommercialCodeSigning"
+	EKUMicrosoftKernelCodeSigning     = "msKernelCodeSigning"
+	EKUAny                            = "any"
+)
+
+// Usage describes a key usage or an extended key usage.
+type Usage struct {
+	Name  string
+	Label string
+
+	keyUsage    x509.KeyUsage
+	extKeyUsage x509.ExtKeyUsage
+}
+
+// KeyUsages lists every key usage in the order of its bit.
+var KeyUsages = []*Usage{
+	{Name: KeyUsageDigitalSignature, Label: "digital signature", keyUsage: x509.KeyUsageDigitalSignature},
+	{Name: KeyUsageContentCommitment, Label: "content commitment", keyUsage: x509.KeyUsageContentCommitment},
+	{Name: KeyUsageKeyEncipherment, Label: "key encipherment", keyUsage: x509.KeyUsageKeyEncipherment},
+	{Name: KeyUsageDataEncipherment, Label: "data encipherment", keyUsage: x509.KeyUsageDataEncipherment},
+	{Name: KeyUsageKeyAgreement, Label: "key agreement", keyUsage: x509.KeyUsageKeyAgreement},
+	{Name: KeyUsageCertSign, Label: "certificate signing", keyUsage: x509.KeyUsageCertSign},
+	{Name: KeyUsageCRLSign, Label: "CRL signing", keyUsage: x509.KeyUsageCRLSign},
+	{Name: KeyUsageEncipherOnly, Label: "encipher only", keyUsage: x509.KeyUsageEncipherOnly},
+	{Name: KeyUsageDecipherOnly, Label: "decipher only", keyUsage: x509.KeyUsageDecipherOnly},
+}
+
+// ExtKeyUsages lists the extended key usages that have a name; any other
+// can be requested by its OID.
+var ExtKeyUsages = []*Usage{
+	{Name: EKUServerAuth, Label: "server auth", extKeyUsage: x509.ExtKeyUsageServerAuth},
+	{Name: EKUClientAuth, Label: "client auth", extKeyUsage: x509.ExtKeyUsageClientAuth},
+	{Name: EKUCodeSigning, Label: "code signing", extKeyUsage: x509.ExtKeyUsageCodeSigning},
+	{Name: EKUEmailProtection, Label: "email protection", extKeyUsage: x509.ExtKeyUsageEmailProtection},
+	{Name: EKUTimeStamping, Label: "time stamping", extKeyUsage: x509.ExtKeyUsageTimeStamping},
+	{Name: EKUOCSPSigning, Label: "OCSP signing", extKeyUsage: x509.ExtKeyUsageOCSPSigning},
+	{Name: EKUIPSECEndSystem, Label: "IPsec end system", extKeyUsage: x509.ExtKeyUsageIPSECEndSystem},
+	{Name: EKUIPSECTunnel, Label: "IPsec tunnel", extKeyUsage: x509.ExtKeyUsageIPSECTunnel},
+	{Name: EKUIPSECUser, Label: "IPsec user", extKeyUsage: x509.ExtKeyUsageIPSECUser},
+	{Name: EKUMicrosoftServerGatedCrypto, Label: "Microsoft server gated crypto", extKeyUsage: x509.ExtKeyUsageMicrosoftServerGatedCrypto},
+	{Name: EKUNetscapeServerGatedCrypto, Label: "Netscape server gated crypto", extKeyUsage: x509.ExtKeyUsageNetscapeServerGatedCrypto},
+	{Name: EKUMicrosoftCommercialCodeSigning, Label: "Microsoft commercial code signing", extKeyUsage: x509.ExtKeyUsageMicrosoftCommercialCodeSigning},
+	{Name: EKUMicrosoftKernelCodeSigning, Label: "Microsoft kernel code signing", extKeyUsage: x509.ExtKeyUsageMicrosoftKernelCodeSigning},
+	{Name: EKUAny, Label: "any", extKeyUsage: x509.ExtKeyUsageAny},
+}
+
+var (
+	oidExtensionCertificatePolicies = asn1.ObjectIdentifier{2, 5, 29, 32}
+	oidExtensionTLSFeature          = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+	oidPolicyQualifierCPS           = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 1}
+)
+
+// The TLS feature that requires OCSP stapling (status_request).
+const tlsFeatureStatusRequest = 5
+
+// findUsage returns the usage with the provided name (ignoring case) or nil.
+func findUsage(usages []*Usage, name string) *Usage {
+	i := slices.IndexFunc(usages, func(u *Usage) bool {
+		return strings.EqualFold(u.Name, name)
+	})
+	if i == -1 {
+		return nil
+	}
+	return usages[i]
+}
+
+// IsExtKeyUsageName indicates whether the value is the name of an extended
+// key usage rather than an OID.
+func IsExtKeyUsageName(v string) bool {
+	return findUsage(ExtKeyUsages, v) != nil
+}
+
+// extKeyUsageName returns the name of the extended key usage or "" if it
+// does not have one.
+func extKeyUsageName(eku x509.ExtKeyUsage) string {
+	for _, u := range ExtKeyUsages {
+		if u.extKeyUsage == eku {
+			return u.Name
+		}
+	}
+	return ""
+}
+
+// extKeyUsageLabel describes the extended key usage.
+func extKeyUsageLabel(eku x509.ExtKeyUsage) string {
+	for _, u := range ExtKeyUsages {
+		if u.extKeyUsage == eku {
+			return u.Label
+		}
+	}
+	return fmt.Sprintf("#%d", eku)
+}
+
+// parseOID parses an OID in dotted decimal notation.
+func parseOID(v string) (asn1.ObjectIdentifier, error) {
+	var (
+		parts = strings.Split(v, ".")
+		oid   = asn1.ObjectIdentifier{}
+	)
+	for _, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, inputError(fmt.Sprintf("invalid OID %s", v))
+		}
+		oid = append(oid, n)
+	}
+	if len(oid) < 2 || oid[0] > 2 || (oid[0] < 2 && oid[1] > 39) {
+		return nil, inputError(fmt.Sprintf("invalid OID %s", v))
+	}
+	return oid, nil
+}
+
+// parseKeyUsages combines the named key usages; each value may itself be a
+// comma or space separated list.
+func parseKeyUsages(values []string) (x509.KeyUsage, error) {
+	var v x509.KeyUsage
+	for _, n := range splitSANs(strings.Join(values, " ")) {
+		u := findUsage(KeyUsages, n)
+		if u == nil {
+			return 0, inputError(fmt.Sprintf("unknown key usage %s", n))
+		}
+		v |= u.keyUsage
+	}
+	return v, nil
+}
+
+// parseExtKeyUsages splits the extended key usages into the named ones and
+// the others, which must be OIDs.
+func parseExtKeyUsages(values []string) ([]x509.ExtKeyUsage, []asn1.ObjectIdentifier, error) {
+	var (
+		ekus = []x509.ExtKeyUsage{}
+		oids = []asn1.ObjectIdentifier{}
+	)
+	for _, n := range splitSANs(strings.Join(values, " ")) {
+		if u := findUsage(ExtKeyUsages, n); u != nil {
+			if !slices.Contains(ekus, u.extKeyUsage) {
+				ekus = append(ekus, u.extKeyUsage)
+			}
+			continue
+		}
+		oid, err := parseOID(n)
+		if err != nil {
+			return nil, nil, inputError(fmt.Sprintf("unknown extended key usage %s", n))
+		}
+		if !slices.ContainsFunc(oids, oid.Equal) {
+			oids = append(oids, oid)
+		}
+	}
+	return ekus, oids, nil
+}
+
+// CertificatePolicy is a policy OID along with the URIs of its certification
+// practice statements.
+type CertificatePolicy struct {
+	OID string   `json:"oid"`
+	CPS []string `json:"cps"`
+	oid asn1.ObjectIdentifier
+}
+
+type policyInformation struct {
+	Policy     asn1.ObjectIdentifier
+	Qualifiers []policyQualifierInfo `asn1:"optional,omitempty"`
+}
+
+type policyQualifierInfo struct {
+	ID        asn1.ObjectIdentifier
+	Qualifier asn1.RawValue
+}
+
+// parseCertificatePolicies parses one policy per line: its OID followed by
+// any CPS URIs.
+func parseCertificatePolicies(v string) ([]*CertificatePolicy, error) {
+	policies := []*CertificatePolicy{}
+	for _, l := range strings.Split(v, "\n") {
+		f := splitSANs(l)
+		if len(f) == 0 {
+			continue
+		}
+		oid, err := parseOID(f[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range f[1:] {
+			u, err := url.Parse(c)
+			if err != nil || u.Scheme == "" || u.Host == "" || !isASCII(c) {
+				return nil, inputError(fmt.Sprintf("invalid CPS URI %s", c))
+			}
+		}
+		policies = append(policies, &CertificatePolicy{
+			OID: oid.String(),
+			CPS: f[1:],
+			oid: oid,
+		})
+	}
+	return policies, nil
+}
+
+func isASCII(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if v[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+func marshalCertificatePolicies(policies []*CertificatePolicy) (*pkix.Extension, error) {
+	v := []policyInformation{}
+	for _, p := range policies {
+		i := policyInformation{Policy: p.oid}
+		for _, c := range p.CPS {
+			i.Qualifiers = append(i.Qualifiers, policyQualifierInfo{
+				ID: oidPolicyQualifierCPS,
+				Qualifier: asn1.RawValue{
+					Class: asn1.ClassUniversal,
+					Tag:   asn1.TagIA5String,
+					Bytes: []byte(c),
+				},
+			})
+		}
+		v = append(v, i)
+	}
+	b, err := asn1.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &pkix.Extension{Id: oidExtensionCertificatePolicies, Value: b}, nil
+}
+
+// standardExtension indicates whether the extension is one that Certy
+// creates from its own parameters and so cannot be added as a custom one.
+func standardExtension(oid asn1.ObjectIdentifier) bool {
+	return slices.ContainsFunc(handledExtensions, oid.Equal) ||
+		oid.Equal(oidExtensionCertificatePolicies) ||
+		oid.Equal(oidExtensionTLSFeature)
+}
+
+// parseCustomExtensions parses one extension per line: its OID, optionally
+// "critical" and its DER encoded value in hex (which may contain colons).
+func parseCustomExtensions(v string) ([]pkix.Extension, error) {
+	extensions := []pkix.Extension{}
+	for _, l := range strings.Split(v, "\n") {
+		f := strings.Fields(l)
+		if len(f) == 0 {
+			continue
+		}
+		oid, err := parseOID(f[0])
+		if err != nil {
+			return nil, err
+		}
+		if standardExtension(oid) {
+			return nil, inputError(fmt.Sprintf("extension %s is set by the other parameters", oid))
+		}
+		if slices.ContainsFunc(extensions, func(e pkix.Extension) bool {
+			return e.Id.Equal(oid)
+		}) {
+			return nil, inputError(fmt.Sprintf("extension %s is included more than once", oid))
+		}
+		e := pkix.Extension{Id: oid}
+		if len(f) > 1 && strings.EqualFold(f[1], "critical") {
+			e.Critical = true
+			f = f[1:]
+		}
+		if len(f) != 2 {
+			return nil, inputError(fmt.Sprintf("extension %s must have exactly one value", oid))
+		}
+		b, err := hex.DecodeString(strings.ReplaceAll(f[1], ":", ""))
+		if err != nil {
+			return nil, inputError(fmt.Sprintf("value of extension %s is not valid hex", oid))
+		}
+		var raw asn1.RawValue
+		if rest, err := asn1.Unmarshal(b, &raw); err != nil || len(rest) != 0 {
+			return nil, inputError(fmt.Sprintf("value of extension %s is not valid DER", oid))
+		}
+		e.Value = b
+		extensions = append(extensions, e)
+	}
+	return extensions, nil
+}
+
+// setExtensions adds the key usages, extended key usages, certificate
+// policies, TLS feature and custom extensions from params to the template.
+func setExtensions(cert *x509.Certificate, params *CreateCertificateParams) error {
+	ku, err := parseKeyUsages(params.KeyUsages)
+	if err != nil {
+		return err
+	}
+	cert.KeyUsage |= ku
+	ekus, oids, err := parseExtKeyUsages(params.ExtKeyUsages)
+	if err != nil {
+		return err
+	}
+	for _, u := range ekus {
+		if !slices.Contains(cert.ExtKeyUsage, u) {
+			cert.ExtKeyUsage = append(cert.ExtKeyUsage, u)
+		}
+	}
+	cert.UnknownExtKeyUsage = append(cert.UnknownExtKeyUsage, oids...)
+	policies, err := parseCertificatePolicies(params.CertificatePolicies)
+	if err != nil {
+		return err
+	}
+	if len(policies) != 0 {
+		e, err := marshalCertificatePolicies(policies)
+		if err != nil {
+			return err
+		}
+		cert.ExtraExtensions = append(cert.ExtraExtensions, *e)
+	}
+	if params.MustStaple {
+		b, err := asn1.Marshal([]int{tlsFeatureStatusRequest})
+		if err != nil {
+			return err
+		}
+		cert.ExtraExtensions = append(cert.ExtraExtensions, pkix.Extension{
+			Id:    oidExtensionTLSFeature,
+			Value: b,
+		})
+	}
+	custom, err := parseCustomExtensions(params.Extensions)
+	if err != nil {
+		return err
+	}
+	cert.ExtraExtensions = append(cert.ExtraExtensions, custom...)
+	return nil
+}
+
+// KeyUsage provides a human-friendly list of the key usages.
+func (c *Certificate) KeyUsage() []string {
+	usages := []string{}
+	for _, u := range KeyUsages {
+		if c.X509.KeyUsage&u.keyUsage != 0 {
+			usages = append(usages, u.Label)
+		}
+	}
+	return usages
+}
+
+// ExtKeyUsage provides a human-friendly list of the extended key usages,
+// using the OID for those without a name.
+func (c *Certificate) ExtKeyUsage() []string {
+	usages := []string{}
+	for _, u := range c.X509.ExtKeyUsage {
+		usages = append(usages, extKeyUsageLabel(u))
+	}
+	for _, oid := range c.X509.UnknownExtKeyUsage {
+		usages = append(usages, oid.String())
+	}
+	return usages
+}
+
+// CertificatePolicies returns the policies in the certificate along with
+// their CPS URIs.
+func (c *Certificate) CertificatePolicies() []*CertificatePolicy {
+	policies := []*CertificatePolicy{}
+	for _, e := range c.X509.Extensions {
+		if !e.Id.Equal(oidExtensionCertificatePolicies) {
+			continue
+		}
+		var v []policyInformation
+		if _, err := asn1.Unmarshal(e.Value, &v); err != nil {
+			continue
+		}
+		for _, i := range v {
+			p := &CertificatePolicy{OID: i.Policy.String(), CPS: []string{}}
+			for _, q := range i.Qualifiers {
+				if q.ID.Equal(oidPolicyQualifierCPS) {
+					p.CPS = append(p.CPS, string(q.Qualifier.Bytes))
+				}
+			}
+			policies = append(policies, p)
+		}
+	}
+	return policies
+}
+
+// MustStaple indicates whether the certificate requires OCSP stapling.
+func (c *Certificate) MustStaple() bool {
+	for _, e := range c.X509.Extensions {
+		if !e.Id.Equal(oidExtensionTLSFeature) {
+			continue
+		}
+		var features []int
+		if _, err := asn1.Unmarshal(e.Value, &features); err == nil &&
+			slices.Contains(features, tlsFeatureStatusRequest) {
+			return true
+		}
+	}
+	return false
+}
+
+// Extension is an extension that Certy does not otherwise interpret.
+type Extension struct {
+	OID      string
+	Critical bool
+	Value    []byte
+}
+
+// CustomExtensions returns the extensions in the certificate other than the
+// standard ones.
+func (c *Certificate) CustomExtensions() []*Extension {
+	extensions := []*Extension{}
+	for _, e := range c.X509.Extensions {
+		if standardExtension(e.Id) {
+			continue
+		}
+		extensions = append(extensions, &Extension{
+			OID:      e.Id.String(),
+			Critical: e.Critical,
+			Value:    e.Value,
+		})
+	}
+	return extensions
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestExtensions(t *testing.T) {
+	s := newTestStorage(t, t.TempDir())
+
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1h",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root certificate: %v", err)
+	}
+
+	// Every kind of extension is included and decoded again
+	c, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName:          childCertCN,
+		Validity:            "30m",
+		ServerAuth:          true,
+		KeyUsages:           []string{"contentCommitment, keyAgreement"},
+		ExtKeyUsages:        []string{EKUTimeStamping, "emailprotection", "1.3.6.1.4.1.311.20.2.2"},
+		CertificatePolicies: "2.23.140.1.2.1\n1.3.6.1.4.1.99999.1 https://example.test/cps",
+		MustStaple:          true,
+		Extensions:          "1.3.6.1.4.1.99999.2 critical 0c:03:61:62:63",
+		KeyType:             KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	check := func(c *Certificate) {
+		t.Helper()
+		if v := c.KeyUsage(); !slices.Equal(v, []string{"digital signature", "content commitment", "key agreement"}) {
+			t.Fatalf("key usage = %v", v)
+		}
+		if v := c.ExtKeyUsage(); !slices.Equal(v, []string{"server auth", "time stamping", "email protection", "1.3.6.1.4.1.311.20.2.2"}) {
+			t.Fatalf("extended key usage = %v", v)
+		}
+		p := c.CertificatePolicies()
+		if len(p) != 2 || p[0].OID != "2.23.140.1.2.1" || len(p[0].CPS) != 0 ||
+			!slices.Equal(p[1].CPS, []string{"https://example.test/cps"}) {
+			t.Fatalf("unexpected certificate policies: %+v", p)
+		}
+		if !c.MustStaple() {
+			t.Fatal("expected the certificate to require OCSP stapling")
+		}
+		e := c.CustomExtensions()
+		if len(e) != 1 || e[0].OID != "1.3.6.1.4.1.99999.2" || !e[0].Critical ||
+			!bytes.Equal(e[0].Value, []byte{0x0c, 0x03, 'a', 'b', 'c'}) {
+			t.Fatalf("unexpected custom extensions: %+v", e)
+		}
+	}
+	check(c)
+	if c.X509.PolicyIdentifiers[1].String() != "1.3.6.1.4.1.99999.1" {
+		t.Fatal("expected Go to parse the certificate policies")
+	}
+
+	// Renewing keeps all of them, including the CPS URIs
+	renewed, err := s.RenewCertificate(c.Path, &RenewCertificateParams{})
+	if err != nil {
+		t.Fatalf("renew certificate: %v", err)
+	}
+	check(renewed)
+
+	// A policy can allow extended key usages by name or OID
+	if err := s.SetPolicy(root.Path, &Policy{
+		AllowedEKUs: []string{EKUServerAuth, "1.3.6.1.4.1.311.20.2.2"},
+	}); err != nil {
+		t.Fatalf("set policy: %v", err)
+	}
+	if _, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName:   childCertCN,
+		Validity:     "30m",
+		ExtKeyUsages: []string{EKUServerAuth, "1.3.6.1.4.1.311.20.2.2"},
+		KeyType:      KeyTypeECDSAP256,
+	}); err != nil {
+		t.Fatalf("create allowed certificate: %v", err)
+	}
+	_, err = s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName:   childCertCN,
+		Validity:     "30m",
+		ExtKeyUsages: []string{EKUOCSPSigning, "1.2.3.4"},
+		KeyType:      KeyTypeECDSAP256,
+	})
+	var pe *PolicyError
+	if !errors.As(err, &pe) || len(pe.Violations) != 2 || pe.Violations[0].Field != "extKeyUsages" {
+		t.Fatalf("expected two extended key usage violations, got %v", err)
+	}
+
+	// Invalid values and extensions that have their own parameters are
+	// rejected
+	for _, params := range []*CreateCertificateParams{
+		{KeyUsages: []string{"signing"}},
+		{ExtKeyUsages: []string{"1.2.x"}},
+		{CertificatePolicies: "2.23.140.1.2.1 example.test/cps"},
+		{Extensions: "2.5.29.19 3000"},
+		{Extensions: "1.2.3.4 zz"},
+		{Extensions: "1.2.3.4 0c03"},
+		{Extensions: "1.2.3.4 0500\n1.2.3.4 0500"},
+	} {
+		params.CommonName = childCertCN
+		params.Validity = "30m"
+		params.KeyType = KeyTypeECDSAP256
+		if _, err := s.CreateCertificate(root.Path, params); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("expected %+v to be rejected, got %v", params, err)
+		}
+	}
+}
+
+func TestParseOID(t *testing.T) {
+	t.Parallel()
+
+	for v, valid := range map[string]bool{
+		"1.3.6.1.4.1.311.20.2.2": true,
+		"2.999.1":                true,
+		"1":                      false,
+		"3.1":                    false,
+		"1.40":                   false,
+		"1..2":                   false,
+		"1.-2":                   false,
+	} {
+		oid, err := parseOID(v)
+		if valid != (err == nil) {
+			t.Fatalf("parseOID(%q) error = %v", v, err)
+		}
+		if valid && oid.String() != v {
+			t.Fatalf("parseOID(%q) = %s", v, oid)
+		}
+	}
+}
@@ -0,0 +1,488 @@
+package storage
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/youmark/pkcs8"
+	"go.mozilla.org/pkcs7"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	typeRSAPrivateKey       = "RSA PRIVATE KEY"
+	typeECPrivateKey        = "EC PRIVATE KEY"
+	typeEncryptedPrivateKey = "ENCRYPTED PRIVATE KEY"
+	typePKCS7               = "PKCS7"
+)
+
+var (
+	errNotImportable        = inputError("data is not a recognized certificate, private key or PKCS#7 or PKCS#12 bundle")
+	errImportNoCert         = inputError("no certificate was provided")
+	errImportKeyCount       = inputError("more than one private key was provided")
+	errImportKeyType        = inputError("the private key type is not supported")
+	errImportKeyPassword    = inputError("the private key is encrypted and requires a password")
+	errImportPKCS12Password = inputError("the password for the PKCS#12 bundle is incorrect")
+	errImportKeyMismatch    = inputError("the private key does not match the certificate")
+	errImportExists         = conflictError("the certificate is already managed")
+	errImportRootScope      = inputError("root certificates cannot be imported below another certificate")
+	errImportIssuerNotFound = inputError("the issuer of the certificate is not managed; import it first")
+)
+
+// ImportCertificateParams provides ImportCertificate with the certificate
+// (and optionally the private key) to import.
+type ImportCertificateParams struct {
+
+	// Certificate holds one or more PEM-encoded certificates, a DER-encoded
+	// certificate or a PKCS#7 or PKCS#12 bundle. PEM data may also include
+	// the private key.
+	Certificate []byte
+
+	// PrivateKey is the private key in PEM or DER form, which may be PKCS#8
+	// (optionally encrypted), PKCS#1 or SEC1.
+	PrivateKey []byte
+
+	// Password decrypts the PKCS#12 bundle or an encrypted private key.
+	Password string
+}
+
+// parsePrivateKeyDER parses an unencrypted PKCS#8, PKCS#1 or SEC1 key.
+func parsePrivateKeyDER(der []byte) (any, error) {
+	if k, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParseECPrivateKey(der); err == nil {
+		return k, nil
+	}
+	return nil, errNotImportable
+}
+
+// parsePrivateKeyPEM parses a PEM block containing a private key, decrypting
+// it with the password if necessary.
+func parsePrivateKeyPEM(block *pem.Block, password string) (any, error) {
+	if block.Type == typeEncryptedPrivateKey {
+		if password == "" {
+			return nil, errImportKeyPassword
+		}
+		k, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(password))
+		if err != nil {
+			return nil, inputError(err.Error())
+		}
+		return k, nil
+	}
+	der := block.Bytes
+
+	// Keys encrypted by older versions of OpenSSL use PEM headers; the
+	// functions for decrypting them are deprecated since the encryption is
+	// weak but there is no other way to read them
+	if x509.IsEncryptedPEMBlock(block) {
+		if password == "" {
+			return nil, errImportKeyPassword
+		}
+		v, err := x509.DecryptPEMBlock(block, []byte(password))
+		if err != nil {
+			return nil, inputError(err.Error())
+		}
+		der = v
+	}
+	return parsePrivateKeyDER(der)
+}
+
+// parseImport extracts the certificates and private keys from data in any of
+// the formats accepted by ImportCertificate.
+func parseImport(b []byte, password string) ([]*x509.Certificate, []any, error) {
+	var (
+		certs = []*x509.Certificate{}
+		keys  = []any{}
+	)
+
+	// PEM data may contain any number of certificates and keys; unrelated
+	// blocks (such as EC PARAMETERS) are ignored
+	if bytes.Contains(b, []byte("-----BEGIN ")) {
+		for {
+			var block *pem.Block
+			block, b = pem.Decode(b)
+			if block == nil {
+				break
+			}
+			switch block.Type {
+			case typeCertificate:
+				c, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return nil, nil, inputError(err.Error())
+				}
+				certs = append(certs, c)
+			case typePKCS7:
+				p, err := pkcs7.Parse(block.Bytes)
+				if err != nil {
+					return nil, nil, inputError(err.Error())
+				}
+				certs = append(certs, p.Certificates...)
+			case typePrivateKey, typeRSAPrivateKey, typeECPrivateKey, typeEncryptedPrivateKey:
+				k, err := parsePrivateKeyPEM(block, password)
+				if err != nil {
+					return nil, nil, err
+				}
+				keys = append(keys, k)
+			}
+		}
+		if len(certs) == 0 && len(keys) == 0 {
+			return nil, nil, errNotImportable
+		}
+		return certs, keys, nil
+	}
+
+	// Otherwise try DER certificates, PKCS#7, a DER key and finally PKCS#12
+	if v, err := x509.ParseCertificates(b); err == nil && len(v) > 0 {
+		return v, keys, nil
+	}
+	if p, err := pkcs7.Parse(b); err == nil && len(p.Certificates) > 0 {
+		return p.Certificates, keys, nil
+	}
+	if k, err := parsePrivateKeyDER(b); err == nil {
+		return certs, append(keys, k), nil
+	}
+	k, c, ca, err := pkcs12.DecodeChain(b, password)
+	if err == nil {
+		return append([]*x509.Certificate{c}, ca...), append(keys, k), nil
+	}
+	if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+		return nil, nil, errImportPKCS12Password
+	}
+
+	// Bundles without a key are trust stores
+	if v, err := pkcs12.DecodeTrustStore(b, password); err == nil && len(v) > 0 {
+		return v, keys, nil
+	}
+	return nil, nil, errNotImportable
+}
+
+// publicKeyMatches indicates whether the key pair includes the public key.
+func publicKeyMatches(k crypto.Signer, pub crypto.PublicKey) bool {
+	v, ok := k.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && v.Equal(pub)
+}
+
+// isSelfSigned indicates whether the certificate is signed by its own key.
+// CheckSignatureFrom is not used since it also requires the certificate to
+// be a CA.
+func isSelfSigned(x *x509.Certificate) bool {
+	return bytes.Equal(x.RawSubject, x.RawIssuer) &&
+		x.CheckSignature(x.SignatureAlgorithm, x.RawTBSCertificate, x.Signature) == nil
+}
+
+// isIssuer indicates whether x was signed by p.
+func isIssuer(p, x *x509.Certificate) bool {
+	return bytes.Equal(p.RawSubject, x.RawIssuer) && x.CheckSignatureFrom(p) == nil
+}
+
+// findIssuer looks for the managed certificate that signed x. Renewed CAs
+// share the subject and key of the previous version, so the one that was
+// valid when x was issued is preferred, followed by the newest.
+func findIssuer(m map[string]*storageCert, x *x509.Certificate) *storageCert {
+	found := []*storageCert{}
+	walk(m, func(c *storageCert) {
+		if isIssuer(c.cert, x) {
+			found = append(found, c)
+		}
+	})
+	if len(found) == 0 {
+		return nil
+	}
+	covers := func(c *storageCert) bool {
+		return !x.NotBefore.Before(c.cert.NotBefore) && !x.NotBefore.After(c.cert.NotAfter)
+	}
+	slices.SortFunc(found, func(a, b *storageCert) int {
+		if ca, cb := covers(a), covers(b); ca != cb {
+			if ca {
+				return -1
+			}
+			return 1
+		}
+		if v := b.cert.NotBefore.Compare(a.cert.NotBefore); v != 0 {
+			return v
+		}
+		return strings.Compare(a.vPath, b.vPath)
+	})
+	return found[0]
+}
+
+// selectImport chooses the certificate being imported: the one matching the
+// key if there is one or else the one that did not sign any of the others.
+func selectImport(certs []*x509.Certificate, key crypto.Signer) (*x509.Certificate, error) {
+	if key != nil {
+		for _, c := range certs {
+			if publicKeyMatches(key, c.PublicKey) {
+				return c, nil
+			}
+		}
+		return nil, errImportKeyMismatch
+	}
+	for _, c := range certs {
+		if !slices.ContainsFunc(certs, func(v *x509.Certificate) bool {
+			return v != c && !isSelfSigned(v) && isIssuer(c, v)
+		}) {
+			return c, nil
+		}
+	}
+	return certs[0], nil
+}
+
+// issuerChain works up from x until reaching an issuer in scope that is
+// managed or a root (if allowed), using the other certificates to fill the
+// gap. The managed issuer (nil for a root) is returned along with the
+// certificates to import, starting with the highest.
+func issuerChain(
+	scope map[string]*storageCert,
+	x *x509.Certificate,
+	certs []*x509.Certificate,
+	allowRoot bool,
+) (*storageCert, []*x509.Certificate, error) {
+	chain := []*x509.Certificate{x}
+	for {
+		top := chain[0]
+		if isSelfSigned(top) {
+			if !allowRoot {
+				return nil, nil, errImportRootScope
+			}
+			return nil, chain, nil
+		}
+		if v := findIssuer(scope, top); v != nil {
+			return v, chain, nil
+		}
+		i := slices.IndexFunc(certs, func(v *x509.Certificate) bool {
+			return !slices.Contains(chain, v) && isIssuer(v, top)
+		})
+		if i == -1 {
+			return nil, nil, errImportIssuerNotFound
+		}
+		chain = append([]*x509.Certificate{certs[i]}, chain...)
+	}
+}
+
+// ImportCertificate adds an existing certificate (and optionally its private
+// key) to the hierarchy. The certificate is placed below the managed
+// certificate that signed it, which is found by verifying signatures, or as
+// a new root if it is self-signed. Issuers that are not yet managed are
+// imported first if they are included in the data, as is often the case with
+// PKCS#12 bundles. If certPath is not empty, the certificate must be placed
+// below that certificate. A private key may be added to an existing
+// certificate that does not have one by importing it again. The imported
+// certificate is returned upon success.
+func (s *Storage) ImportCertificate(
+	certPath string,
+	params *ImportCertificateParams,
+) (*Certificate, error) {
+
+	// Parse everything that was provided
+	certs, keys, err := parseImport(params.Certificate, params.Password)
+	if err != nil {
+		return nil, err
+	}
+	if len(params.PrivateKey) > 0 {
+		_, v, err := parseImport(params.PrivateKey, params.Password)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, v...)
+	}
+	if len(certs) == 0 {
+		return nil, errImportNoCert
+	}
+	if len(keys) > 1 {
+		return nil, errImportKeyCount
+	}
+	var key crypto.Signer
+	if len(keys) == 1 {
+		v, ok := keys[0].(crypto.Signer)
+		if !ok {
+			return nil, errImportKeyType
+		}
+		key = v
+	}
+	x, err := selectImport(certs, key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Limit the search for issuers to the specified certificate
+	scope := s.rootCerts
+	if certPath != "" {
+		c, err := s.getCert(certPath)
+		if err != nil {
+			return nil, err
+		}
+		scope = map[string]*storageCert{c.id: c}
+	}
+
+	// Work up from the certificate until reaching an issuer that is managed
+	// or a root, importing the issuers in between
+	p, chain, err := issuerChain(scope, x, certs, certPath == "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Import each certificate below the previous one
+	c, err := s.importChain(p, chain, s.keyWriter(key))
+	if err != nil {
+		return nil, err
+	}
+	return convertCert(c), nil
+}
+
+// importChain imports each certificate in chain below the previous one,
+// starting below p, and returns the last one, whose private key is added with
+// writeKey (if not nil). If any of them cannot be imported, those already
+// added are removed again so that the tree is left as it was.
+func (s *Storage) importChain(
+	p *storageCert,
+	chain []*x509.Certificate,
+	writeKey func(string, string) error,
+) (*storageCert, error) {
+	var (
+		siblings = s.rootCerts
+		added    *storageCert
+	)
+	if p != nil {
+		siblings = p.children
+	}
+	for i, v := range chain {
+		var w func(string, string) error
+		if i == len(chain)-1 {
+			w = writeKey
+		}
+		_, exists := siblings[certID(v)]
+		c, err := s.importCert(p, v, w)
+		if err != nil {
+			if added != nil {
+				s.unimportCert(added)
+			}
+			return nil, err
+		}
+		if added == nil && !exists {
+			added = c
+		}
+		p, siblings = c, c.children
+	}
+	return p, nil
+}
+
+// unimportCert removes a certificate added by importCert (along with
+// everything below it) and its serial from the issuer's index. Failures are
+// only logged since this is used to clean up after another error.
+func (s *Storage) unimportCert(c *storageCert) {
+	if err := os.RemoveAll(c.fPath); err != nil {
+		s.logger.Error("unable to remove imported certificate", "path", c.fPath, "error", err)
+	}
+	if c.parent == nil {
+		delete(s.rootCerts, c.id)
+	} else {
+		delete(c.parent.children, c.id)
+		issued, err := loadIssued(c.parent.fPath)
+		if err == nil {
+			issued = slices.DeleteFunc(issued, func(v *IssuedSerial) bool {
+				return v.SerialNumber.Cmp(c.cert.SerialNumber) == 0
+			})
+			err = saveIssued(c.parent.fPath, issued)
+		}
+		if err != nil {
+			s.logger.Error("unable to update index", "path", c.parent.fPath, "error", err)
+		}
+	}
+	s.invalidateOCSP()
+}
+
+// importCert stores a single certificate below p, using writeKey (if not
+// nil) to add its private key to the directory. If the certificate is
+// already there, the key is added to it if it does not have one.
+func (s *Storage) importCert(
+	p *storageCert,
+	x *x509.Certificate,
+	writeKey func(string, string) error,
+) (*storageCert, error) {
+	var (
+		id        = certID(x)
+		siblings  = s.rootCerts
+		parentDir = s.certDir
+	)
+	if p != nil {
+		siblings = p.children
+		parentDir = p.fPath
+	}
+
+	// Add the key to a certificate that is already managed
+	if c, ok := siblings[id]; ok {
+		if writeKey == nil || c.hasKey {
+			return nil, errImportExists
+		}
+		if err := writeKey(c.fPath, c.id); err != nil {
+			return nil, err
+		}
+		r, err := loadKeyRef(filepath.Join(c.fPath, filenameKeyRef))
+		if err != nil {
+			return nil, err
+		}
+		c.hasKey = true
+		c.keyRef = r
+		return c, nil
+	}
+
+	// See CreateCertificate for an explanation of the temporary directory
+	d, err := os.MkdirTemp(parentDir, "temp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(d)
+
+	// Write the certificate and key as they were provided
+	if err := os.WriteFile(
+		filepath.Join(d, filenameCert),
+		pem.EncodeToMemory(&pem.Block{
+			Type:  typeCertificate,
+			Bytes: x.Raw,
+		}),
+		0600,
+	); err != nil {
+		return nil, err
+	}
+	if writeKey != nil {
+		if err := writeKey(d, id); err != nil {
+			return nil, err
+		}
+	}
+	c, err := s.loadCert(d, p)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record the serial in the issuer's index so that it is never reused
+	if p != nil {
+		issued, err := s.loadIndex(p)
+		if err != nil {
+			return nil, err
+		}
+		if findIssued(issued, x.SerialNumber) == nil {
+			if err := saveIssued(p.fPath, append(issued, newIssuedSerial(c))); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := s.addCert(p, d, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// newExternalCert creates a certificate the way another tool would, signed by
+// parent (or self-signed if parent is nil).
+func newExternalCert(
+	t *testing.T,
+	cn string,
+	isCA bool,
+	k crypto.Signer,
+	parent *x509.Certificate,
+	parentKey crypto.Signer,
+) *x509.Certificate {
+	t.Helper()
+	n := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(n.UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             n.Add(-time.Minute),
+		NotAfter:              n.Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	}
+	if parent == nil {
+		parent, parentKey = template, k
+	}
+	b, err := x509.CreateCertificate(rand.Reader, template, parent, k.Public(), parentKey)
+	if err != nil {
+		t.Fatalf("create %s: %v", cn, err)
+	}
+	c, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatalf("parse %s: %v", cn, err)
+	}
+	return c
+}
+
+func encodeCertPEM(c *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: typeCertificate, Bytes: c.Raw})
+}
+
+func TestImportCertificate(t *testing.T) {
+	var (
+		dataDir = t.TempDir()
+		s       = newTestStorage(t, dataDir)
+	)
+	rootKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		root = newExternalCert(t, rootCertCN, true, rootKey, nil, nil)
+		imed = newExternalCert(t, "Intermediate CA", true, imedKey, root, rootKey)
+		leaf = newExternalCert(t, childCertCN, false, leafKey, imed, imedKey)
+	)
+
+	// The leaf cannot be imported before its issuer
+	if _, err := s.ImportCertificate("", &ImportCertificateParams{
+		Certificate: encodeCertPEM(leaf),
+	}); !errors.Is(err, errImportIssuerNotFound) {
+		t.Fatalf("expected issuer not found, got %v", err)
+	}
+
+	// Import the root as DER with a PKCS#1 key
+	rootPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  typeRSAPrivateKey,
+		Bytes: x509.MarshalPKCS1PrivateKey(rootKey),
+	})
+	if _, err := s.ImportCertificate("", &ImportCertificateParams{
+		Certificate: root.Raw,
+		PrivateKey:  rootPEM,
+	}); err != nil {
+		t.Fatalf("import root: %v", err)
+	}
+	r := s.GetRootCertificates()
+	if len(r) != 1 {
+		t.Fatalf("root count = %d, want 1", len(r))
+	}
+	v, err := s.GetCertificate(r[0].Path)
+	if err != nil || v.PrivateKey == nil {
+		t.Fatalf("expected root to be imported with its key (%v)", err)
+	}
+
+	// Roots cannot be imported again or below another certificate
+	if _, err := s.ImportCertificate("", &ImportCertificateParams{
+		Certificate: encodeCertPEM(root),
+	}); !errors.Is(err, errImportExists) {
+		t.Fatalf("expected exists, got %v", err)
+	}
+	if _, err := s.ImportCertificate(v.Path, &ImportCertificateParams{
+		Certificate: encodeCertPEM(root),
+	}); !errors.Is(err, errImportRootScope) {
+		t.Fatalf("expected root scope error, got %v", err)
+	}
+
+	// A PKCS#12 bundle brings the intermediate along with the leaf
+	p12, err := pkcs12.Modern.Encode(leafKey, leaf, []*x509.Certificate{imed, root}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ImportCertificate("", &ImportCertificateParams{
+		Certificate: p12,
+		Password:    "wrong",
+	}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid input for wrong password, got %v", err)
+	}
+	l, err := s.ImportCertificate("", &ImportCertificateParams{
+		Certificate: p12,
+		Password:    "secret",
+	})
+	if err != nil {
+		t.Fatalf("import PKCS#12: %v", err)
+	}
+	if len(l.Parents) != 2 || l.Parents[0].ID != v.ID || l.PrivateKey == nil {
+		t.Fatal("expected leaf to be placed below the intermediate with its key")
+	}
+	issued, err := s.GetIssuedSerials(v.Path)
+	if err != nil {
+		t.Fatalf("get issued serials: %v", err)
+	}
+	if findIssued(issued, imed.SerialNumber) == nil {
+		t.Fatal("expected intermediate to be recorded in the root's index")
+	}
+
+	// The intermediate's key can be added afterwards (as encrypted PKCS#8)
+	// after which it can issue certificates
+	der, err := pkcs8.MarshalPrivateKey(imedKey, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: typeEncryptedPrivateKey, Bytes: der})
+	if _, err := s.ImportCertificate("", &ImportCertificateParams{
+		Certificate: encodeCertPEM(imed),
+		PrivateKey:  keyPEM,
+	}); !errors.Is(err, errImportKeyPassword) {
+		t.Fatalf("expected password required, got %v", err)
+	}
+	if _, err := s.ImportCertificate("", &ImportCertificateParams{
+		Certificate: encodeCertPEM(leaf),
+		PrivateKey:  keyPEM,
+		Password:    "secret",
+	}); !errors.Is(err, errImportKeyMismatch) {
+		t.Fatalf("expected key mismatch, got %v", err)
+	}
+	m, err := s.ImportCertificate(v.Path, &ImportCertificateParams{
+		Certificate: encodeCertPEM(imed),
+		PrivateKey:  keyPEM,
+		Password:    "secret",
+	})
+	if err != nil {
+		t.Fatalf("import intermediate key: %v", err)
+	}
+	if m.PrivateKey == nil || len(m.Children) != 1 {
+		t.Fatal("expected key to be added to the existing intermediate")
+	}
+	if _, err := s.CreateCertificate(m.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "30m",
+		KeyType:    KeyTypeECDSAP256,
+	}); err != nil {
+		t.Fatalf("issue with imported intermediate: %v", err)
+	}
+
+	// Everything is still in place after reloading from disk
+	s = newTestStorage(t, dataDir)
+	if _, err := s.GetCertificate(l.Path); err != nil {
+		t.Fatalf("get leaf after reload: %v", err)
+	}
+}
@@ -1,66 +1,212 @@
 package storage
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"os"
+	"path/filepath"
 )
 
 const (
-	typePrivateKey = "PRIVATE KEY"
-	typePublicKey  = "PUBLIC KEY"
+	typePrivateKey   = "PRIVATE KEY"
+	typeEncryptedKey = "CERTY ENCRYPTED PRIVATE KEY"
+	typePublicKey    = "PUBLIC KEY"
 
 	filenamePrivateKey = "key.pem"
 )
 
+// Key types that may be used when creating a certificate.
+const (
+	KeyTypeRSA       = "rsa"
+	KeyTypeECDSAP256 = "ecdsa-p256"
+	KeyTypeECDSAP384 = "ecdsa-p384"
+	KeyTypeECDSAP521 = "ecdsa-p521"
+	KeyTypeEd25519   = "ed25519"
+)
+
+var keyTypes = []string{
+	KeyTypeRSA,
+	KeyTypeECDSAP256,
+	KeyTypeECDSAP384,
+	KeyTypeECDSAP521,
+	KeyTypeEd25519,
+}
+
+// Algorithms used to describe a private key.
+const (
+	AlgorithmRSA     = "RSA"
+	AlgorithmECDSA   = "ECDSA"
+	AlgorithmEd25519 = "Ed25519"
+)
+
 var (
 	errNotAPrivateKey = errors.New("file is not a PKCS#8 private key")
-	errNotAnRSAKey    = errors.New("file is not an RSA private key")
+	errUnsupportedKey = errors.New("file contains an unsupported private key type")
+	errInvalidKeyType = inputError("invalid key type specified")
 )
 
-func generatePrivateKey(filename string, bits int) (*rsa.PrivateKey, error) {
-	p, err := rsa.GenerateKey(rand.Reader, bits)
-	if err != nil {
-		return nil, err
+// PrivateKey holds information about a certificate's private key.
+type PrivateKey struct {
+	Algorithm string
+	Curve     string
+	Size      int
+
+	// Token is the label of the PKCS#11 token holding the key or empty if
+	// the key is stored on disk.
+	Token string
+}
+
+func newPrivateKey(k crypto.Signer) *PrivateKey {
+	return describePublicKey(k.Public())
+}
+
+func describePublicKey(k crypto.PublicKey) *PrivateKey {
+	switch v := k.(type) {
+	case *rsa.PublicKey:
+		return &PrivateKey{
+			Algorithm: AlgorithmRSA,
+			Size:      v.Size() * 8,
+		}
+	case *ecdsa.PublicKey:
+		return &PrivateKey{
+			Algorithm: AlgorithmECDSA,
+			Curve:     v.Curve.Params().Name,
+			Size:      v.Curve.Params().BitSize,
+		}
+	case ed25519.PublicKey:
+		return &PrivateKey{
+			Algorithm: AlgorithmEd25519,
+			Curve:     "Curve25519",
+			Size:      256,
+		}
 	}
-	b, err := x509.MarshalPKCS8PrivateKey(p)
-	if err != nil {
-		return nil, err
+	return nil
+}
+
+func newSigner(keyType string, bits int) (crypto.Signer, error) {
+	switch keyType {
+	case "", KeyTypeRSA:
+		return rsa.GenerateKey(rand.Reader, bits)
+	case KeyTypeECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeECDSAP521:
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case KeyTypeEd25519:
+		_, k, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return k, nil
+	default:
+		return nil, errInvalidKeyType
 	}
-	block := pem.EncodeToMemory(&pem.Block{
-		Type:  typePrivateKey,
-		Bytes: b,
-	})
-	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+}
+
+func generatePrivateKey(filename, keyType string, bits int, masterKey []byte, id string) (crypto.Signer, error) {
+	p, err := newSigner(keyType, bits)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	if _, err := f.Write(block); err != nil {
+	if err := writePrivateKey(filename, p, masterKey, id); err != nil {
 		return nil, err
 	}
 	return p, nil
 }
 
-func loadPrivateKey(filename string) (*rsa.PrivateKey, error) {
-	b, err := os.ReadFile(filename)
+// keyData returns the additional data for encrypting the key stored in
+// filename for the certificate (or pending certificate) with the specified
+// ID, which binds the key to its owner so that the file cannot be moved to
+// another certificate.
+func keyData(filename, id string) []byte {
+	return []byte(typeEncryptedKey + "\n" + id + "/" + filepath.Base(filename))
+}
+
+// encodePrivateKey encodes the key as PKCS#8 PEM, encrypting it with the
+// additional data if a master key is provided.
+func encodePrivateKey(k crypto.Signer, masterKey, data []byte) ([]byte, error) {
+	b, err := x509.MarshalPKCS8PrivateKey(k)
 	if err != nil {
 		return nil, err
 	}
+	block := &pem.Block{
+		Type:  typePrivateKey,
+		Bytes: b,
+	}
+	if masterKey != nil {
+		v, err := encrypt(masterKey, b, data)
+		if err != nil {
+			return nil, err
+		}
+		block = &pem.Block{
+			Type:  typeEncryptedKey,
+			Bytes: v,
+		}
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// decodePrivateKey decodes a PEM-encoded key that is either plaintext or
+// encrypted with the master key and additional data.
+func decodePrivateKey(b, masterKey, data []byte) (crypto.Signer, error) {
 	block, _ := pem.Decode(b)
-	if block == nil || block.Type != typePrivateKey {
+	if block == nil {
+		return nil, errNotAPrivateKey
+	}
+	der := block.Bytes
+	switch block.Type {
+	case typePrivateKey:
+	case typeEncryptedKey:
+		if masterKey == nil {
+			return nil, errSealed
+		}
+		v, err := decrypt(masterKey, der, data)
+		if err != nil {
+			return nil, err
+		}
+		der = v
+	default:
 		return nil, errNotAPrivateKey
 	}
-	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	k, err := x509.ParsePKCS8PrivateKey(der)
 	if err != nil {
 		return nil, err
 	}
-	v, ok := k.(*rsa.PrivateKey)
+	v, ok := k.(crypto.Signer)
 	if !ok {
-		return nil, errNotAnRSAKey
+		return nil, errUnsupportedKey
+	}
+	return v, nil
+}
+
+func writePrivateKey(filename string, k crypto.Signer, masterKey []byte, id string) error {
+	b, err := encodePrivateKey(k, masterKey, keyData(filename, id))
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+func loadPrivateKey(filename string, masterKey []byte, id string) (crypto.Signer, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
 	}
-	return v, err
+	return decodePrivateKey(b, masterKey, keyData(filename, id))
 }
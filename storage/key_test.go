@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestGeneratePrivateKeyRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		keyType   string
+		bits      int
+		algorithm string
+		curve     string
+		size      int
+	}{
+		{name: "default", keyType: "", bits: 2048, algorithm: AlgorithmRSA, size: 2048},
+		{name: "rsa", keyType: KeyTypeRSA, bits: 2048, algorithm: AlgorithmRSA, size: 2048},
+		{name: "p256", keyType: KeyTypeECDSAP256, algorithm: AlgorithmECDSA, curve: "P-256", size: 256},
+		{name: "p384", keyType: KeyTypeECDSAP384, algorithm: AlgorithmECDSA, curve: "P-384", size: 384},
+		{name: "p521", keyType: KeyTypeECDSAP521, algorithm: AlgorithmECDSA, curve: "P-521", size: 521},
+		{name: "ed25519", keyType: KeyTypeEd25519, algorithm: AlgorithmEd25519, curve: "Curve25519", size: 256},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			filename := filepath.Join(t.TempDir(), filenamePrivateKey)
+			if _, err := generatePrivateKey(filename, tt.keyType, tt.bits, nil, ""); err != nil {
+				t.Fatalf("generatePrivateKey(%q) returned error: %v", tt.keyType, err)
+			}
+			k, err := loadPrivateKey(filename, nil, "")
+			if err != nil {
+				t.Fatalf("loadPrivateKey returned error: %v", err)
+			}
+			p := newPrivateKey(k)
+			if p.Algorithm != tt.algorithm || p.Curve != tt.curve || p.Size != tt.size {
+				t.Fatalf(
+					"key = %s/%s/%d, want %s/%s/%d",
+					p.Algorithm, p.Curve, p.Size,
+					tt.algorithm, tt.curve, tt.size,
+				)
+			}
+		})
+	}
+}
+
+func TestGeneratePrivateKeyInvalidType(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), filenamePrivateKey)
+	if _, err := generatePrivateKey(filename, "dsa", 0, nil, ""); !errors.Is(err, errInvalidKeyType) {
+		t.Fatalf("generatePrivateKey error = %v, want %v", err, errInvalidKeyType)
+	}
+}
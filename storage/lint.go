@@ -0,0 +1,410 @@
+package storage
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Severities of lint findings, from most to least severe.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityNotice  = "notice"
+)
+
+var severityRanks = map[string]int{
+	SeverityError:   0,
+	SeverityWarning: 1,
+	SeverityNotice:  2,
+}
+
+// Documents that the lint rules come from.
+const (
+	SourceRFC5280 = "RFC 5280"
+	SourceBR      = "CA/Browser Forum Baseline Requirements"
+)
+
+// maxServerValidity is the longest validity that the Baseline Requirements
+// allow for TLS server certificates.
+const maxServerValidity = 398 * 24 * time.Hour
+
+var oidExtensionBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+
+// LintRule is a rule that certificates are checked against. The check
+// returns a description of the problem or "" if the certificate passes or the
+// rule does not apply to it.
+type LintRule struct {
+	ID          string
+	Severity    string
+	Source      string
+	Section     string
+	Description string
+	check       func(c *x509.Certificate) string
+}
+
+// LintFinding is a rule that a certificate does not pass.
+type LintFinding struct {
+	Rule    *LintRule
+	Message string
+}
+
+// isServerCert indicates whether the certificate is for TLS servers, which
+// the Baseline Requirements apply to.
+func isServerCert(c *x509.Certificate) bool {
+	return !c.IsCA && slices.Contains(c.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+}
+
+// hasCriticalExtension indicates whether the extension is present and marked
+// critical.
+func hasCriticalExtension(c *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	return slices.ContainsFunc(c.Extensions, func(e pkix.Extension) bool {
+		return e.Id.Equal(oid) && e.Critical
+	})
+}
+
+// nameInSANs indicates whether the common name is one of the DNS names or IP
+// addresses in the certificate.
+func nameInSANs(c *x509.Certificate, name string) bool {
+	if ip := net.ParseIP(name); ip != nil {
+		return slices.ContainsFunc(c.IPAddresses, ip.Equal)
+	}
+	return slices.ContainsFunc(c.DNSNames, func(v string) bool {
+		return strings.EqualFold(v, name)
+	})
+}
+
+// LintRules is the catalog of rules that certificates are checked against.
+var LintRules = []*LintRule{
+	{
+		ID:          "serial-positive",
+		Severity:    SeverityError,
+		Source:      SourceRFC5280,
+		Section:     "4.1.2.2",
+		Description: "The serial number must be a positive integer",
+		check: func(c *x509.Certificate) string {
+			if c.SerialNumber.Sign() <= 0 {
+				return "the serial number is not positive"
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "serial-length",
+		Severity:    SeverityError,
+		Source:      SourceRFC5280,
+		Section:     "4.1.2.2",
+		Description: "The serial number must not be longer than 20 octets",
+		check: func(c *x509.Certificate) string {
+			if n := len(c.SerialNumber.Bytes()); n > 20 {
+				return fmt.Sprintf("the serial number is %d octets long", n)
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "serial-entropy",
+		Severity:    SeverityWarning,
+		Source:      SourceBR,
+		Section:     "7.1",
+		Description: "The serial number must contain at least 64 bits of output from a CSPRNG",
+		check: func(c *x509.Certificate) string {
+			if n := len(c.SerialNumber.Bytes()); n < 8 {
+				return fmt.Sprintf("the serial number is only %d octets long, which is too short to contain 64 random bits", n)
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "weak-signature",
+		Severity:    SeverityError,
+		Source:      SourceBR,
+		Section:     "7.1.3.2",
+		Description: "Certificates must not be signed with MD5 or SHA-1",
+		check: func(c *x509.Certificate) string {
+			if isSelfSigned(c) {
+				return ""
+			}
+			switch c.SignatureAlgorithm {
+			case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA,
+				x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+				return fmt.Sprintf("the certificate is signed with %s", c.SignatureAlgorithm)
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "weak-key",
+		Severity:    SeverityError,
+		Source:      SourceBR,
+		Section:     "6.1.5",
+		Description: "RSA keys must be at least 2048 bits and ECDSA keys must use P-256, P-384 or P-521",
+		check: func(c *x509.Certificate) string {
+			switch k := c.PublicKey.(type) {
+			case *rsa.PublicKey:
+				if n := k.N.BitLen(); n < 2048 {
+					return fmt.Sprintf("the RSA key is only %d bits", n)
+				}
+			case *ecdsa.PublicKey:
+				if !slices.Contains([]elliptic.Curve{
+					elliptic.P256(),
+					elliptic.P384(),
+					elliptic.P521(),
+				}, k.Curve) {
+					return fmt.Sprintf("the ECDSA key uses %s", k.Curve.Params().Name)
+				}
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "server-key-algorithm",
+		Severity:    SeverityWarning,
+		Source:      SourceBR,
+		Section:     "6.1.5",
+		Description: "TLS server certificates must have RSA or ECDSA keys",
+		check: func(c *x509.Certificate) string {
+			if _, ok := c.PublicKey.(ed25519.PublicKey); ok && isServerCert(c) {
+				return "Ed25519 keys are not accepted by browsers"
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "ca-basic-constraints-critical",
+		Severity:    SeverityError,
+		Source:      SourceRFC5280,
+		Section:     "4.2.1.9",
+		Description: "CA certificates must have critical basic constraints",
+		check: func(c *x509.Certificate) string {
+			if c.IsCA && !hasCriticalExtension(c, oidExtensionBasicConstraints) {
+				return "the basic constraints extension is not critical"
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "ca-key-usage",
+		Severity:    SeverityError,
+		Source:      SourceRFC5280,
+		Section:     "4.2.1.3",
+		Description: "CA certificates must have a key usage that includes certificate signing",
+		check: func(c *x509.Certificate) string {
+			switch {
+			case !c.IsCA:
+			case c.KeyUsage == 0:
+				return "the key usage extension is missing"
+			case c.KeyUsage&x509.KeyUsageCertSign == 0:
+				return "the key usage does not include certificate signing"
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "ca-crl-sign",
+		Severity:    SeverityWarning,
+		Source:      SourceBR,
+		Section:     "7.1.2.10.7",
+		Description: "CA certificates must have a key usage that includes CRL signing",
+		check: func(c *x509.Certificate) string {
+			if c.IsCA && c.KeyUsage != 0 && c.KeyUsage&x509.KeyUsageCRLSign == 0 {
+				return "the key usage does not include CRL signing"
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "ca-subject-key-id",
+		Severity:    SeverityError,
+		Source:      SourceRFC5280,
+		Section:     "4.2.1.2",
+		Description: "CA certificates must have a subject key identifier",
+		check: func(c *x509.Certificate) string {
+			if c.IsCA && len(c.SubjectKeyId) == 0 {
+				return "the subject key identifier is missing"
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "authority-key-id",
+		Severity:    SeverityError,
+		Source:      SourceRFC5280,
+		Section:     "4.2.1.1",
+		Description: "Certificates must have an authority key identifier unless they are self-signed",
+		check: func(c *x509.Certificate) string {
+			if len(c.AuthorityKeyId) == 0 && !isSelfSigned(c) {
+				return "the authority key identifier is missing"
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "leaf-cert-sign",
+		Severity:    SeverityError,
+		Source:      SourceRFC5280,
+		Section:     "4.2.1.3",
+		Description: "Only CA certificates may have a key usage that includes certificate signing",
+		check: func(c *x509.Certificate) string {
+			if !c.IsCA && c.KeyUsage&x509.KeyUsageCertSign != 0 {
+				return "the key usage includes certificate signing but the certificate is not a CA"
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "leaf-eku-missing",
+		Severity:    SeverityWarning,
+		Source:      SourceBR,
+		Section:     "7.1.2.7.10",
+		Description: "Subscriber certificates must have an extended key usage",
+		check: func(c *x509.Certificate) string {
+			if !c.IsCA && len(c.ExtKeyUsage) == 0 && len(c.UnknownExtKeyUsage) == 0 {
+				return "the extended key usage extension is missing, so the certificate may be used for anything"
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "leaf-eku-any",
+		Severity:    SeverityError,
+		Source:      SourceBR,
+		Section:     "7.1.2.7.10",
+		Description: "Subscriber certificates must not allow any extended key usage",
+		check: func(c *x509.Certificate) string {
+			if !c.IsCA && slices.Contains(c.ExtKeyUsage, x509.ExtKeyUsageAny) {
+				return "the extended key usage includes any extended key usage"
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "server-sans-missing",
+		Severity:    SeverityError,
+		Source:      SourceBR,
+		Section:     "7.1.2.7.12",
+		Description: "TLS server certificates must list their DNS names or IP addresses as SANs",
+		check: func(c *x509.Certificate) string {
+			if isServerCert(c) && len(c.DNSNames) == 0 && len(c.IPAddresses) == 0 {
+				return "there are no DNS name or IP address SANs, so clients will reject the certificate"
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "server-cn-not-in-sans",
+		Severity:    SeverityError,
+		Source:      SourceBR,
+		Section:     "7.1.4.3",
+		Description: "The common name of a TLS server certificate must be one of its SANs",
+		check: func(c *x509.Certificate) string {
+			cn := c.Subject.CommonName
+			if isServerCert(c) && cn != "" && !nameInSANs(c, cn) {
+				return fmt.Sprintf("the common name %s is not one of the SANs", cn)
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "server-validity",
+		Severity:    SeverityError,
+		Source:      SourceBR,
+		Section:     "6.3.2",
+		Description: "TLS server certificates must not be valid for more than 398 days",
+		check: func(c *x509.Certificate) string {
+			if d := c.NotAfter.Sub(c.NotBefore); isServerCert(c) && d > maxServerValidity {
+				return fmt.Sprintf("the certificate is valid for %d days", d/(24*time.Hour))
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "server-key-usage",
+		Severity:    SeverityWarning,
+		Source:      SourceBR,
+		Section:     "7.1.2.7.11",
+		Description: "TLS server certificates with ECDSA keys must not allow key or data encipherment",
+		check: func(c *x509.Certificate) string {
+			_, ok := c.PublicKey.(*ecdsa.PublicKey)
+			if ok && isServerCert(c) &&
+				c.KeyUsage&(x509.KeyUsageKeyEncipherment|x509.KeyUsageDataEncipherment) != 0 {
+				return "the key usage includes encipherment, which ECDSA keys cannot do"
+			}
+			return ""
+		},
+	},
+	{
+		ID:          "revocation-info-missing",
+		Severity:    SeverityNotice,
+		Source:      SourceBR,
+		Section:     "7.1.2.7.7",
+		Description: "Certificates should include a CRL distribution point or OCSP responder URL",
+		check: func(c *x509.Certificate) string {
+			if !isSelfSigned(c) && len(c.CRLDistributionPoints) == 0 && len(c.OCSPServer) == 0 {
+				return "clients cannot check whether the certificate was revoked"
+			}
+			return ""
+		},
+	},
+}
+
+// lint checks the certificate against every rule, returning the most severe
+// findings first.
+func lint(c *x509.Certificate) []*LintFinding {
+	findings := []*LintFinding{}
+	for _, r := range LintRules {
+		if msg := r.check(c); msg != "" {
+			findings = append(findings, &LintFinding{
+				Rule:    r,
+				Message: msg,
+			})
+		}
+	}
+	slices.SortStableFunc(findings, func(a, b *LintFinding) int {
+		return severityRanks[a.Rule.Severity] - severityRanks[b.Rule.Severity]
+	})
+	return findings
+}
+
+// LintReport lists the findings for a single certificate.
+type LintReport struct {
+	Ref      *Ref
+	Findings []*LintFinding
+}
+
+// LintCertificates checks every certificate against the lint rules,
+// returning a report for each one that has findings. Certificates with the
+// most severe findings come first.
+func (s *Storage) LintCertificates() []*LintReport {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	reports := []*LintReport{}
+	walk(s.rootCerts, func(c *storageCert) {
+		if findings := lint(c.cert); len(findings) > 0 {
+			reports = append(reports, &LintReport{
+				Ref:      newRef(c),
+				Findings: findings,
+			})
+		}
+	})
+	slices.SortFunc(reports, func(a, b *LintReport) int {
+		if v := severityRanks[a.Findings[0].Rule.Severity] -
+			severityRanks[b.Findings[0].Rule.Severity]; v != 0 {
+			return v
+		}
+		if v := len(b.Findings) - len(a.Findings); v != 0 {
+			return v
+		}
+		return strings.Compare(a.Ref.Path, b.Ref.Path)
+	})
+	return reports
+}
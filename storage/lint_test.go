@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"slices"
+	"testing"
+)
+
+// ruleIDs returns the IDs of the rules in the findings.
+func ruleIDs(findings []*LintFinding) []string {
+	ids := []string{}
+	for _, f := range findings {
+		ids = append(ids, f.Rule.ID)
+	}
+	return ids
+}
+
+func TestLint(t *testing.T) {
+	s := newTestStorage(t, t.TempDir())
+
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "2y",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root certificate: %v", err)
+	}
+	if len(root.Findings) != 0 {
+		t.Fatalf("root has findings %v", ruleIDs(root.Findings))
+	}
+
+	// A typical server certificate only lacks revocation information
+	good, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "90d",
+		ServerAuth: true,
+		SANs:       childCertCN,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	if v := ruleIDs(good.Findings); !slices.Equal(v, []string{"revocation-info-missing"}) {
+		t.Fatalf("server certificate has findings %v", v)
+	}
+
+	// Problems are reported as soon as the certificate is created, most
+	// severe first
+	bad, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "1y6mo",
+		ServerAuth: true,
+		SANs:       "other.example.test",
+		KeyUsages:  []string{KeyUsageKeyEncipherment},
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	want := []string{
+		"server-cn-not-in-sans",
+		"server-validity",
+		"server-key-usage",
+		"revocation-info-missing",
+	}
+	if v := ruleIDs(bad.Findings); !slices.Equal(v, want) {
+		t.Fatalf("findings = %v, want %v", v, want)
+	}
+
+	// Certificates created elsewhere are checked against the RFC 5280 rules
+	k, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	ca := newExternalCert(t, "Weak CA", true, k, nil, nil)
+	ca.KeyUsage = 0
+	if v := ruleIDs(lint(ca)); !slices.Equal(v, []string{"weak-key", "ca-key-usage"}) {
+		t.Fatalf("external CA findings = %v", v)
+	}
+
+	// The report covers every certificate with findings
+	reports := s.LintCertificates()
+	if len(reports) != 2 || reports[0].Ref.Path != bad.Path {
+		t.Fatalf("expected reports for both leaves, starting with the bad one")
+	}
+}
@@ -0,0 +1,378 @@
+package storage
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	filenameOCSPCert = "ocsp-cert.pem"
+	filenameOCSPKey  = "ocsp-key.pem"
+
+	defaultOCSPValidity = durDay
+	ocspSignerValidity  = 30 * durDay
+
+	// maxOCSPCacheEntries limits the number of cached OCSP responses; once
+	// reached, the entry due to be refreshed first is evicted
+	maxOCSPCacheEntries = 4096
+)
+
+var (
+	// id-pkix-ocsp-nocheck (RFC 6960, section 4.2.2.2.1)
+	oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+	// errOCSPSignerExpired indicates that a new delegated OCSP signer must be
+	// issued, which requires the write lock
+	errOCSPSignerExpired = errors.New("delegated OCSP signer must be reissued")
+)
+
+type ocspCacheEntry struct {
+	response []byte
+	refresh  time.Time
+}
+
+// ocspSigner is used to sign OCSP responses on behalf of a CA.
+type ocspSigner struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// ocspResponse holds everything needed to sign an OCSP response, so that
+// signing can take place without holding the mutex.
+type ocspResponse struct {
+	issuer    *x509.Certificate
+	responder *ocspSigner
+	template  ocsp.Response
+}
+
+func publicKeyHash(c *x509.Certificate, h crypto.Hash) ([]byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(c.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, err
+	}
+	v := h.New()
+	v.Write(spki.PublicKey.RightAlign())
+	return v.Sum(nil), nil
+}
+
+// findIssuer locates the managed CA identified by the hashes in the request.
+// If the CA was renewed with the same key, the version that expires last is
+// returned.
+func (s *Storage) findIssuer(req *ocsp.Request) (*storageCert, error) {
+	if !req.HashAlgorithm.Available() {
+		return nil, nil
+	}
+	var (
+		issuer *storageCert
+		err    error
+	)
+	walk(s.rootCerts, func(c *storageCert) {
+		if err != nil || !c.hasKey || !c.maySign() {
+			return
+		}
+		if issuer != nil && !c.cert.NotAfter.After(issuer.cert.NotAfter) {
+			return
+		}
+		h := req.HashAlgorithm.New()
+		h.Write(c.cert.RawSubject)
+		if !bytes.Equal(h.Sum(nil), req.IssuerNameHash) {
+			return
+		}
+		k, e := publicKeyHash(c.cert, req.HashAlgorithm)
+		if e != nil {
+			err = e
+			return
+		}
+		if bytes.Equal(k, req.IssuerKeyHash) {
+			issuer = c
+		}
+	})
+	return issuer, err
+}
+
+// getOCSPSigner returns the delegated OCSP signing certificate for the CA. If
+// none exists or the existing one is past the midpoint of its validity, a new
+// one is issued when issue is true (which requires the write lock) and
+// errOCSPSignerExpired is returned otherwise.
+func (s *Storage) getOCSPSigner(c *storageCert, caKey crypto.Signer, issue bool) (*ocspSigner, error) {
+	var (
+		certFilename = filepath.Join(c.fPath, filenameOCSPCert)
+		keyFilename  = filepath.Join(c.fPath, filenameOCSPKey)
+	)
+	if x, err := loadCertificate(certFilename); err == nil {
+		half := x.NotBefore.Add(x.NotAfter.Sub(x.NotBefore) / 2)
+		if time.Now().Before(half) {
+			k, err := loadPrivateKey(keyFilename, s.masterKey, c.id)
+			if err != nil {
+				return nil, err
+			}
+			return &ocspSigner{cert: x, key: k}, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if !issue {
+		return nil, errOCSPSignerExpired
+	}
+
+	// ECDSA is always used for the delegated key since it is supported by
+	// all OCSP clients (and golang.org/x/crypto/ocsp only signs with RSA and
+	// ECDSA keys)
+	masterKey, err := s.writeMasterKey()
+	if err != nil {
+		return nil, err
+	}
+	k, err := generatePrivateKey(keyFilename, KeyTypeECDSAP256, 0, masterKey, c.id)
+	if err != nil {
+		return nil, err
+	}
+	issued, err := s.loadIndex(c)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial(issued)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		n        = time.Now()
+		template = &x509.Certificate{
+			SerialNumber: serial,
+			Subject: pkix.Name{
+				CommonName: fmt.Sprintf("%s OCSP Responder", c.cert.Subject.CommonName),
+			},
+			NotBefore:             n,
+			NotAfter:              n.Add(ocspSignerValidity),
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+			ExtraExtensions: []pkix.Extension{
+				{
+					Id:    oidOCSPNoCheck,
+					Value: asn1.NullBytes,
+				},
+			},
+		}
+	)
+	if err := s.writeCertificate(
+		certFilename,
+		template,
+		c.cert,
+		k.Public(),
+		caKey,
+	); err != nil {
+		return nil, err
+	}
+	x, err := loadCertificate(certFilename)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveIssued(c.fPath, append(issued, &IssuedSerial{
+		SerialNumber: x.SerialNumber,
+		Subject:      x.Subject.String(),
+		NotAfter:     x.NotAfter,
+	})); err != nil {
+		return nil, err
+	}
+	return &ocspSigner{cert: x, key: k}, nil
+}
+
+// prepareOCSPResponse determines the status of the certificate in the request
+// and loads the key used to sign the response; nil is returned if the issuer
+// is not a managed CA. The mutex must be held, for writing if issue is true.
+func (s *Storage) prepareOCSPResponse(req *ocsp.Request, issue bool) (*ocspResponse, error) {
+	c, err := s.findIssuer(req)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, nil
+	}
+	k, err := s.loadSigner(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// Determine the status of the certificate; anything in the index of any
+	// version of the CA that has not been revoked is good, even if it was
+	// since deleted
+	revocations, err := s.crlRevocations(c)
+	if err != nil {
+		return nil, err
+	}
+	var issued *IssuedSerial
+	for _, v := range s.caVersions(c) {
+		l, err := s.loadIndex(v)
+		if err != nil {
+			return nil, err
+		}
+		if issued = findIssued(l, req.SerialNumber); issued != nil {
+			break
+		}
+	}
+	var (
+		n        = time.Now()
+		template = ocsp.Response{
+			Status:       ocsp.Unknown,
+			SerialNumber: req.SerialNumber,
+			IssuerHash:   req.HashAlgorithm,
+			ThisUpdate:   n,
+			NextUpdate:   n.Add(s.ocspValidity),
+		}
+	)
+	if r := findRevocation(revocations, req.SerialNumber); r != nil {
+		template.Status = ocsp.Revoked
+		template.RevokedAt = r.RevocationTime
+		template.RevocationReason = r.Reason
+	} else if issued != nil {
+		template.Status = ocsp.Good
+	}
+
+	// Sign the response with the CA key or a delegated signer, which is
+	// always needed for Ed25519 CAs since the OCSP package cannot sign with
+	// Ed25519 keys
+	responder := &ocspSigner{cert: c.cert, key: k}
+	_, isEd25519 := k.(ed25519.PrivateKey)
+	if s.ocspDelegate || isEd25519 {
+		v, err := s.getOCSPSigner(c, k, issue)
+		if err != nil {
+			return nil, err
+		}
+		responder = v
+		template.Certificate = v.cert
+	}
+	return &ocspResponse{
+		issuer:    c.cert,
+		responder: responder,
+		template:  template,
+	}, nil
+}
+
+// invalidateOCSP discards all cached OCSP responses. This must be called
+// whenever the status of a certificate changes.
+func (s *Storage) invalidateOCSP() {
+	s.ocspMutex.Lock()
+	defer s.ocspMutex.Unlock()
+	s.ocspCache = map[string]*ocspCacheEntry{}
+	s.ocspGen++
+}
+
+// cachedOCSPResponse returns the cached response for the key if it has not
+// reached its refresh time, along with the current cache generation.
+func (s *Storage) cachedOCSPResponse(key string) ([]byte, uint64) {
+	s.ocspMutex.Lock()
+	defer s.ocspMutex.Unlock()
+	if e, ok := s.ocspCache[key]; ok && time.Now().Before(e.refresh) {
+		return e.response, s.ocspGen
+	}
+	return nil, s.ocspGen
+}
+
+// cacheOCSPResponse stores the response unless the cache was invalidated
+// since generation was obtained. If the cache is full, expired entries are
+// discarded first and then the entry due to be refreshed soonest.
+func (s *Storage) cacheOCSPResponse(key string, response []byte, generation uint64) {
+	s.ocspMutex.Lock()
+	defer s.ocspMutex.Unlock()
+	if generation != s.ocspGen {
+		return
+	}
+	if _, ok := s.ocspCache[key]; !ok && len(s.ocspCache) >= maxOCSPCacheEntries {
+		var (
+			n      = time.Now()
+			oldest string
+		)
+		for k, e := range s.ocspCache {
+			if !n.Before(e.refresh) {
+				delete(s.ocspCache, k)
+				continue
+			}
+			if oldest == "" || e.refresh.Before(s.ocspCache[oldest].refresh) {
+				oldest = k
+			}
+		}
+		if len(s.ocspCache) >= maxOCSPCacheEntries {
+			delete(s.ocspCache, oldest)
+		}
+	}
+	s.ocspCache[key] = &ocspCacheEntry{
+		response: response,
+		refresh:  time.Now().Add(s.ocspValidity / 2),
+	}
+}
+
+// RespondOCSP creates a signed OCSP response for the DER-encoded request.
+// Responses for certificates that are known to be good or revoked are cached
+// until the midpoint of their validity or until a certificate is revoked or
+// deleted. Malformed requests and requests for certificates not issued by a
+// managed CA receive the appropriate OCSP error response rather than an
+// error.
+func (s *Storage) RespondOCSP(b []byte) ([]byte, error) {
+	req, err := ocsp.ParseRequest(b)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse, nil
+	}
+	key := fmt.Sprintf(
+		"%d:%x:%x:%s",
+		req.HashAlgorithm,
+		req.IssuerNameHash,
+		req.IssuerKeyHash,
+		req.SerialNumber,
+	)
+
+	r, generation := s.cachedOCSPResponse(key)
+	if r != nil {
+		return r, nil
+	}
+
+	// The write lock is only needed when a new delegated signer is issued
+	s.mutex.RLock()
+	v, err := s.prepareOCSPResponse(req, false)
+	s.mutex.RUnlock()
+	if errors.Is(err, errOCSPSignerExpired) {
+		s.mutex.Lock()
+		v, err = s.prepareOCSPResponse(req, true)
+		s.mutex.Unlock()
+	}
+	if err != nil {
+
+		// Responses cannot be signed until storage is unsealed
+		if errors.Is(err, ErrSealed) {
+			return ocsp.TryLaterErrorResponse, nil
+		}
+		return nil, err
+	}
+	if v == nil {
+		return ocsp.UnauthorizedErrorResponse, nil
+	}
+	r, err = ocsp.CreateResponse(
+		v.issuer,
+		v.responder.cert,
+		v.template,
+		v.responder.key,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Serials that were never issued are not cached so that arbitrary
+	// requests cannot fill the cache
+	if v.template.Status != ocsp.Unknown {
+		s.cacheOCSPResponse(key, r, generation)
+	}
+	return r, nil
+}
@@ -0,0 +1,307 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestRespondOCSP(t *testing.T) {
+	for _, delegate := range []bool{false, true} {
+		s, err := New(&Config{
+			DataDir:      t.TempDir(),
+			OCSPDelegate: delegate,
+		})
+		if err != nil {
+			t.Fatalf("new storage: %v", err)
+		}
+
+		root, err := s.CreateCertificate("", &CreateCertificateParams{
+			CommonName: rootCertCN,
+			Validity:   "1h",
+			CanSign:    true,
+			KeyType:    KeyTypeECDSAP256,
+		})
+		if err != nil {
+			t.Fatalf("create root certificate: %v", err)
+		}
+		child, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+			CommonName: childCertCN,
+			Validity:   "30m",
+			KeyType:    KeyTypeECDSAP256,
+		})
+		if err != nil {
+			t.Fatalf("create child certificate: %v", err)
+		}
+
+		query := func() *ocsp.Response {
+			t.Helper()
+			req, err := ocsp.CreateRequest(child.X509, root.X509, nil)
+			if err != nil {
+				t.Fatalf("create OCSP request: %v", err)
+			}
+			b, err := s.RespondOCSP(req)
+			if err != nil {
+				t.Fatalf("respond OCSP: %v", err)
+			}
+			r, err := ocsp.ParseResponseForCert(b, child.X509, root.X509)
+			if err != nil {
+				t.Fatalf("parse OCSP response: %v", err)
+			}
+			if delegate != (r.Certificate != nil) {
+				t.Fatalf("delegate = %v, response certificate = %v", delegate, r.Certificate)
+			}
+			return r
+		}
+
+		if r := query(); r.Status != ocsp.Good {
+			t.Fatalf("status = %d, want good", r.Status)
+		}
+		if err := s.RevokeCertificate(child.Path, &RevokeCertificateParams{
+			Reason: ReasonSuperseded,
+		}); err != nil {
+			t.Fatalf("revoke child certificate: %v", err)
+		}
+		r := query()
+		if r.Status != ocsp.Revoked || r.RevocationReason != ReasonSuperseded {
+			t.Fatalf("status = %d (%d), want revoked (superseded)", r.Status, r.RevocationReason)
+		}
+	}
+}
+
+func TestRespondOCSPRenewedCA(t *testing.T) {
+	s := newTestStorage(t, t.TempDir())
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1h",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root certificate: %v", err)
+	}
+	params := &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "30m",
+		KeyType:    KeyTypeECDSAP256,
+	}
+	revoked, err := s.CreateCertificate(root.Path, params)
+	if err != nil {
+		t.Fatalf("create child certificate: %v", err)
+	}
+	good, err := s.CreateCertificate(root.Path, params)
+	if err != nil {
+		t.Fatalf("create child certificate: %v", err)
+	}
+	if err := s.RevokeCertificate(revoked.Path, &RevokeCertificateParams{
+		Reason: ReasonKeyCompromise,
+	}); err != nil {
+		t.Fatalf("revoke child certificate: %v", err)
+	}
+
+	// Renew the root with the same key and issue from the new version
+	renewed, err := s.RenewCertificate(root.Path, &RenewCertificateParams{Validity: "2h"})
+	if err != nil {
+		t.Fatalf("renew root: %v", err)
+	}
+	newer, err := s.CreateCertificate(renewed.Path, params)
+	if err != nil {
+		t.Fatalf("create child of renewed root: %v", err)
+	}
+
+	// Every version of the CA answers for all of them, whichever is found
+	for i := 0; i < 10; i++ {
+		for _, tt := range []struct {
+			cert   *Certificate
+			status int
+		}{
+			{revoked, ocsp.Revoked},
+			{good, ocsp.Good},
+			{newer, ocsp.Good},
+		} {
+			s.invalidateOCSP()
+			req, err := ocsp.CreateRequest(tt.cert.X509, root.X509, nil)
+			if err != nil {
+				t.Fatalf("create OCSP request: %v", err)
+			}
+			b, err := s.RespondOCSP(req)
+			if err != nil {
+				t.Fatalf("respond OCSP: %v", err)
+			}
+			r, err := ocsp.ParseResponse(b, nil)
+			if err != nil {
+				t.Fatalf("parse OCSP response: %v", err)
+			}
+			if r.Status != tt.status {
+				t.Fatalf("status of %s = %d, want %d", tt.cert.Path, r.Status, tt.status)
+			}
+		}
+	}
+}
+
+func TestRespondOCSPErrors(t *testing.T) {
+	s := newTestStorage(t, t.TempDir())
+
+	b, err := s.RespondOCSP([]byte("invalid"))
+	if err != nil {
+		t.Fatalf("respond OCSP: %v", err)
+	}
+	if !bytes.Equal(b, ocsp.MalformedRequestErrorResponse) {
+		t.Fatalf("response = %x, want malformed request", b)
+	}
+
+	// Create a CA in a different storage instance so that it is unknown
+	other := newTestStorage(t, t.TempDir())
+	root, err := other.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1h",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root certificate: %v", err)
+	}
+	req, err := ocsp.CreateRequest(root.X509, root.X509, nil)
+	if err != nil {
+		t.Fatalf("create OCSP request: %v", err)
+	}
+	b, err = s.RespondOCSP(req)
+	if err != nil {
+		t.Fatalf("respond OCSP: %v", err)
+	}
+	if !bytes.Equal(b, ocsp.UnauthorizedErrorResponse) {
+		t.Fatalf("response = %x, want unauthorized", b)
+	}
+}
+
+func TestRespondOCSPCache(t *testing.T) {
+	s := newTestStorage(t, t.TempDir())
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1h",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root certificate: %v", err)
+	}
+	child, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "30m",
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create child certificate: %v", err)
+	}
+
+	// Responses for serials that were never issued are not cached
+	for i := int64(1); i <= 10; i++ {
+		b, err := ocsp.CreateRequest(child.X509, root.X509, nil)
+		if err != nil {
+			t.Fatalf("create OCSP request: %v", err)
+		}
+		req, err := ocsp.ParseRequest(b)
+		if err != nil {
+			t.Fatalf("parse OCSP request: %v", err)
+		}
+		req.SerialNumber = big.NewInt(i)
+		if b, err = req.Marshal(); err != nil {
+			t.Fatalf("marshal OCSP request: %v", err)
+		}
+		b, err = s.RespondOCSP(b)
+		if err != nil {
+			t.Fatalf("respond OCSP: %v", err)
+		}
+		r, err := ocsp.ParseResponse(b, root.X509)
+		if err != nil {
+			t.Fatalf("parse OCSP response: %v", err)
+		}
+		if r.Status != ocsp.Unknown {
+			t.Fatalf("status = %d, want unknown", r.Status)
+		}
+	}
+	if n := len(s.ocspCache); n != 0 {
+		t.Fatalf("cache entries = %d, want 0", n)
+	}
+
+	// Responses for issued serials are
+	req, err := ocsp.CreateRequest(child.X509, root.X509, nil)
+	if err != nil {
+		t.Fatalf("create OCSP request: %v", err)
+	}
+	if _, err := s.RespondOCSP(req); err != nil {
+		t.Fatalf("respond OCSP: %v", err)
+	}
+	if n := len(s.ocspCache); n != 1 {
+		t.Fatalf("cache entries = %d, want 1", n)
+	}
+
+	// The cache never grows beyond its limit
+	_, generation := s.cachedOCSPResponse("")
+	for i := 0; i < maxOCSPCacheEntries+10; i++ {
+		s.cacheOCSPResponse(fmt.Sprint(i), nil, generation)
+	}
+	if n := len(s.ocspCache); n != maxOCSPCacheEntries {
+		t.Fatalf("cache entries = %d, want %d", n, maxOCSPCacheEntries)
+	}
+
+	// Nor is a response stored once the cache has been invalidated
+	s.invalidateOCSP()
+	s.cacheOCSPResponse("stale", nil, generation)
+	if n := len(s.ocspCache); n != 0 {
+		t.Fatalf("cache entries = %d, want 0", n)
+	}
+}
+
+func TestRespondOCSPEd25519(t *testing.T) {
+	s := newTestStorage(t, t.TempDir())
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1h",
+		CanSign:    true,
+		KeyType:    KeyTypeEd25519,
+	})
+	if err != nil {
+		t.Fatalf("create root certificate: %v", err)
+	}
+	child, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "30m",
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create child certificate: %v", err)
+	}
+
+	// The OCSP package cannot sign with Ed25519 keys, which is why the
+	// response must come from a delegated responder
+	k, err := s.loadSigner(s.rootCerts[root.ID])
+	if err != nil {
+		t.Fatalf("load root key: %v", err)
+	}
+	if _, err := ocsp.CreateResponse(root.X509, root.X509, ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: child.X509.SerialNumber,
+	}, k); err == nil {
+		t.Fatal("expected signing an OCSP response with Ed25519 to fail")
+	}
+
+	req, err := ocsp.CreateRequest(child.X509, root.X509, nil)
+	if err != nil {
+		t.Fatalf("create OCSP request: %v", err)
+	}
+	b, err := s.RespondOCSP(req)
+	if err != nil {
+		t.Fatalf("respond OCSP: %v", err)
+	}
+	r, err := ocsp.ParseResponseForCert(b, child.X509, root.X509)
+	if err != nil {
+		t.Fatalf("parse OCSP response: %v", err)
+	}
+	if r.Status != ocsp.Good || r.Certificate == nil {
+		t.Fatalf("status = %d, certificate = %v, want good from a delegated responder", r.Status, r.Certificate)
+	}
+}
@@ -0,0 +1,352 @@
+package storage
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	filenameRequest = "request.pem"
+
+	pendingIDLength = 6
+)
+
+var (
+	oidSubjectKeyID   = asn1.ObjectIdentifier{2, 5, 29, 14}
+	oidAuthorityKeyID = asn1.ObjectIdentifier{2, 5, 29, 35}
+)
+
+var (
+	errPendingDoesNotExist = notFoundError("pending certificate does not exist")
+	errPendingNoMatch      = inputError("no certificate matches the key of the pending certificate")
+)
+
+// PendingCertificate is a private key that is waiting for its certificate to
+// be signed by an external CA.
+type PendingCertificate struct {
+	ID      string
+	CSR     *CSR
+	Created time.Time
+}
+
+// pendingPath returns the directory of the pending certificate or an error if
+// the ID is not valid or the certificate does not exist.
+func (s *Storage) pendingPath(id string) (string, error) {
+	if b, err := hex.DecodeString(id); err != nil || len(b) != pendingIDLength {
+		return "", errPendingDoesNotExist
+	}
+	d := filepath.Join(s.pendingDir, id)
+	if !isDir(d) {
+		return "", errPendingDoesNotExist
+	}
+	return d, nil
+}
+
+// loadPending reads the pending certificate stored in d.
+func loadPending(d string) (*PendingCertificate, error) {
+	filename := filepath.Join(d, filenameRequest)
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	r, err := ParseCSR(b)
+	if err != nil {
+		return nil, err
+	}
+	i, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := loadKeyRef(filepath.Join(d, filenameKeyRef))
+	if err != nil {
+		return nil, err
+	}
+	if r.Key != nil && ref != nil {
+		r.Key.Token = ref.Token
+	}
+	return &PendingCertificate{
+		ID:      filepath.Base(d),
+		CSR:     r,
+		Created: i.ModTime(),
+	}, nil
+}
+
+// newRequest creates a certificate signing request with the subject and
+// extensions that the template would have if Certy issued it. The extensions
+// are found by signing the template with the key itself; the key identifiers
+// are left for the CA to set.
+func newRequest(template *x509.Certificate, k crypto.Signer) ([]byte, error) {
+	n := time.Now()
+	template.SerialNumber = big.NewInt(1)
+	template.NotBefore = n
+	template.NotAfter = n.Add(time.Minute)
+	b, err := x509.CreateCertificate(rand.Reader, template, template, k.Public(), k)
+	if err != nil {
+		return nil, err
+	}
+	x, err := x509.ParseCertificate(b)
+	if err != nil {
+		return nil, err
+	}
+	r := &x509.CertificateRequest{
+		Subject: template.Subject,
+	}
+	for _, e := range x.Extensions {
+		if e.Id.Equal(oidSubjectKeyID) || e.Id.Equal(oidAuthorityKeyID) {
+			continue
+		}
+		r.ExtraExtensions = append(r.ExtraExtensions, e)
+	}
+	b, err = x509.CreateCertificateRequest(rand.Reader, r, k)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  typeCertificateRequest,
+		Bytes: b,
+	}), nil
+}
+
+// CreatePendingCertificate generates a private key and a certificate signing
+// request for it, which can then be signed by an external CA and passed to
+// CompletePendingCertificate. The request has the subject and extensions
+// described by params; the validity and parent settings are ignored since
+// they are chosen by the CA.
+func (s *Storage) CreatePendingCertificate(
+	params *CreateCertificateParams,
+) (*PendingCertificate, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Apply the profile before anything is created
+	params, err := s.applyProfile(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each pending certificate has a random ID since there is no certificate
+	// to take a fingerprint of
+	b := make([]byte, pendingIDLength)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.pendingDir, 0700); err != nil {
+		return nil, err
+	}
+	d := filepath.Join(s.pendingDir, hex.EncodeToString(b))
+	if err := os.Mkdir(d, 0700); err != nil {
+		return nil, err
+	}
+	success := false
+	defer func() {
+		if !success {
+			os.RemoveAll(d)
+		}
+	}()
+
+	// See CreateCertificate for why the key on the token is tracked
+	k, tokenKey, err := s.generateKey(d, params.KeyType, params.KeySize, params.PKCS11)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if !success && tokenKey != nil {
+			s.token.deleteKey(tokenKey)
+		}
+	}()
+	if tokenKey == nil {
+		if err := s.keyWriter(k)(d, filepath.Base(d)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Build the request from the same template used for new certificates
+	template, err := newTemplate(params, k.Public())
+	if err != nil {
+		return nil, err
+	}
+	r, err := newRequest(template, k)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(d, filenameRequest), r, 0600); err != nil {
+		return nil, err
+	}
+	p, err := loadPending(d)
+	if err != nil {
+		return nil, err
+	}
+	success = true
+	return p, nil
+}
+
+// GetPendingCertificates returns the certificates waiting to be signed,
+// oldest first.
+func (s *Storage) GetPendingCertificates() ([]*PendingCertificate, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	entries, err := os.ReadDir(s.pendingDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	pending := []*PendingCertificate{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		p, err := loadPending(filepath.Join(s.pendingDir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, p)
+	}
+	slices.SortFunc(pending, func(a, b *PendingCertificate) int {
+		if v := a.Created.Compare(b.Created); v != 0 {
+			return v
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return pending, nil
+}
+
+// GetPendingCertificate returns the pending certificate with the specified
+// ID.
+func (s *Storage) GetPendingCertificate(id string) (*PendingCertificate, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	d, err := s.pendingPath(id)
+	if err != nil {
+		return nil, err
+	}
+	return loadPending(d)
+}
+
+// ExportPendingCSR returns the PEM-encoded certificate signing request of
+// the pending certificate.
+func (s *Storage) ExportPendingCSR(id string) ([]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	d, err := s.pendingPath(id)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(d, filenameRequest))
+}
+
+// DeletePendingCertificate discards the pending certificate along with its
+// private key.
+func (s *Storage) DeletePendingCertificate(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d, err := s.pendingPath(id)
+	if err != nil {
+		return err
+	}
+	r, err := loadKeyRef(filepath.Join(d, filenameKeyRef))
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(d); err != nil {
+		return err
+	}
+	if r != nil && s.token != nil {
+		if err := s.token.deleteKey(r); err != nil {
+			s.logger.Error(
+				"unable to delete key from token",
+				"label", r.Label,
+				"error", err,
+			)
+		}
+	}
+	return nil
+}
+
+// copyFile copies src to dst, doing nothing if src does not exist.
+func copyFile(dst, src string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// CompletePendingCertificate adds the certificate signed by the external CA
+// to the hierarchy with the pending private key. The data is in any of the
+// formats accepted by ImportCertificate and must include the certificate
+// matching the key along with any issuers that are not yet managed, up to
+// and including the root. The pending certificate is removed and the new
+// certificate is returned upon success.
+func (s *Storage) CompletePendingCertificate(
+	id string,
+	data []byte,
+) (*Certificate, error) {
+	certs, _, err := parseImport(data, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errImportNoCert
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	d, err := s.pendingPath(id)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := loadPending(d)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the certificate issued for the key
+	i := slices.IndexFunc(certs, func(x *x509.Certificate) bool {
+		v, ok := x.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+		return ok && v.Equal(pending.CSR.X509.PublicKey)
+	})
+	if i == -1 {
+		return nil, errPendingNoMatch
+	}
+	x := certs[i]
+
+	// Import the issuers that are not yet managed and then the certificate
+	// itself, whose key is copied from the pending directory
+	p, chain, err := issuerChain(s.rootCerts, x, certs, true)
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.importChain(p, chain, s.keyCopier(d, id))
+	if err != nil {
+		return nil, err
+	}
+
+	// The key now belongs to the certificate
+	if err := os.RemoveAll(d); err != nil {
+		return nil, err
+	}
+	return convertCert(c), nil
+}
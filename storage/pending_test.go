@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// signPending signs the pending certificate's request the way an external CA
+// would, keeping the subject and extensions requested.
+func signPending(
+	t *testing.T,
+	p *PendingCertificate,
+	parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey,
+) *x509.Certificate {
+	t.Helper()
+	n := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(n.UnixNano()),
+		Subject:         p.CSR.X509.Subject,
+		NotBefore:       n.Add(-time.Minute),
+		NotAfter:        n.Add(30 * time.Minute),
+		ExtraExtensions: p.CSR.X509.Extensions,
+	}
+	b, err := x509.CreateCertificate(rand.Reader, template, parent, p.CSR.X509.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("sign request: %v", err)
+	}
+	x, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatalf("parse signed certificate: %v", err)
+	}
+	return x
+}
+
+func TestPendingCertificate(t *testing.T) {
+	var (
+		dataDir = t.TempDir()
+		s       = newTestStorage(t, dataDir)
+	)
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := newExternalCert(t, rootCertCN, true, rootKey, nil, nil)
+
+	// Generate a key and request for an intermediate CA
+	p, err := s.CreatePendingCertificate(&CreateCertificateParams{
+		CommonName:   "Issuing CA",
+		Organization: "Example",
+		CanSign:      true,
+		SANs:         childCertCN,
+		KeyType:      KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create pending certificate: %v", err)
+	}
+	r := p.CSR.X509
+	if r.Subject.CommonName != "Issuing CA" || len(r.Subject.Organization) != 1 {
+		t.Fatalf("request subject = %s", r.Subject)
+	}
+	if len(r.DNSNames) != 1 || r.DNSNames[0] != childCertCN {
+		t.Fatalf("request DNS names = %v, want %s", r.DNSNames, childCertCN)
+	}
+	if p.CSR.Key.Algorithm != AlgorithmECDSA {
+		t.Fatalf("request key algorithm = %s", p.CSR.Key.Algorithm)
+	}
+	for _, e := range r.Extensions {
+		if e.Id.Equal(oidSubjectKeyID) || e.Id.Equal(oidAuthorityKeyID) {
+			t.Fatalf("request should not include key identifier %s", e.Id)
+		}
+	}
+
+	// The request can be listed and exported
+	l, err := s.GetPendingCertificates()
+	if err != nil || len(l) != 1 || l[0].ID != p.ID {
+		t.Fatalf("pending certificates = %v (%v), want %s", l, err, p.ID)
+	}
+	b, err := s.ExportPendingCSR(p.ID)
+	if err != nil {
+		t.Fatalf("export request: %v", err)
+	}
+	if _, err := ParseCSR(b); err != nil {
+		t.Fatalf("parse exported request: %v", err)
+	}
+	for _, id := range []string{"missing", "../certs", "000000000000"} {
+		if _, err := s.GetPendingCertificate(id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("get pending %q: expected not found, got %v", id, err)
+		}
+	}
+
+	// A certificate for another key is rejected
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newExternalCert(t, "Other", false, otherKey, root, rootKey)
+	if _, err := s.CompletePendingCertificate(
+		p.ID,
+		append(encodeCertPEM(other), encodeCertPEM(root)...),
+	); !errors.Is(err, errPendingNoMatch) {
+		t.Fatalf("expected no match, got %v", err)
+	}
+
+	// ...as is one whose chain does not reach a root
+	signed := signPending(t, p, root, rootKey)
+	if _, err := s.CompletePendingCertificate(
+		p.ID,
+		encodeCertPEM(signed),
+	); !errors.Is(err, errImportIssuerNotFound) {
+		t.Fatalf("expected issuer not found, got %v", err)
+	}
+
+	// Upload the signed certificate with its root
+	c, err := s.CompletePendingCertificate(
+		p.ID,
+		append(encodeCertPEM(signed), encodeCertPEM(root)...),
+	)
+	if err != nil {
+		t.Fatalf("complete pending certificate: %v", err)
+	}
+	if c.PrivateKey == nil || len(c.Parents) != 1 || !c.X509.IsCA {
+		t.Fatalf("completed certificate = %+v, want CA with key below root", c)
+	}
+	if _, err := s.GetPendingCertificate(p.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected pending certificate to be removed, got %v", err)
+	}
+
+	// The new CA can issue certificates, which survive reloading storage
+	leaf, err := s.CreateCertificate(c.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "10m",
+		ServerAuth: true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("issue below completed certificate: %v", err)
+	}
+	if err := leaf.X509.CheckSignatureFrom(signed); err != nil {
+		t.Fatalf("leaf signature: %v", err)
+	}
+	s = newTestStorage(t, dataDir)
+	if _, err := s.GetCertificate(leaf.Path); err != nil {
+		t.Fatalf("get leaf after reload: %v", err)
+	}
+
+	// Encrypting keys includes those still pending
+	p, err = s.CreatePendingCertificate(&CreateCertificateParams{
+		CommonName: childCertCN,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create pending certificate: %v", err)
+	}
+	if err := s.EncryptKeys("passphrase"); err != nil {
+		t.Fatalf("encrypt keys: %v", err)
+	}
+	keyFile := filepath.Join(dataDir, "pending", p.ID, filenamePrivateKey)
+	if v := readPEMType(t, keyFile); v != typeEncryptedKey {
+		t.Fatalf("pending key type = %q, want %q", v, typeEncryptedKey)
+	}
+
+	// Discarding a request removes it
+	if err := s.DeletePendingCertificate(p.ID); err != nil {
+		t.Fatalf("delete pending certificate: %v", err)
+	}
+	if l, err := s.GetPendingCertificates(); err != nil || len(l) != 0 {
+		t.Fatalf("pending certificates after delete = %d (%v), want 0", len(l), err)
+	}
+}
+
+func TestCompletePendingRollback(t *testing.T) {
+	var (
+		dataDir = t.TempDir()
+		s       = newTestStorage(t, dataDir)
+	)
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := newExternalCert(t, rootCertCN, true, rootKey, nil, nil)
+	p, err := s.CreatePendingCertificate(&CreateCertificateParams{
+		CommonName: childCertCN,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create pending certificate: %v", err)
+	}
+	signed := signPending(t, p, root, rootKey)
+
+	// Make copying the key fail once the root has been imported
+	keyFile := filepath.Join(dataDir, "pending", p.ID, filenamePrivateKey)
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(keyFile, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CompletePendingCertificate(
+		p.ID,
+		append(encodeCertPEM(signed), encodeCertPEM(root)...),
+	); err == nil {
+		t.Fatal("expected completing the pending certificate to fail")
+	}
+
+	// The root must not be left behind, in memory or on disk
+	if l := s.GetRootCertificates(); len(l) != 0 {
+		t.Fatalf("root certificates = %d, want 0", len(l))
+	}
+	s = newTestStorage(t, dataDir)
+	if l := s.GetRootCertificates(); len(l) != 0 {
+		t.Fatalf("root certificates after reload = %d, want 0", len(l))
+	}
+	if _, err := s.GetPendingCertificate(p.ID); err != nil {
+		t.Fatalf("expected pending certificate to remain, got %v", err)
+	}
+}
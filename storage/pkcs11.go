@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+const (
+	filenameKeyRef = "key.pkcs11.json"
+
+	keyIDLength = 16
+)
+
+var (
+	errNoToken              = inputError("no PKCS#11 token is configured")
+	errKeyNotExportable     = inputError("the private key is stored on a PKCS#11 token and cannot be exported")
+	errTokenKeyType         = inputError("Ed25519 keys cannot be generated on a PKCS#11 token")
+	errTokenKeyDoesNotExist = notFoundError("the private key does not exist on the PKCS#11 token")
+)
+
+// PKCS11Config provides configuration for generating and using private keys
+// on a PKCS#11 token, such as a hardware security module.
+type PKCS11Config struct {
+
+	// Module is the path to the PKCS#11 library provided by the token vendor
+	// (for example, libsofthsm2.so).
+	Module string
+
+	// TokenLabel identifies the token to use within the module.
+	TokenLabel string
+
+	// PIN is the user PIN used to log in to the token.
+	PIN string
+}
+
+// keyRef is stored in place of key.pem when the private key lives on a
+// PKCS#11 token and identifies the key on that token.
+type keyRef struct {
+	Token string `json:"token"`
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+// id returns the CKA_ID attribute of the key.
+func (r *keyRef) id() []byte {
+	b, _ := hex.DecodeString(r.ID)
+	return b
+}
+
+// token is implemented by the PKCS#11 backend, which is only available when
+// cgo is enabled.
+type token interface {
+	label() string
+	generateKey(ref *keyRef, keyType string, bits int) (crypto.Signer, error)
+	findKey(ref *keyRef) (crypto.Signer, error)
+	deleteKey(ref *keyRef) error
+	close() error
+}
+
+func newKeyRef(t token) (*keyRef, error) {
+	b := make([]byte, keyIDLength)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(b)
+	return &keyRef{
+		Token: t.label(),
+		ID:    id,
+		Label: "certy-" + id,
+	}, nil
+}
+
+func loadKeyRef(filename string) (*keyRef, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	r := &keyRef{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func writeKeyRef(filename string, r *keyRef) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, b, 0600)
+}
+
+// generateTokenKey creates a new key pair on the token and records the
+// reference to it in dir.
+func (s *Storage) generateTokenKey(dir, keyType string, bits int) (crypto.Signer, *keyRef, error) {
+	if s.token == nil {
+		return nil, nil, errNoToken
+	}
+	if keyType == KeyTypeEd25519 {
+		return nil, nil, errTokenKeyType
+	}
+	r, err := newKeyRef(s.token)
+	if err != nil {
+		return nil, nil, err
+	}
+	k, err := s.token.generateKey(r, keyType, bits)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeKeyRef(filepath.Join(dir, filenameKeyRef), r); err != nil {
+		s.token.deleteKey(r)
+		return nil, nil, err
+	}
+	return k, r, nil
+}
+
+// loadSigner returns the private key for the certificate, whether it is
+// stored on disk or on the token; it must be called with the mutex held.
+func (s *Storage) loadSigner(c *storageCert) (crypto.Signer, error) {
+	if c.keyRef == nil {
+		return loadPrivateKey(
+			filepath.Join(c.fPath, filenamePrivateKey),
+			s.masterKey,
+			c.id,
+		)
+	}
+	if s.token == nil {
+		return nil, errNoToken
+	}
+	return s.token.findKey(c.keyRef)
+}
+
+// deleteTokenKeys removes the keys on the token belonging to the certificate
+// and all of its descendants, which must already have been removed from the
+// tree. Keys still used by another certificate (a renewed version) are kept.
+// Failures are logged rather than returned since the certificates have
+// already been removed from disk.
+func (s *Storage) deleteTokenKeys(c *storageCert) {
+	inUse := map[string]bool{}
+	walk(s.rootCerts, func(c *storageCert) {
+		if c.keyRef != nil {
+			inUse[c.keyRef.ID] = true
+		}
+	})
+	fn := func(c *storageCert) {
+		if c.keyRef == nil || s.token == nil || inUse[c.keyRef.ID] {
+			return
+		}
+		if err := s.token.deleteKey(c.keyRef); err != nil {
+			s.logger.Error(
+				"unable to delete key from token",
+				"label", c.keyRef.Label,
+				"error", err,
+			)
+		}
+	}
+	fn(c)
+	walk(c.children, fn)
+}
+
+// PKCS11Enabled indicates whether a PKCS#11 token is available for
+// generating private keys.
+func (s *Storage) PKCS11Enabled() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.token != nil
+}
+
+// Close releases the PKCS#11 token, if one is in use.
+func (s *Storage) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.token == nil {
+		return nil
+	}
+	err := s.token.close()
+	s.token = nil
+	return err
+}
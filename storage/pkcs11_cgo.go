@@ -0,0 +1,82 @@
+//go:build cgo
+
+package storage
+
+import (
+	"crypto"
+	"crypto/elliptic"
+
+	"github.com/ThalesIgnite/crypto11"
+)
+
+// pkcs11Token uses crypto11 to access keys on the token; the context is safe
+// for use in multiple goroutines.
+type pkcs11Token struct {
+	ctx        *crypto11.Context
+	tokenLabel string
+}
+
+func openToken(cfg *PKCS11Config) (token, error) {
+	ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:       cfg.Module,
+		TokenLabel: cfg.TokenLabel,
+		Pin:        cfg.PIN,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pkcs11Token{
+		ctx:        ctx,
+		tokenLabel: cfg.TokenLabel,
+	}, nil
+}
+
+func (t *pkcs11Token) label() string {
+	return t.tokenLabel
+}
+
+func (t *pkcs11Token) generateKey(ref *keyRef, keyType string, bits int) (crypto.Signer, error) {
+	var (
+		id    = ref.id()
+		label = []byte(ref.Label)
+	)
+	switch keyType {
+	case "", KeyTypeRSA:
+		return t.ctx.GenerateRSAKeyPairWithLabel(id, label, bits)
+	case KeyTypeECDSAP256:
+		return t.ctx.GenerateECDSAKeyPairWithLabel(id, label, elliptic.P256())
+	case KeyTypeECDSAP384:
+		return t.ctx.GenerateECDSAKeyPairWithLabel(id, label, elliptic.P384())
+	case KeyTypeECDSAP521:
+		return t.ctx.GenerateECDSAKeyPairWithLabel(id, label, elliptic.P521())
+	default:
+		return nil, errInvalidKeyType
+	}
+}
+
+func (t *pkcs11Token) find(ref *keyRef) (crypto11.Signer, error) {
+	k, err := t.ctx.FindKeyPair(ref.id(), []byte(ref.Label))
+	if err != nil {
+		return nil, err
+	}
+	if k == nil {
+		return nil, errTokenKeyDoesNotExist
+	}
+	return k, nil
+}
+
+func (t *pkcs11Token) findKey(ref *keyRef) (crypto.Signer, error) {
+	return t.find(ref)
+}
+
+func (t *pkcs11Token) deleteKey(ref *keyRef) error {
+	k, err := t.find(ref)
+	if err != nil {
+		return err
+	}
+	return k.Delete()
+}
+
+func (t *pkcs11Token) close() error {
+	return t.ctx.Close()
+}
@@ -0,0 +1,125 @@
+//go:build cgo
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	softHSMLabel = "certy-test"
+	softHSMPIN   = "1234"
+)
+
+// softHSMPaths lists where distributions install the SoftHSM module; the
+// SOFTHSM2_MODULE environment variable takes precedence.
+var softHSMPaths = []string{
+	"/usr/lib/softhsm/libsofthsm2.so",
+	"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/lib/aarch64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/lib64/pkcs11/libsofthsm2.so",
+	"/usr/local/lib/softhsm/libsofthsm2.so",
+}
+
+// newSoftHSM initializes a new SoftHSM token in a temporary directory,
+// skipping the test if SoftHSM is not installed.
+func newSoftHSM(t *testing.T) *PKCS11Config {
+	t.Helper()
+	module := os.Getenv("SOFTHSM2_MODULE")
+	if module == "" {
+		for _, p := range softHSMPaths {
+			if _, err := os.Stat(p); err == nil {
+				module = p
+				break
+			}
+		}
+	}
+	util, err := exec.LookPath("softhsm2-util")
+	if module == "" || err != nil {
+		t.Skip("SoftHSM is not installed")
+	}
+	var (
+		dir     = t.TempDir()
+		tokens  = filepath.Join(dir, "tokens")
+		cfgFile = filepath.Join(dir, "softhsm2.conf")
+	)
+	if err := os.Mkdir(tokens, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(
+		cfgFile,
+		[]byte(fmt.Sprintf("directories.tokendir = %s\n", tokens)),
+		0600,
+	); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SOFTHSM2_CONF", cfgFile)
+	if b, err := exec.Command(
+		util,
+		"--init-token",
+		"--free",
+		"--label", softHSMLabel,
+		"--pin", softHSMPIN,
+		"--so-pin", softHSMPIN,
+	).CombinedOutput(); err != nil {
+		t.Fatalf("init token: %v: %s", err, b)
+	}
+	return &PKCS11Config{
+		Module:     module,
+		TokenLabel: softHSMLabel,
+		PIN:        softHSMPIN,
+	}
+}
+
+func TestSoftHSM(t *testing.T) {
+	var (
+		cfg     = newSoftHSM(t)
+		dataDir = t.TempDir()
+	)
+	s, err := New(&Config{
+		DataDir: dataDir,
+		PKCS11:  cfg,
+	})
+	if err != nil {
+		t.Fatalf("new storage: %v", err)
+	}
+	defer s.Close()
+	testTokenKeys(t, s, dataDir)
+
+	// RSA keys can be generated and used for signing too
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1h",
+		CanSign:    true,
+		KeyType:    KeyTypeRSA,
+		KeySize:    2048,
+		PKCS11:     true,
+	})
+	if err != nil {
+		t.Fatalf("create RSA root on token: %v", err)
+	}
+	if _, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "30m",
+		KeyType:    KeyTypeECDSAP256,
+	}); err != nil {
+		t.Fatalf("sign with RSA key on token: %v", err)
+	}
+
+	// Deleting the certificate removes the key from the token
+	c, err := s.getCert(root.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteCertificate(root.Path); err != nil {
+		t.Fatalf("delete root: %v", err)
+	}
+	if _, err := s.token.findKey(c.keyRef); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected key to be deleted from token, got %v", err)
+	}
+}
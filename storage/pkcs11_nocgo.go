@@ -0,0 +1,13 @@
+//go:build !cgo
+
+package storage
+
+import (
+	"errors"
+)
+
+var errPKCS11Unsupported = errors.New("PKCS#11 support requires a build with cgo enabled")
+
+func openToken(cfg *PKCS11Config) (token, error) {
+	return nil, errPKCS11Unsupported
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeToken keeps keys in memory so that the storage logic can be tested
+// without a PKCS#11 module.
+type fakeToken struct {
+	keys map[string]crypto.Signer
+}
+
+func (t *fakeToken) label() string {
+	return "fake"
+}
+
+func (t *fakeToken) generateKey(ref *keyRef, keyType string, bits int) (crypto.Signer, error) {
+	var (
+		k   crypto.Signer
+		err error
+	)
+	switch keyType {
+	case "", KeyTypeRSA:
+		k, err = rsa.GenerateKey(rand.Reader, bits)
+	default:
+		k, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.keys[ref.ID] = k
+	return k, nil
+}
+
+func (t *fakeToken) findKey(ref *keyRef) (crypto.Signer, error) {
+	k, ok := t.keys[ref.ID]
+	if !ok {
+		return nil, errTokenKeyDoesNotExist
+	}
+	return k, nil
+}
+
+func (t *fakeToken) deleteKey(ref *keyRef) error {
+	delete(t.keys, ref.ID)
+	return nil
+}
+
+func (t *fakeToken) close() error {
+	return nil
+}
+
+// testTokenKeys creates a CA with its key on the token and a child signed by
+// it, then checks that the keys can be used but not exported.
+func testTokenKeys(t *testing.T, s *Storage, dataDir string) {
+	t.Helper()
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1h",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+		PKCS11:     true,
+	})
+	if err != nil {
+		t.Fatalf("create root on token: %v", err)
+	}
+	if root.PrivateKey == nil || root.PrivateKey.Token == "" {
+		t.Fatal("expected root key to be reported as on the token")
+	}
+	rootDir := filepath.Join(dataDir, "certs", root.ID)
+	if _, err := os.Stat(filepath.Join(rootDir, filenamePrivateKey)); !os.IsNotExist(err) {
+		t.Fatalf("expected no key.pem for token key, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(rootDir, filenameKeyRef)); err != nil {
+		t.Fatalf("expected key reference: %v", err)
+	}
+
+	// Sign a certificate and a CRL with the key on the token
+	child, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "30m",
+		ServerAuth: true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create child: %v", err)
+	}
+	results, err := s.ValidateCertificate(child.Path, nil)
+	if err != nil {
+		t.Fatalf("validate child: %v", err)
+	}
+	for _, r := range results {
+		if r.Err != "" {
+			t.Fatalf("validation failed: %s", r.Err)
+		}
+	}
+	if _, err := s.ExportCRLPEM(root.Path); err != nil {
+		t.Fatalf("export CRL: %v", err)
+	}
+
+	// Private material must not be exportable
+	if _, err := s.ExportPrivateKeyPEM(root.Path); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected private key export to be refused, got %v", err)
+	}
+	if _, err := s.ExportCertificatePKCS12(root.Path, &ExportCertificatePKCS12Params{}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected PKCS#12 export to be refused, got %v", err)
+	}
+	if _, err := s.ExportPrivateKeyPEM(child.Path); err != nil {
+		t.Fatalf("export child key: %v", err)
+	}
+
+	// Ed25519 is not available on tokens
+	if _, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1h",
+		KeyType:    KeyTypeEd25519,
+		PKCS11:     true,
+	}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected Ed25519 on token to be rejected, got %v", err)
+	}
+}
+
+func TestTokenKeys(t *testing.T) {
+	var (
+		dataDir = t.TempDir()
+		s       = newTestStorage(t, dataDir)
+		tok     = &fakeToken{keys: map[string]crypto.Signer{}}
+	)
+	if _, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1h",
+		KeyType:    KeyTypeECDSAP256,
+		PKCS11:     true,
+	}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected error without a token, got %v", err)
+	}
+	s.token = tok
+	testTokenKeys(t, s, dataDir)
+
+	// The key survives reloading from disk
+	s = newTestStorage(t, dataDir)
+	s.token = tok
+	root := s.GetRootCertificates()[0]
+	if _, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "30m",
+		KeyType:    KeyTypeECDSAP256,
+	}); err != nil {
+		t.Fatalf("sign after reload: %v", err)
+	}
+
+	// Deleting the certificate removes its key from the token
+	if err := s.DeleteCertificate(root.Path); err != nil {
+		t.Fatalf("delete root: %v", err)
+	}
+	if len(tok.keys) != 0 {
+		t.Fatalf("expected token keys to be deleted, %d remain", len(tok.keys))
+	}
+}
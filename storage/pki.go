@@ -0,0 +1,504 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	filenamePKIIndex     = "index.txt"
+	filenamePKISerial    = "serial"
+	filenamePKICRLNumber = "crlnumber"
+)
+
+var errNotAPKI = inputError("directory does not contain any certificates")
+
+// pkiReasons maps the revocation reasons used in OpenSSL's index.txt to
+// reason codes.
+var pkiReasons = map[string]int{
+	"unspecified":          ReasonUnspecified,
+	"keyCompromise":        ReasonKeyCompromise,
+	"CACompromise":         ReasonCACompromise,
+	"affiliationChanged":   ReasonAffiliationChanged,
+	"superseded":           ReasonSuperseded,
+	"cessationOfOperation": ReasonCessationOfOperation,
+	"certificateHold":      ReasonCertificateHold,
+	"privilegeWithdrawn":   ReasonPrivilegeWithdrawn,
+	"AACompromise":         ReasonAACompromise,
+}
+
+// ImportPKIParams provides ImportPKI with the directory to import.
+type ImportPKIParams struct {
+
+	// Dir is an OpenSSL CA directory (with index.txt, serial, newcerts/ and
+	// private/) or an easy-rsa PKI (either pki/ or the directory containing
+	// it). It is searched recursively so that intermediate CAs kept in
+	// subdirectories are included.
+	Dir string
+
+	// Password decrypts the private keys.
+	Password string
+}
+
+// ImportPKIReport describes the outcome of ImportPKI.
+type ImportPKIReport struct {
+
+	// Imported lists the certificates added to the hierarchy and Existing
+	// lists those that were already managed.
+	Imported []*Ref
+	Existing []*Ref
+
+	// Serials and Revocations are the number of entries carried over from
+	// index.txt files into the issuers' indexes and revocation lists.
+	Serials     int
+	Revocations int
+
+	// Problems describes everything that could not be mapped.
+	Problems []string
+}
+
+func (r *ImportPKIReport) problem(format string, a ...any) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, a...))
+}
+
+// pkiEntry is a single line of index.txt.
+type pkiEntry struct {
+	status  string
+	expiry  time.Time
+	revoked time.Time
+	reason  string
+	serial  *big.Int
+	subject string
+}
+
+// pkiIndex is an index.txt file along with the serial and CRL number files
+// kept beside it.
+type pkiIndex struct {
+	dir        string
+	entries    []*pkiEntry
+	nextSerial *big.Int
+	crlNumber  *big.Int
+}
+
+// pkiKey is a private key along with the file it was read from.
+type pkiKey struct {
+	filename string
+	key      crypto.Signer
+	used     bool
+}
+
+// pki holds everything read from the directory being imported.
+type pki struct {
+	dir     string
+	certs   []*x509.Certificate
+	keys    []*pkiKey
+	indexes []*pkiIndex
+}
+
+// name returns the filename relative to the directory for reports.
+func (p *pki) name(filename string) string {
+	if v, err := filepath.Rel(p.dir, filename); err == nil {
+		return v
+	}
+	return filename
+}
+
+// parsePKITime parses the UTCTime or GeneralizedTime used in index.txt.
+func parsePKITime(v string) (time.Time, error) {
+	layout := "060102150405Z"
+	if len(v) == 15 {
+		layout = "20060102150405Z"
+	}
+	return time.Parse(layout, v)
+}
+
+// parsePKISubject converts the slash-separated subject used by OpenSSL (most
+// significant component first) into the format used by pkix.Name.
+func parsePKISubject(v string) string {
+	parts := strings.Split(strings.TrimPrefix(v, "/"), "/")
+	slices.Reverse(parts)
+	return strings.Join(parts, ",")
+}
+
+// readPKIHex reads a file containing a single hexadecimal number, returning
+// nil if the file does not exist.
+func readPKIHex(filename string) (*big.Int, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	v, ok := new(big.Int).SetString(string(bytes.TrimSpace(b)), 16)
+	if !ok {
+		return nil, fmt.Errorf("%s does not contain a hexadecimal number", filename)
+	}
+	return v, nil
+}
+
+func readPKIIndex(filename string) (*pkiIndex, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	idx := &pkiIndex{
+		dir: filepath.Dir(filename),
+	}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		if sc.Text() == "" {
+			continue
+		}
+		fields := strings.Split(sc.Text(), "\t")
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("%s:%d: expected 6 fields", filename, n)
+		}
+		e := &pkiEntry{
+			status:  fields[0],
+			subject: parsePKISubject(fields[5]),
+		}
+		if e.expiry, err = parsePKITime(fields[1]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filename, n, err)
+		}
+		if e.status == "R" {
+			t, reason, _ := strings.Cut(fields[2], ",")
+			if e.revoked, err = parsePKITime(t); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", filename, n, err)
+			}
+			e.reason = reason
+		}
+		v, ok := new(big.Int).SetString(fields[3], 16)
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: invalid serial number", filename, n)
+		}
+		e.serial = v
+		idx.entries = append(idx.entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if idx.nextSerial, err = readPKIHex(filepath.Join(idx.dir, filenamePKISerial)); err != nil {
+		return nil, err
+	}
+	if idx.crlNumber, err = readPKIHex(filepath.Join(idx.dir, filenamePKICRLNumber)); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// readPKI collects the certificates, private keys and indexes in the
+// directory. Files that cannot be read are added to the report.
+func readPKI(dir, password string, r *ImportPKIReport) (*pki, error) {
+	if v := filepath.Join(dir, "pki"); isDir(v) {
+		dir = v
+	}
+	v := &pki{
+		dir:     dir,
+		certs:   []*x509.Certificate{},
+		keys:    []*pkiKey{},
+		indexes: []*pkiIndex{},
+	}
+	if err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		name := v.name(p)
+		if d.Name() == filenamePKIIndex {
+			idx, err := readPKIIndex(p)
+			if err != nil {
+				r.problem("%s: %s", name, err)
+				return nil
+			}
+			v.indexes = append(v.indexes, idx)
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(p))
+		if !slices.Contains([]string{".pem", ".crt", ".cer", ".der", ".key"}, ext) {
+			return nil
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		// Requests and CRLs also use .pem, so only report unrecognized files
+		// that should have contained a key
+		c, k, err := parseImport(b, password)
+		if err != nil {
+			if !errors.Is(err, errNotImportable) || ext == ".key" {
+				r.problem("%s: %s", name, err)
+			}
+			return nil
+		}
+		for _, x := range c {
+			if !slices.ContainsFunc(v.certs, x.Equal) {
+				v.certs = append(v.certs, x)
+			}
+		}
+		for _, x := range k {
+			s, ok := x.(crypto.Signer)
+			if !ok {
+				r.problem("%s: %s", name, errImportKeyType)
+				continue
+			}
+			if !slices.ContainsFunc(v.keys, func(k *pkiKey) bool {
+				return publicKeyMatches(k.key, s.Public())
+			}) {
+				v.keys = append(v.keys, &pkiKey{filename: p, key: s})
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// ImportPKI imports the certificates, private keys, issued serials and
+// revocations of a PKI managed by OpenSSL's ca command or easy-rsa. Certy
+// assigns random serial numbers so the next serial is not carried over;
+// instead, every serial in index.txt is recorded in the issuer's index so
+// that it is never reused. Certy must not be running.
+func (s *Storage) ImportPKI(params *ImportPKIParams) (*ImportPKIReport, error) {
+	r := &ImportPKIReport{
+		Imported: []*Ref{},
+		Existing: []*Ref{},
+		Problems: []string{},
+	}
+	v, err := readPKI(params.Dir, params.Password, r)
+	if err != nil {
+		return nil, err
+	}
+	if len(v.certs) == 0 {
+		return nil, errNotAPKI
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Import the certificates whose issuers are managed (or that are roots)
+	// until no more can be imported
+	imported := map[*x509.Certificate]*storageCert{}
+	for pending := v.certs; len(pending) > 0; {
+		remaining := []*x509.Certificate{}
+		for _, x := range pending {
+			var p *storageCert
+			if !isSelfSigned(x) {
+				if p = findIssuer(s.rootCerts, x); p == nil {
+					remaining = append(remaining, x)
+					continue
+				}
+			}
+			var k *pkiKey
+			if i := slices.IndexFunc(v.keys, func(k *pkiKey) bool {
+				return publicKeyMatches(k.key, x.PublicKey)
+			}); i != -1 {
+				k = v.keys[i]
+				k.used = true
+			}
+			c, existed, err := s.importPKICert(p, x, k)
+			if err != nil {
+				return nil, err
+			}
+			imported[x] = c
+			if existed {
+				r.Existing = append(r.Existing, newRef(c))
+			} else {
+				r.Imported = append(r.Imported, newRef(c))
+			}
+		}
+		if len(remaining) == len(pending) {
+			for _, x := range remaining {
+				r.problem("%s: issuer %s was not found", x.Subject, x.Issuer)
+			}
+			break
+		}
+		pending = remaining
+	}
+	for _, k := range v.keys {
+		if !k.used {
+			r.problem("%s: private key does not match any certificate", v.name(k.filename))
+		}
+	}
+
+	// Carry over the serials and revocations from each index
+	for _, idx := range v.indexes {
+		name := v.name(filepath.Join(idx.dir, filenamePKIIndex))
+		ca := s.findPKIIssuer(idx, v.keys, imported)
+		if ca == nil {
+			r.problem("%s: the CA could not be determined, so its serials and revocations were not imported", name)
+			continue
+		}
+		if err := s.importPKIIndex(ca, idx, name, r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// importPKICert imports a single certificate and its key (if any), returning
+// true if the certificate was already managed.
+func (s *Storage) importPKICert(
+	p *storageCert,
+	x *x509.Certificate,
+	k *pkiKey,
+) (*storageCert, bool, error) {
+	siblings := s.rootCerts
+	if p != nil {
+		siblings = p.children
+	}
+	c, ok := siblings[certID(x)]
+	if ok && (k == nil || c.hasKey) {
+		return c, true, nil
+	}
+	var key crypto.Signer
+	if k != nil {
+		key = k.key
+	}
+	v, err := s.importCert(p, x, s.keyWriter(key))
+	return v, ok, err
+}
+
+// findPKIIssuer determines which CA the index belongs to: the issuer of most
+// of the certificates it lists or, failing that, the only CA whose private
+// key is stored beside it.
+func (s *Storage) findPKIIssuer(
+	idx *pkiIndex,
+	keys []*pkiKey,
+	imported map[*x509.Certificate]*storageCert,
+) *storageCert {
+	var (
+		counts = map[*storageCert]int{}
+		ca     *storageCert
+	)
+	walk(s.rootCerts, func(c *storageCert) {
+		if c.parent == nil {
+			return
+		}
+		for _, e := range idx.entries {
+			if e.serial.Cmp(c.cert.SerialNumber) == 0 && e.expiry.Equal(c.cert.NotAfter) {
+				counts[c.parent]++
+				if ca == nil || counts[c.parent] > counts[ca] {
+					ca = c.parent
+				}
+			}
+		}
+	})
+	if ca != nil {
+		return ca
+	}
+	privateDir := filepath.Join(idx.dir, "private")
+	for x, c := range imported {
+		if !c.maySign() {
+			continue
+		}
+		for _, k := range keys {
+			if k.used && publicKeyMatches(k.key, x.PublicKey) &&
+				filepath.Dir(k.filename) == privateDir {
+				if ca != nil && ca != c {
+					return nil
+				}
+				ca = c
+			}
+		}
+	}
+	return ca
+}
+
+// importPKIIndex records the serials and revocations listed in the index in
+// the CA's index and revocation list.
+func (s *Storage) importPKIIndex(
+	ca *storageCert,
+	idx *pkiIndex,
+	name string,
+	r *ImportPKIReport,
+) error {
+	issued, err := s.loadIndex(ca)
+	if err != nil {
+		return err
+	}
+	revocations, err := loadRevocations(ca.fPath)
+	if err != nil {
+		return err
+	}
+	var revoked bool
+	for _, e := range idx.entries {
+		if findIssued(issued, e.serial) == nil {
+			issued = append(issued, &IssuedSerial{
+				SerialNumber: e.serial,
+				Subject:      e.subject,
+				NotAfter:     e.expiry,
+			})
+			r.problem(
+				"%s: certificate %s (serial %X) was not found; its serial is reserved",
+				name, e.subject, e.serial,
+			)
+		}
+		r.Serials++
+		if e.status != "R" || findRevocation(revocations, e.serial) != nil {
+			continue
+		}
+		reason, ok := pkiReasons[e.reason]
+		if e.reason == "" {
+			reason, ok = ReasonUnspecified, true
+		}
+		if !ok {
+			r.problem("%s: revocation of serial %X has unsupported reason %q", name, e.serial, e.reason)
+			continue
+		}
+		revocations = append(revocations, &Revocation{
+			SerialNumber:   e.serial,
+			RevocationTime: e.revoked,
+			Reason:         reason,
+		})
+		revoked = true
+		r.Revocations++
+	}
+	if err := saveIssued(ca.fPath, issued); err != nil {
+		return err
+	}
+	if idx.nextSerial != nil {
+		r.problem(
+			"%s: the next serial (%X) is not used since serial numbers are random; the %d serials already issued are reserved instead",
+			name, idx.nextSerial, len(idx.entries),
+		)
+	}
+
+	// The CRL only needs to be regenerated for new revocations or to
+	// continue the numbering the first time
+	crlExists, err := fileExists(filepath.Join(ca.fPath, filenameCRL))
+	if err != nil {
+		return err
+	}
+	if !revoked && (idx.crlNumber == nil || crlExists) {
+		return nil
+	}
+	if err := saveRevocations(ca.fPath, revocations); err != nil {
+		return err
+	}
+	s.invalidateOCSP()
+
+	// Publish the revocations, continuing the CRL numbering
+	if ca.hasKey {
+		if _, err := s.generateCRLNumber(ca, idx.crlNumber); err != nil {
+			r.problem("%s: the CRL could not be generated: %s", name, err)
+		}
+	}
+	return nil
+}
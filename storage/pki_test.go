@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, filename string, b []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportPKI(t *testing.T) {
+	var (
+		dataDir = t.TempDir()
+		pkiDir  = filepath.Join(t.TempDir(), "pki")
+		s       = newTestStorage(t, dataDir)
+	)
+
+	// Create an easy-rsa layout with one valid and one revoked certificate
+	// and an index entry for a certificate that has since been removed
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := newExternalCert(t, rootCertCN, true, caKey, nil, nil)
+	writeTestFile(t, filepath.Join(pkiDir, "ca.crt"), encodeCertPEM(ca))
+	der, err := x509.MarshalECPrivateKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(pkiDir, "private", "ca.key"), pem.EncodeToMemory(&pem.Block{
+		Type:  typeECPrivateKey,
+		Bytes: der,
+	}))
+	var (
+		index  strings.Builder
+		leaves = map[string]*x509.Certificate{}
+	)
+	for _, name := range []string{"server", "revoked"} {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := newExternalCert(t, name, false, k, ca, caKey)
+		leaves[name] = c
+		dir := "issued"
+		if name == "revoked" {
+			dir = "revoked/certs_by_serial"
+		}
+		writeTestFile(t, filepath.Join(pkiDir, dir, name+".crt"), encodeCertPEM(c))
+		status, revoked := "V", ""
+		if name == "revoked" {
+			status, revoked = "R", "260101000000Z,superseded"
+		}
+		fmt.Fprintf(
+			&index, "%s\t%s\t%s\t%X\tunknown\t/CN=%s\n",
+			status, c.NotAfter.UTC().Format("060102150405Z"), revoked, c.SerialNumber, name,
+		)
+	}
+	index.WriteString("V\t300101000000Z\t\t0A\tunknown\t/O=Example/CN=missing\n")
+	writeTestFile(t, filepath.Join(pkiDir, filenamePKIIndex), []byte(index.String()))
+	writeTestFile(t, filepath.Join(pkiDir, filenamePKISerial), []byte("0B\n"))
+	writeTestFile(t, filepath.Join(pkiDir, filenamePKICRLNumber), []byte("10\n"))
+	writeTestFile(t, filepath.Join(pkiDir, "private", "orphan.key"), []byte("not a key"))
+
+	// Import from the directory containing pki/
+	r, err := s.ImportPKI(&ImportPKIParams{
+		Dir: filepath.Dir(pkiDir),
+	})
+	if err != nil {
+		t.Fatalf("import PKI: %v", err)
+	}
+	if len(r.Imported) != 3 || r.Serials != 3 || r.Revocations != 1 {
+		t.Fatalf(
+			"imported %d certificates, %d serials and %d revocations, want 3, 3 and 1",
+			len(r.Imported), r.Serials, r.Revocations,
+		)
+	}
+	for _, want := range []string{"orphan.key", "CN=missing", "next serial (B)"} {
+		found := false
+		for _, p := range r.Problems {
+			found = found || strings.Contains(p, want)
+		}
+		if !found {
+			t.Fatalf("expected a problem mentioning %q in %v", want, r.Problems)
+		}
+	}
+
+	// The CA has its key, all three serials and the revocation
+	root := s.GetRootCertificates()
+	if len(root) != 1 {
+		t.Fatalf("root count = %d, want 1", len(root))
+	}
+	v, err := s.GetCertificate(root[0].Path)
+	if err != nil || v.PrivateKey == nil || len(v.Children) != 2 {
+		t.Fatalf("expected CA with key and two children (%v)", err)
+	}
+	issued, err := s.GetIssuedSerials(v.Path)
+	if err != nil {
+		t.Fatalf("get issued serials: %v", err)
+	}
+	for _, serial := range []*big.Int{
+		leaves["server"].SerialNumber,
+		leaves["revoked"].SerialNumber,
+		big.NewInt(10),
+	} {
+		if findIssued(issued, serial) == nil {
+			t.Fatalf("expected serial %s to be reserved", serial)
+		}
+	}
+	f, err := s.FindCertificate(leaves["revoked"].Raw)
+	if err != nil {
+		t.Fatalf("find revoked certificate: %v", err)
+	}
+	if f.Revocation == nil || f.Revocation.Reason != ReasonSuperseded {
+		t.Fatal("expected revocation to be carried over")
+	}
+
+	// CRL numbering continues from crlnumber
+	l, err := loadCRL(filepath.Join(dataDir, "certs", v.ID, filenameCRL))
+	if err != nil {
+		t.Fatalf("load CRL: %v", err)
+	}
+	if l.Number.Cmp(big.NewInt(16)) != 0 || len(l.RevokedCertificateEntries) != 1 {
+		t.Fatalf("CRL number = %s with %d entries, want 16 with 1", l.Number, len(l.RevokedCertificateEntries))
+	}
+
+	// Importing again does not duplicate anything
+	r, err = s.ImportPKI(&ImportPKIParams{
+		Dir: pkiDir,
+	})
+	if err != nil {
+		t.Fatalf("import PKI again: %v", err)
+	}
+	if len(r.Imported) != 0 || len(r.Existing) != 3 || r.Revocations != 0 {
+		t.Fatal("expected nothing new to be imported")
+	}
+}
@@ -0,0 +1,343 @@
+package storage
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const filenamePolicy = "policy.json"
+
+var errPolicyNotCA = inputError("only certificates that may sign others have an issuance policy")
+
+// Policy restricts the certificates that a CA issues. Empty lists and zero
+// values do not restrict anything, except for AllowSubCAs.
+type Policy struct {
+
+	// DNSSuffixes and IPRanges (in CIDR notation) limit the SANs; a DNS
+	// name is allowed if it equals one of the suffixes or is below it. The
+	// suffixes also apply to the domain of email addresses and UPNs, the
+	// host of URIs and a common name that is a host name or IP address
+	DNSSuffixes []string `json:"dnsSuffixes"`
+	IPRanges    []string `json:"ipRanges"`
+
+	// MaxValidity is a duration such as "1y"
+	MaxValidity string `json:"maxValidity"`
+
+	// MinRSAKeySize is the minimum modulus size and MinECDSAKeySize the
+	// minimum curve size in bits
+	MinRSAKeySize   int `json:"minRSAKeySize"`
+	MinECDSAKeySize int `json:"minECDSAKeySize"`
+
+	// AllowedEKUs lists the EKU* values and OIDs of the extended key usages
+	// that may be included
+	AllowedEKUs []string `json:"allowedEKUs"`
+
+	// AllowSubCAs permits issuing certificates that can sign others
+	AllowSubCAs bool `json:"allowSubCAs"`
+}
+
+// PolicyViolation describes a parameter that the issuer's policy does not
+// allow. Field is the JSON name of the parameter.
+type PolicyViolation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// PolicyError is returned when a new certificate violates its issuer's
+// policy. It is matched by ErrInvalidInput.
+type PolicyError struct {
+	Issuer     string
+	Violations []*PolicyViolation
+}
+
+func (e *PolicyError) Error() string {
+	msgs := []string{}
+	for _, v := range e.Violations {
+		msgs = append(msgs, v.Message)
+	}
+	return fmt.Sprintf(
+		"certificate not allowed by %s: %s",
+		e.Issuer,
+		strings.Join(msgs, "; "),
+	)
+}
+
+func (e *PolicyError) Is(target error) bool {
+	return target == ErrInvalidInput
+}
+
+// normalize validates the policy and converts the suffixes, ranges and
+// extended key usages to a canonical form.
+func (p *Policy) normalize() error {
+	suffixes := []string{}
+	for _, v := range p.DNSSuffixes {
+		v = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(v), "*"), ".")
+		if v == "" {
+			return inputError("DNS suffixes must not be empty")
+		}
+		suffixes = append(suffixes, v)
+	}
+	p.DNSSuffixes = suffixes
+	ranges := []string{}
+	for _, v := range p.IPRanges {
+		n, err := parseIPRange(v)
+		if err != nil {
+			return err
+		}
+		ranges = append(ranges, n.String())
+	}
+	p.IPRanges = ranges
+	if p.MaxValidity != "" {
+		if _, err := parseDuration(p.MaxValidity); err != nil {
+			return err
+		}
+	}
+	if p.MinRSAKeySize < 0 || p.MinECDSAKeySize < 0 {
+		return inputError("minimum key sizes must not be negative")
+	}
+	ekus := []string{}
+	for _, v := range splitSANs(strings.Join(p.AllowedEKUs, " ")) {
+		if u := findUsage(ExtKeyUsages, v); u != nil {
+			ekus = append(ekus, u.Name)
+			continue
+		}
+		oid, err := parseOID(v)
+		if err != nil {
+			return inputError(fmt.Sprintf("unknown extended key usage %s", v))
+		}
+		ekus = append(ekus, oid.String())
+	}
+	p.AllowedEKUs = ekus
+	return nil
+}
+
+func (p *Policy) allowsDNSName(name string) bool {
+	if len(p.DNSSuffixes) == 0 {
+		return true
+	}
+	name = strings.TrimPrefix(strings.ToLower(name), "*.")
+	return slices.ContainsFunc(p.DNSSuffixes, func(s string) bool {
+		return name == s || strings.HasSuffix(name, "."+s)
+	})
+}
+
+func (p *Policy) allowsEKU(name string) bool {
+	return name != "" && slices.Contains(p.AllowedEKUs, name)
+}
+
+func (p *Policy) allowsIP(ip net.IP) bool {
+	if len(p.IPRanges) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(p.IPRanges, func(r string) bool {
+		_, n, err := net.ParseCIDR(r)
+		return err == nil && n.Contains(ip)
+	})
+}
+
+// check returns the ways in which the certificate template violates the
+// policy.
+func (p *Policy) check(cert *x509.Certificate, publicKey crypto.PublicKey) []*PolicyViolation {
+	violations := []*PolicyViolation{}
+	add := func(field, format string, a ...any) {
+		violations = append(violations, &PolicyViolation{
+			Field:   field,
+			Message: fmt.Sprintf(format, a...),
+		})
+	}
+	for _, n := range cert.DNSNames {
+		if !p.allowsDNSName(n) {
+			add("sans", "%s is not below %s", n, strings.Join(p.DNSSuffixes, ", "))
+		}
+	}
+	for _, i := range cert.IPAddresses {
+		if !p.allowsIP(i) {
+			add("sans", "%s is not in %s", i, strings.Join(p.IPRanges, ", "))
+		}
+	}
+
+	// Email addresses and UPNs are checked by their domain and URIs by
+	// their host
+	for _, e := range append(slices.Clone(cert.EmailAddresses), parseUPNs(cert.ExtraExtensions)...) {
+		if !p.allowsDNSName(e[strings.LastIndex(e, "@")+1:]) {
+			add("sans", "%s is not below %s", e, strings.Join(p.DNSSuffixes, ", "))
+		}
+	}
+	for _, u := range cert.URIs {
+		h := u.Hostname()
+		switch i := net.ParseIP(h); {
+		case i != nil:
+			if !p.allowsIP(i) {
+				add("sans", "%s is not in %s", u, strings.Join(p.IPRanges, ", "))
+			}
+		case h == "" && len(p.DNSSuffixes) != 0:
+			add("sans", "%s has no host below %s", u, strings.Join(p.DNSSuffixes, ", "))
+		case !p.allowsDNSName(h):
+			add("sans", "%s is not below %s", u, strings.Join(p.DNSSuffixes, ", "))
+		}
+	}
+
+	// Clients that ignore the SANs may still match the common name, so it
+	// is checked if it is a host name or IP address
+	cn := cert.Subject.CommonName
+	if i := net.ParseIP(cn); i != nil {
+		if !p.allowsIP(i) {
+			add("commonName", "%s is not in %s", cn, strings.Join(p.IPRanges, ", "))
+		}
+	} else if strings.Contains(cn, ".") && !strings.ContainsAny(cn, " @/:") &&
+		!p.allowsDNSName(strings.TrimSuffix(cn, ".")) {
+		add("commonName", "%s is not below %s", cn, strings.Join(p.DNSSuffixes, ", "))
+	}
+	if p.MaxValidity != "" {
+
+		// Backdating and past start dates do not count towards the validity
+		start := cert.NotBefore
+		if n := time.Now(); start.Before(n) {
+			start = n
+		}
+		v, err := parseDuration(p.MaxValidity)
+		if err == nil && cert.NotAfter.Sub(start) > v {
+			add("validity", "validity must not exceed %s", p.MaxValidity)
+		}
+	}
+	k := describePublicKey(publicKey)
+	switch {
+	case k == nil:
+	case k.Algorithm == AlgorithmRSA && k.Size < p.MinRSAKeySize:
+		add("keySize", "RSA keys must be at least %d bits, not %d", p.MinRSAKeySize, k.Size)
+	case k.Algorithm == AlgorithmECDSA && k.Size < p.MinECDSAKeySize:
+		add("keySize", "ECDSA keys must use a curve of at least %d bits, not %d", p.MinECDSAKeySize, k.Size)
+	}
+	if len(p.AllowedEKUs) != 0 {
+		for _, u := range cert.ExtKeyUsage {
+			if !p.allowsEKU(extKeyUsageName(u)) {
+				add(ekuField(u), "extended key usage %s is not allowed", extKeyUsageLabel(u))
+			}
+		}
+		for _, oid := range cert.UnknownExtKeyUsage {
+			if !p.allowsEKU(oid.String()) {
+				add("extKeyUsages", "extended key usage %s is not allowed", oid)
+			}
+		}
+	}
+	if cert.IsCA && !p.AllowSubCAs {
+		add("canSign", "subordinate CAs are not allowed")
+	}
+	return violations
+}
+
+// ekuField returns the parameter that requests the extended key usage.
+func ekuField(u x509.ExtKeyUsage) string {
+	switch n := extKeyUsageName(u); n {
+	case EKUServerAuth, EKUClientAuth, EKUCodeSigning:
+		return n
+	}
+	return "extKeyUsages"
+}
+
+func loadPolicy(dir string) (*Policy, error) {
+	b, err := os.ReadFile(filepath.Join(dir, filenamePolicy))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	p := &Policy{}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func savePolicy(dir string, p *Policy) error {
+	if p == nil {
+		err := os.Remove(filepath.Join(dir, filenamePolicy))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, filenamePolicy), b, 0600)
+}
+
+// checkPolicy ensures that p may issue the certificate template. A CA whose
+// path length is zero can never issue another CA and the names must satisfy
+// the name constraints of p and the CAs above it, regardless of its policy.
+func (s *Storage) checkPolicy(
+	p *storageCert,
+	cert *x509.Certificate,
+	publicKey crypto.PublicKey,
+) error {
+	policy, err := loadPolicy(p.fPath)
+	if err != nil {
+		return err
+	}
+	violations := []*PolicyViolation{}
+	if policy != nil {
+		violations = policy.check(cert, publicKey)
+	}
+	if cert.IsCA && p.cert.MaxPathLen == 0 && p.cert.MaxPathLenZero &&
+		!slices.ContainsFunc(violations, func(v *PolicyViolation) bool {
+			return v.Field == "canSign"
+		}) {
+		violations = append(violations, &PolicyViolation{
+			Field:   "canSign",
+			Message: "the issuer does not allow chaining, so it cannot issue CAs",
+		})
+	}
+	violations = append(violations, constraintViolations(p, cert)...)
+	if len(violations) != 0 {
+		return &PolicyError{
+			Issuer:     p.cert.Subject.CommonName,
+			Violations: violations,
+		}
+	}
+	return nil
+}
+
+// GetPolicy returns the issuance policy of the CA or nil if it does not have
+// one.
+func (s *Storage) GetPolicy(certPath string) (*Policy, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	c, err := s.getCert(certPath)
+	if err != nil {
+		return nil, err
+	}
+	return loadPolicy(c.fPath)
+}
+
+// SetPolicy replaces the issuance policy of the CA; a nil policy removes it.
+// Certificates that were already issued are not affected.
+func (s *Storage) SetPolicy(certPath string, policy *Policy) error {
+	if policy != nil {
+		v := *policy
+		if err := v.normalize(); err != nil {
+			return err
+		}
+		policy = &v
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c, err := s.getCert(certPath)
+	if err != nil {
+		return err
+	}
+	if !c.maySign() {
+		return errPolicyNotCA
+	}
+	return savePolicy(c.fPath, policy)
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	s := newTestStorage(t, t.TempDir())
+
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "3h",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root certificate: %v", err)
+	}
+
+	// A CA that does not allow chaining cannot issue another CA even without
+	// a policy
+	_, err = s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: "Intermediate CA",
+		Validity:   "30m",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	var e *PolicyError
+	if !errors.As(err, &e) || len(e.Violations) != 1 || e.Violations[0].Field != "canSign" {
+		t.Fatalf("expected chaining violation, got %v", err)
+	}
+
+	if err := s.SetPolicy(root.Path, &Policy{
+		DNSSuffixes:     []string{"*.Example.test"},
+		IPRanges:        []string{"10.0.0.0/8", "192.0.2.1"},
+		MaxValidity:     "1h",
+		MinRSAKeySize:   3072,
+		MinECDSAKeySize: 384,
+		AllowedEKUs:     []string{EKUServerAuth},
+	}); err != nil {
+		t.Fatalf("set policy: %v", err)
+	}
+	p, err := s.GetPolicy(root.Path)
+	if err != nil || p == nil || p.DNSSuffixes[0] != "example.test" || p.IPRanges[1] != "192.0.2.1/32" {
+		t.Fatalf("expected normalized policy (%v)", err)
+	}
+
+	// Every violation is reported with the parameter that caused it
+	_, err = s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "2h",
+		ClientAuth: true,
+		SANs:       "www.example.test, www.example.com, 10.1.2.3, 127.0.0.1",
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if !errors.As(err, &e) || !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected policy error, got %v", err)
+	}
+	fields := map[string]int{}
+	for _, v := range e.Violations {
+		fields[v.Field]++
+	}
+	if fields["sans"] != 2 || fields["validity"] != 1 ||
+		fields["keySize"] != 1 || fields[EKUClientAuth] != 1 || len(e.Violations) != 5 {
+		t.Fatalf("unexpected violations: %v", err)
+	}
+
+	// The suffixes also apply to the domain of email addresses and UPNs, the
+	// host of URIs and a common name that is a host name
+	_, err = s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: "www.example.com",
+		Validity:   "30m",
+		ServerAuth: true,
+		SANs: "email:alice@example.com upn:alice@example.com " +
+			"https://www.example.com/path uri:urn:example:test",
+		KeyType: KeyTypeECDSAP384,
+	})
+	if !errors.As(err, &e) {
+		t.Fatalf("expected policy error, got %v", err)
+	}
+	fields = map[string]int{}
+	for _, v := range e.Violations {
+		fields[v.Field]++
+	}
+	if fields["sans"] != 4 || fields["commonName"] != 1 || len(e.Violations) != 5 {
+		t.Fatalf("unexpected violations: %v", err)
+	}
+	if _, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: "Alice",
+		Validity:   "30m",
+		ServerAuth: true,
+		SANs: "email:alice@example.test upn:alice@corp.example.test " +
+			"https://www.example.test/path",
+		KeyType: KeyTypeECDSAP384,
+	}); err != nil {
+		t.Fatalf("create certificate with allowed names: %v", err)
+	}
+
+	// The policy also applies to CSRs and allowed certificates are issued
+	if _, err := s.SignCSR(root.Path, &SignCSRParams{
+		CreateCertificateParams: CreateCertificateParams{
+			CommonName: childCertCN,
+			Validity:   "30m",
+			ServerAuth: true,
+		},
+		CSR: string(newTestCSR(t, childCertCN)),
+	}); !errors.As(err, &e) || e.Violations[0].Field != "keySize" {
+		t.Fatalf("expected key size violation for CSR, got %v", err)
+	}
+	if _, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "30m",
+		ServerAuth: true,
+		SANs:       "example.test 192.0.2.1",
+		KeyType:    KeyTypeECDSAP384,
+	}); err != nil {
+		t.Fatalf("create allowed certificate: %v", err)
+	}
+
+	// Invalid policies are rejected and policies can be removed
+	if err := s.SetPolicy(root.Path, &Policy{
+		IPRanges: []string{"10.0.0.0/33"},
+	}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid range to be rejected, got %v", err)
+	}
+	if err := s.SetPolicy(root.Path, nil); err != nil {
+		t.Fatalf("remove policy: %v", err)
+	}
+	if p, err := s.GetPolicy(root.Path); err != nil || p != nil {
+		t.Fatalf("expected policy to be removed (%v)", err)
+	}
+}
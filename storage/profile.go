@@ -0,0 +1,376 @@
+package storage
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const filenameProfiles = "profiles.json"
+
+var (
+	profileIDRegExp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+	errInvalidProfileID   = inputError("profile ID must contain only lowercase letters, digits and hyphens")
+	errProfileNameMissing = inputError("profile name must not be empty")
+	errProfileNotFound    = notFoundError("profile does not exist")
+)
+
+// profileFields maps the JSON name of each parameter that a profile can fix
+// to a function that copies it from the profile's defaults.
+var profileFields = map[string]func(dst, src *CreateCertificateParams){
+	"keyType":             func(d, s *CreateCertificateParams) { d.KeyType = s.KeyType },
+	"keySize":             func(d, s *CreateCertificateParams) { d.KeySize = s.KeySize },
+	"pkcs11":              func(d, s *CreateCertificateParams) { d.PKCS11 = s.PKCS11 },
+	"validity":            func(d, s *CreateCertificateParams) { d.Validity = s.Validity },
+	"backdate":            func(d, s *CreateCertificateParams) { d.Backdate = s.Backdate },
+	"parentExpiry":        func(d, s *CreateCertificateParams) { d.ParentExpiry = s.ParentExpiry },
+	"canSign":             func(d, s *CreateCertificateParams) { d.CanSign = s.CanSign },
+	"allowChaining":       func(d, s *CreateCertificateParams) { d.AllowChaining = s.AllowChaining },
+	"codeSigning":         func(d, s *CreateCertificateParams) { d.CodeSigning = s.CodeSigning },
+	"clientAuth":          func(d, s *CreateCertificateParams) { d.ClientAuth = s.ClientAuth },
+	"serverAuth":          func(d, s *CreateCertificateParams) { d.ServerAuth = s.ServerAuth },
+	"keyUsages":           func(d, s *CreateCertificateParams) { d.KeyUsages = s.KeyUsages },
+	"extKeyUsages":        func(d, s *CreateCertificateParams) { d.ExtKeyUsages = s.ExtKeyUsages },
+	"certificatePolicies": func(d, s *CreateCertificateParams) { d.CertificatePolicies = s.CertificatePolicies },
+	"mustStaple":          func(d, s *CreateCertificateParams) { d.MustStaple = s.MustStaple },
+	"extensions":          func(d, s *CreateCertificateParams) { d.Extensions = s.Extensions },
+	"organization":        func(d, s *CreateCertificateParams) { d.Organization = s.Organization },
+	"organizationalUnit":  func(d, s *CreateCertificateParams) { d.OrganizationalUnit = s.OrganizationalUnit },
+	"country":             func(d, s *CreateCertificateParams) { d.Country = s.Country },
+	"province":            func(d, s *CreateCertificateParams) { d.Province = s.Province },
+	"locality":            func(d, s *CreateCertificateParams) { d.Locality = s.Locality },
+	"streetAddress":       func(d, s *CreateCertificateParams) { d.StreetAddress = s.StreetAddress },
+	"postalCode":          func(d, s *CreateCertificateParams) { d.PostalCode = s.PostalCode },
+}
+
+// ProfileFields lists the parameters that a profile can fix in the order they
+// appear on the new certificate page.
+var ProfileFields = []string{
+	"validity",
+	"backdate",
+	"parentExpiry",
+	"keyType",
+	"keySize",
+	"pkcs11",
+	"canSign",
+	"allowChaining",
+	"codeSigning",
+	"clientAuth",
+	"serverAuth",
+	"keyUsages",
+	"extKeyUsages",
+	"certificatePolicies",
+	"mustStaple",
+	"extensions",
+	"organization",
+	"organizationalUnit",
+	"country",
+	"province",
+	"locality",
+	"streetAddress",
+	"postalCode",
+}
+
+// Profile is a named set of defaults for new certificates. Parameters listed
+// in Fixed always take the value from Defaults, regardless of what was
+// requested. The key parameters only apply when Certy generates the key and
+// are ignored when signing a CSR.
+type Profile struct {
+	ID          string                  `json:"id"`
+	Name        string                  `json:"name"`
+	Description string                  `json:"description"`
+	Defaults    CreateCertificateParams `json:"defaults"`
+	Fixed       []string                `json:"fixed"`
+
+	// RequireSANs rejects certificates without any SANs, SANTypes limits the
+	// SANs to the listed SANType* values (any type if empty) and
+	// CommonNameInSANs adds the common name to the SANs if it is missing.
+	RequireSANs      bool     `json:"requireSANs"`
+	SANTypes         []string `json:"sanTypes,omitempty"`
+	CommonNameInSANs bool     `json:"commonNameInSANs"`
+}
+
+// Params returns a copy of the profile's defaults that selects the profile.
+func (p *Profile) Params() *CreateCertificateParams {
+	v := p.Defaults
+	v.Profile = p.ID
+	return &v
+}
+
+// IsFixed indicates whether the profile fixes the parameter with the
+// provided JSON name.
+func (p *Profile) IsFixed(name string) bool {
+	return slices.Contains(p.Fixed, name)
+}
+
+func (p *Profile) validate() error {
+	if !profileIDRegExp.MatchString(p.ID) {
+		return errInvalidProfileID
+	}
+	if strings.TrimSpace(p.Name) == "" {
+		return errProfileNameMissing
+	}
+	for _, f := range p.Fixed {
+		if _, ok := profileFields[f]; !ok {
+			return inputError(fmt.Sprintf("%s cannot be fixed by a profile", f))
+		}
+	}
+	for _, t := range p.SANTypes {
+		if !slices.Contains(sanTypes, t) {
+			return inputError(fmt.Sprintf("unknown SAN type %s", t))
+		}
+	}
+	if v := p.Defaults.KeyType; v != "" && !slices.Contains(keyTypes, v) {
+		return errInvalidKeyType
+	}
+	for _, v := range []string{p.Defaults.Validity, p.Defaults.Backdate} {
+		if v == "" {
+			continue
+		}
+		if _, err := parseDuration(v); err != nil {
+			return err
+		}
+	}
+	if err := validParentExpiry(p.Defaults.ParentExpiry); err != nil {
+		return err
+	}
+	return setExtensions(&x509.Certificate{}, &p.Defaults)
+}
+
+// apply returns a copy of params with the fixed parameters replaced and the
+// common name added to the SANs if required, after checking the SAN rules.
+func (p *Profile) apply(params *CreateCertificateParams) (*CreateCertificateParams, error) {
+	v := *params
+	if p.IsFixed("validity") && v.NotAfter != "" {
+		return nil, inputError(fmt.Sprintf("profile %s fixes the validity, so an end date cannot be chosen", p.Name))
+	}
+	for _, f := range p.Fixed {
+		profileFields[f](&v, &p.Defaults)
+	}
+	sans, err := ParseSANs(v.SANs)
+	if err != nil {
+		return nil, err
+	}
+	if p.CommonNameInSANs && v.CommonName != "" {
+
+		// Common names that are not valid names (such as "John Smith") are
+		// not added
+		cn, err := normalizeSAN(detectSANType(v.CommonName), v.CommonName)
+		if err == nil && !slices.ContainsFunc(sans, func(s *SAN) bool {
+			return *s == *cn
+		}) {
+			sans = append(sans, cn)
+			v.SANs = strings.TrimSpace(v.SANs + " " + cn.String())
+		}
+	}
+	if p.RequireSANs && len(sans) == 0 {
+		return nil, inputError(fmt.Sprintf("profile %s requires at least one SAN", p.Name))
+	}
+	if len(p.SANTypes) != 0 {
+		for _, s := range sans {
+			if !slices.Contains(p.SANTypes, s.Type) {
+				return nil, inputError(fmt.Sprintf(
+					"profile %s does not allow %s SANs such as %s",
+					p.Name,
+					strings.ToUpper(s.Type),
+					s.Value,
+				))
+			}
+		}
+	}
+	return &v, nil
+}
+
+// defaultProfiles returns the profiles used until profiles.json is created.
+func defaultProfiles() []*Profile {
+	leaf := []string{
+		"canSign",
+		"allowChaining",
+		"codeSigning",
+		"clientAuth",
+		"serverAuth",
+		"keyUsages",
+		"extKeyUsages",
+		"certificatePolicies",
+		"mustStaple",
+		"extensions",
+	}
+	return []*Profile{
+		{
+			ID:          "tls-server",
+			Name:        "TLS server",
+			Description: "Web and other TLS servers identified by their DNS names or IP addresses",
+			Defaults: CreateCertificateParams{
+				Validity:   "1y",
+				ServerAuth: true,
+				KeyType:    KeyTypeECDSAP256,
+				KeySize:    2048,
+			},
+			Fixed:            leaf,
+			RequireSANs:      true,
+			SANTypes:         []string{SANTypeDNS, SANTypeIP},
+			CommonNameInSANs: true,
+		},
+		{
+			ID:          "mtls-client",
+			Name:        "mTLS client",
+			Description: "Clients authenticating to servers with mutual TLS",
+			Defaults: CreateCertificateParams{
+				Validity:   "1y",
+				ClientAuth: true,
+				KeyType:    KeyTypeECDSAP256,
+				KeySize:    2048,
+			},
+			Fixed: leaf,
+		},
+		{
+			ID:          "intermediate-ca",
+			Name:        "Intermediate CA",
+			Description: "CAs that issue end-entity certificates on behalf of a root",
+			Defaults: CreateCertificateParams{
+				Validity: "5y",
+				CanSign:  true,
+				KeyType:  KeyTypeRSA,
+				KeySize:  4096,
+			},
+			Fixed: []string{
+				"canSign",
+				"codeSigning",
+				"clientAuth",
+				"serverAuth",
+				"keyUsages",
+				"extKeyUsages",
+				"certificatePolicies",
+				"mustStaple",
+				"extensions",
+			},
+		},
+		{
+			ID:          "code-signing",
+			Name:        "Code signing",
+			Description: "Signing software and scripts",
+			Defaults: CreateCertificateParams{
+				Validity:    "1y",
+				CodeSigning: true,
+				KeyType:     KeyTypeRSA,
+				KeySize:     3072,
+			},
+			Fixed: leaf,
+		},
+	}
+}
+
+func loadProfiles(filename string) ([]*Profile, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return defaultProfiles(), nil
+		}
+		return nil, err
+	}
+	profiles := []*Profile{}
+	if err := json.Unmarshal(b, &profiles); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+func (s *Storage) saveProfiles(profiles []*Profile) error {
+	b, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.profilesFilename, b, 0600); err != nil {
+		return err
+	}
+	s.profiles = profiles
+	return nil
+}
+
+func (s *Storage) getProfile(id string) (*Profile, error) {
+	i := slices.IndexFunc(s.profiles, func(p *Profile) bool {
+		return p.ID == id
+	})
+	if i == -1 {
+		return nil, errProfileNotFound
+	}
+	return s.profiles[i], nil
+}
+
+// applyProfile applies the profile selected by params (if any), returning
+// the parameters to issue the certificate with.
+func (s *Storage) applyProfile(params *CreateCertificateParams) (*CreateCertificateParams, error) {
+	if params.Profile == "" {
+		return params, nil
+	}
+	p, err := s.getProfile(params.Profile)
+	if err != nil {
+		return nil, err
+	}
+	return p.apply(params)
+}
+
+// GetProfiles returns all of the certificate profiles sorted by name.
+func (s *Storage) GetProfiles() []*Profile {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	profiles := []*Profile{}
+	for _, p := range s.profiles {
+		v := *p
+		profiles = append(profiles, &v)
+	}
+	slices.SortFunc(profiles, func(a, b *Profile) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return profiles
+}
+
+// GetProfile returns the profile with the specified ID.
+func (s *Storage) GetProfile(id string) (*Profile, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	p, err := s.getProfile(id)
+	if err != nil {
+		return nil, err
+	}
+	v := *p
+	return &v, nil
+}
+
+// SaveProfile creates the profile or replaces the existing profile with the
+// same ID.
+func (s *Storage) SaveProfile(profile *Profile) error {
+	if err := profile.validate(); err != nil {
+		return err
+	}
+	v := *profile
+	v.Defaults.Profile = ""
+	v.Fixed = slices.Clone(v.Fixed)
+	v.SANTypes = slices.Clone(v.SANTypes)
+	v.Defaults.KeyUsages = splitSANs(strings.Join(v.Defaults.KeyUsages, " "))
+	v.Defaults.ExtKeyUsages = splitSANs(strings.Join(v.Defaults.ExtKeyUsages, " "))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	profiles := slices.DeleteFunc(slices.Clone(s.profiles), func(p *Profile) bool {
+		return p.ID == v.ID
+	})
+	return s.saveProfiles(append(profiles, &v))
+}
+
+// DeleteProfile removes the profile with the specified ID. Certificates
+// issued with the profile are not affected.
+func (s *Storage) DeleteProfile(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, err := s.getProfile(id); err != nil {
+		return err
+	}
+	return s.saveProfiles(slices.DeleteFunc(slices.Clone(s.profiles), func(p *Profile) bool {
+		return p.ID == id
+	}))
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"crypto/x509"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	var (
+		dataDir = t.TempDir()
+		s       = newTestStorage(t, dataDir)
+	)
+
+	// The built-in profiles are available before any are saved
+	if len(s.GetProfiles()) != len(defaultProfiles()) {
+		t.Fatal("expected the built-in profiles")
+	}
+
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1h",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root certificate: %v", err)
+	}
+
+	// Fixed fields override the request and the common name is added to
+	// the SANs
+	p, err := s.GetProfile("tls-server")
+	if err != nil {
+		t.Fatalf("get profile: %v", err)
+	}
+	params := p.Params()
+	params.CommonName = childCertCN
+	params.Validity = "30m"
+	params.CanSign = true
+	params.ServerAuth = false
+	c, err := s.CreateCertificate(root.Path, params)
+	if err != nil {
+		t.Fatalf("create with profile: %v", err)
+	}
+	if c.X509.IsCA || !slices.Contains(c.X509.ExtKeyUsage, x509.ExtKeyUsageServerAuth) ||
+		!slices.Contains(c.X509.DNSNames, childCertCN) {
+		t.Fatal("expected fixed fields and common name SAN to be applied")
+	}
+	if c.PrivateKey.Algorithm != AlgorithmECDSA {
+		t.Fatalf("key algorithm = %s, want ECDSA", c.PrivateKey.Algorithm)
+	}
+
+	// SAN rules are enforced
+	if err := s.SaveProfile(&Profile{
+		ID:          "ip-only",
+		Name:        "IP only",
+		Defaults:    CreateCertificateParams{Validity: "1h", KeyType: KeyTypeECDSAP256},
+		Fixed:       []string{"validity"},
+		RequireSANs: true,
+		SANTypes:    []string{SANTypeIP},
+	}); err != nil {
+		t.Fatalf("save profile: %v", err)
+	}
+	for _, sans := range []string{"", "www.example.test"} {
+		if _, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+			CommonName: childCertCN,
+			SANs:       sans,
+			KeyType:    KeyTypeECDSAP256,
+			Profile:    "ip-only",
+		}); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("expected SANs %q to be rejected, got %v", sans, err)
+		}
+	}
+	csr := &SignCSRParams{
+		CreateCertificateParams: CreateCertificateParams{
+			CommonName: childCertCN,
+			SANs:       childCertIP,
+			Profile:    "ip-only",
+		},
+		CSR: string(newTestCSR(t, childCertCN)),
+	}
+	if _, err := s.SignCSR(root.Path, csr); err != nil {
+		t.Fatalf("sign CSR with profile: %v", err)
+	}
+
+	// Invalid profiles are rejected and unknown profiles are not found
+	for _, v := range []*Profile{
+		{ID: "Bad ID", Name: "Bad"},
+		{ID: "no-name"},
+		{ID: "bad-field", Name: "Bad", Fixed: []string{"commonName"}},
+		{ID: "bad-type", Name: "Bad", SANTypes: []string{"fax"}},
+	} {
+		if err := s.SaveProfile(v); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("expected %s to be rejected, got %v", v.ID, err)
+		}
+	}
+	if _, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Profile:    "missing",
+	}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected profile not found, got %v", err)
+	}
+
+	// Saved profiles persist and can be deleted
+	if err := s.DeleteProfile("code-signing"); err != nil {
+		t.Fatalf("delete profile: %v", err)
+	}
+	s = newTestStorage(t, dataDir)
+	if _, err := s.GetProfile("ip-only"); err != nil {
+		t.Fatalf("get profile after reload: %v", err)
+	}
+	if _, err := s.GetProfile("code-signing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected deleted profile to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,337 @@
+package storage
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	filenameLineage = "lineage.json"
+)
+
+var errRootNeedsKey = inputError("root certificates cannot be renewed without their private key")
+
+// Actions that create a new version of a certificate.
+const (
+	LineageRenew = "renew"
+	LineageRekey = "rekey"
+)
+
+// handledExtensions are generated by x509.CreateCertificate from the template
+// fields or (for the SCT list and CT poison) must not be copied to a new
+// certificate; all other extensions are copied as-is when renewing.
+var handledExtensions = []asn1.ObjectIdentifier{
+	{2, 5, 29, 14},                     // subject key identifier
+	{2, 5, 29, 15},                     // key usage
+	{2, 5, 29, 17},                     // subject alternative name
+	{2, 5, 29, 19},                     // basic constraints
+	{2, 5, 29, 30},                     // name constraints
+	{2, 5, 29, 31},                     // CRL distribution points
+	{2, 5, 29, 35},                     // authority key identifier
+	{2, 5, 29, 37},                     // extended key usage
+	{1, 3, 6, 1, 5, 5, 7, 1, 1},        // authority information access
+	{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}, // SCT list
+	{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}, // CT poison
+}
+
+// lineage records the certificate that a certificate replaced. Both are
+// always signed by the same issuer so only the ID is stored.
+type lineage struct {
+	Previous string    `json:"previous"`
+	Action   string    `json:"action"`
+	Time     time.Time `json:"time"`
+}
+
+func loadLineage(dir string) (*lineage, error) {
+	b, err := os.ReadFile(filepath.Join(dir, filenameLineage))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	l := &lineage{}
+	if err := json.Unmarshal(b, l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func saveLineage(dir string, l *lineage) error {
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, filenameLineage), b, 0600)
+}
+
+// siblings returns the certificates signed by the same issuer as c,
+// including c itself.
+func (s *Storage) siblings(c *storageCert) map[string]*storageCert {
+	if c.parent == nil {
+		return s.rootCerts
+	}
+	return c.parent.children
+}
+
+// setLineage fills in the previous and next versions of the certificate.
+func (s *Storage) setLineage(c *storageCert, v *Certificate) {
+	siblings := s.siblings(c)
+	if c.lineage != nil {
+		if p, ok := siblings[c.lineage.Previous]; ok {
+			v.Previous = newRef(p)
+		}
+	}
+	next := []*storageCert{}
+	for _, n := range siblings {
+		if n.lineage != nil && n.lineage.Previous == c.id {
+			next = append(next, n)
+		}
+	}
+	slices.SortFunc(next, func(a, b *storageCert) int {
+		return a.lineage.Time.Compare(b.lineage.Time)
+	})
+	v.Next = []*Ref{}
+	for _, n := range next {
+		v.Next = append(v.Next, newRef(n))
+	}
+}
+
+// renewalTemplate copies the subject and extensions of the certificate into
+// a template with a new validity period. The subject key identifier is only
+// kept when the key is unchanged.
+func renewalTemplate(old *x509.Certificate, validity time.Duration, sameKey bool) *x509.Certificate {
+	n := time.Now()
+	t := &x509.Certificate{
+		RawSubject:                  old.RawSubject,
+		Subject:                     old.Subject,
+		NotBefore:                   n,
+		NotAfter:                    n.Add(validity),
+		KeyUsage:                    old.KeyUsage,
+		ExtKeyUsage:                 old.ExtKeyUsage,
+		UnknownExtKeyUsage:          old.UnknownExtKeyUsage,
+		BasicConstraintsValid:       old.BasicConstraintsValid,
+		IsCA:                        old.IsCA,
+		MaxPathLen:                  old.MaxPathLen,
+		MaxPathLenZero:              old.MaxPathLenZero,
+		DNSNames:                    old.DNSNames,
+		EmailAddresses:              old.EmailAddresses,
+		IPAddresses:                 old.IPAddresses,
+		URIs:                        old.URIs,
+		PermittedDNSDomainsCritical: old.PermittedDNSDomainsCritical,
+		PermittedDNSDomains:         old.PermittedDNSDomains,
+		ExcludedDNSDomains:          old.ExcludedDNSDomains,
+		PermittedIPRanges:           old.PermittedIPRanges,
+		ExcludedIPRanges:            old.ExcludedIPRanges,
+		PermittedEmailAddresses:     old.PermittedEmailAddresses,
+		ExcludedEmailAddresses:      old.ExcludedEmailAddresses,
+		PermittedURIDomains:         old.PermittedURIDomains,
+		ExcludedURIDomains:          old.ExcludedURIDomains,
+		OCSPServer:                  old.OCSPServer,
+		IssuingCertificateURL:       old.IssuingCertificateURL,
+		CRLDistributionPoints:       old.CRLDistributionPoints,
+	}
+	if sameKey {
+		t.SubjectKeyId = old.SubjectKeyId
+	}
+
+	// CAs created by older versions could not sign CRLs, which renewing fixes
+	if old.IsCA && old.KeyUsage&x509.KeyUsageCertSign != 0 {
+		t.KeyUsage |= x509.KeyUsageCRLSign
+	}
+	for _, e := range old.Extensions {
+		if !slices.ContainsFunc(handledExtensions, e.Id.Equal) {
+			t.ExtraExtensions = append(t.ExtraExtensions, e)
+		}
+	}
+
+	// Go cannot encode UPNs, so the SANs are copied as-is if there are any
+	if len(parseUPNs(old.Extensions)) != 0 {
+		for _, e := range old.Extensions {
+			if e.Id.Equal(oidExtensionSubjectAltName) {
+				t.ExtraExtensions = append(t.ExtraExtensions, e)
+			}
+		}
+	}
+	return t
+}
+
+// keyCopier returns a function that copies the private key (or the reference
+// to it on the token) of the owner with ID srcID from the directory src to
+// the directory of the certificate with the specified ID. Encrypted keys are
+// encrypted again since they are bound to their owner.
+func (s *Storage) keyCopier(src, srcID string) func(string, string) error {
+	return func(d, id string) error {
+		b, err := os.ReadFile(filepath.Join(src, filenameKeyRef))
+		if err == nil {
+			err = os.WriteFile(filepath.Join(d, filenameKeyRef), b, 0600)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		k, err := loadPrivateKey(filepath.Join(src, filenamePrivateKey), s.masterKey, srcID)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		return writePrivateKey(filepath.Join(d, filenamePrivateKey), k, s.masterKey, id)
+	}
+}
+
+// RenewCertificateParams provides RenewCertificate and RekeyCertificate with
+// parameters for the new version of a certificate.
+type RenewCertificateParams struct {
+
+	// Validity of the new certificate; if empty, the validity period of the
+	// existing certificate is used.
+	Validity string
+
+	// ParentExpiry is one of the ParentExpiry* values and decides what
+	// happens if the new certificate would outlive its issuer.
+	ParentExpiry string
+
+	// KeyType, KeySize and PKCS11 describe the new key and are only used by
+	// RekeyCertificate.
+	KeyType string
+	KeySize int
+	PKCS11  bool
+}
+
+// RenewCertificate issues a new version of the certificate with the same key,
+// subject and extensions but a new validity period. The new certificate is
+// signed by the same issuer and records the certificate it replaces. The
+// existing certificate is not revoked.
+func (s *Storage) RenewCertificate(
+	certPath string,
+	params *RenewCertificateParams,
+) (*Certificate, error) {
+	return s.renewCertificate(certPath, params, LineageRenew)
+}
+
+// RekeyCertificate is like RenewCertificate but generates a new private key
+// for the new version of the certificate.
+func (s *Storage) RekeyCertificate(
+	certPath string,
+	params *RenewCertificateParams,
+) (*Certificate, error) {
+	return s.renewCertificate(certPath, params, LineageRekey)
+}
+
+func (s *Storage) renewCertificate(
+	certPath string,
+	params *RenewCertificateParams,
+	action string,
+) (*Certificate, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Find the certificate and make sure its issuer can sign a new one
+	old, err := s.getCert(certPath)
+	if err != nil {
+		return nil, err
+	}
+	parentDir := s.certDir
+	if old.parent != nil {
+		if !old.parent.hasKey || !old.parent.maySign() {
+			return nil, errParentCantSign
+		}
+		parentDir = old.parent.fPath
+	}
+
+	// Use the existing validity period unless a new one was provided
+	validity := old.cert.NotAfter.Sub(old.cert.NotBefore)
+	if params.Validity != "" {
+		v, err := parseDuration(params.Validity)
+		if err != nil {
+			return nil, err
+		}
+		validity = v
+	}
+
+	// See CreateCertificate for an explanation of the temporary directory
+	d, err := os.MkdirTemp(parentDir, "temp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(d)
+
+	// Either generate a new key or reuse the existing one; a root signs
+	// itself and therefore needs the private key in both cases
+	var (
+		rekey     = action == LineageRekey
+		template  = renewalTemplate(old.cert, validity, !rekey)
+		publicKey = old.cert.PublicKey
+		selfKey   crypto.Signer
+		tokenKey  *keyRef
+		writeKey  func(string, string) error
+	)
+	if rekey {
+		k, r, err := s.generateKey(d, params.KeyType, params.KeySize, params.PKCS11)
+		if err != nil {
+			return nil, err
+		}
+		tokenKey = r
+		defer func() {
+			if tokenKey != nil {
+				s.token.deleteKey(tokenKey)
+			}
+		}()
+		publicKey = k.Public()
+		selfKey = k
+		if tokenKey == nil {
+			writeKey = s.keyWriter(k)
+		}
+
+		// Key encipherment only makes sense for RSA keys
+		if _, ok := publicKey.(*rsa.PublicKey); !ok {
+			template.KeyUsage &^= x509.KeyUsageKeyEncipherment
+		}
+	} else {
+		writeKey = s.keyCopier(old.fPath, old.id)
+		if old.parent == nil {
+			if !old.hasKey {
+				return nil, errRootNeedsKey
+			}
+			k, err := s.loadSigner(old)
+			if err != nil {
+				return nil, err
+			}
+			selfKey = k
+		}
+	}
+
+	// Make sure the new certificate does not outlive its issuer
+	clamped, err := fitIssuer(old.parent, template, params.ParentExpiry)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record the certificate being replaced
+	if err := saveLineage(d, &lineage{
+		Previous: old.id,
+		Action:   action,
+		Time:     time.Now(),
+	}); err != nil {
+		return nil, err
+	}
+
+	// Sign the certificate and add it to the tree
+	c, err := s.storeCertificate(old.parent, d, template, publicKey, selfKey, writeKey)
+	if err != nil {
+		return nil, err
+	}
+	tokenKey = nil
+	v := convertCert(c)
+	v.Clamped = clamped
+	s.setLineage(c, v)
+	return v, nil
+}
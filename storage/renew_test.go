@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"testing"
+)
+
+func TestRenewAndRekey(t *testing.T) {
+	var (
+		dataDir = t.TempDir()
+		s       = newTestStorage(t, dataDir)
+	)
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "3h",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root: %v", err)
+	}
+	child, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName:   childCertCN,
+		Organization: "Example",
+		Validity:     "30m",
+		ServerAuth:   true,
+		SANs:         childCertCN + " " + childCertIP,
+		KeyType:      KeyTypeRSA,
+		KeySize:      2048,
+	})
+	if err != nil {
+		t.Fatalf("create child: %v", err)
+	}
+
+	// Renewing keeps the key, subject and extensions
+	renewed, err := s.RenewCertificate(child.Path, &RenewCertificateParams{
+		Validity: "2h",
+	})
+	if err != nil {
+		t.Fatalf("renew child: %v", err)
+	}
+	if !bytes.Equal(renewed.X509.RawSubject, child.X509.RawSubject) ||
+		!bytes.Equal(renewed.X509.RawSubjectPublicKeyInfo, child.X509.RawSubjectPublicKeyInfo) {
+		t.Fatal("expected renewed certificate to have the same subject and key")
+	}
+	if renewed.X509.SerialNumber.Cmp(child.X509.SerialNumber) == 0 {
+		t.Fatal("expected renewed certificate to have a new serial")
+	}
+	if renewed.X509.KeyUsage != child.X509.KeyUsage ||
+		len(renewed.X509.ExtKeyUsage) != 1 ||
+		len(renewed.X509.DNSNames) != 1 ||
+		len(renewed.X509.IPAddresses) != 1 {
+		t.Fatal("expected renewed certificate to have the same extensions")
+	}
+	if d := renewed.X509.NotAfter.Sub(renewed.X509.NotBefore); d.Hours() != 2 {
+		t.Fatalf("renewed validity = %s, want 2h", d)
+	}
+	if renewed.Previous == nil || renewed.Previous.ID != child.ID {
+		t.Fatal("expected renewed certificate to link to the previous version")
+	}
+	if _, err := s.ExportPrivateKeyPEM(renewed.Path); err != nil {
+		t.Fatalf("export renewed key: %v", err)
+	}
+
+	// Re-keying replaces the key and drops key encipherment for ECDSA
+	rekeyed, err := s.RekeyCertificate(child.Path, &RenewCertificateParams{
+		KeyType: KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("rekey child: %v", err)
+	}
+	if bytes.Equal(rekeyed.X509.RawSubjectPublicKeyInfo, child.X509.RawSubjectPublicKeyInfo) {
+		t.Fatal("expected re-keyed certificate to have a new key")
+	}
+	if rekeyed.X509.KeyUsage&x509.KeyUsageKeyEncipherment != 0 {
+		t.Fatal("expected key encipherment to be removed for an ECDSA key")
+	}
+	if rekeyed.X509.NotAfter.Sub(rekeyed.X509.NotBefore) != child.X509.NotAfter.Sub(child.X509.NotBefore) {
+		t.Fatal("expected re-keyed certificate to keep the validity period")
+	}
+
+	// The original certificate links to both new versions
+	v, err := s.GetCertificate(child.Path)
+	if err != nil {
+		t.Fatalf("get child: %v", err)
+	}
+	if len(v.Next) != 2 || v.Next[0].ID != renewed.ID || v.Next[1].ID != rekeyed.ID {
+		t.Fatalf("expected two next versions, got %d", len(v.Next))
+	}
+
+	// Certificates issued by the old root still chain to the renewed root
+	newRoot, err := s.RenewCertificate(root.Path, &RenewCertificateParams{})
+	if err != nil {
+		t.Fatalf("renew root: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(newRoot.X509)
+	if _, err := child.X509.Verify(x509.VerifyOptions{
+		Roots:     pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		t.Fatalf("verify child against renewed root: %v", err)
+	}
+
+	// Lineage survives reloading from disk
+	s = newTestStorage(t, dataDir)
+	v, err = s.GetCertificate(renewed.Path)
+	if err != nil {
+		t.Fatalf("get renewed: %v", err)
+	}
+	if v.Previous == nil || v.Previous.ID != child.ID {
+		t.Fatal("expected lineage to be loaded from disk")
+	}
+}
+
+func TestRenewTokenKeyIsShared(t *testing.T) {
+	var (
+		dataDir = t.TempDir()
+		s       = newTestStorage(t, dataDir)
+		tok     = &fakeToken{keys: map[string]crypto.Signer{}}
+	)
+	s.token = tok
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1h",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+		PKCS11:     true,
+	})
+	if err != nil {
+		t.Fatalf("create root: %v", err)
+	}
+	renewed, err := s.RenewCertificate(root.Path, &RenewCertificateParams{})
+	if err != nil {
+		t.Fatalf("renew root: %v", err)
+	}
+
+	// Deleting the original must not delete the key the renewal still uses
+	if err := s.DeleteCertificate(root.Path); err != nil {
+		t.Fatalf("delete root: %v", err)
+	}
+	if len(tok.keys) != 1 {
+		t.Fatal("expected key to remain on the token")
+	}
+	if _, err := s.CreateCertificate(renewed.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "30m",
+		KeyType:    KeyTypeECDSAP256,
+	}); err != nil {
+		t.Fatalf("sign with renewed root: %v", err)
+	}
+	if err := s.DeleteCertificate(renewed.Path); err != nil {
+		t.Fatalf("delete renewed root: %v", err)
+	}
+	if len(tok.keys) != 0 {
+		t.Fatal("expected key to be deleted with its last certificate")
+	}
+
+	// Unknown certificates cannot be renewed
+	if _, err := s.RenewCertificate("missing", &RenewCertificateParams{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
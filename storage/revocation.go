@@ -0,0 +1,363 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	typeCRL = "X509 CRL"
+
+	filenameRevoked = "revoked.json"
+	filenameCRL     = "crl.pem"
+)
+
+// Revocation reason codes as defined in RFC 5280, section 5.3.1.
+const (
+	ReasonUnspecified          = 0
+	ReasonKeyCompromise        = 1
+	ReasonCACompromise         = 2
+	ReasonAffiliationChanged   = 3
+	ReasonSuperseded           = 4
+	ReasonCessationOfOperation = 5
+	ReasonCertificateHold      = 6
+	ReasonPrivilegeWithdrawn   = 9
+	ReasonAACompromise         = 10
+)
+
+// ReasonNames maps each supported revocation reason to a human-friendly name.
+var ReasonNames = map[int]string{
+	ReasonUnspecified:          "unspecified",
+	ReasonKeyCompromise:        "key compromise",
+	ReasonCACompromise:         "CA compromise",
+	ReasonAffiliationChanged:   "affiliation changed",
+	ReasonSuperseded:           "superseded",
+	ReasonCessationOfOperation: "cessation of operation",
+	ReasonCertificateHold:      "certificate hold",
+	ReasonPrivilegeWithdrawn:   "privilege withdrawn",
+	ReasonAACompromise:         "AA compromise",
+}
+
+var (
+	errNotACRL           = errors.New("file is not a PEM-encoded CRL")
+	errInvalidReason     = inputError("invalid revocation reason specified")
+	errAlreadyRevoked    = conflictError("certificate is already revoked")
+	errCannotRevokeRoot  = inputError("root certificates cannot be revoked")
+	errCRLRequiresSigner = inputError("certificate cannot sign CRLs")
+	errCRLSignUsage      = inputError("the CA certificate does not allow signing CRLs; renew it to add the CRL signing key usage")
+)
+
+// Revocation describes the revocation of a single certificate. A list of
+// these is stored in the directory of the issuing CA.
+type Revocation struct {
+	SerialNumber   *big.Int  `json:"serial_number"`
+	RevocationTime time.Time `json:"revocation_time"`
+	Reason         int       `json:"reason"`
+}
+
+// ReasonName returns a human-friendly name for the revocation reason.
+func (r *Revocation) ReasonName() string {
+	return ReasonNames[r.Reason]
+}
+
+func loadRevocations(dir string) ([]*Revocation, error) {
+	b, err := os.ReadFile(filepath.Join(dir, filenameRevoked))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*Revocation{}, nil
+		}
+		return nil, err
+	}
+	revocations := []*Revocation{}
+	if err := json.Unmarshal(b, &revocations); err != nil {
+		return nil, err
+	}
+	return revocations, nil
+}
+
+func saveRevocations(dir string, revocations []*Revocation) error {
+	b, err := json.MarshalIndent(revocations, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, filenameRevoked), b, 0600)
+}
+
+func findRevocation(revocations []*Revocation, serial *big.Int) *Revocation {
+	for _, r := range revocations {
+		if r.SerialNumber.Cmp(serial) == 0 {
+			return r
+		}
+	}
+	return nil
+}
+
+// getRevocation returns the revocation entry for the certificate (or nil if
+// it has not been revoked).
+func (s *Storage) getRevocation(c *storageCert) (*Revocation, error) {
+	if c.parent == nil {
+		return nil, nil
+	}
+	revocations, err := loadRevocations(c.parent.fPath)
+	if err != nil {
+		return nil, err
+	}
+	return findRevocation(revocations, c.cert.SerialNumber), nil
+}
+
+// RevokeCertificateParams provides RevokeCertificate with parameters for
+// revoking a certificate.
+type RevokeCertificateParams struct {
+	Reason int
+}
+
+// RevokeCertificate marks the specified certificate as revoked by its issuer.
+// The revocation will be included in all CRLs subsequently generated by the
+// issuing CA.
+func (s *Storage) RevokeCertificate(
+	certPath string,
+	params *RevokeCertificateParams,
+) error {
+	if _, ok := ReasonNames[params.Reason]; !ok {
+		return errInvalidReason
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c, err := s.getCert(certPath)
+	if err != nil {
+		return err
+	}
+	if c.parent == nil {
+		return errCannotRevokeRoot
+	}
+
+	// The CRL cannot be signed while sealed, so refuse to revoke rather than
+	// publish the revocation late
+	if _, err := s.writeMasterKey(); err != nil {
+		return err
+	}
+	revocations, err := loadRevocations(c.parent.fPath)
+	if err != nil {
+		return err
+	}
+	if findRevocation(revocations, c.cert.SerialNumber) != nil {
+		return errAlreadyRevoked
+	}
+	r := &Revocation{
+		SerialNumber:   c.cert.SerialNumber,
+		RevocationTime: time.Now().UTC(),
+		Reason:         params.Reason,
+	}
+	updated := append(revocations, r)
+
+	// Sign the new CRL before recording anything so that a CA unable to
+	// sign CRLs does not end up with an unpublished revocation
+	listed, err := s.crlRevocations(c.parent)
+	if err != nil {
+		return err
+	}
+	b, err := s.signCRL(c.parent, append(listed, r), nil)
+	if err != nil {
+		return err
+	}
+	if err := saveRevocations(c.parent.fPath, updated); err != nil {
+		return err
+	}
+	if err := writeCRL(c.parent, b); err != nil {
+		saveRevocations(c.parent.fPath, revocations)
+		return err
+	}
+	s.invalidateOCSP()
+	return nil
+}
+
+func loadCRL(filename string) (*x509.RevocationList, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != typeCRL {
+		return nil, errNotACRL
+	}
+	return x509.ParseRevocationList(block.Bytes)
+}
+
+// generateCRL creates and stores a new CRL for the CA, incrementing the CRL
+// number from the previous one.
+func (s *Storage) generateCRL(c *storageCert) (*x509.RevocationList, error) {
+	return s.generateCRLNumber(c, nil)
+}
+
+// generateCRLNumber is like generateCRL but uses at least minNumber (if not
+// nil) as the CRL number, which allows numbering to continue from CRLs
+// issued before the CA was managed by Certy.
+func (s *Storage) generateCRLNumber(c *storageCert, minNumber *big.Int) (*x509.RevocationList, error) {
+	revocations, err := s.crlRevocations(c)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.signCRL(c, revocations, minNumber)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCRL(c, b); err != nil {
+		return nil, err
+	}
+	return x509.ParseRevocationList(b)
+}
+
+// caVersions returns every version of the CA with the same subject and key,
+// including c itself. Versions of a CA renewed with the same key are the
+// same issuer as far as clients are concerned.
+func (s *Storage) caVersions(c *storageCert) []*storageCert {
+	var versions []*storageCert
+	walk(s.rootCerts, func(v *storageCert) {
+		if bytes.Equal(v.cert.RawSubject, c.cert.RawSubject) &&
+			bytes.Equal(v.cert.RawSubjectPublicKeyInfo, c.cert.RawSubjectPublicKeyInfo) {
+			versions = append(versions, v)
+		}
+	})
+	return versions
+}
+
+// crlRevocations returns the revocations to list in the CA's CRL, which are
+// those of every version of the CA (see caVersions).
+func (s *Storage) crlRevocations(c *storageCert) ([]*Revocation, error) {
+	revocations := []*Revocation{}
+	for _, v := range s.caVersions(c) {
+		r, err := loadRevocations(v.fPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range r {
+			if findRevocation(revocations, e.SerialNumber) == nil {
+				revocations = append(revocations, e)
+			}
+		}
+	}
+	return revocations, nil
+}
+
+// signCRL creates a CRL for the CA listing the revocations, numbered after
+// the stored CRL (or at least minNumber if not nil), without storing it.
+func (s *Storage) signCRL(
+	c *storageCert,
+	revocations []*Revocation,
+	minNumber *big.Int,
+) ([]byte, error) {
+	if !c.hasKey || !c.maySign() {
+		return nil, errCRLRequiresSigner
+	}
+	if c.cert.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		return nil, errCRLSignUsage
+	}
+	k, err := s.loadSigner(c)
+	if err != nil {
+		return nil, err
+	}
+	number := big.NewInt(1)
+	if old, err := loadCRL(filepath.Join(c.fPath, filenameCRL)); err == nil {
+		number.Add(old.Number, number)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if minNumber != nil && number.Cmp(minNumber) < 0 {
+		number.Set(minNumber)
+	}
+	var (
+		n        = time.Now()
+		template = &x509.RevocationList{
+			Number:     number,
+			ThisUpdate: n,
+			NextUpdate: n.Add(s.crlValidity),
+		}
+	)
+	for _, r := range revocations {
+		template.RevokedCertificateEntries = append(
+			template.RevokedCertificateEntries,
+			x509.RevocationListEntry{
+				SerialNumber:   r.SerialNumber,
+				RevocationTime: r.RevocationTime,
+				ReasonCode:     r.Reason,
+			},
+		)
+	}
+	return x509.CreateRevocationList(rand.Reader, template, c.cert, k)
+}
+
+// writeCRL stores a DER-encoded CRL signed by signCRL as the CA's current
+// CRL.
+func writeCRL(c *storageCert, b []byte) error {
+	return os.WriteFile(
+		filepath.Join(c.fPath, filenameCRL),
+		pem.EncodeToMemory(&pem.Block{
+			Type:  typeCRL,
+			Bytes: b,
+		}),
+		0600,
+	)
+}
+
+// currentCRL returns the stored CRL for the CA, generating a new one if none
+// exists, the existing one has passed the midpoint of its validity or it is
+// missing revocations made by another version of the CA.
+func (s *Storage) currentCRL(c *storageCert) (*x509.RevocationList, error) {
+	l, err := loadCRL(filepath.Join(c.fPath, filenameCRL))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		return s.generateCRL(c)
+	}
+	if time.Now().After(l.ThisUpdate.Add(l.NextUpdate.Sub(l.ThisUpdate) / 2)) {
+		return s.generateCRL(c)
+	}
+	revocations, err := s.crlRevocations(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(revocations) != len(l.RevokedCertificateEntries) {
+		return s.generateCRL(c)
+	}
+	return l, nil
+}
+
+// ExportCRLDER exports the current CRL for the specified CA in DER format.
+// A new CRL is generated if necessary.
+func (s *Storage) ExportCRLDER(certPath string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c, err := s.getCert(certPath)
+	if err != nil {
+		return nil, err
+	}
+	l, err := s.currentCRL(c)
+	if err != nil {
+		return nil, err
+	}
+	return l.Raw, nil
+}
+
+// ExportCRLPEM exports the current CRL for the specified CA as a PEM-encoded
+// file. A new CRL is generated if necessary.
+func (s *Storage) ExportCRLPEM(certPath string) ([]byte, error) {
+	b, err := s.ExportCRLDER(certPath)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  typeCRL,
+		Bytes: b,
+	}), nil
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRevokeCertificateAndGenerateCRL(t *testing.T) {
+	s := newTestStorage(t, t.TempDir())
+
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1h",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root certificate: %v", err)
+	}
+	child, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "30m",
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create child certificate: %v", err)
+	}
+
+	// The initial CRL should be empty
+	b, err := s.ExportCRLDER(root.Path)
+	if err != nil {
+		t.Fatalf("export CRL: %v", err)
+	}
+	l, err := x509.ParseRevocationList(b)
+	if err != nil {
+		t.Fatalf("parse CRL: %v", err)
+	}
+	if len(l.RevokedCertificateEntries) != 0 {
+		t.Fatalf("initial CRL has %d entries, want 0", len(l.RevokedCertificateEntries))
+	}
+	if err := l.CheckSignatureFrom(root.X509); err != nil {
+		t.Fatalf("CRL signature: %v", err)
+	}
+
+	// Revoke the child and confirm it is reported as such
+	if err := s.RevokeCertificate(child.Path, &RevokeCertificateParams{
+		Reason: ReasonKeyCompromise,
+	}); err != nil {
+		t.Fatalf("revoke child certificate: %v", err)
+	}
+	c, err := s.GetCertificate(child.Path)
+	if err != nil {
+		t.Fatalf("get child certificate: %v", err)
+	}
+	if c.Revocation == nil || c.Revocation.Reason != ReasonKeyCompromise {
+		t.Fatalf("child revocation = %#v, want key compromise", c.Revocation)
+	}
+
+	// The new CRL must list the child and have a higher number
+	b, err = s.ExportCRLDER(root.Path)
+	if err != nil {
+		t.Fatalf("export CRL: %v", err)
+	}
+	l2, err := x509.ParseRevocationList(b)
+	if err != nil {
+		t.Fatalf("parse CRL: %v", err)
+	}
+	if len(l2.RevokedCertificateEntries) != 1 ||
+		l2.RevokedCertificateEntries[0].SerialNumber.Cmp(child.X509.SerialNumber) != 0 {
+		t.Fatalf("CRL entries = %#v, want child serial", l2.RevokedCertificateEntries)
+	}
+	if l2.Number.Cmp(l.Number) <= 0 {
+		t.Fatalf("CRL number = %v, want greater than %v", l2.Number, l.Number)
+	}
+
+	// Revoking twice or revoking the root must fail
+	if err := s.RevokeCertificate(child.Path, &RevokeCertificateParams{}); !errors.Is(err, errAlreadyRevoked) {
+		t.Fatalf("revoke twice error = %v, want %v", err, errAlreadyRevoked)
+	}
+	if err := s.RevokeCertificate(root.Path, &RevokeCertificateParams{}); !errors.Is(err, errCannotRevokeRoot) {
+		t.Fatalf("revoke root error = %v, want %v", err, errCannotRevokeRoot)
+	}
+	if err := s.RevokeCertificate(child.Path, &RevokeCertificateParams{Reason: 7}); !errors.Is(err, errInvalidReason) {
+		t.Fatalf("invalid reason error = %v, want %v", err, errInvalidReason)
+	}
+}
+
+func TestRevokeRequiresCRLSign(t *testing.T) {
+	var (
+		dataDir = t.TempDir()
+		s       = newTestStorage(t, dataDir)
+	)
+
+	// Older versions created CAs that could only sign certificates
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: rootCertCN},
+		NotBefore:             n.Add(-time.Minute),
+		NotAfter:              n.Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	b, err := x509.CreateCertificate(rand.Reader, template, template, k.Public(), k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := s.ImportCertificate("", &ImportCertificateParams{
+		Certificate: b,
+		PrivateKey:  pem.EncodeToMemory(&pem.Block{Type: typePrivateKey, Bytes: der}),
+	})
+	if err != nil {
+		t.Fatalf("import root: %v", err)
+	}
+	child, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "30m",
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create child: %v", err)
+	}
+
+	// Revoking fails without recording the revocation, so it can be retried
+	if err := s.RevokeCertificate(child.Path, &RevokeCertificateParams{}); !errors.Is(err, errCRLSignUsage) {
+		t.Fatalf("revoke error = %v, want %v", err, errCRLSignUsage)
+	}
+	c, err := s.GetCertificate(child.Path)
+	if err != nil {
+		t.Fatalf("get child: %v", err)
+	}
+	if c.Revocation != nil {
+		t.Fatalf("child revocation = %#v, want none", c.Revocation)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "certs", root.ID, filenameRevoked)); !os.IsNotExist(err) {
+		t.Fatalf("expected no revocations to be saved, got %v", err)
+	}
+	if _, err := s.ExportCRLDER(root.Path); !errors.Is(err, errCRLSignUsage) {
+		t.Fatalf("export CRL error = %v, want %v", err, errCRLSignUsage)
+	}
+
+	// Renewing the CA adds the usage
+	renewed, err := s.RenewCertificate(root.Path, &RenewCertificateParams{})
+	if err != nil {
+		t.Fatalf("renew root: %v", err)
+	}
+	if renewed.X509.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		t.Fatal("expected renewed CA to be able to sign CRLs")
+	}
+	if _, err := s.ExportCRLDER(renewed.Path); err != nil {
+		t.Fatalf("export CRL of renewed CA: %v", err)
+	}
+}
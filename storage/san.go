@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Types of SAN.
+const (
+	SANTypeDNS   = "dns"
+	SANTypeIP    = "ip"
+	SANTypeEmail = "email"
+	SANTypeURI   = "uri"
+	SANTypeUPN   = "upn"
+)
+
+var (
+	oidExtensionSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidUPN                     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+
+	sanTypes = []string{
+		SANTypeDNS,
+		SANTypeIP,
+		SANTypeEmail,
+		SANTypeURI,
+		SANTypeUPN,
+	}
+
+	dnsLabelRegExp = regexp.MustCompile(`^[a-z0-9_]([a-z0-9_-]*[a-z0-9_])?$`)
+)
+
+// Tags of the GeneralName choices used for SANs.
+const (
+	tagOtherName = 0
+	tagEmail     = 1
+	tagDNS       = 2
+	tagURI       = 6
+	tagIP        = 7
+)
+
+// SAN is a subject alternative name of one of the SANType* types.
+type SAN struct {
+	Type  string
+	Value string
+}
+
+func (s *SAN) String() string {
+	return fmt.Sprintf("%s:%s", s.Type, s.Value)
+}
+
+// ParseSANs parses a comma or space separated list of SANs. Each may be
+// prefixed with its type and a colon, such as "email:alice@example.com";
+// otherwise IP addresses, email addresses and URIs (which contain "://") are
+// recognized and anything else is a DNS name. UPNs always need the prefix.
+// Internationalized domain names are converted to punycode.
+func ParseSANs(v string) ([]*SAN, error) {
+	sans := []*SAN{}
+	for _, s := range splitSANs(v) {
+		t, value, ok := strings.Cut(s, ":")
+		if !ok || !slices.Contains(sanTypes, strings.ToLower(t)) {
+			t, value = detectSANType(s), s
+		}
+		san, err := normalizeSAN(strings.ToLower(t), value)
+		if err != nil {
+			return nil, err
+		}
+		sans = append(sans, san)
+	}
+	return sans, nil
+}
+
+func detectSANType(v string) string {
+	switch {
+	case net.ParseIP(v) != nil:
+		return SANTypeIP
+	case strings.Contains(v, "://"):
+		return SANTypeURI
+	case strings.Contains(v, "@"):
+		return SANTypeEmail
+	}
+	return SANTypeDNS
+}
+
+func normalizeSAN(t, v string) (*SAN, error) {
+	invalid := inputError(fmt.Sprintf("invalid %s SAN %s", strings.ToUpper(t), v))
+	switch t {
+	case SANTypeDNS:
+		n, err := normalizeDNSName(v, true)
+		if err != nil {
+			return nil, err
+		}
+		v = n
+	case SANTypeIP:
+		i := net.ParseIP(v)
+		if i == nil {
+			return nil, invalid
+		}
+		v = i.String()
+	case SANTypeEmail, SANTypeUPN:
+		i := strings.LastIndex(v, "@")
+		if i < 1 {
+			return nil, invalid
+		}
+		d, err := normalizeDNSName(v[i+1:], false)
+		if err != nil {
+			return nil, invalid
+		}
+		v = v[:i+1] + d
+	case SANTypeURI:
+		u, err := url.Parse(v)
+		if err != nil || u.Scheme == "" || (u.Host == "" && u.Opaque == "") {
+			return nil, invalid
+		}
+	}
+	return &SAN{Type: t, Value: v}, nil
+}
+
+// normalizeDNSName converts the name to lowercase punycode and checks that
+// it is a valid host name. A wildcard is only allowed as the entire leftmost
+// label of a name with at least two other labels.
+func normalizeDNSName(v string, allowWildcard bool) (string, error) {
+	var (
+		name     = strings.TrimSuffix(v, ".")
+		wildcard = allowWildcard && strings.HasPrefix(name, "*.")
+	)
+	if wildcard {
+		name = name[2:]
+	}
+	if strings.Contains(name, "*") {
+		return "", inputError(fmt.Sprintf("wildcards are only allowed as the leftmost label, not in %s", v))
+	}
+	a, err := idna.Lookup.ToASCII(name)
+	if err != nil {
+		// The lookup profile rejects underscores, which are common in
+		// internal names, so fall back to the lenient profile
+		a, err = idna.Punycode.ToASCII(strings.ToLower(name))
+		if err != nil {
+			return "", inputError(fmt.Sprintf("invalid DNS name %s", v))
+		}
+	}
+	labels := strings.Split(a, ".")
+	if len(a) > 253 {
+		return "", inputError(fmt.Sprintf("DNS name %s is too long", v))
+	}
+	for _, l := range labels {
+		if len(l) > 63 || !dnsLabelRegExp.MatchString(l) {
+			return "", inputError(fmt.Sprintf("invalid DNS name %s", v))
+		}
+	}
+	if wildcard {
+		if len(labels) < 2 {
+			return "", inputError(fmt.Sprintf("wildcard %s must be below a registered domain", v))
+		}
+		a = "*." + a
+	}
+	return a, nil
+}
+
+// setSANs adds the SANs to the certificate template. Go cannot encode UPNs,
+// so if there are any, the extension is encoded here and the template
+// fields are only used for checks.
+func setSANs(cert *x509.Certificate, sans []*SAN) error {
+	upn := false
+	for _, s := range sans {
+		switch s.Type {
+		case SANTypeDNS:
+			cert.DNSNames = append(cert.DNSNames, s.Value)
+		case SANTypeIP:
+			cert.IPAddresses = append(cert.IPAddresses, net.ParseIP(s.Value))
+		case SANTypeEmail:
+			cert.EmailAddresses = append(cert.EmailAddresses, s.Value)
+		case SANTypeURI:
+			u, err := url.Parse(s.Value)
+			if err != nil {
+				return err
+			}
+			cert.URIs = append(cert.URIs, u)
+		case SANTypeUPN:
+			upn = true
+		}
+	}
+	if !upn {
+		return nil
+	}
+	e, err := marshalSANs(sans)
+	if err != nil {
+		return err
+	}
+	cert.ExtraExtensions = append(cert.ExtraExtensions, *e)
+	return nil
+}
+
+func marshalSANs(sans []*SAN) (*pkix.Extension, error) {
+	names := []asn1.RawValue{}
+	for _, s := range sans {
+		switch s.Type {
+		case SANTypeDNS:
+			names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tagDNS, Bytes: []byte(s.Value)})
+		case SANTypeIP:
+			i := net.ParseIP(s.Value)
+			if v := i.To4(); v != nil {
+				i = v
+			}
+			names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tagIP, Bytes: i})
+		case SANTypeEmail:
+			names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tagEmail, Bytes: []byte(s.Value)})
+		case SANTypeURI:
+			names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tagURI, Bytes: []byte(s.Value)})
+		case SANTypeUPN:
+			oid, err := asn1.Marshal(oidUPN)
+			if err != nil {
+				return nil, err
+			}
+			value, err := asn1.MarshalWithParams(s.Value, "utf8,explicit,tag:0")
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, asn1.RawValue{
+				Class:      asn1.ClassContextSpecific,
+				Tag:        tagOtherName,
+				IsCompound: true,
+				Bytes:      append(oid, value...),
+			})
+		}
+	}
+	b, err := asn1.Marshal(names)
+	if err != nil {
+		return nil, err
+	}
+	return &pkix.Extension{Id: oidExtensionSubjectAltName, Value: b}, nil
+}
+
+// parseUPNs returns the UPNs in the SAN extension (if any) of the provided
+// extensions, which Go does not parse.
+func parseUPNs(extensions []pkix.Extension) []string {
+	upns := []string{}
+	for _, e := range extensions {
+		if !e.Id.Equal(oidExtensionSubjectAltName) {
+			continue
+		}
+		var names []asn1.RawValue
+		if _, err := asn1.Unmarshal(e.Value, &names); err != nil {
+			continue
+		}
+		for _, n := range names {
+			if n.Class != asn1.ClassContextSpecific || n.Tag != tagOtherName {
+				continue
+			}
+			var (
+				oid   asn1.ObjectIdentifier
+				value asn1.RawValue
+			)
+			rest, err := asn1.Unmarshal(n.Bytes, &oid)
+			if err != nil || !oid.Equal(oidUPN) {
+				continue
+			}
+			if _, err := asn1.Unmarshal(rest, &value); err != nil {
+				continue
+			}
+			var upn string
+			if _, err := asn1.UnmarshalWithParams(value.Bytes, &upn, "utf8"); err == nil {
+				upns = append(upns, upn)
+			}
+		}
+	}
+	return upns
+}
+
+// sanList combines the SANs of a certificate or CSR of every type.
+func sanList(
+	dnsNames []string,
+	ipAddresses []net.IP,
+	emailAddresses []string,
+	uris []*url.URL,
+	extensions []pkix.Extension,
+) []*SAN {
+	sans := []*SAN{}
+	for _, n := range dnsNames {
+		sans = append(sans, &SAN{Type: SANTypeDNS, Value: n})
+	}
+	for _, i := range ipAddresses {
+		sans = append(sans, &SAN{Type: SANTypeIP, Value: i.String()})
+	}
+	for _, e := range emailAddresses {
+		sans = append(sans, &SAN{Type: SANTypeEmail, Value: e})
+	}
+	for _, u := range uris {
+		sans = append(sans, &SAN{Type: SANTypeURI, Value: u.String()})
+	}
+	for _, u := range parseUPNs(extensions) {
+		sans = append(sans, &SAN{Type: SANTypeUPN, Value: u})
+	}
+	return sans
+}
+
+// SANs returns the subject alternative names of the certificate.
+func (c *Certificate) SANs() []*SAN {
+	x := c.X509
+	return sanList(x.DNSNames, x.IPAddresses, x.EmailAddresses, x.URIs, x.Extensions)
+}
+
+// SANs returns the subject alternative names in the request.
+func (c *CSR) SANs() []*SAN {
+	x := c.X509
+	return sanList(x.DNSNames, x.IPAddresses, x.EmailAddresses, x.URIs, x.Extensions)
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParseSANs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		in   string
+		want []SAN
+		err  bool
+	}{
+		{name: "detected", in: "www.example.com, 10.0.0.1 2001:db8::1", want: []SAN{
+			{SANTypeDNS, "www.example.com"},
+			{SANTypeIP, "10.0.0.1"},
+			{SANTypeIP, "2001:db8::1"},
+		}},
+		{name: "email and URI", in: "alice@example.com spiffe://example.org/web", want: []SAN{
+			{SANTypeEmail, "alice@example.com"},
+			{SANTypeURI, "spiffe://example.org/web"},
+		}},
+		{name: "typed", in: "upn:alice@corp.example.com DNS:WWW.Example.com ip:::1", want: []SAN{
+			{SANTypeUPN, "alice@corp.example.com"},
+			{SANTypeDNS, "www.example.com"},
+			{SANTypeIP, "::1"},
+		}},
+		{name: "IDN", in: "bücher.example *.bücher.example", want: []SAN{
+			{SANTypeDNS, "xn--bcher-kva.example"},
+			{SANTypeDNS, "*.xn--bcher-kva.example"},
+		}},
+		{name: "underscore", in: "_acme.example.com", want: []SAN{
+			{SANTypeDNS, "_acme.example.com"},
+		}},
+		{name: "wildcard not leftmost", in: "www.*.example.com", err: true},
+		{name: "partial wildcard", in: "w*.example.com", err: true},
+		{name: "wildcard TLD", in: "*.com", err: true},
+		{name: "invalid label", in: "-bad.example.com", err: true},
+		{name: "invalid IP", in: "ip:example.com", err: true},
+		{name: "relative URI", in: "uri:/web", err: true},
+		{name: "invalid email", in: "email:example.com", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSANs(tt.in)
+			if tt.err {
+				if !errors.Is(err, ErrInvalidInput) {
+					t.Fatalf("ParseSANs(%q) error = %v, want invalid input", tt.in, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSANs(%q) error = %v", tt.in, err)
+			}
+			if !slices.EqualFunc(got, tt.want, func(a *SAN, b SAN) bool { return *a == b }) {
+				t.Fatalf("ParseSANs(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSANs(t *testing.T) {
+	s := newTestStorage(t, t.TempDir())
+
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1h",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root certificate: %v", err)
+	}
+
+	// Every type of SAN is included, including UPNs that Go cannot encode
+	c, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "30m",
+		SANs:       "www.example.test 192.0.2.1 alice@example.test spiffe://example.test/web upn:alice@corp.example.test",
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	want := []string{
+		"dns:www.example.test",
+		"ip:192.0.2.1",
+		"email:alice@example.test",
+		"uri:spiffe://example.test/web",
+		"upn:alice@corp.example.test",
+	}
+	check := func(c *Certificate) {
+		t.Helper()
+		got := []string{}
+		for _, v := range c.SANs() {
+			got = append(got, v.String())
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("SANs = %v, want %v", got, want)
+		}
+	}
+	check(c)
+
+	// Renewing keeps all of them
+	renewed, err := s.RenewCertificate(c.Path, &RenewCertificateParams{})
+	if err != nil {
+		t.Fatalf("renew certificate: %v", err)
+	}
+	check(renewed)
+}
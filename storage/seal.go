@@ -0,0 +1,297 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	filenameSeal = "seal.json"
+
+	kdfArgon2id = "argon2id"
+
+	// Parameters for deriving the key-encryption key from the passphrase
+	// (the second recommended option in RFC 9106, section 4)
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+
+	masterKeySize = 32
+)
+
+var (
+	errSealed            = sealedError("private keys are sealed; unseal storage with the passphrase first")
+	errNotEncrypted      = inputError("private keys are not encrypted")
+	errWrongPassphrase   = inputError("the passphrase is incorrect")
+	errEmptyPassphrase   = inputError("the passphrase must not be empty")
+	errUnsupportedKDF    = errors.New("seal file uses an unsupported key derivation function")
+	errInvalidCiphertext = errors.New("encrypted data is too short")
+)
+
+// sealFile describes how the master key is derived and stores the master
+// key encrypted with the key derived from the passphrase.
+type sealFile struct {
+	KDF     string `json:"kdf"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+	Salt    []byte `json:"salt"`
+	Key     []byte `json:"key"`
+}
+
+// encrypt encrypts the plaintext with AES-256-GCM; the random nonce is
+// prepended to the ciphertext.
+func encrypt(key, plaintext, additionalData []byte) ([]byte, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	g, err := cipher.NewGCM(b)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, g.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return g.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// decrypt reverses encrypt.
+func decrypt(key, ciphertext, additionalData []byte) ([]byte, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	g, err := cipher.NewGCM(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < g.NonceSize() {
+		return nil, errInvalidCiphertext
+	}
+	return g.Open(
+		nil,
+		ciphertext[:g.NonceSize()],
+		ciphertext[g.NonceSize():],
+		additionalData,
+	)
+}
+
+func (f *sealFile) deriveKey(passphrase string) ([]byte, error) {
+	if f.KDF != kdfArgon2id {
+		return nil, errUnsupportedKDF
+	}
+	return argon2.IDKey(
+		[]byte(passphrase),
+		f.Salt,
+		f.Time,
+		f.Memory,
+		f.Threads,
+		masterKeySize,
+	), nil
+}
+
+// unwrap derives the key-encryption key and uses it to decrypt the master
+// key.
+func (f *sealFile) unwrap(passphrase string) ([]byte, error) {
+	k, err := f.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	v, err := decrypt(k, f.Key, []byte(kdfArgon2id))
+	if err != nil {
+		return nil, errWrongPassphrase
+	}
+	return v, nil
+}
+
+// newSealFile generates a new master key and wraps it with a key derived from
+// the passphrase.
+func newSealFile(passphrase string) (*sealFile, []byte, error) {
+	if passphrase == "" {
+		return nil, nil, errEmptyPassphrase
+	}
+	f := &sealFile{
+		KDF:     kdfArgon2id,
+		Time:    argon2Time,
+		Memory:  argon2Memory,
+		Threads: argon2Threads,
+		Salt:    make([]byte, 16),
+	}
+	masterKey := make([]byte, masterKeySize)
+	if _, err := rand.Read(f.Salt); err != nil {
+		return nil, nil, err
+	}
+	if _, err := rand.Read(masterKey); err != nil {
+		return nil, nil, err
+	}
+	k, err := f.deriveKey(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, err := encrypt(k, masterKey, []byte(kdfArgon2id))
+	if err != nil {
+		return nil, nil, err
+	}
+	f.Key = v
+	return f, masterKey, nil
+}
+
+// loadSealFile reads the seal file, returning nil if keys are not encrypted.
+func loadSealFile(filename string) (*sealFile, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	f := &sealFile{}
+	if err := json.Unmarshal(b, f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// writeMasterKey returns the key that newly written private keys must be
+// encrypted with, which is nil when keys are not encrypted; it must be called
+// with the mutex held.
+func (s *Storage) writeMasterKey() ([]byte, error) {
+	if s.seal != nil && s.masterKey == nil {
+		return nil, errSealed
+	}
+	return s.masterKey, nil
+}
+
+// Encrypted indicates whether private keys are encrypted at rest.
+func (s *Storage) Encrypted() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.seal != nil
+}
+
+// Sealed indicates that private keys are encrypted and cannot be used until
+// Unseal is called with the passphrase.
+func (s *Storage) Sealed() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.seal != nil && s.masterKey == nil
+}
+
+// Unseal derives the master key from the passphrase, allowing private keys to
+// be used. Unsealing storage that is already unsealed still verifies the
+// passphrase.
+func (s *Storage) Unseal(passphrase string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.seal == nil {
+		return errNotEncrypted
+	}
+	k, err := s.seal.unwrap(passphrase)
+	if err != nil {
+		return err
+	}
+	s.masterKey = k
+	return nil
+}
+
+// Seal discards the master key. Private keys cannot be used again until
+// Unseal is called.
+func (s *Storage) Seal() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.seal == nil {
+		return errNotEncrypted
+	}
+	clear(s.masterKey)
+	s.masterKey = nil
+	return nil
+}
+
+// EncryptKeys enables encryption of private keys and encrypts every existing
+// plaintext key in place. If keys are already encrypted, the passphrase must
+// match and any remaining plaintext keys (for example, from an interrupted
+// migration) are encrypted. Storage is left unsealed.
+func (s *Storage) EncryptKeys(passphrase string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// The seal file is written before any key is encrypted so that an
+	// interrupted migration never leaves keys that cannot be decrypted
+	if s.seal == nil {
+		f, k, err := newSealFile(passphrase)
+		if err != nil {
+			return err
+		}
+		b, err := json.MarshalIndent(f, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(s.sealFilename, b, 0600); err != nil {
+			return err
+		}
+		s.seal = f
+		s.masterKey = k
+	} else {
+		k, err := s.seal.unwrap(passphrase)
+		if err != nil {
+			return err
+		}
+		s.masterKey = k
+	}
+
+	// Each key is bound to the ID of the certificate (or pending certificate)
+	// whose directory it is stored in
+	type keyFile struct {
+		filename string
+		id       string
+	}
+	var files []keyFile
+	walk(s.rootCerts, func(c *storageCert) {
+		for _, n := range []string{filenamePrivateKey, filenameOCSPKey} {
+			files = append(files, keyFile{filepath.Join(c.fPath, n), c.id})
+		}
+	})
+	entries, err := os.ReadDir(s.pendingDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, e := range entries {
+		files = append(files, keyFile{
+			filepath.Join(s.pendingDir, e.Name(), filenamePrivateKey),
+			e.Name(),
+		})
+	}
+	for _, f := range files {
+		k, err := loadPrivateKey(f.filename, s.masterKey, f.id)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+
+		// Write the encrypted key alongside the original and then replace it
+		// so that the key is never lost
+		b, err := encodePrivateKey(k, s.masterKey, keyData(f.filename, f.id))
+		if err != nil {
+			return err
+		}
+		tmp := f.filename + ".tmp"
+		if err := os.WriteFile(tmp, b, 0600); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, f.filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testPassphrase = "open sesame"
+
+func readPEMType(t *testing.T, filename string) string {
+	t.Helper()
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("read %s: %v", filename, err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		t.Fatalf("%s is not PEM-encoded", filename)
+	}
+	return block.Type
+}
+
+func TestEncryptKeysAndUnseal(t *testing.T) {
+	var (
+		dataDir = t.TempDir()
+		s       = newTestStorage(t, dataDir)
+	)
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1h",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root: %v", err)
+	}
+	if s.Encrypted() || s.Sealed() {
+		t.Fatal("expected new storage to be unencrypted")
+	}
+
+	// Migrate the existing key
+	if err := s.EncryptKeys(""); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected empty passphrase to be rejected, got %v", err)
+	}
+	if err := s.EncryptKeys(testPassphrase); err != nil {
+		t.Fatalf("encrypt keys: %v", err)
+	}
+	keyFilename := filepath.Join(dataDir, "certs", root.ID, filenamePrivateKey)
+	if v := readPEMType(t, keyFilename); v != typeEncryptedKey {
+		t.Fatalf("expected encrypted key, got %s", v)
+	}
+
+	// Reopen storage, which should start sealed
+	s = newTestStorage(t, dataDir)
+	if !s.Sealed() {
+		t.Fatal("expected storage to be sealed")
+	}
+	v, err := s.GetCertificate(root.Path)
+	if err != nil {
+		t.Fatalf("get certificate while sealed: %v", err)
+	}
+	if v.PrivateKey == nil || v.PrivateKey.Algorithm != AlgorithmECDSA {
+		t.Fatalf("expected ECDSA key description, got %+v", v.PrivateKey)
+	}
+	childParams := &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "1h",
+		KeyType:    KeyTypeECDSAP256,
+	}
+	if _, err := s.CreateCertificate(root.Path, childParams); !errors.Is(err, ErrSealed) {
+		t.Fatalf("expected ErrSealed when issuing, got %v", err)
+	}
+	if _, err := s.ExportPrivateKeyPEM(root.Path); !errors.Is(err, ErrSealed) {
+		t.Fatalf("expected ErrSealed when exporting, got %v", err)
+	}
+
+	// Unseal and use the key
+	if err := s.Unseal("wrong"); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected wrong passphrase to be rejected, got %v", err)
+	}
+	if err := s.Unseal(testPassphrase); err != nil {
+		t.Fatalf("unseal: %v", err)
+	}
+	child, err := s.CreateCertificate(root.Path, childParams)
+	if err != nil {
+		t.Fatalf("create child: %v", err)
+	}
+	if v := readPEMType(
+		t,
+		filepath.Join(dataDir, "certs", root.ID, child.ID, filenamePrivateKey),
+	); v != typeEncryptedKey {
+		t.Fatalf("expected new key to be encrypted, got %s", v)
+	}
+	b, err := s.ExportPrivateKeyPEM(child.Path)
+	if err != nil {
+		t.Fatalf("export private key: %v", err)
+	}
+	if block, _ := pem.Decode(b); block == nil || block.Type != typePrivateKey {
+		t.Fatal("expected exported key to be plaintext PKCS#8")
+	}
+
+	// Sealing again prevents use of the keys
+	if err := s.Seal(); err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if _, err := s.ExportPrivateKeyPEM(child.Path); !errors.Is(err, ErrSealed) {
+		t.Fatalf("expected ErrSealed after sealing, got %v", err)
+	}
+}
+
+func TestEncryptedKeyBoundToCertificate(t *testing.T) {
+	var (
+		dataDir = t.TempDir()
+		s       = newTestStorage(t, dataDir)
+	)
+	if err := s.EncryptKeys(testPassphrase); err != nil {
+		t.Fatalf("encrypt keys: %v", err)
+	}
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1d",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root certificate: %v", err)
+	}
+	params := &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "1h",
+		KeyType:    KeyTypeECDSAP256,
+	}
+	a, err := s.CreateCertificate(root.Path, params)
+	if err != nil {
+		t.Fatalf("create first child: %v", err)
+	}
+	b, err := s.CreateCertificate(root.Path, params)
+	if err != nil {
+		t.Fatalf("create second child: %v", err)
+	}
+	if _, err := s.ExportPrivateKeyPEM(a.Path); err != nil {
+		t.Fatalf("export key: %v", err)
+	}
+
+	// A renewed certificate keeps a usable copy of the key
+	renewed, err := s.RenewCertificate(a.Path, &RenewCertificateParams{})
+	if err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	if _, err := s.ExportPrivateKeyPEM(renewed.Path); err != nil {
+		t.Fatalf("export renewed key: %v", err)
+	}
+
+	// Moving a key to another certificate makes it unusable
+	data, err := os.ReadFile(filepath.Join(dataDir, "certs", root.ID, a.ID, filenamePrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(
+		filepath.Join(dataDir, "certs", root.ID, b.ID, filenamePrivateKey),
+		data,
+		0600,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ExportPrivateKeyPEM(b.Path); err == nil {
+		t.Fatal("expected a key moved from another certificate to be rejected")
+	}
+}
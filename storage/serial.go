@@ -1,38 +1,171 @@
 package storage
 
 import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"math/big"
 	"os"
 	"path/filepath"
-	"strconv"
+	"slices"
+	"time"
 )
 
 const (
-	filenameSerial = "serial"
+	filenameIssued = "issued.json"
+
+	// Serials are 16 random bytes with the high bit cleared so that they
+	// are always positive, giving 127 bits of entropy
+	serialBytes = 16
+
+	maxSerialAttempts = 8
+)
+
+// Status of a certificate in a CA's index.
+const (
+	StatusValid   = "valid"
+	StatusExpired = "expired"
+	StatusRevoked = "revoked"
 )
 
-func (s *Storage) allocNextSerial(dir string) (int64, error) {
-	var (
-		filename       = filepath.Join(dir, filenameSerial)
-		serial   int64 = 1
-	)
-	b, err := os.ReadFile(filename)
+var errSerialExhausted = errors.New("unable to generate a unique serial number")
+
+// IssuedSerial records a certificate issued by a CA. The index of issued
+// certificates is kept even after the certificates are deleted so that
+// serial numbers are never reused.
+type IssuedSerial struct {
+	SerialNumber *big.Int  `json:"serial_number"`
+	Subject      string    `json:"subject"`
+	NotAfter     time.Time `json:"not_after"`
+	ID           string    `json:"id"`
+
+	// Path is the path of the certificate if it is still stored and Status
+	// is one of the Status* constants; both are filled in when the index is
+	// retrieved.
+	Path   string `json:"-"`
+	Status string `json:"-"`
+}
+
+func newIssuedSerial(c *storageCert) *IssuedSerial {
+	return &IssuedSerial{
+		SerialNumber: c.cert.SerialNumber,
+		Subject:      c.cert.Subject.String(),
+		NotAfter:     c.cert.NotAfter,
+		ID:           c.id,
+	}
+}
+
+func loadIssued(dir string) ([]*IssuedSerial, error) {
+	b, err := os.ReadFile(filepath.Join(dir, filenameIssued))
+	if err != nil {
+		return nil, err
+	}
+	issued := []*IssuedSerial{}
+	if err := json.Unmarshal(b, &issued); err != nil {
+		return nil, err
+	}
+	return issued, nil
+}
+
+func saveIssued(dir string, issued []*IssuedSerial) error {
+	b, err := json.MarshalIndent(issued, "", "  ")
 	if err != nil {
-		if !os.IsNotExist(err) {
-			return 0, err
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, filenameIssued), b, 0600)
+}
+
+// loadIndex returns the certificates issued by the CA. CAs created before the
+// index existed have one built from the certificates still in storage, which
+// is written the next time the CA issues a certificate.
+func (s *Storage) loadIndex(c *storageCert) ([]*IssuedSerial, error) {
+	issued, err := loadIssued(c.fPath)
+	if err == nil {
+		return issued, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	issued = []*IssuedSerial{}
+	if c.parent == nil {
+		issued = append(issued, newIssuedSerial(c))
+	}
+	for _, v := range c.children {
+		issued = append(issued, newIssuedSerial(v))
+	}
+	if x, err := loadCertificate(filepath.Join(c.fPath, filenameOCSPCert)); err == nil {
+		issued = append(issued, &IssuedSerial{
+			SerialNumber: x.SerialNumber,
+			Subject:      x.Subject.String(),
+			NotAfter:     x.NotAfter,
+		})
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	slices.SortFunc(issued, func(a, b *IssuedSerial) int {
+		return a.SerialNumber.Cmp(b.SerialNumber)
+	})
+	return issued, nil
+}
+
+func findIssued(issued []*IssuedSerial, serial *big.Int) *IssuedSerial {
+	for _, v := range issued {
+		if v.SerialNumber.Cmp(serial) == 0 {
+			return v
+		}
+	}
+	return nil
+}
+
+// newSerial generates a random serial number that does not appear in the
+// index.
+func newSerial(issued []*IssuedSerial) (*big.Int, error) {
+	b := make([]byte, serialBytes)
+	for i := 0; i < maxSerialAttempts; i++ {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
 		}
-	} else {
-		v, err := strconv.ParseInt(string(b), 10, 64)
-		if err != nil {
-			return 0, nil
+		b[0] &= 0x7f
+		v := new(big.Int).SetBytes(b)
+		if v.Sign() > 0 && findIssued(issued, v) == nil {
+			return v, nil
 		}
-		serial = v + 1
 	}
-	if err := os.WriteFile(
-		filename,
-		[]byte(strconv.FormatInt(serial, 10)),
-		0600,
-	); err != nil {
-		return 0, err
+	return nil, errSerialExhausted
+}
+
+// GetIssuedSerials returns the index of certificates issued by the CA in the
+// order they were issued.
+func (s *Storage) GetIssuedSerials(certPath string) ([]*IssuedSerial, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	c, err := s.getCert(certPath)
+	if err != nil {
+		return nil, err
+	}
+	issued, err := s.loadIndex(c)
+	if err != nil {
+		return nil, err
+	}
+	revocations, err := loadRevocations(c.fPath)
+	if err != nil {
+		return nil, err
+	}
+	n := time.Now()
+	for _, v := range issued {
+		switch {
+		case findRevocation(revocations, v.SerialNumber) != nil:
+			v.Status = StatusRevoked
+		case v.NotAfter.Before(n):
+			v.Status = StatusExpired
+		default:
+			v.Status = StatusValid
+		}
+		if v.ID == c.id && c.parent == nil {
+			v.Path = c.vPath
+		} else if child, ok := c.children[v.ID]; ok {
+			v.Path = child.vPath
+		}
 	}
-	return serial, nil
+	return issued, nil
 }
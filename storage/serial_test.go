@@ -1,39 +1,136 @@
 package storage
 
 import (
+	"math/big"
 	"os"
 	"path/filepath"
 	"testing"
 )
 
-func TestAllocNextSerialStartsAtOneAndIncrements(t *testing.T) {
+func TestNewSerialIsPositiveAndUnique(t *testing.T) {
 	t.Parallel()
 
-	s := &Storage{}
-	dir := t.TempDir()
+	issued := []*IssuedSerial{}
+	for i := 0; i < 100; i++ {
+		v, err := newSerial(issued)
+		if err != nil {
+			t.Fatalf("newSerial returned error: %v", err)
+		}
+		if v.Sign() <= 0 || v.BitLen() > serialBytes*8-1 {
+			t.Fatalf("serial %s is out of range", v)
+		}
+		if findIssued(issued, v) != nil {
+			t.Fatalf("serial %s was generated twice", v)
+		}
+		issued = append(issued, &IssuedSerial{SerialNumber: v})
+	}
+}
 
-	first, err := s.allocNextSerial(dir)
+func TestIssuedIndex(t *testing.T) {
+	var (
+		dataDir = t.TempDir()
+		s       = newTestStorage(t, dataDir)
+	)
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1h",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
 	if err != nil {
-		t.Fatalf("allocNextSerial first call returned error: %v", err)
+		t.Fatalf("create root: %v", err)
+	}
+	if root.X509.SerialNumber.Cmp(big.NewInt(1)) == 0 {
+		t.Fatal("expected root to have a random serial")
+	}
+	var children []*Certificate
+	for i := 0; i < 3; i++ {
+		c, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+			CommonName: childCertCN,
+			Validity:   "30m",
+			KeyType:    KeyTypeECDSAP256,
+		})
+		if err != nil {
+			t.Fatalf("create child: %v", err)
+		}
+		children = append(children, c)
+	}
+	if err := s.RevokeCertificate(children[1].Path, &RevokeCertificateParams{}); err != nil {
+		t.Fatalf("revoke child: %v", err)
 	}
-	if first != 1 {
-		t.Fatalf("first serial = %d, want 1", first)
+	if err := s.DeleteCertificate(children[2].Path); err != nil {
+		t.Fatalf("delete child: %v", err)
 	}
 
-	second, err := s.allocNextSerial(dir)
+	// The root, all three children and their statuses should be listed in
+	// the order they were issued
+	issued, err := s.GetIssuedSerials(root.Path)
 	if err != nil {
-		t.Fatalf("allocNextSerial second call returned error: %v", err)
+		t.Fatalf("get issued serials: %v", err)
+	}
+	if len(issued) != 4 {
+		t.Fatalf("index length = %d, want 4", len(issued))
+	}
+	for i, c := range append([]*Certificate{root}, children...) {
+		if issued[i].SerialNumber.Cmp(c.X509.SerialNumber) != 0 {
+			t.Fatalf("index entry %d has serial %s, want %s", i, issued[i].SerialNumber, c.X509.SerialNumber)
+		}
+	}
+	if issued[0].Path != root.Path || issued[1].Path != children[0].Path {
+		t.Fatalf("expected paths of stored certificates to be set")
+	}
+	if issued[3].Path != "" {
+		t.Fatalf("expected deleted certificate to have no path, got %q", issued[3].Path)
 	}
-	if second != 2 {
-		t.Fatalf("second serial = %d, want 2", second)
+	for i, want := range []string{StatusValid, StatusValid, StatusRevoked, StatusValid} {
+		if issued[i].Status != want {
+			t.Fatalf("index entry %d status = %s, want %s", i, issued[i].Status, want)
+		}
 	}
 
-	serialFile := filepath.Join(dir, filenameSerial)
-	contents, err := os.ReadFile(serialFile)
+	// A corrupt index must be reported rather than ignored
+	if err := os.WriteFile(filepath.Join(dataDir, "certs", root.ID, filenameIssued), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "30m",
+		KeyType:    KeyTypeECDSAP256,
+	}); err == nil {
+		t.Fatal("expected corrupt index to cause an error")
+	}
+}
+
+func TestIssuedIndexIsBuiltForExistingCAs(t *testing.T) {
+	var (
+		dataDir = t.TempDir()
+		s       = newTestStorage(t, dataDir)
+	)
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1h",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root: %v", err)
+	}
+	child, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "30m",
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create child: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dataDir, "certs", root.ID, filenameIssued)); err != nil {
+		t.Fatal(err)
+	}
+	issued, err := s.GetIssuedSerials(root.Path)
 	if err != nil {
-		t.Fatalf("read serial file: %v", err)
+		t.Fatalf("get issued serials: %v", err)
 	}
-	if string(contents) != "2" {
-		t.Fatalf("serial file = %q, want %q", contents, "2")
+	if len(issued) != 2 || findIssued(issued, child.X509.SerialNumber) == nil {
+		t.Fatalf("expected index to be rebuilt from stored certificates, got %d entries", len(issued))
 	}
 }
@@ -5,39 +5,96 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
+)
+
+const (
+	defaultCRLValidity = 7 * durDay
 )
 
 // Internally, the directory structure looks something like this:
 //
+// - seal.json
+// - profiles.json
 // - certs/
 //   - [SHA-256]/
 //     - cert.pem
 //     - key.pem
-//     - serial
+//     - key.pkcs11.json
+//     - issued.json
+//     - revoked.json
+//     - crl.pem
+//     - policy.json
+//     - ocsp-cert.pem
+//     - ocsp-key.pem
 //     - [SHA-256]/
 //       - cert.pem
 //       - key.pem
+// - pending/
+//   - [random ID]/
+//     - request.pem
+//     - key.pem
+//     - key.pkcs11.json
 //
 // A few things to note:
 //   - this structure can be arbitrarily deep
-//   - serial is present in all intermediate certificates
+//   - key.pkcs11.json replaces key.pem when the private key is stored on a
+//     PKCS#11 token; it identifies the key on the token
+//   - issued.json is present in all CAs and lists every serial number the CA
+//     has issued (including certificates since deleted) so that serials are
+//     never reused
+//   - revoked.json lists certificates revoked by the CA and crl.pem is the
+//     most recently generated CRL; both are created on demand
+//   - policy.json is the CA's issuance policy, which is optional
+//   - ocsp-cert.pem and ocsp-key.pem are the delegated OCSP signer, which is
+//     only created when delegated signing is used
+//   - seal.json only exists when private keys are encrypted; it holds the
+//     master key encrypted with a key derived from the passphrase
+//   - profiles.json holds the certificate profiles; the built-in profiles
+//     are used until it is first written
+//   - pending/ holds private keys waiting for a certificate from an external
+//     CA along with the certificate signing request for each; the directory
+//     is moved into certs/ once the certificate is uploaded
 //   - certificates are identified by their path in the hierarchy:
 //     [SHA-256 of root]/[SHA-256 of intermediate]/[SHA-256]
 
 // Storage provides an abstraction to the certificate data stored on disk.
 // All public methods are safe for use in multiple goroutines.
 type Storage struct {
-	mutex     sync.RWMutex
-	logger    *slog.Logger
-	certDir   string
-	rootCerts map[string]*storageCert
+	mutex        sync.RWMutex
+	logger       *slog.Logger
+	certDir      string
+	pendingDir   string
+	crlValidity  time.Duration
+	ocspValidity time.Duration
+	ocspDelegate bool
+	ocspMutex    sync.Mutex
+	ocspCache    map[string]*ocspCacheEntry
+	ocspGen      uint64
+	sealFilename string
+	seal         *sealFile
+	masterKey    []byte
+	token        token
+	rootCerts    map[string]*storageCert
+	issuerURLs   func(*Ref) *IssuerURLs
+
+	profilesFilename string
+	profiles         []*Profile
 }
 
 // New creates a new Storage instance.
 func New(cfg *Config) (*Storage, error) {
 	s := &Storage{
-		logger:  cfg.Logger,
-		certDir: filepath.Join(cfg.DataDir, "certs"),
+		logger:       cfg.Logger,
+		certDir:      filepath.Join(cfg.DataDir, "certs"),
+		pendingDir:   filepath.Join(cfg.DataDir, "pending"),
+		crlValidity:  cfg.CRLValidity,
+		ocspValidity: cfg.OCSPValidity,
+		ocspDelegate: cfg.OCSPDelegate,
+		ocspCache:    map[string]*ocspCacheEntry{},
+		sealFilename: filepath.Join(cfg.DataDir, filenameSeal),
+
+		profilesFilename: filepath.Join(cfg.DataDir, filenameProfiles),
 	}
 	if err := os.MkdirAll(s.certDir, 0700); err != nil {
 		return nil, err
@@ -45,11 +102,39 @@ func New(cfg *Config) (*Storage, error) {
 	if s.logger == nil {
 		s.logger = slog.Default()
 	}
+	if s.crlValidity == 0 {
+		s.crlValidity = defaultCRLValidity
+	}
+	if s.ocspValidity == 0 {
+		s.ocspValidity = defaultOCSPValidity
+	}
 	s.logger = s.logger.With("package", "storage")
 	certs, err := s.loadCerts(s.certDir, nil)
 	if err != nil {
 		return nil, err
 	}
 	s.rootCerts = certs
+	f, err := loadSealFile(s.sealFilename)
+	if err != nil {
+		return nil, err
+	}
+	s.seal = f
+	profiles, err := loadProfiles(s.profilesFilename)
+	if err != nil {
+		return nil, err
+	}
+	s.profiles = profiles
+	if f != nil && cfg.Passphrase != "" {
+		if err := s.Unseal(cfg.Passphrase); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.PKCS11 != nil {
+		t, err := openToken(cfg.PKCS11)
+		if err != nil {
+			return nil, err
+		}
+		s.token = t
+	}
 	return s, nil
 }
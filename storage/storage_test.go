@@ -89,9 +89,12 @@ func TestStorageCreatesPersistsExportsAndDeletesCertificates(t *testing.T) {
 	if childCert.CanSign() {
 		t.Fatal("leaf certificate should not be able to sign")
 	}
+	if childCert.PrivateKey.Algorithm != AlgorithmRSA {
+		t.Fatalf("child key algorithm = %q, want %q", childCert.PrivateKey.Algorithm, AlgorithmRSA)
+	}
 
 	// Confirm its validity
-	results, err := s.ValidateCertificate(childCert.Path)
+	results, err := s.ValidateCertificate(childCert.Path, nil)
 	for _, r := range results {
 		if r.Err != "" {
 			t.Fatalf("validate certificate chain failed: %v", r.Err)
@@ -136,3 +139,74 @@ func TestStorageCreatesPersistsExportsAndDeletesCertificates(t *testing.T) {
 		t.Fatalf("get deleted child error = %v, want %v", err, errCertDoesNotExist)
 	}
 }
+
+func TestStorageCreatesChildrenWithKeyTypes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		keyType   string
+		algorithm string
+	}{
+		{name: "ecdsa", keyType: KeyTypeECDSAP256, algorithm: AlgorithmECDSA},
+		{name: "ed25519", keyType: KeyTypeEd25519, algorithm: AlgorithmEd25519},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var (
+				dataDir = t.TempDir()
+				s       = newTestStorage(t, dataDir)
+			)
+			root, err := s.CreateCertificate("", &CreateCertificateParams{
+				CommonName: rootCertCN,
+				Validity:   "1h",
+				CanSign:    true,
+				KeySize:    2048,
+			})
+			if err != nil {
+				t.Fatalf("create root certificate: %v", err)
+			}
+			c, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+				CommonName: childCertCN,
+				Validity:   "30m",
+				ServerAuth: true,
+				SANs:       childCertCN + " " + childCertIP,
+				KeyType:    tt.keyType,
+			})
+			if err != nil {
+				t.Fatalf("create child certificate: %v", err)
+			}
+			if c.PrivateKey.Algorithm != tt.algorithm {
+				t.Fatalf("child key algorithm = %q, want %q", c.PrivateKey.Algorithm, tt.algorithm)
+			}
+			results, err := s.ValidateCertificate(c.Path, nil)
+			if err != nil {
+				t.Fatalf("validate child certificate: %v", err)
+			}
+			for _, r := range results {
+				if r.Err != "" {
+					t.Fatalf("validate certificate chain failed: %v", r.Err)
+				}
+			}
+			publicKey, err := s.ExportPublicKeyPEM(c.Path)
+			if err != nil {
+				t.Fatalf("export child public key: %v", err)
+			}
+			if !bytes.Contains(publicKey, []byte("BEGIN PUBLIC KEY")) {
+				t.Fatalf("public key export does not contain a PEM public key: %q", publicKey)
+			}
+
+			// The key is loaded with the right type after a reload
+			s = newTestStorage(t, dataDir)
+			v, err := s.GetCertificate(c.Path)
+			if err != nil {
+				t.Fatalf("get child certificate after reload: %v", err)
+			}
+			if v.PrivateKey == nil || v.PrivateKey.Algorithm != tt.algorithm {
+				t.Fatalf("child key after reload = %#v, want %q", v.PrivateKey, tt.algorithm)
+			}
+		})
+	}
+}
@@ -1,7 +1,11 @@
 package storage
 
 import (
+	"fmt"
+	"net"
 	"os"
+	"strings"
+	"unicode"
 )
 
 func ifProvided(v string) []string {
@@ -11,6 +15,29 @@ func ifProvided(v string) []string {
 	return []string{v}
 }
 
+// splitSANs splits a comma or space separated list of SANs.
+func splitSANs(v string) []string {
+	return strings.FieldsFunc(v, func(c rune) bool {
+		return c == ',' || unicode.IsSpace(c)
+	})
+}
+
+// parseIPRange parses a range in CIDR notation or a single IP address.
+func parseIPRange(v string) (*net.IPNet, error) {
+	if i := net.ParseIP(v); i != nil {
+		bits := 128
+		if i.To4() != nil {
+			bits = 32
+		}
+		v = fmt.Sprintf("%s/%d", i, bits)
+	}
+	_, n, err := net.ParseCIDR(v)
+	if err != nil {
+		return nil, inputError(fmt.Sprintf("invalid IP range %s", v))
+	}
+	return n, nil
+}
+
 func fileExists(f string) (bool, error) {
 	_, err := os.Stat(f)
 	if err != nil {
@@ -21,3 +48,8 @@ func fileExists(f string) (bool, error) {
 	}
 	return true, nil
 }
+
+func isDir(p string) bool {
+	v, err := os.Stat(p)
+	return err == nil && v.IsDir()
+}
@@ -0,0 +1,325 @@
+package storage
+
+import (
+	"crypto/x509"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Checks made when validating each certificate in a chain.
+const (
+	CheckValidity   = "validity"
+	CheckSignature  = "signature"
+	CheckIssuer     = "issuer"
+	CheckPurpose    = "purpose"
+	CheckHostname   = "hostname"
+	CheckRevocation = "revocation"
+)
+
+var errInvalidPurpose = inputError("purpose must be the name of an extended key usage")
+
+// ValidateParams provides ValidateCertificate with the conditions the
+// certificate must meet. The zero value validates the chain now for any
+// purpose.
+type ValidateParams struct {
+
+	// Purpose is the name of an extended key usage, such as serverAuth, that
+	// every certificate in the chain must allow
+	Purpose string `json:"purpose"`
+
+	// Host is a DNS name or IP address that must match the leaf certificate
+	Host string `json:"host"`
+
+	// Time is when the chain is evaluated (in the same formats as NotBefore)
+	// and defaults to now
+	Time string `json:"time"`
+
+	// Revocation checks whether each certificate was revoked by its issuer
+	Revocation bool `json:"revocation"`
+}
+
+// ValidationCheck is the outcome of a single check of a certificate along
+// with an explanation.
+type ValidationCheck struct {
+	Name    string
+	Passed  bool
+	Message string
+}
+
+// ValidationResult indicates the validity of a single certificate in a chain
+// represented by Err being nil or not. Checks lists every check that was made.
+type ValidationResult struct {
+	X509   *x509.Certificate
+	Err    string
+	Checks []*ValidationCheck
+}
+
+func formatCheckTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04 MST")
+}
+
+func validityCheck(cert *x509.Certificate, t time.Time) *ValidationCheck {
+	v := &ValidationCheck{Name: CheckValidity}
+	switch {
+	case t.Before(cert.NotBefore):
+		v.Message = fmt.Sprintf("not valid until %s", formatCheckTime(cert.NotBefore))
+	case t.After(cert.NotAfter):
+		v.Message = fmt.Sprintf("expired on %s", formatCheckTime(cert.NotAfter))
+	default:
+		v.Passed = true
+		v.Message = fmt.Sprintf(
+			"valid from %s until %s",
+			formatCheckTime(cert.NotBefore),
+			formatCheckTime(cert.NotAfter),
+		)
+	}
+	return v
+}
+
+// signatureCheck verifies link i of the chain against the links above it.
+// Its own validity period is checked separately, so the chain is evaluated at
+// the closest time that the certificate is valid to avoid reporting it twice.
+func signatureCheck(
+	chain []*chainLink,
+	i int,
+	t time.Time,
+	anchored bool,
+	roots, intermediates *x509.CertPool,
+) *ValidationCheck {
+	var (
+		v    = &ValidationCheck{Name: CheckSignature}
+		cert = chain[i].cert
+	)
+	if i == 0 && !anchored {
+		v.Message = "not issued by a CA managed by Certy"
+		return v
+	}
+	if t.Before(cert.NotBefore) {
+		t = cert.NotBefore
+	}
+	if t.After(cert.NotAfter) {
+		t = cert.NotAfter
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   t,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		v.Message = err.Error()
+		return v
+	}
+	v.Passed = true
+	if i == 0 {
+		v.Message = "trusted as the root of the chain"
+	} else {
+		v.Message = fmt.Sprintf("signed by %s", chain[i-1].cert.Subject.CommonName)
+	}
+	return v
+}
+
+// issuerCheck ensures that a certificate above the leaf may issue
+// certificates.
+func issuerCheck(cert *x509.Certificate) *ValidationCheck {
+	v := &ValidationCheck{Name: CheckIssuer}
+	switch {
+	case !cert.BasicConstraintsValid || !cert.IsCA:
+		v.Message = "is not a CA so it cannot issue certificates"
+	case cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageCertSign == 0:
+		v.Message = "key usage does not include certificate signing"
+	default:
+		v.Passed = true
+		v.Message = "may issue certificates"
+	}
+	return v
+}
+
+// purposeCheck ensures that the certificate allows the extended key usage.
+// Like clients, a certificate without the extension allows every usage.
+func purposeCheck(cert *x509.Certificate, u *Usage) *ValidationCheck {
+	v := &ValidationCheck{Name: CheckPurpose}
+	switch {
+	case len(cert.ExtKeyUsage) == 0 && len(cert.UnknownExtKeyUsage) == 0:
+		v.Passed = true
+		v.Message = "does not restrict extended key usage"
+	case slices.Contains(cert.ExtKeyUsage, x509.ExtKeyUsageAny):
+		v.Passed = true
+		v.Message = "allows any extended key usage"
+	case slices.Contains(cert.ExtKeyUsage, u.extKeyUsage):
+		v.Passed = true
+		v.Message = fmt.Sprintf("allows %s", u.Label)
+	default:
+		v.Message = fmt.Sprintf(
+			"does not allow %s, only %s",
+			u.Label,
+			strings.Join((&Certificate{X509: cert}).ExtKeyUsage(), ", "),
+		)
+	}
+	return v
+}
+
+func hostnameCheck(cert *x509.Certificate, host string) *ValidationCheck {
+	v := &ValidationCheck{Name: CheckHostname}
+	if err := cert.VerifyHostname(host); err != nil {
+		v.Message = err.Error()
+		return v
+	}
+	v.Passed = true
+	v.Message = fmt.Sprintf("%s matches the certificate", host)
+	return v
+}
+
+// revocationCheck reports whether the certificate had been revoked at time t.
+func revocationCheck(r *Revocation, t time.Time) *ValidationCheck {
+	v := &ValidationCheck{Name: CheckRevocation}
+	switch {
+	case r == nil:
+		v.Passed = true
+		v.Message = "not revoked by its issuer"
+	case r.RevocationTime.After(t):
+		v.Passed = true
+		v.Message = fmt.Sprintf("not yet revoked (revoked on %s)", formatCheckTime(r.RevocationTime))
+	default:
+		v.Message = fmt.Sprintf(
+			"revoked on %s (%s)",
+			formatCheckTime(r.RevocationTime),
+			r.ReasonName(),
+		)
+	}
+	return v
+}
+
+// chainLink is a certificate in a chain being validated along with the
+// managed certificate that issued it (if any) for checking revocation.
+type chainLink struct {
+	cert   *x509.Certificate
+	issuer *storageCert
+}
+
+// managedChain returns the links from the root down to c.
+func managedChain(c *storageCert) []*chainLink {
+	links := []*chainLink{}
+	for _, v := range c.chain() {
+		links = append(links, &chainLink{cert: v.cert, issuer: v.parent})
+	}
+	return links
+}
+
+// validateOptions holds the parsed ValidateParams.
+type validateOptions struct {
+	purpose    *Usage
+	host       string
+	time       time.Time
+	revocation bool
+}
+
+func (p *ValidateParams) options() (*validateOptions, error) {
+	o := &validateOptions{
+		host:       p.Host,
+		time:       time.Now(),
+		revocation: p.Revocation,
+	}
+	if p.Purpose != "" {
+		u := findUsage(ExtKeyUsages, p.Purpose)
+		if u == nil {
+			return nil, errInvalidPurpose
+		}
+		if u.extKeyUsage != x509.ExtKeyUsageAny {
+			o.purpose = u
+		}
+	}
+	if p.Time != "" {
+		t, err := parseTime(p.Time)
+		if err != nil {
+			return nil, err
+		}
+		o.time = t
+	}
+	return o, nil
+}
+
+// validateChain checks each link of the chain, starting with its root. The
+// root is only trusted if anchored is true, meaning that it is managed.
+func (s *Storage) validateChain(
+	chain []*chainLink,
+	o *validateOptions,
+	anchored bool,
+) ([]*ValidationResult, error) {
+	var (
+		results    []*ValidationResult
+		pRoot      = x509.NewCertPool()
+		pImed      = x509.NewCertPool()
+		foundError bool
+	)
+	for i, c := range chain {
+		if i == 0 {
+			pRoot.AddCert(c.cert)
+		}
+		checks := []*ValidationCheck{
+			validityCheck(c.cert, o.time),
+			signatureCheck(chain, i, o.time, anchored, pRoot, pImed),
+		}
+		if i < len(chain)-1 {
+			checks = append(checks, issuerCheck(c.cert))
+		}
+		if o.purpose != nil {
+			checks = append(checks, purposeCheck(c.cert, o.purpose))
+		}
+		if o.host != "" && i == len(chain)-1 {
+			checks = append(checks, hostnameCheck(c.cert, o.host))
+		}
+		if o.revocation && c.issuer != nil {
+			revocations, err := loadRevocations(c.issuer.fPath)
+			if err != nil {
+				return nil, err
+			}
+			checks = append(checks, revocationCheck(
+				findRevocation(revocations, c.cert.SerialNumber),
+				o.time,
+			))
+		}
+		result := &ValidationResult{
+			X509:   c.cert,
+			Checks: checks,
+		}
+		if foundError {
+			result.Err = "cannot validate because parent certificate failed to validate"
+		} else if i := slices.IndexFunc(checks, func(v *ValidationCheck) bool {
+			return !v.Passed
+		}); i != -1 {
+			result.Err = checks[i].Message
+			foundError = true
+		}
+		results = append(results, result)
+		if i > 0 {
+			pImed.AddCert(c.cert)
+		}
+	}
+	return results, nil
+}
+
+// ValidateCertificate attempts to validate the specified certificate. The
+// result is returned as a slice indicating the validity of each link in the
+// chain of trust. If params is nil, the chain is validated now for any
+// purpose.
+func (s *Storage) ValidateCertificate(
+	certPath string,
+	params *ValidateParams,
+) ([]*ValidationResult, error) {
+	if params == nil {
+		params = &ValidateParams{}
+	}
+	o, err := params.options()
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	c, err := s.getCert(certPath)
+	if err != nil {
+		return nil, err
+	}
+	return s.validateChain(managedChain(c), o, true)
+}
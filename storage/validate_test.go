@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// failedCheck returns the name of the first check of the result that failed
+// or "" if they all passed.
+func failedCheck(r *ValidationResult) string {
+	for _, c := range r.Checks {
+		if !c.Passed {
+			return c.Name
+		}
+	}
+	return ""
+}
+
+func TestValidateCertificate(t *testing.T) {
+	s := newTestStorage(t, t.TempDir())
+
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1h",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root certificate: %v", err)
+	}
+	child, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "30m",
+		ServerAuth: true,
+		SANs:       childCertCN,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create child certificate: %v", err)
+	}
+
+	validate := func(params *ValidateParams) []*ValidationResult {
+		t.Helper()
+		results, err := s.ValidateCertificate(child.Path, params)
+		if err != nil {
+			t.Fatalf("validate certificate: %v", err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("got %d results, want 2", len(results))
+		}
+		return results
+	}
+
+	// A server certificate for its own name passes every check
+	results := validate(&ValidateParams{
+		Purpose:    EKUServerAuth,
+		Host:       childCertCN,
+		Revocation: true,
+	})
+	for _, r := range results {
+		if r.Err != "" {
+			t.Fatalf("validation failed: %s", r.Err)
+		}
+	}
+	if n := len(results[1].Checks); n != 5 {
+		t.Fatalf("leaf has %d checks, want 5", n)
+	}
+
+	// Each condition is reported by the check that failed
+	for _, test := range []struct {
+		params *ValidateParams
+		link   int
+		check  string
+	}{
+		{&ValidateParams{Purpose: EKUClientAuth}, 1, CheckPurpose},
+		{&ValidateParams{Host: "other.example.test"}, 1, CheckHostname},
+		{&ValidateParams{Time: time.Now().Add(45 * time.Minute).UTC().Format(time.RFC3339)}, 1, CheckValidity},
+		{&ValidateParams{Time: time.Now().Add(2 * time.Hour).UTC().Format(time.RFC3339)}, 0, CheckValidity},
+	} {
+		results := validate(test.params)
+		if v := failedCheck(results[test.link]); v != test.check {
+			t.Fatalf("%+v failed %q, want %q", test.params, v, test.check)
+		}
+		if test.link == 0 && results[1].Err == "" {
+			t.Fatal("expected the leaf to fail with its parent")
+		}
+	}
+
+	// A revoked certificate only fails when revocation is checked
+	if err := s.RevokeCertificate(child.Path, &RevokeCertificateParams{
+		Reason: ReasonKeyCompromise,
+	}); err != nil {
+		t.Fatalf("revoke certificate: %v", err)
+	}
+	if results := validate(nil); results[1].Err != "" {
+		t.Fatalf("validation failed: %s", results[1].Err)
+	}
+	if v := failedCheck(validate(&ValidateParams{Revocation: true})[1]); v != CheckRevocation {
+		t.Fatalf("revoked certificate failed %q", v)
+	}
+
+	// The purpose must be a known extended key usage
+	if _, err := s.ValidateCertificate(child.Path, &ValidateParams{
+		Purpose: "1.2.3.4",
+	}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected an invalid purpose to be rejected, got %v", err)
+	}
+}
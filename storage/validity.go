@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// Ways of handling a certificate that would expire after its issuer.
+const (
+	ParentExpiryClamp  = "clamp"
+	ParentExpiryReject = "reject"
+)
+
+// timeFormats lists the formats accepted for explicit dates; those without a
+// time zone are interpreted as UTC.
+var timeFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+var (
+	errBackdateWithStart   = inputError("backdating cannot be combined with a start date")
+	errValidityEnded       = inputError("the end date must be after the start date and in the future")
+	errInvalidParentExpiry = inputError("parent expiry must be clamp or reject")
+)
+
+func parseTime(v string) (time.Time, error) {
+	for _, f := range timeFormats {
+		if t, err := time.Parse(f, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, inputError(fmt.Sprintf("invalid date %s", v))
+}
+
+// validityPeriod returns the start and end of the certificate's validity. The
+// validity is counted from the start date (or now) and backdating only moves
+// the start so that clients with slow clocks accept the certificate.
+func validityPeriod(params *CreateCertificateParams) (time.Time, time.Time, error) {
+	var (
+		n         = time.Now()
+		notBefore = n
+	)
+	if params.NotBefore != "" {
+		if params.Backdate != "" {
+			return time.Time{}, time.Time{}, errBackdateWithStart
+		}
+		t, err := parseTime(params.NotBefore)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		notBefore = t
+	}
+	var notAfter time.Time
+	if params.NotAfter != "" {
+		t, err := parseTime(params.NotAfter)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		notAfter = t
+	} else {
+		v, err := parseDuration(params.Validity)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		notAfter = notBefore.Add(v)
+	}
+	if params.Backdate != "" {
+		v, err := parseDuration(params.Backdate)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		notBefore = notBefore.Add(-v)
+	}
+	if !notAfter.After(notBefore) || !notAfter.After(n) {
+		return time.Time{}, time.Time{}, errValidityEnded
+	}
+	return notBefore, notAfter, nil
+}
+
+func validParentExpiry(v string) error {
+	if v != "" && v != ParentExpiryClamp && v != ParentExpiryReject {
+		return errInvalidParentExpiry
+	}
+	return nil
+}
+
+// fitIssuer ensures that the certificate template is only valid while its
+// issuer p (if any) is by either shortening it or rejecting it according to
+// rule, which defaults to clamping. The return value indicates whether the
+// template was shortened.
+func fitIssuer(p *storageCert, cert *x509.Certificate, rule string) (bool, error) {
+	if err := validParentExpiry(rule); err != nil {
+		return false, err
+	}
+	if p == nil {
+		return false, nil
+	}
+	clamped := false
+	if cert.NotBefore.Before(p.cert.NotBefore) {
+		if !cert.NotAfter.After(p.cert.NotBefore) || rule == ParentExpiryReject {
+			return false, inputError(fmt.Sprintf(
+				"the certificate would be valid before its issuer %s starts on %s",
+				p.cert.Subject.CommonName,
+				p.cert.NotBefore.UTC().Format("2006-01-02 15:04 MST"),
+			))
+		}
+		cert.NotBefore = p.cert.NotBefore
+		clamped = true
+	}
+	if !cert.NotAfter.After(p.cert.NotAfter) {
+		return clamped, nil
+	}
+	if !cert.NotBefore.Before(p.cert.NotAfter) || rule == ParentExpiryReject {
+		return false, inputError(fmt.Sprintf(
+			"the certificate would be valid after its issuer %s expires on %s",
+			p.cert.Subject.CommonName,
+			p.cert.NotAfter.UTC().Format("2006-01-02 15:04 MST"),
+		))
+	}
+	cert.NotAfter = p.cert.NotAfter
+	return true, nil
+}
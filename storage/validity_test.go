@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidity(t *testing.T) {
+	s := newTestStorage(t, t.TempDir())
+
+	root, err := s.CreateCertificate("", &CreateCertificateParams{
+		CommonName: rootCertCN,
+		Validity:   "1y",
+		Backdate:   "1d",
+		CanSign:    true,
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create root certificate: %v", err)
+	}
+
+	// Explicit dates are used as-is
+	var (
+		notBefore = time.Now().UTC().Truncate(time.Second).Add(durHour)
+		notAfter  = notBefore.Add(durWeek)
+	)
+	c, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		NotBefore:  notBefore.Format(time.RFC3339),
+		NotAfter:   notAfter.Format("2006-01-02T15:04:05"),
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create with dates: %v", err)
+	}
+	if !c.X509.NotBefore.Equal(notBefore) || !c.X509.NotAfter.Equal(notAfter) || c.Clamped {
+		t.Fatalf("validity = %s - %s, want %s - %s", c.X509.NotBefore, c.X509.NotAfter, notBefore, notAfter)
+	}
+
+	// Backdating moves the start but not the end
+	c, err = s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "1w1d",
+		Backdate:   "1h",
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create backdated: %v", err)
+	}
+	if d := c.X509.NotAfter.Sub(c.X509.NotBefore); d != durWeek+durDay+durHour {
+		t.Fatalf("backdated validity = %s", d)
+	}
+
+	// A child that would outlive its issuer is clamped by default or
+	// rejected if requested
+	c, err = s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		Validity:   "2y",
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create clamped: %v", err)
+	}
+	if !c.Clamped || !c.X509.NotAfter.Equal(root.X509.NotAfter) {
+		t.Fatal("expected validity to be clamped to the issuer's")
+	}
+	if _, err := s.RenewCertificate(c.Path, &RenewCertificateParams{
+		Validity:     "2y",
+		ParentExpiry: ParentExpiryReject,
+	}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected renewal beyond the issuer to be rejected, got %v", err)
+	}
+
+	// ...as is one that would start before its issuer
+	notBefore = root.X509.NotBefore.Add(-durDay)
+	c, err = s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName: childCertCN,
+		NotBefore:  notBefore.Format(time.RFC3339),
+		NotAfter:   notBefore.Add(durWeek).Format(time.RFC3339),
+		KeyType:    KeyTypeECDSAP256,
+	})
+	if err != nil {
+		t.Fatalf("create clamped start: %v", err)
+	}
+	if !c.Clamped || !c.X509.NotBefore.Equal(root.X509.NotBefore) {
+		t.Fatalf("start = %s, want issuer's %s", c.X509.NotBefore, root.X509.NotBefore)
+	}
+	if _, err := s.CreateCertificate(root.Path, &CreateCertificateParams{
+		CommonName:   childCertCN,
+		Validity:     "1d",
+		Backdate:     "1w",
+		ParentExpiry: ParentExpiryReject,
+		KeyType:      KeyTypeECDSAP256,
+	}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected start before the issuer to be rejected, got %v", err)
+	}
+
+	// Invalid windows are rejected
+	for _, params := range []*CreateCertificateParams{
+		{Validity: "1d", NotBefore: "tomorrow"},
+		{Validity: "1d", NotBefore: "2020-01-01", Backdate: "1h"},
+		{NotBefore: "2020-01-01", NotAfter: "2020-02-01"},
+		{Validity: "1d", ParentExpiry: "extend"},
+	} {
+		params.CommonName = childCertCN
+		params.KeyType = KeyTypeECDSAP256
+		if _, err := s.CreateCertificate(root.Path, params); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("expected %+v to be rejected, got %v", params, err)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/nathan-osman/certy/auth"
+	"github.com/urfave/cli/v2"
+)
+
+var errInvalidRole = errors.New("role must be one of: " + strings.Join(auth.Roles, ", "))
+
+// userAction wraps a user subcommand, providing it with an Auth instance and
+// the username argument.
+func userAction(fn func(c *cli.Context, au *auth.Auth, username string) error) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.ShowSubcommandHelp(c)
+		}
+		au, err := auth.New(&auth.Config{
+			DataDir: c.String("data-dir"),
+		})
+		if err != nil {
+			return err
+		}
+		return fn(c, au, c.Args().First())
+	}
+}
+
+// grantAction wraps the grant and revoke subcommands, which take a username
+// and role.
+func grantAction(fn func(au *auth.Auth, username string, g auth.Grant) error) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		if c.NArg() != 2 {
+			return cli.ShowSubcommandHelp(c)
+		}
+		au, err := auth.New(&auth.Config{
+			DataDir: c.String("data-dir"),
+		})
+		if err != nil {
+			return err
+		}
+		return fn(au, c.Args().Get(0), auth.Grant{
+			Role: c.Args().Get(1),
+			Path: c.String("path"),
+		})
+	}
+}
+
+var pathFlag = &cli.StringFlag{
+	Name:  "path",
+	Usage: "certificate path the role applies to (the whole tree if omitted)",
+}
+
+var userCommand = &cli.Command{
+	Name:  "user",
+	Usage: "manage users of the web interface",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "add",
+			Usage:     "add a new user",
+			ArgsUsage: "[username]",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "role",
+					Value: auth.RoleAdmin,
+					Usage: "role to grant the user (" + strings.Join(auth.Roles, ", ") + ")",
+				},
+				pathFlag,
+			},
+			Action: userAction(func(c *cli.Context, au *auth.Auth, username string) error {
+				if !slices.Contains(auth.Roles, c.String("role")) {
+					return errInvalidRole
+				}
+				p, err := readSecret("Password", true)
+				if err != nil {
+					return err
+				}
+				if err := au.AddUser(username, p); err != nil {
+					return err
+				}
+				return au.AddGrant(username, auth.Grant{
+					Role: c.String("role"),
+					Path: c.String("path"),
+				})
+			}),
+		},
+		{
+			Name:      "grant",
+			Usage:     "grant a role to a user",
+			ArgsUsage: "[username] [role]",
+			Flags:     []cli.Flag{pathFlag},
+			Action:    grantAction((*auth.Auth).AddGrant),
+		},
+		{
+			Name:      "revoke",
+			Usage:     "revoke a role from a user",
+			ArgsUsage: "[username] [role]",
+			Flags:     []cli.Flag{pathFlag},
+			Action:    grantAction((*auth.Auth).RemoveGrant),
+		},
+		{
+			Name:      "passwd",
+			Usage:     "change the password for a user",
+			ArgsUsage: "[username]",
+			Action: userAction(func(c *cli.Context, au *auth.Auth, username string) error {
+				p, err := readSecret("Password", true)
+				if err != nil {
+					return err
+				}
+				return au.SetPassword(username, p)
+			}),
+		},
+		{
+			Name:      "remove",
+			Usage:     "remove a user",
+			ArgsUsage: "[username]",
+			Action: userAction(func(c *cli.Context, au *auth.Auth, username string) error {
+				return au.RemoveUser(username)
+			}),
+		},
+		{
+			Name:  "list",
+			Usage: "list all users",
+			Action: func(c *cli.Context) error {
+				au, err := auth.New(&auth.Config{
+					DataDir: c.String("data-dir"),
+				})
+				if err != nil {
+					return err
+				}
+				v, err := au.Usernames()
+				if err != nil {
+					return err
+				}
+				for _, u := range v {
+					grants, err := au.Grants(u)
+					if err != nil {
+						return err
+					}
+					fmt.Println(u)
+					for _, g := range grants {
+						p := g.Path
+						if p == "" {
+							p = "(all)"
+						}
+						fmt.Printf("  %s %s\n", g.Role, p)
+					}
+				}
+				return nil
+			},
+		},
+	},
+}